@@ -61,6 +61,12 @@ func init() {
 	pflag.StringVarP(&Config.AgentConfig.ReporterConfig.SignKey, "key", "k", "",
 		"Sign key for metrics")
 
+	pflag.StringVar(&Config.AgentConfig.ReporterConfig.Tenant, "tenant", "",
+		"Tenant to report metrics to, metrics are reported to the default tenant if it's empty")
+
+	pflag.StringVar(&Config.AgentConfig.ReporterConfig.TenantToken, "tenant-token", "",
+		"Auth token of tenant, it selects the tenant on its own")
+
 	pflag.StringVarP(&Config.AgentConfig.LogLevel, "log-level", "l", "ERROR",
 		"Set log level: DEBUG|INFO|WARNING|ERROR")
 }
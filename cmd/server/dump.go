@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/itd27m01/go-metrics-service/internal/dump"
+	"github.com/itd27m01/go-metrics-service/internal/server/storage"
+	"github.com/itd27m01/go-metrics-service/pkg/logging/log"
+)
+
+const dumpUsage = "usage: server export|import json|ndjson [file]"
+
+var errDumpUsage = errors.New(dumpUsage)
+
+// runDump runs export and import subcommands: export writes metrics of the configured storage to the file,
+// import reads them from the file to the storage. Standard output and input are used if the file is omitted or "-",
+// so metrics can be moved between backends by the pipe, e.g. server -f metrics.json export ndjson | server -d DSN import ndjson
+func runDump(ctx context.Context, config storage.Config, command string, args []string) error {
+	if len(args) == 0 || len(args) > 2 || args[0] != dump.FormatJSON && args[0] != dump.FormatNDJSON {
+		return errDumpUsage
+	}
+	format, path := args[0], "-"
+	if len(args) == 2 {
+		path = args[1]
+	}
+
+	// Logs must not be mixed with the dump on standard output
+	log.Logger = log.Logger.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339})
+
+	// Metrics of file storage must be loaded to be exported or to be kept on import
+	config.Restore = true
+	metricsStore, closeStore := storage.StartMetricsStorage(ctx, &config)
+	defer func() {
+		if err := closeStore(); err != nil {
+			log.Error().Err(err).Msg("Some error occurred while store close")
+		}
+	}()
+
+	switch command {
+	case "export":
+		var w io.Writer = os.Stdout
+		if path != "-" {
+			file, err := os.Create(path)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			w = file
+		}
+
+		exported, err := dump.Export(ctx, metricsStore, w, format)
+		if err != nil {
+			return err
+		}
+		log.Info().Msgf("Exported %d metrics", exported)
+	case "import":
+		var r io.Reader = os.Stdin
+		if path != "-" {
+			file, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			r = file
+		}
+
+		imported, err := dump.Import(ctx, metricsStore, r, format)
+		log.Info().Msgf("Imported %d metrics", imported)
+		if err != nil {
+			return err
+		}
+	default:
+		return errDumpUsage
+	}
+
+	return nil
+}
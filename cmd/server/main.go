@@ -21,9 +21,13 @@ var (
 )
 
 const (
-	defaultServerAddress = "127.0.0.1:8080"
-	defaultStoreFilePath = "/tmp/devops-metrics-db.json"
-	defaultStoreInterval = 300 * time.Second
+	defaultServerAddress    = "127.0.0.1:8080"
+	defaultStoreFilePath    = "/tmp/devops-metrics-db.json"
+	defaultStoreInterval    = 300 * time.Second
+	defaultHistoryRetention = 24 * time.Hour
+	defaultSweepInterval    = 1 * time.Minute
+	defaultArchiveInterval  = 1 * time.Hour
+	defaultArchiveKeep      = 24
 )
 
 var (
@@ -40,12 +44,73 @@ func init() {
 	pflag.StringVarP(&Config.ServerConfig.StorageConfig.StoreFilePath, "file", "f", defaultStoreFilePath,
 		"Number of seconds to periodically save metrics")
 
+	pflag.StringVar(&Config.ServerConfig.StorageConfig.BoltPath, "bolt", "",
+		"A path to the embedded bolt database for metrics store, it takes precedence over the store file")
+
 	pflag.BoolVarP(&Config.ServerConfig.StorageConfig.Restore, "restore", "r", false,
 		"Flag to load initial metrics from storage backend")
 
+	pflag.StringVar(&Config.ServerConfig.StorageConfig.RestoreFrom, "restore-from", "",
+		"A path of snapshot or RFC3339 time of archived snapshot to restore metrics from, it takes precedence over restore")
+
+	pflag.StringVar(&Config.ServerConfig.StorageConfig.ArchiveDir, "archive-dir", "",
+		"A directory to archive compressed snapshots of the store file to, snapshots aren't archived if it's empty")
+
+	pflag.DurationVar(&Config.ServerConfig.StorageConfig.ArchiveInterval, "archive-interval", defaultArchiveInterval,
+		"How often to archive snapshot of metrics")
+
+	pflag.IntVar(&Config.ServerConfig.StorageConfig.ArchiveKeep, "archive-keep", defaultArchiveKeep,
+		"Number of the latest archived snapshots to keep, zero keeps all of them")
+
+	pflag.DurationVar(&Config.ServerConfig.StorageConfig.ArchiveMaxAge, "archive-max-age", 0,
+		"How long to keep archived snapshots, zero keeps them regardless of age")
+
+	pflag.StringVar(&Config.ServerConfig.StorageConfig.SnapshotKeyFile, "snapshot-key-file", "",
+		"A path to the file of base64 encoded AES keys, one per line, to encrypt snapshots of the store file, "+
+			"the first key encrypts and the rest only decrypt")
+
 	pflag.DurationVarP(&Config.ServerConfig.StorageConfig.StoreInterval, "interval", "i", defaultStoreInterval,
 		"Number of seconds to periodically save metrics")
 
+	pflag.BoolVar(&Config.ServerConfig.StorageConfig.WAL, "wal", false,
+		"Append every update to write-ahead log next to the store file, the log is compacted on save")
+
+	pflag.DurationVar(&Config.ServerConfig.StorageConfig.HistoryRetention, "history-retention", defaultHistoryRetention,
+		"How long to keep history of metrics, zero disables the history")
+
+	pflag.IntVar(&Config.ServerConfig.StorageConfig.Shards, "shards", 0,
+		"Number of shards of memory storage, the storage isn't sharded by default")
+
+	pflag.DurationVar(&Config.ServerConfig.StorageConfig.FlushInterval, "flush-interval", 0,
+		"Serve metrics from memory and flush them to database on the interval, zero writes every update to database")
+
+	pflag.StringSliceVar(&Config.ServerConfig.StorageConfig.Mirrors, "mirror", nil,
+		"Database DSN, bolt:// path or file path of secondary store to mirror updates to, can be repeated")
+
+	pflag.BoolVar(&Config.ServerConfig.StorageConfig.MirrorAsync, "mirror-async", false,
+		"Mirror updates to secondary stores asynchronously")
+
+	pflag.BoolVar(&Config.ServerConfig.StorageConfig.MirrorResyncEmpty, "mirror-resync-empty", false,
+		"Resync secondary stores on start even if the primary store is empty, it wipes secondary stores")
+
+	pflag.DurationVar(&Config.ServerConfig.StorageConfig.TTL, "ttl", 0,
+		"How long to keep metrics after the last update, zero keeps them forever")
+
+	pflag.DurationVar(&Config.ServerConfig.StorageConfig.SweepInterval, "sweep-interval", defaultSweepInterval,
+		"How often to evict metrics which are stale by TTL and expired history of database")
+
+	pflag.IntVar(&Config.ServerConfig.StorageConfig.MaxMetrics, "max-metrics", 0,
+		"Limit of the total number of metrics of every tenant, zero disables the limit")
+
+	pflag.IntVar(&Config.ServerConfig.StorageConfig.MaxNewPerMinute, "max-new-per-minute", 0,
+		"Limit of the number of new metrics of every tenant per minute, zero disables the limit")
+
+	pflag.IntVar(&Config.ServerConfig.StorageConfig.MaxNameLength, "max-name-length", 0,
+		"Limit of the length of metric name, zero disables the limit")
+
+	pflag.StringVar(&Config.ServerConfig.StorageConfig.AllowedNames, "allowed-names", "",
+		"Regexp which has to match the whole name of metric, all of names are allowed if it's empty")
+
 	pflag.StringVar(&Config.ServerConfig.HTTPConfig.CryptoKey, "crypto-key", "",
 		"A path to the pem file of private RSA key")
 
@@ -53,7 +118,11 @@ func init() {
 		"Sign key for metrics")
 
 	pflag.StringVarP(&Config.ServerConfig.StorageConfig.DatabaseDSN, "databaseDSN", "d", "",
-		"Database DSN for metrics store")
+		"Database DSN for metrics store, sqlite:///path/to/metrics.db selects SQLite database")
+
+	pflag.StringVar(&Config.ServerConfig.AdminToken, "admin-token", "",
+		"Token of administrative API which is sent in X-Admin-Token header or x-admin-token gRPC metadata, "+
+			"the API is disabled if it's empty")
 
 	pflag.StringVarP(&Config.ServerConfig.HTTPConfig.TrustedSubnet, "trusted-subnet", "t", "",
 		"Trusted subnet for this server")
@@ -63,15 +132,31 @@ func init() {
 }
 
 func main() {
-	if err := greetings.Print(buildVersion, buildDate, buildCommit); err != nil {
-		log.Fatal().Err(err).Msg("Failed to start agent, failed to print greetings")
-	}
-
 	pflag.Parse()
 	Config.MergeConfig()
 
 	logging.LogLevel(Config.ServerConfig.LogLevel)
 
+	if pflag.Arg(0) == "migrate" {
+		if err := runMigrate(context.Background(), Config.ServerConfig.StorageConfig.DatabaseDSN, pflag.Args()[1:]); err != nil {
+			log.Fatal().Err(err).Msg("Failed to migrate database")
+		}
+
+		return
+	}
+
+	if pflag.Arg(0) == "export" || pflag.Arg(0) == "import" {
+		if err := runDump(context.Background(), Config.ServerConfig.StorageConfig, pflag.Arg(0), pflag.Args()[1:]); err != nil {
+			log.Fatal().Err(err).Msgf("Failed to %s metrics", pflag.Arg(0))
+		}
+
+		return
+	}
+
+	if err := greetings.Print(buildVersion, buildDate, buildCommit); err != nil {
+		log.Fatal().Err(err).Msg("Failed to start agent, failed to print greetings")
+	}
+
 	metricsServer := server.MetricsServer{
 		Cfg: &Config.ServerConfig,
 	}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/itd27m01/go-metrics-service/internal/repository"
+	"github.com/itd27m01/go-metrics-service/pkg/logging/log"
+)
+
+const migrateUsage = "usage: server migrate up|down [steps]|status"
+
+var errMigrateUsage = errors.New(migrateUsage)
+
+// runMigrate runs migrate subcommand: up applies pending migrations,
+// down reverts the last applied migrations (one by default), status prints state of the schema
+func runMigrate(ctx context.Context, databaseDSN string, args []string) error {
+	if databaseDSN == "" {
+		return errors.New("database DSN is required for migrations")
+	}
+	if len(args) == 0 {
+		return errMigrateUsage
+	}
+
+	migrator, err := repository.NewMigrator(databaseDSN)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := migrator.Close(); err != nil {
+			log.Error().Err(err).Msg("Failed to close database connection")
+		}
+	}()
+
+	switch {
+	case args[0] == "up" && len(args) == 1:
+		err = migrator.Up(ctx)
+	case args[0] == "down" && len(args) <= 2:
+		steps := 1
+		if len(args) == 2 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return errMigrateUsage
+			}
+		}
+		err = migrator.Down(ctx, steps)
+	case args[0] == "status" && len(args) == 1:
+	default:
+		return errMigrateUsage
+	}
+	if err != nil {
+		return err
+	}
+
+	status, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+	printMigrationsStatus(status)
+
+	return nil
+}
+
+// printMigrationsStatus prints version of the schema and the list of migrations
+func printMigrationsStatus(status *repository.MigrationsStatus) {
+	fmt.Printf("Version: %d, dirty: %t\n", status.Version, status.Dirty)
+	for _, migration := range status.Applied {
+		fmt.Printf("applied  %06d_%s\n", migration.Version, migration.Title)
+	}
+	for _, migration := range status.Pending {
+		fmt.Printf("pending  %06d_%s\n", migration.Version, migration.Title)
+	}
+}
@@ -0,0 +1,16 @@
+// Package db keeps versioned SQL migrations of the database schema
+package db
+
+import "embed"
+
+// Migrations are SQL migrations of PostgreSQL embedded from the migrations directory,
+// files are named {version}_{title}.up.sql and {version}_{title}.down.sql
+//
+//go:embed migrations/*.sql
+var Migrations embed.FS
+
+// SQLiteMigrations are SQL migrations of SQLite embedded from the sqlite directory,
+// they are versioned independently of PostgreSQL ones
+//
+//go:embed sqlite/*.sql
+var SQLiteMigrations embed.FS
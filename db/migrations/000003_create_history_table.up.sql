@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS history(
+    metric_id VARCHAR (50) NOT NULL,
+    metric_type VARCHAR (16) NOT NULL,
+    metric_delta BIGINT,
+    metric_value DOUBLE PRECISION,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS history_metric_id_created_at_idx ON history (metric_id, created_at);
@@ -0,0 +1,14 @@
+DROP INDEX IF EXISTS history_metric_id_labels_created_at_idx;
+DELETE FROM history WHERE labels <> '{}'::jsonb;
+ALTER TABLE history DROP COLUMN IF EXISTS labels;
+CREATE INDEX IF NOT EXISTS history_metric_id_created_at_idx ON history (metric_id, created_at);
+
+DELETE FROM counter WHERE labels <> '{}'::jsonb;
+ALTER TABLE counter DROP CONSTRAINT IF EXISTS counter_pkey;
+ALTER TABLE counter DROP COLUMN IF EXISTS labels;
+ALTER TABLE counter ADD PRIMARY KEY (metric_id);
+
+DELETE FROM gauge WHERE labels <> '{}'::jsonb;
+ALTER TABLE gauge DROP CONSTRAINT IF EXISTS gauge_pkey;
+ALTER TABLE gauge DROP COLUMN IF EXISTS labels;
+ALTER TABLE gauge ADD PRIMARY KEY (metric_id);
@@ -0,0 +1,11 @@
+ALTER TABLE gauge ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}'::jsonb;
+ALTER TABLE gauge DROP CONSTRAINT IF EXISTS gauge_pkey;
+ALTER TABLE gauge ADD PRIMARY KEY (metric_id, labels);
+
+ALTER TABLE counter ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}'::jsonb;
+ALTER TABLE counter DROP CONSTRAINT IF EXISTS counter_pkey;
+ALTER TABLE counter ADD PRIMARY KEY (metric_id, labels);
+
+ALTER TABLE history ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}'::jsonb;
+DROP INDEX IF EXISTS history_metric_id_created_at_idx;
+CREATE INDEX IF NOT EXISTS history_metric_id_labels_created_at_idx ON history (metric_id, labels, created_at);
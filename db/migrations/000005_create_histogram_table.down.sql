@@ -0,0 +1,4 @@
+DELETE FROM history WHERE metric_type = 'histogram';
+ALTER TABLE history DROP COLUMN IF EXISTS metric_histogram;
+
+DROP TABLE IF EXISTS histogram;
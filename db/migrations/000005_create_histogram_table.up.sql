@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS histogram(
+    metric_id VARCHAR (50) NOT NULL,
+    labels JSONB NOT NULL DEFAULT '{}'::jsonb,
+    metric_histogram JSONB NOT NULL,
+    PRIMARY KEY (metric_id, labels)
+);
+
+ALTER TABLE history ADD COLUMN IF NOT EXISTS metric_histogram JSONB;
@@ -0,0 +1,3 @@
+ALTER TABLE histogram DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE counter DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE gauge DROP COLUMN IF EXISTS updated_at;
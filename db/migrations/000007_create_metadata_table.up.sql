@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS metadata(
+    metric_id VARCHAR (50) PRIMARY KEY,
+    description TEXT NOT NULL DEFAULT '',
+    unit VARCHAR (50) NOT NULL DEFAULT '',
+    owner VARCHAR (100) NOT NULL DEFAULT '',
+    help_url TEXT NOT NULL DEFAULT ''
+);
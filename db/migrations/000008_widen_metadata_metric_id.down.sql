@@ -0,0 +1 @@
+ALTER TABLE metadata ALTER COLUMN metric_id TYPE VARCHAR (50);
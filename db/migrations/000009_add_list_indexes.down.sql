@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS gauge_list_idx;
+DROP INDEX IF EXISTS counter_list_idx;
+DROP INDEX IF EXISTS histogram_list_idx;
@@ -0,0 +1,3 @@
+CREATE INDEX IF NOT EXISTS gauge_list_idx ON gauge ((metric_id COLLATE "C"), ((labels::text) COLLATE "C"));
+CREATE INDEX IF NOT EXISTS counter_list_idx ON counter ((metric_id COLLATE "C"), ((labels::text) COLLATE "C"));
+CREATE INDEX IF NOT EXISTS histogram_list_idx ON histogram ((metric_id COLLATE "C"), ((labels::text) COLLATE "C"));
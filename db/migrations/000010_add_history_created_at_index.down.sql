@@ -0,0 +1 @@
+DROP INDEX IF EXISTS history_created_at_idx;
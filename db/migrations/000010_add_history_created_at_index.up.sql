@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS history_created_at_idx ON history (created_at);
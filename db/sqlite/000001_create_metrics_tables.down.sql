@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS history_metric_id_labels_created_at_idx;
+DROP TABLE IF EXISTS history;
+DROP TABLE IF EXISTS histogram;
+DROP TABLE IF EXISTS counter;
+DROP TABLE IF EXISTS gauge;
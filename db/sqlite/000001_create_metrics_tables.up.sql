@@ -0,0 +1,35 @@
+CREATE TABLE IF NOT EXISTS gauge(
+    metric_id VARCHAR (50) NOT NULL,
+    labels TEXT NOT NULL DEFAULT '{}',
+    metric_value DOUBLE PRECISION,
+    updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    PRIMARY KEY (metric_id, labels)
+);
+
+CREATE TABLE IF NOT EXISTS counter(
+    metric_id VARCHAR (50) NOT NULL,
+    labels TEXT NOT NULL DEFAULT '{}',
+    metric_delta BIGINT,
+    updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    PRIMARY KEY (metric_id, labels)
+);
+
+CREATE TABLE IF NOT EXISTS histogram(
+    metric_id VARCHAR (50) NOT NULL,
+    labels TEXT NOT NULL DEFAULT '{}',
+    metric_histogram TEXT NOT NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    PRIMARY KEY (metric_id, labels)
+);
+
+CREATE TABLE IF NOT EXISTS history(
+    metric_id VARCHAR (50) NOT NULL,
+    labels TEXT NOT NULL DEFAULT '{}',
+    metric_type VARCHAR (16) NOT NULL,
+    metric_delta BIGINT,
+    metric_value DOUBLE PRECISION,
+    metric_histogram TEXT,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS history_metric_id_labels_created_at_idx ON history (metric_id, labels, created_at);
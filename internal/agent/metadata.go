@@ -0,0 +1,86 @@
+package agent
+
+import (
+	"context"
+
+	"github.com/itd27m01/go-metrics-service/internal/models/metrics"
+	"github.com/itd27m01/go-metrics-service/internal/repository"
+	"github.com/itd27m01/go-metrics-service/pkg/logging/log"
+)
+
+const (
+	metadataOwner   = "go-metrics-agent"
+	memStatsHelpURL = "https://pkg.go.dev/runtime#MemStats"
+	unitBytes       = "bytes"
+	unitCount       = "count"
+)
+
+// memStatsMetadata describes metrics which are collected from runtime.MemStats
+var memStatsMetadata = map[string]*metrics.Metadata{
+	"PollCount":     {Description: "Number of polls of the agent since the last report", Unit: unitCount},
+	"RandomValue":   {Description: "Random value to check delivery of metrics"},
+	"Alloc":         {Description: "Bytes of allocated heap objects", Unit: unitBytes},
+	"BuckHashSys":   {Description: "Bytes of memory in profiling bucket hash tables", Unit: unitBytes},
+	"Frees":         {Description: "Cumulative count of heap objects freed", Unit: unitCount},
+	"GCCPUFraction": {Description: "Fraction of available CPU time used by the GC since the program started"},
+	"GCSys":         {Description: "Bytes of memory in garbage collection metadata", Unit: unitBytes},
+	"HeapAlloc":     {Description: "Bytes of allocated heap objects", Unit: unitBytes},
+	"HeapIdle":      {Description: "Bytes in idle (unused) spans", Unit: unitBytes},
+	"HeapInuse":     {Description: "Bytes in in-use spans", Unit: unitBytes},
+	"HeapObjects":   {Description: "Number of allocated heap objects", Unit: unitCount},
+	"HeapReleased":  {Description: "Bytes of physical memory returned to the OS", Unit: unitBytes},
+	"HeapSys":       {Description: "Bytes of heap memory obtained from the OS", Unit: unitBytes},
+	"LastGC":        {Description: "Time the last garbage collection finished, as nanoseconds since 1970", Unit: "ns"},
+	"Lookups":       {Description: "Number of pointer lookups performed by the runtime", Unit: unitCount},
+	"MCacheInuse":   {Description: "Bytes of allocated mcache structures", Unit: unitBytes},
+	"MCacheSys":     {Description: "Bytes of memory obtained from the OS for mcache structures", Unit: unitBytes},
+	"MSpanInuse":    {Description: "Bytes of allocated mspan structures", Unit: unitBytes},
+	"MSpanSys":      {Description: "Bytes of memory obtained from the OS for mspan structures", Unit: unitBytes},
+	"Mallocs":       {Description: "Cumulative count of heap objects allocated", Unit: unitCount},
+	"NextGC":        {Description: "Target heap size of the next GC cycle", Unit: unitBytes},
+	"NumForcedGC":   {Description: "Number of GC cycles that were forced by the application", Unit: unitCount},
+	"NumGC":         {Description: "Number of completed GC cycles", Unit: unitCount},
+	"OtherSys":      {Description: "Bytes of memory in miscellaneous off-heap runtime allocations", Unit: unitBytes},
+	"PauseTotalNs":  {Description: "Cumulative nanoseconds in GC stop-the-world pauses", Unit: "ns"},
+	"StackInuse":    {Description: "Bytes in stack spans", Unit: unitBytes},
+	"StackSys":      {Description: "Bytes of stack memory obtained from the OS", Unit: unitBytes},
+	"Sys":           {Description: "Total bytes of memory obtained from the OS", Unit: unitBytes},
+	"TotalAlloc":    {Description: "Cumulative bytes allocated for heap objects", Unit: unitBytes},
+}
+
+// psMetadata describes metrics which are collected from processes and cpu stats
+var psMetadata = map[string]*metrics.Metadata{
+	"TotalMemory":    {Description: "Total amount of virtual memory of the host", Unit: unitBytes},
+	"FreeMemory":     {Description: "Amount of free virtual memory of the host", Unit: unitBytes},
+	"CPUutilization": {Description: "Utilization of the cpu of the host", Unit: "percent"},
+}
+
+// UpdateMemStatsMetadata registers metadata of the memory metrics
+func UpdateMemStatsMetadata(ctx context.Context, mtr repository.Store) {
+	for name, metadata := range memStatsMetadata {
+		md := *metadata
+		md.Owner = metadataOwner
+		if name != "PollCount" && name != "RandomValue" {
+			md.HelpURL = memStatsHelpURL
+		}
+
+		updateMetadata(ctx, mtr, name, &md)
+	}
+}
+
+// UpdatePsMetadata registers metadata of the process and cpu metrics
+func UpdatePsMetadata(ctx context.Context, mtr repository.Store) {
+	for name, metadata := range psMetadata {
+		md := *metadata
+		md.Owner = metadataOwner
+
+		updateMetadata(ctx, mtr, name, &md)
+	}
+}
+
+// updateMetadata sets metadata of metric in the store and logs failure
+func updateMetadata(ctx context.Context, mtr repository.Store, name string, metadata *metrics.Metadata) {
+	if err := mtr.SetMetadata(ctx, name, metadata); err != nil {
+		log.Error().Err(err).Msgf("Couldn't set metadata of metric %s", name)
+	}
+}
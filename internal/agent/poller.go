@@ -2,9 +2,9 @@ package agent
 
 import (
 	"context"
-	"fmt"
 	"math/rand"
 	"runtime"
+	"strconv"
 	"time"
 
 	"github.com/shirou/gopsutil/v3/cpu"
@@ -39,6 +39,8 @@ func (pw *PollerWorker) RunMemStats(ctx context.Context, mtr repository.Store) {
 	storeContext, storeCancel := context.WithTimeout(ctx, pollTimeout)
 	defer storeCancel()
 
+	UpdateMemStatsMetadata(storeContext, mtr)
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -57,6 +59,8 @@ func (pw *PollerWorker) RunPsStats(ctx context.Context, mtr repository.Store) {
 	storeContext, storeCancel := context.WithCancel(ctx)
 	defer storeCancel()
 
+	UpdatePsMetadata(storeContext, mtr)
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -136,6 +140,7 @@ func updateCPUPsMetrics(ctx context.Context, mtr repository.Store) {
 	}
 
 	for i, v := range cpuUtilization {
-		_ = mtr.UpdateGaugeMetric(ctx, fmt.Sprintf("CPUutilization%d", i+1), metrics.Gauge(v))
+		cpuLabels := metrics.Labels{"cpu": strconv.Itoa(i + 1)}
+		_ = mtr.UpdateGaugeMetric(ctx, metrics.Key("CPUutilization", cpuLabels), metrics.Gauge(v))
 	}
 }
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
@@ -16,6 +17,7 @@ import (
 	"github.com/itd27m01/go-metrics-service/internal/models/metrics"
 	pb "github.com/itd27m01/go-metrics-service/internal/proto" // import protobufs
 	"github.com/itd27m01/go-metrics-service/internal/repository"
+	"github.com/itd27m01/go-metrics-service/internal/tenant"
 	"github.com/itd27m01/go-metrics-service/pkg/encryption"
 	"github.com/itd27m01/go-metrics-service/pkg/logging/log"
 	"github.com/itd27m01/go-metrics-service/pkg/security"
@@ -31,6 +33,8 @@ type ReporterConfig struct {
 	ServerTimeout     time.Duration `yaml:"server_timeout" env:"SERVER_TIMEOUT"`
 	CryptoKey         string        `yaml:"crypto_key" env:"CRYPTO_KEY"`
 	SignKey           string        `yaml:"sign_key" env:"KEY"`
+	Tenant            string        `yaml:"tenant" env:"TENANT"`
+	TenantToken       string        `yaml:"tenant_token" env:"TENANT_TOKEN"`
 }
 
 // ReportWorker defines reporter worker object
@@ -45,6 +49,7 @@ func (rw *ReportWorker) Run(ctx context.Context, mtr repository.Store) {
 
 	httpClient := rw.getHTTPClient()
 	grpcClient, grpcConnection := rw.getGRPCClient()
+	ctx = tenant.NewOutgoingContext(ctx, rw.Cfg.Tenant, rw.Cfg.TenantToken)
 
 	serverHTTPURL := rw.Cfg.ServerScheme + "://" + rw.Cfg.ServerAddress
 	sendHTTPURL := serverHTTPURL + rw.Cfg.ServerPath
@@ -61,6 +66,8 @@ func (rw *ReportWorker) Run(ctx context.Context, mtr repository.Store) {
 			SendHTTPReportJSON(ctx, mtr, sendHTTPURL, httpClient, rw.Cfg.SignKey)
 			SendHTTPBatchJSON(ctx, mtr, serverHTTPURL, httpClient)
 			SendGRPCReport(ctx, mtr, grpcClient)
+			SendHTTPMetadata(ctx, mtr, serverHTTPURL, httpClient)
+			SendGRPCMetadata(ctx, mtr, grpcClient)
 			resetCounters(ctx, mtr)
 		}
 	}
@@ -76,6 +83,7 @@ func (rw *ReportWorker) getHTTPClient() *http.Client {
 	transport := http.DefaultTransport
 	transport = encryption.NewEncryptRoundTripper(transport, publicKey)
 	transport = security.NewRealIPRoundTripper(transport)
+	transport = tenant.NewRoundTripper(transport, rw.Cfg.Tenant, rw.Cfg.TenantToken)
 	return &http.Client{
 		Timeout:   rw.Cfg.ServerTimeout,
 		Transport: transport,
@@ -121,15 +129,19 @@ func SendGRPCReport(ctx context.Context, mtr repository.Store, client pb.Metrics
 	for _, v := range metricsMap {
 		request := pb.UpdateMetricRequest{
 			Metric: &pb.Metric{
-				ID:   v.ID,
-				Type: v.MType,
-				Hash: v.Hash,
+				ID:     v.ID,
+				Type:   v.MType,
+				Hash:   v.Hash,
+				Labels: v.Labels,
 			},
 		}
-		if v.MType == metrics.MetricTypeGauge {
+		switch v.MType {
+		case metrics.MetricTypeGauge:
 			request.Metric.Value = float32(*v.Value)
-		} else {
+		case metrics.MetricTypeCounter:
 			request.Metric.Delta = int64(*v.Delta)
+		case metrics.MetricTypeHistogram:
+			request.Metric.Histogram = pb.NewHistogram(v.Histogram)
 		}
 		if err := stream.Send(&request); err != nil {
 			log.Error().Err(err).Msgf("Failed to send metric %s", v.ID)
@@ -137,6 +149,52 @@ func SendGRPCReport(ctx context.Context, mtr repository.Store, client pb.Metrics
 	}
 }
 
+// SendGRPCMetadata gets metadata of metrics from underlying storage and sends it to the server
+func SendGRPCMetadata(ctx context.Context, mtr repository.Store, client pb.MetricsClient) {
+	getContext, getCancel := context.WithTimeout(ctx, pollTimeout)
+	defer getCancel()
+
+	metadata, err := mtr.GetMetadata(getContext)
+	if err != nil {
+		log.Error().Err(err).Msg("Some error occurred during metadata get")
+	}
+
+	if len(metadata) == 0 {
+		return
+	}
+
+	resp, err := client.UpdateMetadata(ctx, &pb.UpdateMetadataRequest{Metadata: pb.NewMetadata(metadata)})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to send metadata of metrics")
+		return
+	}
+	if resp.Error != "" {
+		log.Error().Msgf("Failed to update metadata of metrics: %s", resp.Error)
+	}
+}
+
+// SendHTTPMetadata gets metadata of metrics from underlying storage and sends it as a json object by names
+func SendHTTPMetadata(ctx context.Context, mtr repository.Store, serverURL string, client *http.Client) {
+	getContext, getCancel := context.WithTimeout(ctx, pollTimeout)
+	defer getCancel()
+
+	metadata, err := mtr.GetMetadata(getContext)
+	if err != nil {
+		log.Error().Err(err).Msg("Some error occurred during metadata get")
+	}
+
+	if len(metadata) == 0 {
+		return
+	}
+
+	serverURL = strings.TrimSuffix(serverURL, "/")
+	updateURL := fmt.Sprintf("%s/metadata/", serverURL)
+
+	if err := sendHTTPJSON(ctx, updateURL, client, metadata); err != nil {
+		log.Error().Err(err).Msg("Filed to send metadata of metrics")
+	}
+}
+
 // SendHTTPReport makes work for sending each metric in url params
 func SendHTTPReport(ctx context.Context, mtr repository.Store, serverURL string, client *http.Client) {
 	getContext, getCancel := context.WithTimeout(ctx, pollTimeout)
@@ -151,12 +209,17 @@ func SendHTTPReport(ctx context.Context, mtr repository.Store, serverURL string,
 	var stringifyMetricValue string
 
 	for _, v := range metricsMap {
-		if v.MType == metrics.MetricTypeGauge {
+		switch v.MType {
+		case metrics.MetricTypeGauge:
 			stringifyMetricValue = fmt.Sprintf("%f", *v.Value)
-		} else {
+		case metrics.MetricTypeCounter:
 			stringifyMetricValue = fmt.Sprintf("%d", *v.Delta)
+		default:
+			log.Error().Msgf("Metric %s of type %s can't be sent in url params", v.ID, v.MType)
+
+			continue
 		}
-		metricUpdateURL := fmt.Sprintf("%s/%s/%s/%s", serverURL, v.MType, v.ID, stringifyMetricValue)
+		metricUpdateURL := fmt.Sprintf("%s/%s/%s/%s", serverURL, v.MType, url.PathEscape(v.Key()), stringifyMetricValue)
 		err := sendHTTPMetric(ctx, metricUpdateURL, client)
 		if err != nil {
 			log.Error().Err(err).Msgf("Failed to send metric %s", v.ID)
@@ -273,13 +336,17 @@ func sendHTTPMetricJSON(ctx context.Context, serverURL string,
 
 // sendHTTPBatchJSON reports to the server a batch of metrics
 func sendHTTPBatchJSON(ctx context.Context, metricsUpdateURL string, client *http.Client, metrics []*metrics.Metric) error {
+	return sendHTTPJSON(ctx, metricsUpdateURL, client, metrics)
+}
 
-	encodedMetrics, err := json.Marshal(metrics)
+// sendHTTPJSON reports to the server data encoded in json
+func sendHTTPJSON(ctx context.Context, updateURL string, client *http.Client, data interface{}) error {
+	encodedData, err := json.Marshal(data)
 	if err != nil {
 		return err
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, metricsUpdateURL, bytes.NewBuffer(encodedMetrics))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, updateURL, bytes.NewBuffer(encodedData))
 	if err != nil {
 		return err
 	}
@@ -107,3 +107,30 @@ func TestSendReportJSON(t *testing.T) {
 
 	agent.SendHTTPReportJSON(context.Background(), mtr, server.URL, server.Client(), "")
 }
+
+func TestSendHTTPMetadata(t *testing.T) {
+	mtr := repository.NewInMemoryStore()
+	agent.UpdateMemStatsMetadata(context.Background(), mtr)
+
+	var received map[string]*metrics.Metadata
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/metadata/" {
+			t.Errorf("Unexpected path of metadata update: %s", r.URL.Path)
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			http.Error(w, fmt.Sprintf("Cannot decode provided data: %q", err), http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	agent.SendHTTPMetadata(context.Background(), mtr, server.URL, server.Client())
+
+	metadata, _ := mtr.GetMetadata(context.Background())
+	if len(received) == 0 || len(received) != len(metadata) {
+		t.Errorf("Metadata of %d metrics is sent, want %d", len(received), len(metadata))
+	}
+	if alloc := received["Alloc"]; alloc == nil || alloc.Unit != "bytes" || alloc.HelpURL == "" {
+		t.Errorf("Metadata of Alloc mismatch: %+v", alloc)
+	}
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/itd27m01/go-metrics-service/internal/server/http"
 	"github.com/itd27m01/go-metrics-service/internal/server/storage"
+	"github.com/itd27m01/go-metrics-service/internal/tenant"
 
 	"github.com/caarlos0/env/v6"
 	"gopkg.in/yaml.v3"
@@ -24,11 +25,13 @@ type Config struct {
 }
 
 type ServerConfig struct {
-	HTTPConfig    http.Config    `yaml:"http"`
-	GRPCConfig    grpc.Config    `yaml:"grpc"`
-	StorageConfig storage.Config `yaml:"storage"`
-	SignKey       string         `yaml:"sign_key" env:"KEY"`
-	LogLevel      string         `yaml:"log_level" env:"LOG_LEVEL"`
+	HTTPConfig    http.Config     `yaml:"http"`
+	GRPCConfig    grpc.Config     `yaml:"grpc"`
+	StorageConfig storage.Config  `yaml:"storage"`
+	SignKey       string          `yaml:"sign_key" env:"KEY"`
+	AdminToken    string          `yaml:"admin_token" env:"ADMIN_TOKEN"`
+	Tenants       []tenant.Config `yaml:"tenants"`
+	LogLevel      string          `yaml:"log_level" env:"LOG_LEVEL"`
 }
 
 // ParseConfig parses config from file
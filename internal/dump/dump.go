@@ -0,0 +1,227 @@
+// Package dump provides portable dumps of metrics to move them between storage backends
+package dump
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/itd27m01/go-metrics-service/internal/models/metrics"
+	"github.com/itd27m01/go-metrics-service/internal/repository"
+)
+
+// Formats of dump: JSON is a single document with the list of records, NDJSON is a record per line
+const (
+	FormatJSON   = "json"
+	FormatNDJSON = "ndjson"
+)
+
+// Version is the version of JSON dump document
+const Version = 1
+
+// Errors of dumps
+var (
+	ErrUnsupportedFormat = errors.New("unsupported dump format")
+	ErrInvalidRecord     = errors.New("invalid dump record")
+)
+
+// Record defines dumped metric with its history and metadata of its name
+type Record struct {
+	Metric   *metrics.Metric   `json:"metric"`
+	Metadata *metrics.Metadata `json:"metadata,omitempty"`
+	History  []metrics.Sample  `json:"history,omitempty"`
+}
+
+// Document defines JSON dump
+type Document struct {
+	Version int       `json:"version"`
+	Metrics []*Record `json:"metrics"`
+}
+
+// Export writes all of metrics of the store with their history and metadata to the writer and returns their number,
+// metrics are ordered by their keys
+func Export(ctx context.Context, store repository.Store, w io.Writer, format string) (int, error) {
+	if format != FormatJSON && format != FormatNDJSON {
+		return 0, fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+	}
+
+	metricsData, err := store.GetMetrics(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	metadata, err := store.GetMetadata(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	metricKeys := make([]string, 0, len(metricsData))
+	for metricKey := range metricsData {
+		metricKeys = append(metricKeys, metricKey)
+	}
+	sort.Strings(metricKeys)
+
+	encoder := json.NewEncoder(w)
+	records := make([]*Record, 0, len(metricKeys))
+	for i, metricKey := range metricKeys {
+		metric := metricsData[metricKey]
+		history, err := exportHistory(ctx, store, metricKey, metric.MType)
+		if err != nil {
+			return i, err
+		}
+
+		record := Record{Metric: metric, Metadata: metadata[metric.ID], History: history}
+		if format == FormatNDJSON {
+			if err := encoder.Encode(&record); err != nil {
+				return i, err
+			}
+
+			continue
+		}
+
+		records = append(records, &record)
+	}
+
+	if format == FormatJSON {
+		encoder.SetIndent("", "  ")
+
+		return len(records), encoder.Encode(&Document{Version: Version, Metrics: records})
+	}
+
+	return len(metricKeys), nil
+}
+
+// exportHistory returns the whole history of metric if the store keeps it
+func exportHistory(ctx context.Context, store repository.Store, metricKey string, metricType string) ([]metrics.Sample, error) {
+	historyStore, ok := store.(repository.HistoryStore)
+	if !ok {
+		return nil, nil
+	}
+
+	history, err := historyStore.GetHistory(ctx, metricKey, metricType, time.Time{}, time.Now(), 0)
+	if errors.Is(err, repository.ErrHistoryDisabled) {
+		return nil, nil
+	}
+
+	return history, err
+}
+
+// Import reads metrics with their history from the reader to the store and returns their number.
+// Imported metrics replace stored ones, so counters and histograms are set to the dumped values instead of adding them.
+func Import(ctx context.Context, store repository.Store, r io.Reader, format string) (int, error) {
+	imported := 0
+	importNext := func(record *Record) error {
+		if err := importRecord(ctx, store, record); err != nil {
+			return err
+		}
+		imported++
+
+		return nil
+	}
+
+	switch format {
+	case FormatJSON:
+		var document Document
+		if err := json.NewDecoder(r).Decode(&document); err != nil {
+			return 0, err
+		}
+		if document.Version != Version {
+			return 0, fmt.Errorf("%w: unsupported version %d", ErrUnsupportedFormat, document.Version)
+		}
+
+		for _, record := range document.Metrics {
+			if err := importNext(record); err != nil {
+				return imported, err
+			}
+		}
+	case FormatNDJSON:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(nil, bufio.MaxScanTokenSize*64)
+		for line := 1; scanner.Scan(); line++ {
+			if len(scanner.Bytes()) == 0 {
+				continue
+			}
+
+			var record Record
+			if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+				return imported, fmt.Errorf("%w at line %d: %s", ErrInvalidRecord, line, err)
+			}
+			if err := importNext(&record); err != nil {
+				return imported, err
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return imported, err
+		}
+	default:
+		return 0, fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+	}
+
+	return imported, nil
+}
+
+// importRecord replaces metric of the store by the dumped one, sets its metadata and appends the dumped history
+func importRecord(ctx context.Context, store repository.Store, record *Record) error {
+	if err := validateRecord(record); err != nil {
+		return err
+	}
+
+	metric := record.Metric
+	metric.Hash = ""
+	metricKey := metric.Key()
+
+	err := store.DeleteMetric(ctx, metricKey, metric.MType)
+	if err != nil && !errors.Is(err, repository.ErrMetricNotFound) {
+		return err
+	}
+	if err := store.UpdateMetrics(ctx, []*metrics.Metric{metric}); err != nil {
+		return err
+	}
+	if !record.Metadata.IsEmpty() {
+		if err := store.SetMetadata(ctx, metric.ID, record.Metadata); err != nil {
+			return fmt.Errorf("%w %s: %s", ErrInvalidRecord, metricKey, err)
+		}
+	}
+
+	if len(record.History) == 0 {
+		return nil
+	}
+
+	historyStore, ok := store.(repository.HistoryStore)
+	if !ok {
+		return nil
+	}
+
+	err = historyStore.AppendSamples(ctx, metricKey, metric.MType, record.History...)
+	if errors.Is(err, repository.ErrHistoryDisabled) {
+		return nil
+	}
+
+	return err
+}
+
+// validateRecord checks that dumped metric has the value of its type
+func validateRecord(record *Record) error {
+	metric := record.Metric
+	if metric == nil || metric.ID == "" {
+		return fmt.Errorf("%w: metric without name", ErrInvalidRecord)
+	}
+
+	switch {
+	case metric.MType == metrics.MetricTypeGauge && metric.Value != nil:
+	case metric.MType == metrics.MetricTypeCounter && metric.Delta != nil:
+	case metric.MType == metrics.MetricTypeHistogram && metric.Histogram != nil:
+		if err := metric.Histogram.Validate(); err != nil {
+			return fmt.Errorf("%w %s: %s", ErrInvalidRecord, metric.Key(), err)
+		}
+	default:
+		return fmt.Errorf("%w %s: metric has no value of type %q", ErrInvalidRecord, metric.Key(), metric.MType)
+	}
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package dump
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/itd27m01/go-metrics-service/internal/models/metrics"
+	"github.com/itd27m01/go-metrics-service/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportImport(t *testing.T) {
+	for _, format := range []string{FormatJSON, FormatNDJSON} {
+		t.Run(format, func(t *testing.T) {
+			ctx := context.Background()
+			source := repository.NewInMemoryStoreWithHistory(time.Hour)
+			histogram := metrics.NewHistogram(1, 2)
+			histogram.Observe(1)
+			require.NoError(t, source.UpdateGaugeMetric(ctx, "Alloc", 1))
+			require.NoError(t, source.UpdateGaugeMetric(ctx, "Alloc", 2))
+			require.NoError(t, source.UpdateCounterMetric(ctx, `PollCount{agent="1"}`, 5))
+			require.NoError(t, source.UpdateHistogramMetric(ctx, "Latency", histogram))
+			require.NoError(t, source.SetMetadata(ctx, "PollCount", &metrics.Metadata{Unit: "count"}))
+
+			var buf bytes.Buffer
+			exported, err := Export(ctx, source, &buf, format)
+			require.NoError(t, err)
+			assert.Equal(t, 3, exported)
+
+			target := repository.NewInMemoryStoreWithHistory(time.Hour)
+			require.NoError(t, target.UpdateCounterMetric(ctx, `PollCount{agent="1"}`, 100))
+			require.NoError(t, target.UpdateHistogramMetric(ctx, "Latency", histogram))
+			dumped := buf.String()
+			for i := 0; i < 2; i++ {
+				imported, err := Import(ctx, target, strings.NewReader(dumped), format)
+				require.NoError(t, err)
+				assert.Equal(t, 3, imported)
+			}
+
+			want, err := source.GetMetrics(ctx)
+			require.NoError(t, err)
+			got, err := target.GetMetrics(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, want, got, "imported metrics must replace stored ones")
+
+			metadata, err := target.GetMetadata(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, map[string]*metrics.Metadata{"PollCount": {Unit: "count"}}, metadata,
+				"metadata must be imported")
+
+			history, err := target.GetHistory(ctx, "Alloc", metrics.MetricTypeGauge, time.Time{}, time.Now(), 0)
+			require.NoError(t, err)
+			require.GreaterOrEqual(t, len(history), 2, "history must be imported")
+			assert.Equal(t, metrics.Gauge(1), *history[0].Value)
+		})
+	}
+}
+
+func TestImport_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		dump    string
+		wantErr error
+	}{
+		{
+			name:    "Unsupported format",
+			format:  "xml",
+			wantErr: ErrUnsupportedFormat,
+		},
+		{
+			name:    "Unsupported version",
+			format:  FormatJSON,
+			dump:    `{"version":2,"metrics":[]}`,
+			wantErr: ErrUnsupportedFormat,
+		},
+		{
+			name:    "Metric without value",
+			format:  FormatNDJSON,
+			dump:    `{"metric":{"id":"Alloc","type":"gauge","delta":1}}`,
+			wantErr: ErrInvalidRecord,
+		},
+		{
+			name:    "Invalid metadata",
+			format:  FormatNDJSON,
+			dump:    `{"metric":{"id":"Alloc","type":"gauge","value":1},"metadata":{"help_url":"docs"}}`,
+			wantErr: ErrInvalidRecord,
+		},
+		{
+			name:    "Malformed record",
+			format:  FormatNDJSON,
+			dump:    "{\"metric\":{\"id\":\"Alloc\",\"type\":\"gauge\",\"value\":1}}\n{",
+			wantErr: ErrInvalidRecord,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Import(context.Background(), repository.NewInMemoryStore(), strings.NewReader(tt.dump), tt.format)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
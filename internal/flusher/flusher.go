@@ -0,0 +1,44 @@
+package flusher
+
+import (
+	"context"
+	"time"
+
+	"github.com/itd27m01/go-metrics-service/internal/repository"
+	"github.com/itd27m01/go-metrics-service/pkg/logging/log"
+)
+
+// Flusher defines worker to write dirty metrics of tiered store behind to the back store
+type Flusher struct {
+	store         *repository.TieredStore
+	flushInterval time.Duration
+}
+
+// NewFlusher creates flusher
+func NewFlusher(store *repository.TieredStore, flushInterval time.Duration) *Flusher {
+	f := Flusher{
+		store:         store,
+		flushInterval: flushInterval,
+	}
+
+	return &f
+}
+
+// RunFlusher runs flusher worker, the last flush on shutdown is up to the owner of store
+func (f *Flusher) RunFlusher(ctx context.Context) {
+	log.Info().Msgf("Flush metrics to database every %s", f.flushInterval)
+
+	flushTicker := time.NewTicker(f.flushInterval)
+	defer flushTicker.Stop()
+
+	for {
+		select {
+		case <-flushTicker.C:
+			if err := f.store.Flush(ctx); err != nil {
+				log.Error().Err(err).Msg("Something went wrong during metrics flush, changes are kept for the next flush")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
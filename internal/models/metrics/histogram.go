@@ -0,0 +1,153 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Errors for histograms
+var (
+	ErrInvalidHistogram         = errors.New("invalid histogram")
+	ErrHistogramBucketsMismatch = errors.New("histogram buckets mismatch")
+)
+
+// DefaultBuckets are upper bounds of histogram buckets used when buckets aren't configured
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Histogram defines distribution of observations by buckets.
+// Counts has a count for every bucket of Bounds and the last one for the implicit +Inf bucket,
+// counts aren't cumulative so the observation is counted in the only bucket.
+type Histogram struct {
+	Bounds []float64 `json:"bounds"` // Upper bounds of buckets in increasing order
+	Counts []Counter `json:"counts"` // Number of observations in each bucket
+	Sum    Gauge     `json:"sum"`    // Sum of all observations
+	Count  Counter   `json:"count"`  // Number of all observations
+}
+
+// NewHistogram creates empty histogram with buckets bounds, DefaultBuckets are used if bounds are empty
+func NewHistogram(bounds ...float64) *Histogram {
+	if len(bounds) == 0 {
+		bounds = DefaultBuckets
+	}
+
+	h := Histogram{
+		Bounds: make([]float64, len(bounds)),
+		Counts: make([]Counter, len(bounds)+1),
+	}
+	copy(h.Bounds, bounds)
+
+	return &h
+}
+
+// Observe adds the value to the histogram
+func (h *Histogram) Observe(value float64) {
+	i := 0
+	for i < len(h.Bounds) && value > h.Bounds[i] {
+		i++
+	}
+
+	h.Counts[i]++
+	h.Count++
+	h.Sum += Gauge(value)
+}
+
+// Validate checks that bounds are increasing and counts are consistent with them
+func (h *Histogram) Validate() error {
+	if h == nil {
+		return fmt.Errorf("%w: histogram is required", ErrInvalidHistogram)
+	}
+
+	if len(h.Counts) != len(h.Bounds)+1 {
+		return fmt.Errorf("%w: expected %d counts for %d bounds, got %d",
+			ErrInvalidHistogram, len(h.Bounds)+1, len(h.Bounds), len(h.Counts))
+	}
+
+	for i, bound := range h.Bounds {
+		if math.IsNaN(bound) || math.IsInf(bound, 0) {
+			return fmt.Errorf("%w: bound %g isn't finite", ErrInvalidHistogram, bound)
+		}
+		if i > 0 && bound <= h.Bounds[i-1] {
+			return fmt.Errorf("%w: bounds aren't increasing at %g", ErrInvalidHistogram, bound)
+		}
+	}
+
+	var count Counter
+	for _, c := range h.Counts {
+		if c < 0 {
+			return fmt.Errorf("%w: negative count %d", ErrInvalidHistogram, c)
+		}
+		count += c
+	}
+	if count != h.Count {
+		return fmt.Errorf("%w: count %d doesn't match counts of buckets %d", ErrInvalidHistogram, h.Count, count)
+	}
+
+	return nil
+}
+
+// Merge adds observations of other histogram with the same buckets to the histogram
+func (h *Histogram) Merge(other *Histogram) error {
+	if err := other.Validate(); err != nil {
+		return err
+	}
+
+	if len(h.Bounds) != len(other.Bounds) {
+		return fmt.Errorf("%w: %v and %v", ErrHistogramBucketsMismatch, h.Bounds, other.Bounds)
+	}
+	for i := range h.Bounds {
+		if h.Bounds[i] != other.Bounds[i] {
+			return fmt.Errorf("%w: %v and %v", ErrHistogramBucketsMismatch, h.Bounds, other.Bounds)
+		}
+	}
+
+	for i := range h.Counts {
+		h.Counts[i] += other.Counts[i]
+	}
+	h.Sum += other.Sum
+	h.Count += other.Count
+
+	return nil
+}
+
+// Copy returns deep copy of the histogram
+func (h *Histogram) Copy() *Histogram {
+	c := Histogram{
+		Bounds: make([]float64, len(h.Bounds)),
+		Counts: make([]Counter, len(h.Counts)),
+		Sum:    h.Sum,
+		Count:  h.Count,
+	}
+	copy(c.Bounds, h.Bounds)
+	copy(c.Counts, h.Counts)
+
+	return &c
+}
+
+// String implements stringer interface for histogram, e.g. count=3 sum=1.5 buckets=[0.1:1 1:2 +Inf:0]
+func (h *Histogram) String() string {
+	var sb strings.Builder
+
+	sb.WriteString("count=")
+	sb.WriteString(strconv.FormatInt(int64(h.Count), 10))
+	sb.WriteString(" sum=")
+	sb.WriteString(strconv.FormatFloat(float64(h.Sum), 'g', -1, 64))
+	sb.WriteString(" buckets=[")
+	for i, count := range h.Counts {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		if i < len(h.Bounds) {
+			sb.WriteString(strconv.FormatFloat(h.Bounds[i], 'g', -1, 64))
+		} else {
+			sb.WriteString("+Inf")
+		}
+		sb.WriteByte(':')
+		sb.WriteString(strconv.FormatInt(int64(count), 10))
+	}
+	sb.WriteByte(']')
+
+	return sb.String()
+}
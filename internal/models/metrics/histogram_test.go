@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogram_Observe(t *testing.T) {
+	h := NewHistogram(0.1, 1)
+
+	for _, value := range []float64{0.05, 0.1, 0.5, 1, 5} {
+		h.Observe(value)
+	}
+
+	assert.Equal(t, []Counter{2, 2, 1}, h.Counts)
+	assert.Equal(t, Counter(5), h.Count)
+	assert.InDelta(t, 6.65, float64(h.Sum), 1e-9)
+	assert.NoError(t, h.Validate())
+	assert.Equal(t, DefaultBuckets, NewHistogram().Bounds)
+}
+
+func TestHistogram_Validate(t *testing.T) {
+	tests := []struct {
+		name      string
+		histogram *Histogram
+		wantErr   bool
+	}{
+		{
+			name:      "Valid histogram",
+			histogram: &Histogram{Bounds: []float64{1, 2}, Counts: []Counter{1, 0, 2}, Sum: 10, Count: 3},
+		},
+		{
+			name:      "Valid histogram with the only +Inf bucket",
+			histogram: &Histogram{Counts: []Counter{1}, Sum: 10, Count: 1},
+		},
+		{
+			name:    "Nil histogram",
+			wantErr: true,
+		},
+		{
+			name:      "Counts without +Inf bucket",
+			histogram: &Histogram{Bounds: []float64{1, 2}, Counts: []Counter{1, 2}, Count: 3},
+			wantErr:   true,
+		},
+		{
+			name:      "Bounds aren't increasing",
+			histogram: &Histogram{Bounds: []float64{2, 1}, Counts: []Counter{1, 0, 2}, Count: 3},
+			wantErr:   true,
+		},
+		{
+			name:      "Count doesn't match buckets",
+			histogram: &Histogram{Bounds: []float64{1}, Counts: []Counter{1, 1}, Count: 3},
+			wantErr:   true,
+		},
+		{
+			name:      "Negative count of bucket",
+			histogram: &Histogram{Bounds: []float64{1}, Counts: []Counter{-1, 1}, Count: 0},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.histogram.Validate()
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidHistogram)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestHistogram_Merge(t *testing.T) {
+	h := &Histogram{Bounds: []float64{1, 2}, Counts: []Counter{1, 0, 2}, Sum: 10, Count: 3}
+
+	assert.NoError(t, h.Merge(&Histogram{Bounds: []float64{1, 2}, Counts: []Counter{0, 1, 0}, Sum: 1.5, Count: 1}))
+	assert.Equal(t, &Histogram{Bounds: []float64{1, 2}, Counts: []Counter{1, 1, 2}, Sum: 11.5, Count: 4}, h)
+
+	assert.ErrorIs(t, h.Merge(&Histogram{Bounds: []float64{1, 3}, Counts: []Counter{0, 1, 0}, Count: 1}),
+		ErrHistogramBucketsMismatch)
+	assert.ErrorIs(t, h.Merge(&Histogram{Bounds: []float64{1}, Counts: []Counter{0, 1}, Count: 1}),
+		ErrHistogramBucketsMismatch)
+	assert.ErrorIs(t, h.Merge(nil), ErrInvalidHistogram)
+	assert.Equal(t, Counter(4), h.Count)
+}
+
+func TestHistogram_String(t *testing.T) {
+	h := &Histogram{Bounds: []float64{0.1, 1}, Counts: []Counter{1, 2, 0}, Sum: 1.5, Count: 3}
+
+	assert.Equal(t, "count=3 sum=1.5 buckets=[0.1:1 1:2 +Inf:0]", h.String())
+}
@@ -0,0 +1,279 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Special label names
+const (
+	// NameLabel matches the name of metric in selectors
+	NameLabel = "__name__"
+	// TenantLabel scopes storage keys of metrics and metadata by tenant, it's hidden from tenants
+	TenantLabel = "__tenant__"
+)
+
+// Errors for labels and selectors
+var (
+	ErrInvalidSelector = errors.New("invalid metric selector")
+)
+
+// Labels defines dimensions of the metric
+type Labels map[string]string
+
+// String renders labels in canonical form {name="value",...} ordered by label name
+func (l Labels) String() string {
+	if len(l) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(l))
+	for name := range l {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(name)
+		sb.WriteByte('=')
+		sb.WriteString(strconv.Quote(l[name]))
+	}
+	sb.WriteByte('}')
+
+	return sb.String()
+}
+
+// Key builds unique key of the metric from its name and labels, e.g. CPUutilization{cpu="3"}
+func Key(name string, labels Labels) string {
+	return name + labels.String()
+}
+
+// ParseKey splits key of the metric to the name and labels
+func ParseKey(key string) (string, Labels, error) {
+	if !strings.ContainsRune(key, '{') {
+		return key, nil, nil
+	}
+
+	matchers, err := ParseSelector(key)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var name string
+	labels := make(Labels, len(matchers))
+	for _, matcher := range matchers {
+		if matcher.Type != MatchEqual {
+			return "", nil, fmt.Errorf("%w: only equality is allowed in metric key %s", ErrInvalidSelector, key)
+		}
+
+		if matcher.Name == NameLabel {
+			name = matcher.Value
+
+			continue
+		}
+
+		labels[matcher.Name] = matcher.Value
+	}
+
+	if len(labels) == 0 {
+		labels = nil
+	}
+
+	return name, labels, nil
+}
+
+// MatchType defines type of label matching
+type MatchType int
+
+// Types of label matching
+const (
+	MatchEqual MatchType = iota
+	MatchNotEqual
+	MatchRegexp
+	MatchNotRegexp
+)
+
+// String implements stringer interface for match type
+func (t MatchType) String() string {
+	switch t {
+	case MatchEqual:
+		return "="
+	case MatchNotEqual:
+		return "!="
+	case MatchRegexp:
+		return "=~"
+	case MatchNotRegexp:
+		return "!~"
+	default:
+		return ""
+	}
+}
+
+// Matcher matches the label of metric against the value
+type Matcher struct {
+	Type  MatchType
+	Name  string
+	Value string
+	re    *regexp.Regexp
+}
+
+// NewMatcher creates matcher, regexps are anchored to the whole value of label
+func NewMatcher(matchType MatchType, name string, value string) (*Matcher, error) {
+	m := Matcher{
+		Type:  matchType,
+		Name:  name,
+		Value: value,
+	}
+
+	if matchType == MatchRegexp || matchType == MatchNotRegexp {
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidSelector, err)
+		}
+		m.re = re
+	}
+
+	return &m, nil
+}
+
+// NewPrefixMatcher creates matcher of metrics which names start with the prefix
+func NewPrefixMatcher(prefix string) *Matcher {
+	value := regexp.QuoteMeta(prefix) + ".*"
+
+	return &Matcher{
+		Type:  MatchRegexp,
+		Name:  NameLabel,
+		Value: value,
+		re:    regexp.MustCompile("^(?:" + value + ")$"),
+	}
+}
+
+// Matches checks if metric satisfies the matcher, absent label has empty value
+func (m *Matcher) Matches(metric *Metric) bool {
+	value := metric.Labels[m.Name]
+	if m.Name == NameLabel {
+		value = metric.ID
+	}
+
+	switch m.Type {
+	case MatchEqual:
+		return value == m.Value
+	case MatchNotEqual:
+		return value != m.Value
+	case MatchRegexp:
+		return m.re.MatchString(value)
+	case MatchNotRegexp:
+		return !m.re.MatchString(value)
+	default:
+		return false
+	}
+}
+
+// String implements stringer interface for matcher
+func (m *Matcher) String() string {
+	return m.Name + m.Type.String() + strconv.Quote(m.Value)
+}
+
+// MatchesAll checks if metric satisfies all of matchers
+func MatchesAll(metric *Metric, matchers []*Matcher) bool {
+	for _, matcher := range matchers {
+		if !matcher.Matches(metric) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ParseSelector parses selector in name{label="value",label!="value",label=~"regexp",label!~"regexp"} form,
+// both the name and the labels part are optional
+func ParseSelector(selector string) ([]*Matcher, error) {
+	matchers := make([]*Matcher, 0)
+
+	name, rest := selector, ""
+	if i := strings.IndexByte(selector, '{'); i >= 0 {
+		name, rest = selector[:i], selector[i+1:]
+		if !strings.HasSuffix(rest, "}") {
+			return nil, fmt.Errorf("%w: unclosed labels in %s", ErrInvalidSelector, selector)
+		}
+		rest = rest[:len(rest)-1]
+	}
+
+	name = strings.TrimSpace(name)
+	if name != "" {
+		matchers = append(matchers, &Matcher{Type: MatchEqual, Name: NameLabel, Value: name})
+	}
+
+	for rest = strings.TrimSpace(rest); rest != ""; rest = strings.TrimSpace(rest) {
+		matcher, tail, err := parseMatcher(rest)
+		if err != nil {
+			return nil, fmt.Errorf("%w in %s", err, selector)
+		}
+		matchers = append(matchers, matcher)
+
+		tail = strings.TrimSpace(tail)
+		if tail != "" && !strings.HasPrefix(tail, ",") {
+			return nil, fmt.Errorf("%w: expected comma in %s", ErrInvalidSelector, selector)
+		}
+		rest = strings.TrimPrefix(tail, ",")
+	}
+
+	return matchers, nil
+}
+
+// parseMatcher parses the first label matcher of the labels part and returns the rest of it
+func parseMatcher(labels string) (*Matcher, string, error) {
+	i := 0
+	for i < len(labels) && isLabelNameChar(labels[i], i) {
+		i++
+	}
+	if i == 0 {
+		return nil, "", fmt.Errorf("%w: expected label name", ErrInvalidSelector)
+	}
+	name, rest := labels[:i], strings.TrimSpace(labels[i:])
+
+	var matchType MatchType
+	switch {
+	case strings.HasPrefix(rest, "=~"):
+		matchType = MatchRegexp
+	case strings.HasPrefix(rest, "!~"):
+		matchType = MatchNotRegexp
+	case strings.HasPrefix(rest, "!="):
+		matchType = MatchNotEqual
+	case strings.HasPrefix(rest, "="):
+		matchType = MatchEqual
+	default:
+		return nil, "", fmt.Errorf("%w: expected operator after label %s", ErrInvalidSelector, name)
+	}
+	rest = strings.TrimSpace(rest[len(matchType.String()):])
+
+	quoted, err := strconv.QuotedPrefix(rest)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: expected quoted value of label %s", ErrInvalidSelector, name)
+	}
+	value, err := strconv.Unquote(quoted)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %s", ErrInvalidSelector, err)
+	}
+
+	matcher, err := NewMatcher(matchType, name, value)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return matcher, rest[len(quoted):], nil
+}
+
+// isLabelNameChar checks that char is allowed at the position of label name
+func isLabelNameChar(c byte, position int) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (position > 0 && c >= '0' && c <= '9')
+}
@@ -0,0 +1,170 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLabels_String(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels Labels
+		want   string
+	}{
+		{
+			name:   "No labels",
+			labels: nil,
+			want:   "",
+		},
+		{
+			name:   "Ordered labels",
+			labels: Labels{"host": "db1", "cpu": "3"},
+			want:   `{cpu="3",host="db1"}`,
+		},
+		{
+			name:   "Escaped value",
+			labels: Labels{"path": `C:\"tmp"`},
+			want:   `{path="C:\\\"tmp\""}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.labels.String())
+		})
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		key        string
+		wantName   string
+		wantLabels Labels
+		wantErr    bool
+	}{
+		{
+			name:     "Plain name",
+			key:      "Alloc",
+			wantName: "Alloc",
+		},
+		{
+			name:       "Name with labels",
+			key:        `CPUutilization{ host = "db1", cpu="3" }`,
+			wantName:   "CPUutilization",
+			wantLabels: Labels{"cpu": "3", "host": "db1"},
+		},
+		{
+			name:    "Regexp in key",
+			key:     `CPUutilization{cpu=~"3"}`,
+			wantErr: true,
+		},
+		{
+			name:    "Unclosed labels",
+			key:     `CPUutilization{cpu="3"`,
+			wantErr: true,
+		},
+		{
+			name:    "Unquoted value",
+			key:     `CPUutilization{cpu=3}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, labels, err := ParseKey(tt.key)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidSelector)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantName, name)
+			assert.Equal(t, tt.wantLabels, labels)
+		})
+	}
+}
+
+func TestParseSelector(t *testing.T) {
+	cpu1 := &Metric{ID: "CPUutilization", Labels: Labels{"cpu": "1", "host": "db1"}}
+	cpu2 := &Metric{ID: "CPUutilization", Labels: Labels{"cpu": "2"}}
+	alloc := &Metric{ID: "Alloc"}
+
+	tests := []struct {
+		name     string
+		selector string
+		want     []bool
+	}{
+		{
+			name:     "Empty selector",
+			selector: "",
+			want:     []bool{true, true, true},
+		},
+		{
+			name:     "By name",
+			selector: "CPUutilization",
+			want:     []bool{true, true, false},
+		},
+		{
+			name:     "By regexp",
+			selector: `CPUutilization{cpu=~"1|3"}`,
+			want:     []bool{true, false, false},
+		},
+		{
+			name:     "By absent label",
+			selector: `{host!="db1"}`,
+			want:     []bool{false, true, true},
+		},
+		{
+			name:     "By negative regexp",
+			selector: `{__name__!~"CPU.*"}`,
+			want:     []bool{false, false, true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matchers, err := ParseSelector(tt.selector)
+			require.NoError(t, err)
+
+			got := []bool{MatchesAll(cpu1, matchers), MatchesAll(cpu2, matchers), MatchesAll(alloc, matchers)}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestMetric_HashWithLabels(t *testing.T) {
+	gaugeValue := Gauge(96969.519)
+	m := &Metric{
+		ID:     "CPUutilization",
+		MType:  MetricTypeGauge,
+		Value:  &gaugeValue,
+		Labels: Labels{"cpu": "1"},
+	}
+	m.SetHash("test")
+
+	m.Labels = Labels{"cpu": "2"}
+	assert.False(t, m.IsHashValid("test"))
+}
+
+func TestNewPrefixMatcher(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		metric string
+		want   bool
+	}{
+		{name: "Prefix of name", prefix: "CPU", metric: "CPUutilization", want: true},
+		{name: "Whole name", prefix: "CPUutilization", metric: "CPUutilization", want: true},
+		{name: "Other name", prefix: "CPU", metric: "Alloc", want: false},
+		{name: "Quoted prefix", prefix: "cpu.", metric: "cpu.total", want: true},
+		{name: "Quoted prefix of other name", prefix: "cpu.", metric: "cpu_total", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metric := &Metric{ID: tt.metric, MType: MetricTypeGauge}
+			assert.Equal(t, tt.want, NewPrefixMatcher(tt.prefix).Matches(metric))
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// ErrInvalidMetadata means that metadata of metric can't be stored
+var ErrInvalidMetadata = errors.New("invalid metadata of metric")
+
+// Metadata describes what the metric measures, it's kept by the name of metric and shared by all of its labels
+type Metadata struct {
+	Description string `json:"description,omitempty"` // What the metric measures
+	Unit        string `json:"unit,omitempty"`        // Unit of the metric value, e.g. bytes or seconds
+	Owner       string `json:"owner,omitempty"`       // Team or person who is responsible for the metric
+	HelpURL     string `json:"help_url,omitempty"`    // Link to the documentation of the metric
+}
+
+// IsEmpty reports whether metadata has no fields set
+func (md *Metadata) IsEmpty() bool {
+	return md == nil || *md == Metadata{}
+}
+
+// Validate checks that help URL of metadata is an absolute http or https URL
+func (md *Metadata) Validate() error {
+	if md == nil || md.HelpURL == "" {
+		return nil
+	}
+
+	helpURL, err := url.Parse(md.HelpURL)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidMetadata, err)
+	}
+	if (helpURL.Scheme != "http" && helpURL.Scheme != "https") || helpURL.Host == "" {
+		return fmt.Errorf("%w: help URL %q must be absolute http or https URL", ErrInvalidMetadata, md.HelpURL)
+	}
+
+	return nil
+}
@@ -7,13 +7,15 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/itd27m01/go-metrics-service/pkg/logging/log"
 )
 
 const (
-	MetricTypeGauge   = "gauge"
-	MetricTypeCounter = "counter"
+	MetricTypeGauge     = "gauge"
+	MetricTypeCounter   = "counter"
+	MetricTypeHistogram = "histogram"
 )
 
 type Gauge float64
@@ -21,11 +23,51 @@ type Counter int64
 
 // Metric defines type for metric
 type Metric struct {
-	ID    string   `json:"id"`              // Metric name
-	MType string   `json:"type"`            // Type can be gauge or counter
-	Delta *Counter `json:"delta,omitempty"` // Metric value for counter
-	Value *Gauge   `json:"value,omitempty"` // Metric value for gauge
-	Hash  string   `json:"hash,omitempty"`  // Metric hash
+	ID        string     `json:"id"`                  // Metric name
+	MType     string     `json:"type"`                // Type can be gauge, counter or histogram
+	Delta     *Counter   `json:"delta,omitempty"`     // Metric value for counter
+	Value     *Gauge     `json:"value,omitempty"`     // Metric value for gauge
+	Histogram *Histogram `json:"histogram,omitempty"` // Metric value for histogram
+	Hash      string     `json:"hash,omitempty"`      // Metric hash
+	Labels    Labels     `json:"labels,omitempty"`    // Metric dimensions
+}
+
+// Sample defines the value of a metric at the moment of time
+type Sample struct {
+	Timestamp time.Time  `json:"timestamp"`           // Moment when the value was observed
+	Delta     *Counter   `json:"delta,omitempty"`     // Metric value for counter
+	Value     *Gauge     `json:"value,omitempty"`     // Metric value for gauge
+	Histogram *Histogram `json:"histogram,omitempty"` // Metric value for histogram
+}
+
+// Key returns unique key of the metric built from its name and labels
+func (m *Metric) Key() string {
+	return Key(m.ID, m.Labels)
+}
+
+// Copy returns deep copy of the metric which doesn't share values and labels with it
+func (m *Metric) Copy() *Metric {
+	c := *m
+
+	if m.Delta != nil {
+		delta := *(m.Delta)
+		c.Delta = &delta
+	}
+	if m.Value != nil {
+		value := *(m.Value)
+		c.Value = &value
+	}
+	if m.Histogram != nil {
+		c.Histogram = m.Histogram.Copy()
+	}
+	if m.Labels != nil {
+		c.Labels = make(Labels, len(m.Labels))
+		for k, v := range m.Labels {
+			c.Labels[k] = v
+		}
+	}
+
+	return &c
 }
 
 // EncodeMetric helps to encode the metric
@@ -58,14 +100,16 @@ func (m *Metric) IsHashValid(key string) bool {
 	return m.Hash == m.getHash(key)
 }
 
-// getHash calculates hash for metric by key
+// getHash calculates hash for metric by key, labels are signed as a part of the metric key
 func (m *Metric) getHash(key string) string {
 	var metricString string
 	switch m.MType {
 	case MetricTypeGauge:
-		metricString = fmt.Sprintf("%s:%s:%f", m.ID, MetricTypeGauge, *(m.Value))
+		metricString = fmt.Sprintf("%s:%s:%f", m.Key(), MetricTypeGauge, *(m.Value))
 	case MetricTypeCounter:
-		metricString = fmt.Sprintf("%s:%s:%d", m.ID, MetricTypeCounter, *(m.Delta))
+		metricString = fmt.Sprintf("%s:%s:%d", m.Key(), MetricTypeCounter, *(m.Delta))
+	case MetricTypeHistogram:
+		metricString = fmt.Sprintf("%s:%s:%s", m.Key(), MetricTypeHistogram, m.Histogram)
 	default:
 		log.Error().Msgf("unsupported metric type: %s", m.MType)
 	}
@@ -76,6 +120,25 @@ func (m *Metric) getHash(key string) string {
 	return hex.EncodeToString(mac.Sum(nil))
 }
 
+// Sample returns the current value of the metric as a sample taken at timestamp
+func (m *Metric) Sample(timestamp time.Time) Sample {
+	sample := Sample{Timestamp: timestamp}
+
+	if m.Value != nil {
+		value := *(m.Value)
+		sample.Value = &value
+	}
+	if m.Delta != nil {
+		delta := *(m.Delta)
+		sample.Delta = &delta
+	}
+	if m.Histogram != nil {
+		sample.Histogram = m.Histogram.Copy()
+	}
+
+	return sample
+}
+
 // String implements stringer interface for metric
 func (m *Metric) String() string {
 	switch m.MType {
@@ -83,6 +146,8 @@ func (m *Metric) String() string {
 		return fmt.Sprintf("%g", *(m.Value))
 	case MetricTypeCounter:
 		return fmt.Sprintf("%d", *(m.Delta))
+	case MetricTypeHistogram:
+		return m.Histogram.String()
 	default:
 		return ""
 	}
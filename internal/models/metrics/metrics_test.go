@@ -102,3 +102,31 @@ func TestMetric_IsHashValid(t *testing.T) {
 		})
 	}
 }
+
+func TestMetric_Copy(t *testing.T) {
+	delta := Counter(1)
+	value := Gauge(2)
+	histogram := NewHistogram(1, 2)
+	histogram.Observe(1)
+	metric := Metric{
+		ID:        "TestMetric",
+		MType:     MetricTypeCounter,
+		Delta:     &delta,
+		Value:     &value,
+		Histogram: histogram,
+		Labels:    Labels{"agent": "1"},
+	}
+
+	got := metric.Copy()
+	if !reflect.DeepEqual(got, &metric) {
+		t.Fatalf("Copy() = %v, want %v", got, metric)
+	}
+
+	*got.Delta = 10
+	*got.Value = 20
+	got.Histogram.Observe(2)
+	got.Labels["agent"] = "2"
+	if delta != 1 || value != 2 || histogram.Count != 1 || metric.Labels["agent"] != "1" {
+		t.Errorf("Copy() shares data with original metric: %v", metric)
+	}
+}
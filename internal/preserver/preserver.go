@@ -8,11 +8,16 @@ import (
 	"github.com/itd27m01/go-metrics-service/pkg/logging/log"
 )
 
-// Preserver defines worker to preserve the metrics in file store
+// Preserver defines worker to preserve the metrics in file store,
+// every save compacts the write-ahead log of store into a fresh snapshot.
+// Compressed snapshots are archived on the interval if archive is enabled.
 type Preserver struct {
-	store         *repository.FileStore
-	storeInterval time.Duration
-	syncChannel   chan struct{}
+	store           *repository.FileStore
+	storeInterval   time.Duration
+	syncChannel     chan struct{}
+	archiveDir      string
+	archiveInterval time.Duration
+	retention       repository.Retention
 }
 
 // NewPreserver creates preserver
@@ -26,6 +31,14 @@ func NewPreserver(store *repository.FileStore, storeInterval time.Duration, sync
 	return &p
 }
 
+// EnableArchive enables archive of snapshots in the directory on the interval,
+// archived snapshots which aren't kept by retention are removed after every archiving
+func (p *Preserver) EnableArchive(archiveDir string, archiveInterval time.Duration, retention repository.Retention) {
+	p.archiveDir = archiveDir
+	p.archiveInterval = archiveInterval
+	p.retention = retention
+}
+
 // RunPreserver runs preserver worker
 func (p *Preserver) RunPreserver(ctx context.Context) {
 	log.Info().Msg("Run preserver for metrics")
@@ -38,12 +51,22 @@ func (p *Preserver) RunPreserver(ctx context.Context) {
 	}
 	defer pollTicker.Stop()
 
+	archiveTicker := new(time.Ticker)
+	if p.archiveDir != "" && p.archiveInterval > 0 {
+		archiveTicker = time.NewTicker(p.archiveInterval)
+
+		log.Info().Msgf("Archive metrics to %s every %s", p.archiveDir, p.archiveInterval)
+	}
+	defer archiveTicker.Stop()
+
 	var err error
 	const errMessage = "Something went wrong during metrics preserve"
 	for {
 		select {
 		case <-pollTicker.C:
 			err = p.store.SaveMetrics()
+		case <-archiveTicker.C:
+			err = p.archive()
 		case <-p.syncChannel:
 			if p.storeInterval == 0 {
 				err = p.store.SaveMetrics()
@@ -62,3 +85,22 @@ func (p *Preserver) RunPreserver(ctx context.Context) {
 		}
 	}
 }
+
+// archive archives snapshot of metrics and removes archived snapshots which aren't kept by retention
+func (p *Preserver) archive() error {
+	now := time.Now()
+
+	archivePath, err := p.store.ArchiveMetrics(p.archiveDir, now)
+	if err != nil {
+		return err
+	}
+
+	log.Info().Msgf("Archived metrics to %s", archivePath)
+
+	removed, err := p.store.PruneArchive(p.archiveDir, &p.retention, now)
+	if removed > 0 {
+		log.Info().Msgf("Removed %d archived snapshots by retention", removed)
+	}
+
+	return err
+}
@@ -0,0 +1,43 @@
+package proto
+
+import "github.com/itd27m01/go-metrics-service/internal/models/metrics"
+
+// NewHistogram converts histogram of metric model to the message, nil histogram is converted to nil
+func NewHistogram(histogram *metrics.Histogram) *Histogram {
+	if histogram == nil {
+		return nil
+	}
+
+	message := Histogram{
+		Bounds: make([]float64, len(histogram.Bounds)),
+		Counts: make([]int64, len(histogram.Counts)),
+		Sum:    float64(histogram.Sum),
+		Count:  int64(histogram.Count),
+	}
+	copy(message.Bounds, histogram.Bounds)
+	for i, count := range histogram.Counts {
+		message.Counts[i] = int64(count)
+	}
+
+	return &message
+}
+
+// ToModel converts message to histogram of metric model, nil message is converted to nil
+func (x *Histogram) ToModel() *metrics.Histogram {
+	if x == nil {
+		return nil
+	}
+
+	histogram := metrics.Histogram{
+		Bounds: make([]float64, len(x.Bounds)),
+		Counts: make([]metrics.Counter, len(x.Counts)),
+		Sum:    metrics.Gauge(x.Sum),
+		Count:  metrics.Counter(x.Count),
+	}
+	copy(histogram.Bounds, x.Bounds)
+	for i, count := range x.Counts {
+		histogram.Counts[i] = metrics.Counter(count)
+	}
+
+	return &histogram
+}
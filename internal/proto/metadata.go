@@ -0,0 +1,32 @@
+package proto
+
+import "github.com/itd27m01/go-metrics-service/internal/models/metrics"
+
+// NewMetadata converts metadata of metrics model by names of metrics to the messages
+func NewMetadata(metadata map[string]*metrics.Metadata) map[string]*Metadata {
+	messages := make(map[string]*Metadata, len(metadata))
+	for name, md := range metadata {
+		messages[name] = &Metadata{
+			Description: md.Description,
+			Unit:        md.Unit,
+			Owner:       md.Owner,
+			HelpURL:     md.HelpURL,
+		}
+	}
+
+	return messages
+}
+
+// ToModel converts message to metadata of metric model, nil message is converted to empty metadata
+func (x *Metadata) ToModel() *metrics.Metadata {
+	if x == nil {
+		return &metrics.Metadata{}
+	}
+
+	return &metrics.Metadata{
+		Description: x.Description,
+		Unit:        x.Unit,
+		Owner:       x.Owner,
+		HelpURL:     x.HelpURL,
+	}
+}
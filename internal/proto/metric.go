@@ -0,0 +1,24 @@
+package proto
+
+import "github.com/itd27m01/go-metrics-service/internal/models/metrics"
+
+// NewMetric converts metric model to the message, only the value of its type is set
+func NewMetric(metric *metrics.Metric) *Metric {
+	message := Metric{
+		ID:     metric.ID,
+		Type:   metric.MType,
+		Hash:   metric.Hash,
+		Labels: metric.Labels,
+	}
+
+	switch {
+	case metric.Value != nil:
+		message.Value = float32(*metric.Value)
+	case metric.Delta != nil:
+		message.Delta = int64(*metric.Delta)
+	case metric.Histogram != nil:
+		message.Histogram = NewHistogram(metric.Histogram)
+	}
+
+	return &message
+}
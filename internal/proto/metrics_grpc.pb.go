@@ -23,6 +23,13 @@ const _ = grpc.SupportPackageIsVersion7
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type MetricsClient interface {
 	UpdateMetrics(ctx context.Context, opts ...grpc.CallOption) (Metrics_UpdateMetricsClient, error)
+	GetHistory(ctx context.Context, in *GetHistoryRequest, opts ...grpc.CallOption) (*GetHistoryResponse, error)
+	DeleteMetrics(ctx context.Context, in *DeleteMetricsRequest, opts ...grpc.CallOption) (*DeleteMetricsResponse, error)
+	UpdateMetadata(ctx context.Context, in *UpdateMetadataRequest, opts ...grpc.CallOption) (*UpdateMetadataResponse, error)
+	GetMetadata(ctx context.Context, in *GetMetadataRequest, opts ...grpc.CallOption) (*GetMetadataResponse, error)
+	AddGauge(ctx context.Context, in *AddGaugeRequest, opts ...grpc.CallOption) (*AddGaugeResponse, error)
+	CompareAndSetGauge(ctx context.Context, in *CompareAndSetGaugeRequest, opts ...grpc.CallOption) (*CompareAndSetGaugeResponse, error)
+	ListMetrics(ctx context.Context, in *ListMetricsRequest, opts ...grpc.CallOption) (*ListMetricsResponse, error)
 }
 
 type metricsClient struct {
@@ -67,11 +74,81 @@ func (x *metricsUpdateMetricsClient) CloseAndRecv() (*UpdateMetricResponse, erro
 	return m, nil
 }
 
+func (c *metricsClient) GetHistory(ctx context.Context, in *GetHistoryRequest, opts ...grpc.CallOption) (*GetHistoryResponse, error) {
+	out := new(GetHistoryResponse)
+	err := c.cc.Invoke(ctx, "/proto.Metrics/GetHistory", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metricsClient) DeleteMetrics(ctx context.Context, in *DeleteMetricsRequest, opts ...grpc.CallOption) (*DeleteMetricsResponse, error) {
+	out := new(DeleteMetricsResponse)
+	err := c.cc.Invoke(ctx, "/proto.Metrics/DeleteMetrics", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metricsClient) UpdateMetadata(ctx context.Context, in *UpdateMetadataRequest, opts ...grpc.CallOption) (*UpdateMetadataResponse, error) {
+	out := new(UpdateMetadataResponse)
+	err := c.cc.Invoke(ctx, "/proto.Metrics/UpdateMetadata", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metricsClient) GetMetadata(ctx context.Context, in *GetMetadataRequest, opts ...grpc.CallOption) (*GetMetadataResponse, error) {
+	out := new(GetMetadataResponse)
+	err := c.cc.Invoke(ctx, "/proto.Metrics/GetMetadata", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metricsClient) AddGauge(ctx context.Context, in *AddGaugeRequest, opts ...grpc.CallOption) (*AddGaugeResponse, error) {
+	out := new(AddGaugeResponse)
+	err := c.cc.Invoke(ctx, "/proto.Metrics/AddGauge", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metricsClient) CompareAndSetGauge(ctx context.Context, in *CompareAndSetGaugeRequest, opts ...grpc.CallOption) (*CompareAndSetGaugeResponse, error) {
+	out := new(CompareAndSetGaugeResponse)
+	err := c.cc.Invoke(ctx, "/proto.Metrics/CompareAndSetGauge", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metricsClient) ListMetrics(ctx context.Context, in *ListMetricsRequest, opts ...grpc.CallOption) (*ListMetricsResponse, error) {
+	out := new(ListMetricsResponse)
+	err := c.cc.Invoke(ctx, "/proto.Metrics/ListMetrics", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // MetricsServer is the server API for Metrics service.
 // All implementations must embed UnimplementedMetricsServer
 // for forward compatibility
 type MetricsServer interface {
 	UpdateMetrics(Metrics_UpdateMetricsServer) error
+	GetHistory(context.Context, *GetHistoryRequest) (*GetHistoryResponse, error)
+	DeleteMetrics(context.Context, *DeleteMetricsRequest) (*DeleteMetricsResponse, error)
+	UpdateMetadata(context.Context, *UpdateMetadataRequest) (*UpdateMetadataResponse, error)
+	GetMetadata(context.Context, *GetMetadataRequest) (*GetMetadataResponse, error)
+	AddGauge(context.Context, *AddGaugeRequest) (*AddGaugeResponse, error)
+	CompareAndSetGauge(context.Context, *CompareAndSetGaugeRequest) (*CompareAndSetGaugeResponse, error)
+	ListMetrics(context.Context, *ListMetricsRequest) (*ListMetricsResponse, error)
 	mustEmbedUnimplementedMetricsServer()
 }
 
@@ -82,6 +159,27 @@ type UnimplementedMetricsServer struct {
 func (UnimplementedMetricsServer) UpdateMetrics(Metrics_UpdateMetricsServer) error {
 	return status.Errorf(codes.Unimplemented, "method UpdateMetrics not implemented")
 }
+func (UnimplementedMetricsServer) GetHistory(context.Context, *GetHistoryRequest) (*GetHistoryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetHistory not implemented")
+}
+func (UnimplementedMetricsServer) DeleteMetrics(context.Context, *DeleteMetricsRequest) (*DeleteMetricsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteMetrics not implemented")
+}
+func (UnimplementedMetricsServer) UpdateMetadata(context.Context, *UpdateMetadataRequest) (*UpdateMetadataResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateMetadata not implemented")
+}
+func (UnimplementedMetricsServer) GetMetadata(context.Context, *GetMetadataRequest) (*GetMetadataResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetMetadata not implemented")
+}
+func (UnimplementedMetricsServer) AddGauge(context.Context, *AddGaugeRequest) (*AddGaugeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddGauge not implemented")
+}
+func (UnimplementedMetricsServer) CompareAndSetGauge(context.Context, *CompareAndSetGaugeRequest) (*CompareAndSetGaugeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CompareAndSetGauge not implemented")
+}
+func (UnimplementedMetricsServer) ListMetrics(context.Context, *ListMetricsRequest) (*ListMetricsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListMetrics not implemented")
+}
 func (UnimplementedMetricsServer) mustEmbedUnimplementedMetricsServer() {}
 
 // UnsafeMetricsServer may be embedded to opt out of forward compatibility for this service.
@@ -121,13 +219,168 @@ func (x *metricsUpdateMetricsServer) Recv() (*UpdateMetricRequest, error) {
 	return m, nil
 }
 
+func _Metrics_GetHistory_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetHistoryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsServer).GetHistory(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Metrics/GetHistory",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsServer).GetHistory(ctx, req.(*GetHistoryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Metrics_DeleteMetrics_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteMetricsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsServer).DeleteMetrics(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Metrics/DeleteMetrics",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsServer).DeleteMetrics(ctx, req.(*DeleteMetricsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Metrics_UpdateMetadata_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateMetadataRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsServer).UpdateMetadata(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Metrics/UpdateMetadata",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsServer).UpdateMetadata(ctx, req.(*UpdateMetadataRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Metrics_GetMetadata_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetMetadataRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsServer).GetMetadata(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Metrics/GetMetadata",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsServer).GetMetadata(ctx, req.(*GetMetadataRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Metrics_AddGauge_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddGaugeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsServer).AddGauge(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Metrics/AddGauge",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsServer).AddGauge(ctx, req.(*AddGaugeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Metrics_CompareAndSetGauge_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CompareAndSetGaugeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsServer).CompareAndSetGauge(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Metrics/CompareAndSetGauge",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsServer).CompareAndSetGauge(ctx, req.(*CompareAndSetGaugeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Metrics_ListMetrics_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListMetricsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsServer).ListMetrics(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Metrics/ListMetrics",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsServer).ListMetrics(ctx, req.(*ListMetricsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Metrics_ServiceDesc is the grpc.ServiceDesc for Metrics service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
 var Metrics_ServiceDesc = grpc.ServiceDesc{
 	ServiceName: "proto.Metrics",
 	HandlerType: (*MetricsServer)(nil),
-	Methods:     []grpc.MethodDesc{},
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetHistory",
+			Handler:    _Metrics_GetHistory_Handler,
+		},
+		{
+			MethodName: "DeleteMetrics",
+			Handler:    _Metrics_DeleteMetrics_Handler,
+		},
+		{
+			MethodName: "UpdateMetadata",
+			Handler:    _Metrics_UpdateMetadata_Handler,
+		},
+		{
+			MethodName: "GetMetadata",
+			Handler:    _Metrics_GetMetadata_Handler,
+		},
+		{
+			MethodName: "AddGauge",
+			Handler:    _Metrics_AddGauge_Handler,
+		},
+		{
+			MethodName: "CompareAndSetGauge",
+			Handler:    _Metrics_CompareAndSetGauge_Handler,
+		},
+		{
+			MethodName: "ListMetrics",
+			Handler:    _Metrics_ListMetrics_Handler,
+		},
+	},
 	Streams: []grpc.StreamDesc{
 		{
 			StreamName:    "UpdateMetrics",
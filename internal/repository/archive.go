@@ -0,0 +1,207 @@
+package repository
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/itd27m01/go-metrics-service/pkg/logging/log"
+)
+
+const (
+	// archived snapshot is named by the snapshot file and UTC time of archiving, names are sorted by time
+	archivedSnapshotTimeFormat = "20060102T150405.000000000Z"
+	archivedSnapshotSuffix     = ".gz"
+)
+
+// ErrArchivedSnapshotNotFound means that archive has no snapshot for the time
+var ErrArchivedSnapshotNotFound = errors.New("archived snapshot of metrics not found")
+
+// Retention defines which archived snapshots are kept, zero fields don't limit the archive.
+// The latest snapshot is always kept.
+type Retention struct {
+	Keep   int
+	MaxAge time.Duration
+}
+
+// keeps checks if archived snapshot has to be kept, position is the number of newer snapshots
+func (r *Retention) keeps(position int, age time.Duration) bool {
+	if position == 0 || r == nil {
+		return true
+	}
+	if r.Keep > 0 && position >= r.Keep {
+		return false
+	}
+
+	return r.MaxAge <= 0 || age <= r.MaxAge
+}
+
+// ArchivedSnapshot is compressed snapshot of metrics at the time in archive directory
+type ArchivedSnapshot struct {
+	Path string
+	Time time.Time
+}
+
+// ArchiveMetrics writes compressed snapshot of metrics and their metadata to archive directory,
+// snapshot is encrypted if keys of snapshots are set. Path of archived snapshot is returned.
+func (fs *FileStore) ArchiveMetrics(archiveDir string, now time.Time) (string, error) {
+	fs.mu.Lock()
+	data, err := encodeSnapshot(&snapshotData{Metrics: fs.metricsCache, Metadata: fs.metadata.metadata})
+	keys, base := fs.keys, filepath.Base(fs.path())
+	fs.mu.Unlock()
+	if err != nil {
+		return "", err
+	}
+
+	var compressed bytes.Buffer
+	gzipWriter := gzip.NewWriter(&compressed)
+	if _, err := gzipWriter.Write(data); err != nil {
+		return "", err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return "", err
+	}
+
+	data = compressed.Bytes()
+	if keys != nil {
+		if data, err = sealSnapshot(data, keys); err != nil {
+			return "", err
+		}
+	}
+
+	if err := os.MkdirAll(archiveDir, 0o750); err != nil {
+		return "", err
+	}
+
+	archivePath := filepath.Join(archiveDir,
+		base+"."+now.UTC().Format(archivedSnapshotTimeFormat)+archivedSnapshotSuffix)
+	file, err := os.CreateTemp(archiveDir, base+".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	defer func(file *os.File) {
+		if err := file.Close(); err != nil {
+			log.Error().Err(err).Msgf("Failed to close snapshot %s", file.Name())
+		}
+	}(file)
+
+	if err = writeSnapshotFile(file, data); err == nil {
+		err = os.Rename(file.Name(), archivePath)
+	}
+	if err != nil {
+		if err := os.Remove(file.Name()); err != nil {
+			log.Error().Err(err).Msgf("Failed to remove snapshot %s", file.Name())
+		}
+
+		return "", err
+	}
+
+	return archivePath, syncDir(archiveDir)
+}
+
+// ArchivedSnapshots returns archived snapshots of the store from the newest to the oldest one
+func (fs *FileStore) ArchivedSnapshots(archiveDir string) ([]ArchivedSnapshot, error) {
+	fs.mu.Lock()
+	prefix := filepath.Base(fs.path()) + "."
+	fs.mu.Unlock()
+
+	entries, err := os.ReadDir(archiveDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := make([]ArchivedSnapshot, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, archivedSnapshotSuffix) {
+			continue
+		}
+
+		archivedAt, err := time.Parse(archivedSnapshotTimeFormat,
+			strings.TrimSuffix(strings.TrimPrefix(name, prefix), archivedSnapshotSuffix))
+		if err != nil {
+			continue
+		}
+
+		snapshots = append(snapshots, ArchivedSnapshot{Path: filepath.Join(archiveDir, name), Time: archivedAt})
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Time.After(snapshots[j].Time)
+	})
+
+	return snapshots, nil
+}
+
+// FindArchivedSnapshot returns the latest archived snapshot of the store which isn't newer than the time
+func (fs *FileStore) FindArchivedSnapshot(archiveDir string, at time.Time) (*ArchivedSnapshot, error) {
+	snapshots, err := fs.ArchivedSnapshots(archiveDir)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range snapshots {
+		if !snapshots[i].Time.After(at) {
+			return &snapshots[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w in %s at %s", ErrArchivedSnapshotNotFound, archiveDir, at.Format(time.RFC3339))
+}
+
+// PruneArchive removes archived snapshots of the store which aren't kept by retention,
+// the number of removed snapshots is returned
+func (fs *FileStore) PruneArchive(archiveDir string, retention *Retention, now time.Time) (int, error) {
+	snapshots, err := fs.ArchivedSnapshots(archiveDir)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for i, snapshot := range snapshots {
+		if retention.keeps(i, now.Sub(snapshot.Time)) {
+			continue
+		}
+
+		if err := os.Remove(snapshot.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return removed, err
+		}
+		removed++
+	}
+
+	return removed, nil
+}
+
+// RestoreMetrics replaces metrics and their metadata by snapshot from file by path, archived snapshots are supported.
+// Empty snapshot isn't restored.
+// Records of write-ahead log aren't replayed, restored metrics are saved as the current snapshot
+// and the replaced one is kept as the previous snapshot.
+func (fs *FileStore) RestoreMetrics(snapshotPath string) error {
+	fs.mu.Lock()
+	log.Info().Msgf("Restore metrics from %s", snapshotPath)
+
+	snapshot, err := readSnapshotFile(snapshotPath, fs.keys)
+	if errors.Is(err, io.EOF) {
+		err = fmt.Errorf("%w: empty snapshot %s", ErrCorruptedSnapshot, snapshotPath)
+	}
+	if err == nil {
+		fs.metricsCache = snapshot.Metrics
+		fs.metadata = metadataRegistry{metadata: snapshot.Metadata}
+		fs.touchAll(time.Now())
+	}
+	fs.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return fs.SaveMetrics()
+}
@@ -0,0 +1,217 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/itd27m01/go-metrics-service/internal/models/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// archiveTestMetrics archives snapshot of store with Alloc gauge of every value, the time of snapshot is the value
+// in hours after the start
+func archiveTestMetrics(t *testing.T, fs *FileStore, archiveDir string, start time.Time, values ...metrics.Gauge) {
+	t.Helper()
+
+	for _, value := range values {
+		require.NoError(t, fs.UpdateGaugeMetric(context.Background(), "Alloc", value))
+		_, err := fs.ArchiveMetrics(archiveDir, start.Add(time.Duration(value)*time.Hour))
+		require.NoError(t, err)
+	}
+}
+
+func TestFileStore_ArchiveMetrics(t *testing.T) {
+	tests := []struct {
+		name    string
+		encrypt bool
+	}{
+		{
+			name: "Compressed snapshot",
+		},
+		{
+			name:    "Compressed and encrypted snapshot",
+			encrypt: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			archiveDir := filepath.Join(dir, "archive")
+			ctx := context.Background()
+
+			fs, err := newTestSnapshotFileStore(t, dir)
+			require.ErrorIs(t, err, io.EOF, "new snapshot is empty")
+			if tt.encrypt {
+				fs.EncryptSnapshots(newTestKeyRing(t, 1))
+			}
+			for i := 0; i < 100; i++ {
+				require.NoError(t, fs.UpdateCounterMetric(ctx, fmt.Sprintf("PollCount%d", i), metrics.Counter(i)))
+			}
+
+			archivePath, err := fs.ArchiveMetrics(archiveDir, time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC))
+			require.NoError(t, err)
+			assert.Equal(t, filepath.Join(archiveDir, "metrics.json.20220501T120000.000000000Z.gz"), archivePath)
+
+			want, err := fs.GetMetrics(ctx)
+			require.NoError(t, err)
+			encoded, err := encodeSnapshot(&snapshotData{Metrics: want})
+			require.NoError(t, err)
+			info, err := os.Stat(archivePath)
+			require.NoError(t, err)
+			assert.Less(t, info.Size(), int64(len(encoded))/2, "snapshot must be compressed")
+
+			snapshot, err := readSnapshotFile(archivePath, fs.keys)
+			require.NoError(t, err)
+			assert.Equal(t, want, snapshot.Metrics)
+
+			leftovers, err := filepath.Glob(filepath.Join(archiveDir, "*.tmp-*"))
+			require.NoError(t, err)
+			assert.Empty(t, leftovers, "temporary snapshots must be renamed")
+		})
+	}
+}
+
+func TestFileStore_PruneArchive(t *testing.T) {
+	start := time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		retention   *Retention
+		wantRemoved int
+		wantAllocs  []metrics.Gauge
+	}{
+		{
+			name:        "No retention",
+			wantRemoved: 0,
+			wantAllocs:  []metrics.Gauge{5, 4, 3, 2, 1},
+		},
+		{
+			name:        "Number of snapshots",
+			retention:   &Retention{Keep: 2},
+			wantRemoved: 3,
+			wantAllocs:  []metrics.Gauge{5, 4},
+		},
+		{
+			name:        "Age of snapshots",
+			retention:   &Retention{MaxAge: 3 * time.Hour},
+			wantRemoved: 2,
+			wantAllocs:  []metrics.Gauge{5, 4, 3},
+		},
+		{
+			name:        "Number and age of snapshots",
+			retention:   &Retention{Keep: 2, MaxAge: 3 * time.Hour},
+			wantRemoved: 3,
+			wantAllocs:  []metrics.Gauge{5, 4},
+		},
+		{
+			name:        "The latest snapshot is kept",
+			retention:   &Retention{MaxAge: time.Minute},
+			wantRemoved: 4,
+			wantAllocs:  []metrics.Gauge{5},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			archiveDir := filepath.Join(dir, "archive")
+
+			fs, err := newTestSnapshotFileStore(t, dir)
+			require.ErrorIs(t, err, io.EOF, "new snapshot is empty")
+			archiveTestMetrics(t, fs, archiveDir, start, 1, 2, 3, 4, 5)
+			require.NoError(t, os.WriteFile(filepath.Join(archiveDir, "other.json.gz"), nil, fileMode))
+
+			removed, err := fs.PruneArchive(archiveDir, tt.retention, start.Add(6*time.Hour))
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantRemoved, removed)
+
+			snapshots, err := fs.ArchivedSnapshots(archiveDir)
+			require.NoError(t, err)
+			allocs := make([]metrics.Gauge, 0, len(snapshots))
+			for _, snapshot := range snapshots {
+				data, err := readSnapshotFile(snapshot.Path, nil)
+				require.NoError(t, err)
+				allocs = append(allocs, *data.Metrics["Alloc"].Value)
+			}
+			assert.Equal(t, tt.wantAllocs, allocs)
+			assert.FileExists(t, filepath.Join(archiveDir, "other.json.gz"), "other files must be kept")
+		})
+	}
+}
+
+func TestFileStore_FindArchivedSnapshot(t *testing.T) {
+	start := time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)
+	dir := t.TempDir()
+	archiveDir := filepath.Join(dir, "archive")
+
+	fs, err := newTestSnapshotFileStore(t, dir)
+	require.ErrorIs(t, err, io.EOF, "new snapshot is empty")
+
+	_, err = fs.FindArchivedSnapshot(archiveDir, start)
+	assert.ErrorIs(t, err, ErrArchivedSnapshotNotFound, "archive doesn't exist")
+
+	archiveTestMetrics(t, fs, archiveDir, start, 1, 2, 3)
+
+	snapshot, err := fs.FindArchivedSnapshot(archiveDir, start.Add(2*time.Hour+30*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, start.Add(2*time.Hour), snapshot.Time)
+
+	snapshot, err = fs.FindArchivedSnapshot(archiveDir, start.Add(3*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, start.Add(3*time.Hour), snapshot.Time)
+
+	_, err = fs.FindArchivedSnapshot(archiveDir, start)
+	assert.ErrorIs(t, err, ErrArchivedSnapshotNotFound)
+}
+
+func TestFileStore_RestoreMetrics(t *testing.T) {
+	dir := t.TempDir()
+	archiveDir := filepath.Join(dir, "archive")
+	ctx := context.Background()
+
+	fs := newTestWALFileStore(t, dir)
+	require.NoError(t, fs.SetMetadata(ctx, "Alloc", &metrics.Metadata{Description: "Allocated bytes"}))
+	archiveTestMetrics(t, fs, archiveDir, time.Now(), 1)
+	require.NoError(t, fs.UpdateGaugeMetric(ctx, "Alloc", 2))
+	require.NoError(t, fs.UpdateCounterMetric(ctx, "PollCount", 1))
+	require.NoError(t, fs.SaveMetrics())
+	require.NoError(t, fs.UpdateGaugeMetric(ctx, "Alloc", 3))
+	crashTestFileStore(t, fs)
+
+	snapshots, err := fs.ArchivedSnapshots(archiveDir)
+	require.NoError(t, err)
+	require.Len(t, snapshots, 1)
+
+	fs = newTestWALFileStore(t, dir)
+	require.NoError(t, fs.RestoreMetrics(snapshots[0].Path))
+
+	assertRestored := func(fs *FileStore) {
+		t.Helper()
+
+		alloc, err := fs.GetMetric(ctx, "Alloc", metrics.MetricTypeGauge)
+		require.NoError(t, err)
+		assert.Equal(t, metrics.Gauge(1), *alloc.Value)
+		_, err = fs.GetMetric(ctx, "PollCount", metrics.MetricTypeCounter)
+		assert.ErrorIs(t, err, ErrMetricNotFound, "metrics after snapshot must be rolled back")
+		metadata, err := fs.GetMetadata(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "Allocated bytes", metadata["Alloc"].Description)
+	}
+	assertRestored(fs)
+	crashTestFileStore(t, fs)
+
+	fs = newTestWALFileStore(t, dir)
+	assertRestored(fs)
+
+	previous, err := readSnapshotFile(filepath.Join(dir, "metrics.json"+previousSnapshotSuffix), nil)
+	require.NoError(t, err)
+	assert.Contains(t, previous.Metrics, "PollCount", "replaced snapshot must be kept as the previous one")
+
+	emptyPath := filepath.Join(dir, "empty.json")
+	require.NoError(t, os.WriteFile(emptyPath, nil, fileMode))
+	assert.ErrorIs(t, fs.RestoreMetrics(emptyPath), ErrCorruptedSnapshot)
+}
@@ -0,0 +1,594 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/itd27m01/go-metrics-service/internal/models/metrics"
+)
+
+var (
+	_ Store        = (*BoltStore)(nil)
+	_ HistoryStore = (*BoltStore)(nil)
+)
+
+// boltOpenTimeout limits waiting for the lock of database file which is held by another process
+const boltOpenTimeout = 5 * time.Second
+
+// Buckets of database: metrics by their keys and metadata by names of metrics, values are encoded to JSON
+var (
+	boltMetricsBucket  = []byte("metrics")
+	boltMetadataBucket = []byte("metadata")
+)
+
+// BoltStore implements Store interface to store metrics in embedded bbolt database.
+// Every update is committed in a transaction which is synced to disk, so no preserver is needed.
+// History of metrics is kept in memory only like in FileStore.
+type BoltStore struct {
+	db       *bolt.DB
+	history  *metricsHistory
+	updated  updateTimes
+	watchers *watchHub
+	mu       sync.Mutex // guards update times of metrics
+}
+
+// NewBoltStore opens bbolt database at the path, zero historyRetention disables history of metrics.
+// Metrics which are already stored are aged by TTL from the moment of open.
+func NewBoltStore(path string, historyRetention time.Duration) (*BoltStore, error) {
+	db, err := bolt.Open(path, fileMode, &bolt.Options{Timeout: boltOpenTimeout})
+	if err != nil {
+		return nil, err
+	}
+
+	bs := BoltStore{
+		db:       db,
+		history:  newMetricsHistory(historyRetention),
+		watchers: newWatchHub(),
+	}
+
+	now := time.Now()
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(boltMetadataBucket); err != nil {
+			return err
+		}
+
+		bucket, err := tx.CreateBucketIfNotExists(boltMetricsBucket)
+		if err != nil {
+			return err
+		}
+
+		return bucket.ForEach(func(k, _ []byte) error {
+			bs.updated.touch(string(k), now)
+
+			return nil
+		})
+	})
+	if err != nil {
+		db.Close()
+
+		return nil, err
+	}
+
+	return &bs, nil
+}
+
+// UpdateCounterMetric updates counter metric type
+func (bs *BoltStore) UpdateCounterMetric(_ context.Context, metricName string, metricData metrics.Counter) error {
+	return bs.updateMetric(metricName, &metrics.Metric{MType: metrics.MetricTypeCounter, Delta: &metricData})
+}
+
+// ResetCounterMetric resets counter to default zero value
+func (bs *BoltStore) ResetCounterMetric(_ context.Context, metricName string) error {
+	metricKey, name, labels, err := parseMetricKey(metricName)
+	if err != nil {
+		return err
+	}
+
+	var zero metrics.Counter
+	metric := &metrics.Metric{
+		ID:     name,
+		Labels: labels,
+		MType:  metrics.MetricTypeCounter,
+		Delta:  &zero,
+	}
+	err = bs.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltMetricsBucket)
+
+		currentMetric, err := getBoltMetric(bucket, metricKey)
+		if err != nil {
+			return err
+		}
+		if currentMetric != nil && currentMetric.MType != metrics.MetricTypeCounter {
+			return fmt.Errorf("%w %s:%s", ErrMetricTypeMismatch, metricKey, currentMetric.MType)
+		}
+
+		return putBoltMetric(bucket, metricKey, metric)
+	})
+	if err != nil {
+		return err
+	}
+
+	bs.recordUpdated([]string{metricKey}, []*metrics.Metric{metric})
+
+	return nil
+}
+
+// UpdateGaugeMetric updates gauge type metric
+func (bs *BoltStore) UpdateGaugeMetric(_ context.Context, metricName string, metricData metrics.Gauge) error {
+	return bs.updateMetric(metricName, &metrics.Metric{MType: metrics.MetricTypeGauge, Value: &metricData})
+}
+
+// AddGauge adds delta to gauge type metric in one transaction and returns its new value
+func (bs *BoltStore) AddGauge(_ context.Context, metricName string, delta metrics.Gauge) (metrics.Gauge, error) {
+	return bs.setGauge(metricName, func(metricKey string, currentMetric *metrics.Metric) (metrics.Gauge, error) {
+		return addGauge(metricKey, currentMetric, delta)
+	})
+}
+
+// CompareAndSetGauge sets value of gauge type metric in one transaction if its current value equals to expected one
+func (bs *BoltStore) CompareAndSetGauge(_ context.Context, metricName string, expected *metrics.Gauge,
+	value metrics.Gauge) error {
+	_, err := bs.setGauge(metricName, func(metricKey string, currentMetric *metrics.Metric) (metrics.Gauge, error) {
+		return value, compareGauge(metricKey, currentMetric, expected)
+	})
+
+	return err
+}
+
+// setGauge sets value of gauge by name in one transaction and returns it, the value is computed from the stored
+// metric which is nil for an absent gauge
+func (bs *BoltStore) setGauge(metricName string,
+	gaugeValue func(metricKey string, currentMetric *metrics.Metric) (metrics.Gauge, error)) (metrics.Gauge, error) {
+	metricKey, name, labels, err := parseMetricKey(metricName)
+	if err != nil {
+		return 0, err
+	}
+
+	metric := &metrics.Metric{
+		ID:     name,
+		Labels: labels,
+		MType:  metrics.MetricTypeGauge,
+	}
+	err = bs.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltMetricsBucket)
+
+		currentMetric, err := getBoltMetric(bucket, metricKey)
+		if err != nil {
+			return err
+		}
+
+		value, err := gaugeValue(metricKey, currentMetric)
+		if err != nil {
+			return err
+		}
+		metric.Value = &value
+
+		return putBoltMetric(bucket, metricKey, metric)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	bs.recordUpdated([]string{metricKey}, []*metrics.Metric{metric})
+
+	return *(metric.Value), nil
+}
+
+// UpdateHistogramMetric merges observations to histogram type metric
+func (bs *BoltStore) UpdateHistogramMetric(_ context.Context, metricName string, metricData *metrics.Histogram) error {
+	return bs.updateMetric(metricName, &metrics.Metric{MType: metrics.MetricTypeHistogram, Histogram: metricData})
+}
+
+// UpdateMetrics update number of metrics in one transaction, observations of histograms are merged
+func (bs *BoltStore) UpdateMetrics(_ context.Context, metricsBatch []*metrics.Metric) error {
+	metricKeys := make([]string, 0, len(metricsBatch))
+	for _, metric := range metricsBatch {
+		metricKeys = append(metricKeys, metric.Key())
+	}
+
+	return bs.updateKeyedMetrics(metricKeys, metricsBatch)
+}
+
+// updateMetric updates metric by name, the name and labels of metric are taken from the name
+func (bs *BoltStore) updateMetric(metricName string, metric *metrics.Metric) error {
+	metricKey, name, labels, err := parseMetricKey(metricName)
+	if err != nil {
+		return err
+	}
+	metric.ID, metric.Labels = name, labels
+
+	return bs.updateKeyedMetrics([]string{metricKey}, []*metrics.Metric{metric})
+}
+
+// updateKeyedMetrics updates number of metrics by their keys in one transaction
+func (bs *BoltStore) updateKeyedMetrics(metricKeys []string, metricsBatch []*metrics.Metric) error {
+	updatedMetrics := make([]*metrics.Metric, 0, len(metricsBatch))
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltMetricsBucket)
+
+		for i, metric := range metricsBatch {
+			currentMetric, err := getBoltMetric(bucket, metricKeys[i])
+			if err != nil {
+				return err
+			}
+
+			updatedMetric, err := mergeBoltMetric(metricKeys[i], currentMetric, metric)
+			if err != nil {
+				return err
+			}
+
+			if err := putBoltMetric(bucket, metricKeys[i], updatedMetric); err != nil {
+				return err
+			}
+			updatedMetrics = append(updatedMetrics, updatedMetric)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	bs.recordUpdated(metricKeys, updatedMetrics)
+
+	return nil
+}
+
+// mergeBoltMetric applies update of metric to its stored value, currentMetric is nil for a new metric
+func mergeBoltMetric(metricKey string, currentMetric *metrics.Metric, metric *metrics.Metric) (*metrics.Metric, error) {
+	if err := validateMetric(metric); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case currentMetric == nil && metric.MType == metrics.MetricTypeHistogram:
+		if err := metric.Histogram.Validate(); err != nil {
+			return nil, err
+		}
+
+		return metric.Copy(), nil
+	case currentMetric == nil:
+		return metric.Copy(), nil
+	case currentMetric.MType != metric.MType:
+		return nil, fmt.Errorf("%w %s:%s", ErrMetricTypeMismatch, metricKey, currentMetric.MType)
+	case metric.MType == metrics.MetricTypeCounter:
+		*(currentMetric.Delta) += *(metric.Delta)
+	case metric.MType == metrics.MetricTypeHistogram:
+		if err := currentMetric.Histogram.Merge(metric.Histogram); err != nil {
+			return nil, err
+		}
+	default:
+		*(currentMetric.Value) = *(metric.Value)
+	}
+
+	return currentMetric, nil
+}
+
+// recordUpdated appends updated metrics to their history, marks them as updated now and publishes them to watchers
+func (bs *BoltStore) recordUpdated(metricKeys []string, updatedMetrics []*metrics.Metric) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	now := time.Now()
+	for i, metric := range updatedMetrics {
+		bs.history.record(metric)
+		bs.updated.touch(metricKeys[i], now)
+	}
+	bs.watchers.publish(EventUpdate, updatedMetrics...)
+}
+
+// GetMetric return copy of metric by name
+func (bs *BoltStore) GetMetric(_ context.Context, metricName string, _ string) (*metrics.Metric, error) {
+	metricKey, _, _, err := parseMetricKey(metricName)
+	if err != nil {
+		return nil, err
+	}
+
+	var metric *metrics.Metric
+	err = bs.db.View(func(tx *bolt.Tx) error {
+		metric, err = getBoltMetric(tx.Bucket(boltMetricsBucket), metricKey)
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if metric == nil {
+		return nil, ErrMetricNotFound
+	}
+
+	return metric, nil
+}
+
+// GetMetrics returns copies of all stored metrics which satisfy label matchers
+func (bs *BoltStore) GetMetrics(_ context.Context, matchers ...*metrics.Matcher) (map[string]*metrics.Metric, error) {
+	metricsData := make(map[string]*metrics.Metric)
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltMetricsBucket).ForEach(func(k, v []byte) error {
+			var metric metrics.Metric
+			if err := json.Unmarshal(v, &metric); err != nil {
+				return err
+			}
+
+			if metrics.MatchesAll(&metric, matchers) {
+				metricsData[string(k)] = &metric
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return metricsData, nil
+}
+
+// ListMetrics returns the page of stored metrics which satisfy the filter
+func (bs *BoltStore) ListMetrics(ctx context.Context, filter *ListFilter) (*MetricsPage, error) {
+	cursor, err := filter.cursor()
+	if err != nil {
+		return nil, err
+	}
+
+	metricsData, err := bs.GetMetrics(ctx, filter.Matchers...)
+	if err != nil {
+		return nil, err
+	}
+
+	return newMetricsPage(listMetrics(nil, metricsData, filter, cursor), filter), nil
+}
+
+// DeleteMetric removes metric and its history
+func (bs *BoltStore) DeleteMetric(_ context.Context, metricName string, metricType string) error {
+	metricKey, _, _, err := parseMetricKey(metricName)
+	if err != nil {
+		return err
+	}
+
+	var deletedMetric *metrics.Metric
+	err = bs.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltMetricsBucket)
+
+		currentMetric, err := getBoltMetric(bucket, metricKey)
+		switch {
+		case err != nil:
+			return err
+		case currentMetric == nil:
+			return fmt.Errorf("%w %s:%s", ErrMetricNotFound, metricKey, metricType)
+		case currentMetric.MType != metricType:
+			return fmt.Errorf("%w %s:%s", ErrMetricTypeMismatch, metricKey, currentMetric.MType)
+		}
+		deletedMetric = currentMetric
+
+		return bucket.Delete([]byte(metricKey))
+	})
+	if err != nil {
+		return err
+	}
+
+	bs.forgetDeleted([]string{metricKey}, []*metrics.Metric{deletedMetric})
+
+	return nil
+}
+
+// DeleteMetrics removes all of metrics which satisfy label matchers in one transaction and returns their number
+func (bs *BoltStore) DeleteMetrics(_ context.Context, matchers ...*metrics.Matcher) (int, error) {
+	return bs.deleteMetrics(func(metricsData map[string]*metrics.Metric) []string {
+		deletedKeys := make([]string, 0)
+		for k, v := range metricsData {
+			if metrics.MatchesAll(v, matchers) {
+				deletedKeys = append(deletedKeys, k)
+			}
+		}
+
+		return deletedKeys
+	})
+}
+
+// DeleteStaleMetrics removes metrics which aren't updated for their TTL and returns their number
+func (bs *BoltStore) DeleteStaleMetrics(_ context.Context, ttl *TTL, now time.Time) (int, error) {
+	return bs.deleteMetrics(func(metricsData map[string]*metrics.Metric) []string {
+		bs.mu.Lock()
+		defer bs.mu.Unlock()
+
+		return bs.updated.stale(metricsData, ttl, now)
+	})
+}
+
+// deleteMetrics removes metrics which are selected from all of stored metrics in one transaction
+// and returns their number
+func (bs *BoltStore) deleteMetrics(selectKeys func(metricsData map[string]*metrics.Metric) []string) (int, error) {
+	var deletedKeys []string
+	var deletedMetrics []*metrics.Metric
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltMetricsBucket)
+
+		metricsData := make(map[string]*metrics.Metric)
+		err := bucket.ForEach(func(k, v []byte) error {
+			var metric metrics.Metric
+			if err := json.Unmarshal(v, &metric); err != nil {
+				return err
+			}
+			metricsData[string(k)] = &metric
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		deletedKeys = selectKeys(metricsData)
+		deletedMetrics = make([]*metrics.Metric, 0, len(deletedKeys))
+		for _, k := range deletedKeys {
+			if err := bucket.Delete([]byte(k)); err != nil {
+				return err
+			}
+			deletedMetrics = append(deletedMetrics, metricsData[k])
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	bs.forgetDeleted(deletedKeys, deletedMetrics)
+
+	return len(deletedKeys), nil
+}
+
+// forgetDeleted drops history and update times of deleted metrics and publishes them to watchers
+func (bs *BoltStore) forgetDeleted(metricKeys []string, deletedMetrics []*metrics.Metric) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	for _, k := range metricKeys {
+		bs.history.delete(k)
+		bs.updated.forget(k)
+	}
+	bs.watchers.publish(EventDelete, deletedMetrics...)
+}
+
+// Watch returns change feed of metrics which satisfy the filter
+func (bs *BoltStore) Watch(ctx context.Context, filter *WatchFilter) (<-chan *Event, error) {
+	return bs.watchers.watch(ctx, filter), nil
+}
+
+// SetMetadata sets metadata of metric by its name, empty metadata removes it
+func (bs *BoltStore) SetMetadata(_ context.Context, metricName string, metadata *metrics.Metadata) error {
+	if err := validateMetadata(metricName, metadata); err != nil {
+		return err
+	}
+
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltMetadataBucket)
+		if metadata.IsEmpty() {
+			return bucket.Delete([]byte(metricName))
+		}
+
+		data, err := json.Marshal(metadata)
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put([]byte(metricName), data)
+	})
+}
+
+// GetMetadata returns copies of metadata of all metrics by their names
+func (bs *BoltStore) GetMetadata(_ context.Context) (map[string]*metrics.Metadata, error) {
+	metadata := make(map[string]*metrics.Metadata)
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltMetadataBucket).ForEach(func(k, v []byte) error {
+			var md metrics.Metadata
+			if err := json.Unmarshal(v, &md); err != nil {
+				return err
+			}
+			metadata[string(k)] = &md
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return metadata, nil
+}
+
+// Ping checks that database is open
+func (bs *BoltStore) Ping(_ context.Context) error {
+	return bs.db.View(func(tx *bolt.Tx) error { return nil })
+}
+
+// Close closes database
+func (bs *BoltStore) Close() error {
+	return bs.db.Close()
+}
+
+// AppendSamples adds timestamped samples to the history of metric
+func (bs *BoltStore) AppendSamples(ctx context.Context, metricName string, metricType string,
+	samples ...metrics.Sample) error {
+	if bs.history == nil {
+		return ErrHistoryDisabled
+	}
+
+	metricKey, err := bs.checkMetricType(ctx, metricName, metricType)
+	if err != nil {
+		return err
+	}
+
+	if err := validateSamples(metricKey, metricType, samples); err != nil {
+		return err
+	}
+
+	bs.history.append(metricKey, samples...)
+
+	return nil
+}
+
+// GetHistory returns samples of metric in the [from, to] range downsampled by step
+func (bs *BoltStore) GetHistory(ctx context.Context, metricName string, metricType string,
+	from time.Time, to time.Time, step time.Duration) ([]metrics.Sample, error) {
+	if err := validateTimeRange(from, to, step); err != nil {
+		return nil, err
+	}
+
+	metricKey, err := bs.checkMetricType(ctx, metricName, metricType)
+	if err != nil {
+		return nil, err
+	}
+
+	return bs.history.query(metricKey, from, to, step)
+}
+
+// checkMetricType checks that stored metric has the same type and returns canonical key of metric
+func (bs *BoltStore) checkMetricType(ctx context.Context, metricName string, metricType string) (string, error) {
+	metricKey, _, _, err := parseMetricKey(metricName)
+	if err != nil {
+		return "", err
+	}
+
+	currentMetric, err := bs.GetMetric(ctx, metricKey, metricType)
+	switch {
+	case errors.Is(err, ErrMetricNotFound):
+	case err != nil:
+		return "", err
+	case currentMetric.MType != metricType:
+		return "", fmt.Errorf("%w %s:%s", ErrMetricTypeMismatch, metricKey, currentMetric.MType)
+	}
+
+	return metricKey, nil
+}
+
+// getBoltMetric decodes stored metric by key, it returns nil if metric isn't stored
+func getBoltMetric(bucket *bolt.Bucket, metricKey string) (*metrics.Metric, error) {
+	data := bucket.Get([]byte(metricKey))
+	if data == nil {
+		return nil, nil
+	}
+
+	var metric metrics.Metric
+	if err := json.Unmarshal(data, &metric); err != nil {
+		return nil, err
+	}
+
+	return &metric, nil
+}
+
+// putBoltMetric encodes metric and stores it by key
+func putBoltMetric(bucket *bolt.Bucket, metricKey string, metric *metrics.Metric) error {
+	data, err := json.Marshal(metric)
+	if err != nil {
+		return err
+	}
+
+	return bucket.Put([]byte(metricKey), data)
+}
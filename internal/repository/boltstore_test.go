@@ -0,0 +1,255 @@
+package repository
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/itd27m01/go-metrics-service/internal/models/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestBoltStore opens bolt store in the directory
+func newTestBoltStore(t *testing.T, dir string) *BoltStore {
+	t.Helper()
+
+	bs, err := NewBoltStore(filepath.Join(dir, "metrics.db"), time.Hour)
+	require.NoError(t, err)
+	t.Cleanup(func() { bs.Close() })
+
+	return bs
+}
+
+func TestBoltStore_Reopen(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	bs := newTestBoltStore(t, dir)
+	require.NoError(t, bs.UpdateGaugeMetric(ctx, "Alloc", testMetricValue))
+	require.NoError(t, bs.UpdateCounterMetric(ctx, `PollCount{agent="1"}`, 2))
+	want, err := bs.GetMetrics(ctx)
+	require.NoError(t, err)
+	require.NoError(t, bs.Close())
+
+	bs = newTestBoltStore(t, dir)
+	got, err := bs.GetMetrics(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, want, got, "updates must be durable without preserver")
+
+	deleted, err := bs.DeleteStaleMetrics(ctx, &TTL{Default: time.Minute}, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted, "reopened metrics must be aged from the moment of open")
+}
+
+func TestBoltStore_UpdateCounterMetric(t *testing.T) {
+	tests := []struct {
+		name       string
+		metricName string
+		deltas     []metrics.Counter
+		want       metrics.Counter
+		wantErr    error
+	}{
+		{
+			name:       "New counter",
+			metricName: "PollCount",
+			deltas:     []metrics.Counter{1},
+			want:       1,
+		},
+		{
+			name:       "Counter is incremented",
+			metricName: `PollCount{agent="1"}`,
+			deltas:     []metrics.Counter{1, 2, 3},
+			want:       6,
+		},
+		{
+			name:       "Gauge with the same name",
+			metricName: "Alloc",
+			deltas:     []metrics.Counter{1},
+			wantErr:    ErrMetricTypeMismatch,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			bs := newTestBoltStore(t, t.TempDir())
+			require.NoError(t, bs.UpdateGaugeMetric(ctx, "Alloc", testMetricValue))
+
+			var err error
+			for _, delta := range tt.deltas {
+				err = bs.UpdateCounterMetric(ctx, tt.metricName, delta)
+			}
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+
+				return
+			}
+			require.NoError(t, err)
+
+			metric, err := bs.GetMetric(ctx, tt.metricName, metrics.MetricTypeCounter)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, *metric.Delta)
+		})
+	}
+}
+
+func TestBoltStore_ResetCounterMetric(t *testing.T) {
+	ctx := context.Background()
+	bs := newTestBoltStore(t, t.TempDir())
+
+	require.NoError(t, bs.UpdateCounterMetric(ctx, "PollCount", 5))
+	require.NoError(t, bs.ResetCounterMetric(ctx, "PollCount"))
+	require.NoError(t, bs.ResetCounterMetric(ctx, "NewCount"))
+	require.NoError(t, bs.UpdateGaugeMetric(ctx, "Alloc", testMetricValue))
+	assert.ErrorIs(t, bs.ResetCounterMetric(ctx, "Alloc"), ErrMetricTypeMismatch)
+
+	for _, metricName := range []string{"PollCount", "NewCount"} {
+		metric, err := bs.GetMetric(ctx, metricName, metrics.MetricTypeCounter)
+		require.NoError(t, err)
+		assert.Equal(t, metrics.Counter(0), *metric.Delta)
+	}
+}
+
+func TestBoltStore_UpdateGaugeMetric(t *testing.T) {
+	ctx := context.Background()
+	bs := newTestBoltStore(t, t.TempDir())
+
+	require.NoError(t, bs.UpdateGaugeMetric(ctx, "Alloc", testMetricValue))
+	require.NoError(t, bs.UpdateGaugeMetric(ctx, "Alloc", testMetricValue2))
+	require.NoError(t, bs.UpdateCounterMetric(ctx, "PollCount", 1))
+	assert.ErrorIs(t, bs.UpdateGaugeMetric(ctx, "PollCount", 1), ErrMetricTypeMismatch)
+
+	metric, err := bs.GetMetric(ctx, "Alloc", metrics.MetricTypeGauge)
+	require.NoError(t, err)
+	assert.Equal(t, metrics.Gauge(testMetricValue2), *metric.Value)
+}
+
+func TestBoltStore_UpdateMetrics(t *testing.T) {
+	ctx := context.Background()
+	bs := newTestBoltStore(t, t.TempDir())
+
+	value := metrics.Gauge(testMetricValue)
+	delta := metrics.Counter(2)
+	histogram := metrics.NewHistogram(1, 2)
+	histogram.Observe(1)
+	metricsBatch := []*metrics.Metric{
+		{ID: "Alloc", MType: metrics.MetricTypeGauge, Value: &value},
+		{ID: "PollCount", MType: metrics.MetricTypeCounter, Labels: metrics.Labels{"agent": "1"}, Delta: &delta},
+		{ID: "PollCount", MType: metrics.MetricTypeCounter, Labels: metrics.Labels{"agent": "1"}, Delta: &delta},
+		{ID: "Latency", MType: metrics.MetricTypeHistogram, Histogram: histogram},
+	}
+	require.NoError(t, bs.UpdateMetrics(ctx, metricsBatch))
+
+	got, err := bs.GetMetrics(ctx)
+	require.NoError(t, err)
+	assert.Len(t, got, 3)
+	assert.Equal(t, metrics.Counter(4), *got[`PollCount{agent="1"}`].Delta)
+	assert.Equal(t, metrics.Counter(1), got["Latency"].Histogram.Count)
+
+	mismatched := append(metricsBatch, &metrics.Metric{ID: "Alloc", MType: metrics.MetricTypeCounter, Delta: &delta})
+	assert.ErrorIs(t, bs.UpdateMetrics(ctx, mismatched), ErrMetricTypeMismatch)
+
+	rolledBack, err := bs.GetMetrics(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, got, rolledBack, "failed batch must be rolled back")
+
+	for _, invalid := range []*metrics.Metric{
+		{ID: "Alloc", MType: metrics.MetricTypeGauge},
+		{ID: "PollCount", MType: metrics.MetricTypeCounter, Labels: metrics.Labels{"agent": "1"}},
+		{ID: "HeapAlloc", MType: metrics.MetricTypeGauge},
+	} {
+		assert.ErrorIs(t, bs.UpdateMetrics(ctx, append(metricsBatch, invalid)), ErrInvalidMetric)
+	}
+
+	rolledBack, err = bs.GetMetrics(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, got, rolledBack, "batch with metric without value must be rolled back")
+}
+
+func TestBoltStore_GetMetric(t *testing.T) {
+	ctx := context.Background()
+	bs := newTestBoltStore(t, t.TempDir())
+	require.NoError(t, bs.UpdateGaugeMetric(ctx, `Alloc{host="a",agent="1"}`, testMetricValue))
+
+	metric, err := bs.GetMetric(ctx, `Alloc{agent="1",host="a"}`, metrics.MetricTypeGauge)
+	require.NoError(t, err)
+	assert.Equal(t, metrics.Gauge(testMetricValue), *metric.Value)
+	assert.Equal(t, metrics.Labels{"agent": "1", "host": "a"}, metric.Labels)
+
+	_, err = bs.GetMetric(ctx, "Alloc", metrics.MetricTypeGauge)
+	assert.ErrorIs(t, err, ErrMetricNotFound)
+	_, err = bs.GetMetric(ctx, "Alloc{", metrics.MetricTypeGauge)
+	assert.Error(t, err)
+}
+
+func TestBoltStore_GetMetrics(t *testing.T) {
+	ctx := context.Background()
+	bs := newTestBoltStore(t, t.TempDir())
+	require.NoError(t, bs.UpdateGaugeMetric(ctx, `Alloc{agent="1"}`, testMetricValue))
+	require.NoError(t, bs.UpdateGaugeMetric(ctx, `Alloc{agent="2"}`, testMetricValue))
+	require.NoError(t, bs.UpdateCounterMetric(ctx, `PollCount{agent="1"}`, 1))
+
+	tests := []struct {
+		name     string
+		selector string
+		want     int
+	}{
+		{name: "All of metrics", selector: "", want: 3},
+		{name: "Metrics by name", selector: "Alloc", want: 2},
+		{name: "Metrics by label", selector: `{agent="1"}`, want: 2},
+		{name: "No metrics", selector: `{agent="3"}`, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matchers, err := metrics.ParseSelector(tt.selector)
+			require.NoError(t, err)
+
+			got, err := bs.GetMetrics(ctx, matchers...)
+			require.NoError(t, err)
+			assert.Len(t, got, tt.want)
+		})
+	}
+}
+
+func TestBoltStore_DeleteMetrics(t *testing.T) {
+	ctx := context.Background()
+	bs := newTestBoltStore(t, t.TempDir())
+	require.NoError(t, bs.UpdateGaugeMetric(ctx, `Alloc{agent="1"}`, testMetricValue))
+	require.NoError(t, bs.UpdateGaugeMetric(ctx, `Alloc{agent="2"}`, testMetricValue))
+	require.NoError(t, bs.UpdateCounterMetric(ctx, "PollCount", 1))
+
+	assert.ErrorIs(t, bs.DeleteMetric(ctx, "PollCount", metrics.MetricTypeGauge), ErrMetricTypeMismatch)
+	assert.ErrorIs(t, bs.DeleteMetric(ctx, "Unknown", metrics.MetricTypeGauge), ErrMetricNotFound)
+	require.NoError(t, bs.DeleteMetric(ctx, "PollCount", metrics.MetricTypeCounter))
+
+	samples, err := bs.GetHistory(ctx, "PollCount", metrics.MetricTypeCounter, time.Time{}, time.Now(), 0)
+	require.NoError(t, err)
+	assert.Empty(t, samples, "history must be deleted with metric")
+
+	deleted, err := bs.DeleteMetrics(ctx, metrics.NewPrefixMatcher("All"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+
+	got, err := bs.GetMetrics(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, got)
+}
+
+func TestBoltStore_History(t *testing.T) {
+	ctx := context.Background()
+	bs := newTestBoltStore(t, t.TempDir())
+	require.NoError(t, bs.UpdateGaugeMetric(ctx, "Alloc", 1))
+	require.NoError(t, bs.UpdateGaugeMetric(ctx, "Alloc", 2))
+
+	value := metrics.Gauge(0)
+	require.NoError(t, bs.AppendSamples(ctx, "Alloc", metrics.MetricTypeGauge,
+		metrics.Sample{Timestamp: time.Now().Add(-time.Minute), Value: &value}))
+	assert.ErrorIs(t, bs.AppendSamples(ctx, "Alloc", metrics.MetricTypeCounter), ErrMetricTypeMismatch)
+
+	samples, err := bs.GetHistory(ctx, "Alloc", metrics.MetricTypeGauge, time.Time{}, time.Now(), 0)
+	require.NoError(t, err)
+	require.Len(t, samples, 3)
+	assert.Equal(t, metrics.Gauge(0), *samples[0].Value)
+	assert.Equal(t, metrics.Gauge(2), *samples[2].Value)
+}
@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"strings"
+)
+
+// nameSeparators split the name of metric to the prefix and the rest of it
+const nameSeparators = "_.:-0123456789"
+
+// Cardinality is the report of the number of metrics by prefixes of their names
+type Cardinality struct {
+	Series   int                  `json:"series"`   // Total number of metrics
+	Prefixes []*PrefixCardinality `json:"prefixes"` // Prefixes ordered by the number of metrics
+}
+
+// PrefixCardinality is the number of metrics and their names with the prefix of name
+type PrefixCardinality struct {
+	Prefix string `json:"prefix"`
+	Series int    `json:"series"`
+	Names  int    `json:"names"`
+}
+
+// GetCardinality returns top prefixes of names of metrics by the number of metrics, every metric of the name is counted
+// separately by its labels. Prefix of name is its first prefixLength chars, if prefixLength isn't positive it's
+// the part of name before the first separator or digit. Limit isn't applied if it isn't positive.
+func GetCardinality(ctx context.Context, store Store, prefixLength int, limit int) (*Cardinality, error) {
+	metricsData, err := store.GetMetrics(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	prefixes := make(map[string]*PrefixCardinality)
+	names := make(map[string]struct{})
+	for _, metric := range metricsData {
+		prefix := namePrefix(metric.ID, prefixLength)
+		pc, ok := prefixes[prefix]
+		if !ok {
+			pc = &PrefixCardinality{Prefix: prefix}
+			prefixes[prefix] = pc
+		}
+
+		pc.Series++
+		if _, ok := names[metric.ID]; !ok {
+			names[metric.ID] = struct{}{}
+			pc.Names++
+		}
+	}
+
+	report := Cardinality{
+		Series:   len(metricsData),
+		Prefixes: make([]*PrefixCardinality, 0, len(prefixes)),
+	}
+	for _, pc := range prefixes {
+		report.Prefixes = append(report.Prefixes, pc)
+	}
+	sort.Slice(report.Prefixes, func(i, j int) bool {
+		if report.Prefixes[i].Series != report.Prefixes[j].Series {
+			return report.Prefixes[i].Series > report.Prefixes[j].Series
+		}
+
+		return report.Prefixes[i].Prefix < report.Prefixes[j].Prefix
+	})
+	if limit > 0 && len(report.Prefixes) > limit {
+		report.Prefixes = report.Prefixes[:limit]
+	}
+
+	return &report, nil
+}
+
+// namePrefix returns prefix of the name of metric, name without separators is the prefix itself
+func namePrefix(name string, prefixLength int) string {
+	if prefixLength > 0 {
+		if len(name) > prefixLength {
+			return name[:prefixLength]
+		}
+
+		return name
+	}
+
+	if i := strings.IndexAny(name, nameSeparators); i > 0 {
+		return name[:i]
+	}
+
+	return name
+}
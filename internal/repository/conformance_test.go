@@ -0,0 +1,61 @@
+package repository_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/itd27m01/go-metrics-service/internal/repository"
+	"github.com/itd27m01/go-metrics-service/internal/repository/storetest"
+)
+
+// newConformanceDBStore returns factory of stores of the database, stores are closed by cleanup of tests
+func newConformanceDBStore(databaseDSN string) storetest.Factory {
+	return func(t *testing.T) repository.Store {
+		db, err := repository.NewDBStore(databaseDSN, 0)
+		require.NoError(t, err)
+		require.NoError(t, db.Ping(context.Background()))
+		t.Cleanup(func() { require.NoError(t, db.Close()) })
+
+		return db
+	}
+}
+
+func TestConformance_InMemoryStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) repository.Store {
+		return repository.NewInMemoryStore()
+	}, storetest.Behavior{})
+}
+
+func TestConformance_FileStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) repository.Store {
+		fs, err := repository.NewFileStore(filepath.Join(t.TempDir(), "metrics.json"), make(chan struct{}), 0)
+		require.NoError(t, err)
+		t.Cleanup(func() { require.NoError(t, fs.Close()) })
+
+		return fs
+	}, storetest.Behavior{})
+}
+
+func TestConformance_BoltStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) repository.Store {
+		bs, err := repository.NewBoltStore(filepath.Join(t.TempDir(), "metrics.db"), 0)
+		require.NoError(t, err)
+		t.Cleanup(func() { require.NoError(t, bs.Close()) })
+
+		return bs
+	}, storetest.Behavior{AtomicBatches: true})
+}
+
+func TestConformance_DBStoreSQLite(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) repository.Store {
+		return newConformanceDBStore(repository.SQLiteScheme + filepath.Join(t.TempDir(), "metrics.db"))(t)
+	}, storetest.Behavior{SeparateTypes: true, AtomicBatches: true, HonorsContext: true})
+}
+
+func TestConformance_DBStorePostgres(t *testing.T) {
+	storetest.Run(t, newConformanceDBStore(storetest.PostgresDSN(t)),
+		storetest.Behavior{SeparateTypes: true, AtomicBatches: true, HonorsContext: true})
+}
@@ -3,96 +3,310 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
-
-	_ "github.com/jackc/pgx/v4/stdlib" // init postgresql driver
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 
 	"github.com/itd27m01/go-metrics-service/internal/models/metrics"
 	"github.com/itd27m01/go-metrics-service/pkg/logging/log"
 )
 
+const maxBatchRows = 1000
+
+// surrogate halves of UTF-16 aren't valid runes of UTF-8 text
 const (
-	psqlDriverName = "pgx"
+	surrogateMin = 0xD800
+	surrogateMax = 0xDFFF
 )
 
 var (
-	_ Store = (*DBStore)(nil)
+	_ Store         = (*DBStore)(nil)
+	_ HistoryStore  = (*DBStore)(nil)
+	_ HistoryPruner = (*DBStore)(nil)
 )
 
-// DBStore implements Store interface to store metrics in database
+// metricTables maps types of metrics to their tables
+var metricTables = map[string]string{
+	metrics.MetricTypeCounter:   "counter",
+	metrics.MetricTypeGauge:     "gauge",
+	metrics.MetricTypeHistogram: "histogram",
+}
+
+// metricValueColumns maps types of metrics to columns of their values
+var metricValueColumns = map[string]string{
+	metrics.MetricTypeCounter:   "metric_delta",
+	metrics.MetricTypeGauge:     "metric_value",
+	metrics.MetricTypeHistogram: "metric_histogram",
+}
+
+// execer defines common interface of connection and transaction to execute statements
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// queryer defines common interface of connection and transaction to execute statements and queries
+type queryer interface {
+	execer
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// DBStore implements Store interface to store metrics in PostgreSQL or SQLite database
 type DBStore struct {
-	connection *sql.DB
+	connection       *sql.DB
+	dialect          *sqlDialect
+	dsn              string
+	historyRetention time.Duration
+	watchers         *watchHub
+	listener         *dbListener
+	mu               sync.Mutex // guards listener of notifications
 }
 
-// NewDBStore creates db store
-func NewDBStore(databaseDSN string) (*DBStore, error) {
+// NewDBStore creates db store and applies pending migrations to the database,
+// DSN with sqlite:// scheme selects SQLite database, zero historyRetention disables history of metrics
+func NewDBStore(databaseDSN string, historyRetention time.Duration) (*DBStore, error) {
 	var db DBStore
 
-	conn, err := sql.Open(psqlDriverName, databaseDSN)
+	conn, dialect, err := openDatabase(databaseDSN)
+	if err != nil {
+		return nil, err
+	}
+
+	migrator, err := newMigrator(conn, dialect)
+	if err == nil {
+		err = migrator.Up(context.Background())
+	}
 	if err != nil {
+		if err := conn.Close(); err != nil {
+			log.Error().Err(err).Msg("Failed to close database connection")
+		}
+
 		return nil, err
 	}
 
 	db = DBStore{
-		connection: conn,
+		connection:       conn,
+		dialect:          dialect,
+		dsn:              databaseDSN,
+		historyRetention: historyRetention,
+		watchers:         newWatchHub(),
 	}
 
 	return &db, nil
 }
 
-// UpdateCounterMetric updates counter metric type
+// UpdateCounterMetric increments counter metric atomically in database
 func (db *DBStore) UpdateCounterMetric(ctx context.Context, metricName string, metricData metrics.Counter) error {
-	var counter metrics.Counter
-	row := db.connection.QueryRowContext(ctx,
-		"SELECT metric_delta FROM counter WHERE metric_id = $1", metricName)
-
-	err := row.Scan(&counter)
-	if !errors.Is(err, nil) && !errors.Is(err, sql.ErrNoRows) {
+	name, labels, err := metrics.ParseKey(metricName)
+	if err != nil {
 		return err
 	}
 
-	counter += metricData
-	_, err = db.connection.ExecContext(ctx,
-		"INSERT INTO counter (metric_id, metric_delta) VALUES ($1, $2) "+
-			"ON CONFLICT (metric_id) DO UPDATE SET metric_delta = $2",
-		metricName, counter)
+	counters, err := db.incrementCounters(ctx, db.connection, []*metrics.Metric{
+		{
+			ID:     name,
+			Labels: labels,
+			MType:  metrics.MetricTypeCounter,
+			Delta:  &metricData,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	db.publish(ctx, EventUpdate, counters[0])
 
-	return err
+	return db.recordHistory(ctx, db.connection, counters[0])
 }
 
 // ResetCounterMetric resets counter to default zero value
 func (db *DBStore) ResetCounterMetric(ctx context.Context, metricName string) error {
+	name, labels, err := metrics.ParseKey(metricName)
+	if err != nil {
+		return err
+	}
+
 	var zero metrics.Counter
-	_, err := db.connection.ExecContext(ctx,
-		"INSERT INTO counter (metric_id, metric_delta) VALUES ($1, $2) "+
-			"ON CONFLICT (metric_id) DO UPDATE SET metric_delta = $2",
-		metricName, zero)
+	_, err = db.connection.ExecContext(ctx,
+		"INSERT INTO counter (metric_id, labels, metric_delta) VALUES ($1, $2, $3) "+
+			"ON CONFLICT (metric_id, labels) DO UPDATE SET metric_delta = $3, updated_at = "+db.dialect.now,
+		name, encodeLabels(labels), zero)
+	if err != nil {
+		return err
+	}
 
-	return err
+	counter := metrics.Metric{
+		ID:     name,
+		Labels: labels,
+		MType:  metrics.MetricTypeCounter,
+		Delta:  &zero,
+	}
+	db.publish(ctx, EventUpdate, &counter)
+
+	return db.recordHistory(ctx, db.connection, &counter)
 }
 
 // UpdateGaugeMetric updates gauge type metric
 func (db *DBStore) UpdateGaugeMetric(ctx context.Context, metricName string, metricData metrics.Gauge) error {
-	_, err := db.connection.ExecContext(ctx,
-		"INSERT INTO gauge (metric_id, metric_value) VALUES ($1, $2) "+
-			"ON CONFLICT (metric_id) DO UPDATE SET metric_value = $2",
-		metricName, metricData)
+	name, labels, err := metrics.ParseKey(metricName)
+	if err != nil {
+		return err
+	}
 
-	return err
+	_, err = db.connection.ExecContext(ctx,
+		"INSERT INTO gauge (metric_id, labels, metric_value) VALUES ($1, $2, $3) "+
+			"ON CONFLICT (metric_id, labels) DO UPDATE SET metric_value = $3, updated_at = "+db.dialect.now,
+		name, encodeLabels(labels), metricData)
+	if err != nil {
+		return err
+	}
+
+	gauge := metrics.Metric{
+		ID:     name,
+		Labels: labels,
+		MType:  metrics.MetricTypeGauge,
+		Value:  &metricData,
+	}
+	db.publish(ctx, EventUpdate, &gauge)
+
+	return db.recordHistory(ctx, db.connection, &gauge)
+}
+
+// AddGauge adds delta to gauge type metric atomically in database and returns its new value
+func (db *DBStore) AddGauge(ctx context.Context, metricName string, delta metrics.Gauge) (metrics.Gauge, error) {
+	name, labels, err := metrics.ParseKey(metricName)
+	if err != nil {
+		return 0, err
+	}
+
+	var value metrics.Gauge
+	row := db.connection.QueryRowContext(ctx,
+		"INSERT INTO gauge AS g (metric_id, labels, metric_value) VALUES ($1, $2, $3) "+
+			"ON CONFLICT (metric_id, labels) DO UPDATE SET metric_value = g.metric_value + EXCLUDED.metric_value,"+
+			" updated_at = "+db.dialect.now+
+			" RETURNING metric_value",
+		name, encodeLabels(labels), delta)
+	if err := row.Scan(&value); err != nil {
+		return 0, err
+	}
+
+	gauge := metrics.Metric{
+		ID:     name,
+		Labels: labels,
+		MType:  metrics.MetricTypeGauge,
+		Value:  &value,
+	}
+	db.publish(ctx, EventUpdate, &gauge)
+
+	return value, db.recordHistory(ctx, db.connection, &gauge)
+}
+
+// CompareAndSetGauge sets value of gauge type metric atomically in database if its current value equals to expected one
+func (db *DBStore) CompareAndSetGauge(ctx context.Context, metricName string, expected *metrics.Gauge,
+	value metrics.Gauge) error {
+	name, labels, err := metrics.ParseKey(metricName)
+	if err != nil {
+		return err
+	}
+
+	var result sql.Result
+	if expected == nil {
+		result, err = db.connection.ExecContext(ctx,
+			"INSERT INTO gauge (metric_id, labels, metric_value) VALUES ($1, $2, $3) "+
+				"ON CONFLICT (metric_id, labels) DO NOTHING",
+			name, encodeLabels(labels), value)
+	} else {
+		result, err = db.connection.ExecContext(ctx,
+			"UPDATE gauge SET metric_value = $3, updated_at = "+db.dialect.now+
+				" WHERE metric_id = $1 AND labels = $2 AND metric_value = $4",
+			name, encodeLabels(labels), value, *expected)
+	}
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	switch {
+	case err != nil:
+		return err
+	case updated == 0 && expected == nil:
+		return fmt.Errorf("%w %s: gauge exists", ErrGaugeConflict, metrics.Key(name, labels))
+	case updated == 0:
+		return fmt.Errorf("%w %s: gauge doesn't exist or its value isn't %v", ErrGaugeConflict,
+			metrics.Key(name, labels), *expected)
+	}
+
+	gauge := metrics.Metric{
+		ID:     name,
+		Labels: labels,
+		MType:  metrics.MetricTypeGauge,
+		Value:  &value,
+	}
+	db.publish(ctx, EventUpdate, &gauge)
+
+	return db.recordHistory(ctx, db.connection, &gauge)
+}
+
+// UpdateHistogramMetric merges observations to histogram type metric
+func (db *DBStore) UpdateHistogramMetric(ctx context.Context, metricName string, metricData *metrics.Histogram) error {
+	name, labels, err := metrics.ParseKey(metricName)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.connection.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	metric := metrics.Metric{
+		ID:     name,
+		Labels: labels,
+		MType:  metrics.MetricTypeHistogram,
+	}
+	metric.Histogram, err = db.mergeHistogram(ctx, tx, name, labels, metricData)
+	if err == nil {
+		err = db.recordHistory(ctx, tx, &metric)
+	}
+	if err != nil {
+		if err := tx.Rollback(); err != nil {
+			log.Error().Err(err).Msg("unable to rollback transaction")
+		}
+
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	db.publish(ctx, EventUpdate, &metric)
+
+	return nil
 }
 
 // GetMetric return metric by name
 func (db *DBStore) GetMetric(ctx context.Context, metricName string, metricType string) (*metrics.Metric, error) {
+	name, labels, err := metrics.ParseKey(metricName)
+	if err != nil {
+		return nil, err
+	}
+
 	metric := metrics.Metric{
-		ID:    metricName,
-		MType: metricType,
+		ID:     name,
+		Labels: labels,
+		MType:  metricType,
 	}
 
 	switch metricType {
 	case metrics.MetricTypeCounter:
 		var counter metrics.Counter
 		row := db.connection.QueryRowContext(ctx,
-			"SELECT metric_delta FROM counter WHERE metric_id = $1", metricName)
+			"SELECT metric_delta FROM counter WHERE metric_id = $1 AND labels = $2", name, encodeLabels(labels))
 
 		err := row.Scan(&counter)
 		switch {
@@ -105,7 +319,7 @@ func (db *DBStore) GetMetric(ctx context.Context, metricName string, metricType
 	case metrics.MetricTypeGauge:
 		var gauge metrics.Gauge
 		row := db.connection.QueryRowContext(ctx,
-			"SELECT metric_value FROM gauge WHERE metric_id = $1", metricName)
+			"SELECT metric_value FROM gauge WHERE metric_id = $1 AND labels = $2", name, encodeLabels(labels))
 
 		err := row.Scan(&gauge)
 		switch {
@@ -115,6 +329,21 @@ func (db *DBStore) GetMetric(ctx context.Context, metricName string, metricType
 			return nil, err
 		}
 		metric.Value = &gauge
+	case metrics.MetricTypeHistogram:
+		var encodedHistogram []byte
+		row := db.connection.QueryRowContext(ctx,
+			"SELECT metric_histogram FROM histogram WHERE metric_id = $1 AND labels = $2", name, encodeLabels(labels))
+
+		err := row.Scan(&encodedHistogram)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrMetricNotFound
+		case !errors.Is(err, nil):
+			return nil, err
+		}
+		if metric.Histogram, err = decodeHistogram(encodedHistogram); err != nil {
+			return nil, err
+		}
 	default:
 		return nil, ErrMetricNotFound
 	}
@@ -122,93 +351,80 @@ func (db *DBStore) GetMetric(ctx context.Context, metricName string, metricType
 	return &metric, nil
 }
 
-// UpdateMetrics update number of metrics
+// UpdateMetrics update number of metrics in one transaction, observations of histograms are merged.
+// Gauges and counters are upserted by multi-row statements and counters are incremented atomically in database.
 func (db *DBStore) UpdateMetrics(ctx context.Context, metricsBatch []*metrics.Metric) error {
-	tx, err := db.connection.BeginTx(ctx, nil)
+	gauges, counters, histograms, err := splitMetricsBatch(metricsBatch)
 	if err != nil {
 		return err
 	}
 
-	stmtInsertGauge, err := tx.Prepare("INSERT INTO gauge (metric_id, metric_value) VALUES ($1, $2) " +
-		"ON CONFLICT (metric_id) DO UPDATE SET metric_value = $2")
+	tx, err := db.connection.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	defer func(stmtInsertGauge *sql.Stmt) {
-		if err := stmtInsertGauge.Close(); err != nil {
-			log.Error().Err(err).Msg("Failed to close insert statement")
-		}
-	}(stmtInsertGauge)
 
-	stmtSelectCounter, err := tx.Prepare("SELECT metric_delta FROM counter WHERE metric_id = $1")
+	updatedMetrics, err := db.updateMetrics(ctx, tx, gauges, counters, histograms)
 	if err != nil {
-		return err
-	}
-	defer func(stmtSelectCounter *sql.Stmt) {
-		if err := stmtSelectCounter.Close(); err != nil {
-			log.Error().Err(err).Msgf("Failed to close insert statement")
+		if err := tx.Rollback(); err != nil {
+			log.Error().Err(err).Msg("unable to rollback transaction")
 		}
-	}(stmtSelectCounter)
 
-	stmtInsertCounter, err := tx.Prepare("INSERT INTO counter (metric_id, metric_delta) VALUES ($1, $2) " +
-		"ON CONFLICT (metric_id) DO UPDATE SET metric_delta = $2")
-	if err != nil {
 		return err
 	}
-	defer func(stmtInsertCounter *sql.Stmt) {
-		if err := stmtInsertCounter.Close(); err != nil {
-			log.Error().Err(err).Msgf("Failed to close insert statement")
-		}
-	}(stmtInsertCounter)
 
-	for _, metric := range metricsBatch {
-		switch {
-		case metric.MType == metrics.MetricTypeGauge:
-			if _, err := stmtInsertGauge.Exec(metric.ID, *(metric.Value)); err != nil {
-				if err := tx.Rollback(); err != nil {
-					log.Error().Err(err).Msg("unable to rollback transaction")
-				}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	db.publish(ctx, EventUpdate, updatedMetrics...)
 
-				return err
-			}
-		case metric.MType == metrics.MetricTypeCounter:
-			var counter metrics.Counter
-			query := stmtSelectCounter.QueryRow(metric.ID)
+	return nil
+}
 
-			err = query.Scan(&counter)
-			if !errors.Is(err, nil) && !errors.Is(err, sql.ErrNoRows) {
-				if err := tx.Rollback(); err != nil {
-					log.Error().Err(err).Msgf("unable to rollback transaction")
-				}
+// updateMetrics does actual work to update metrics of the batch in transaction and returns the updated metrics
+func (db *DBStore) updateMetrics(ctx context.Context, tx *sql.Tx,
+	gauges []*metrics.Metric, counters []*metrics.Metric, histograms []*metrics.Metric) ([]*metrics.Metric, error) {
+	updatedMetrics := make([]*metrics.Metric, 0, len(gauges)+len(counters)+len(histograms))
 
-				return err
-			}
+	if err := db.upsertGauges(ctx, tx, gauges); err != nil {
+		return nil, err
+	}
+	updatedMetrics = append(updatedMetrics, gauges...)
 
-			counter += *(metric.Delta)
+	counters, err := db.incrementCounters(ctx, tx, counters)
+	if err != nil {
+		return nil, err
+	}
+	updatedMetrics = append(updatedMetrics, counters...)
 
-			if _, err := stmtInsertCounter.Exec(metric.ID, counter); err != nil {
-				if err := tx.Rollback(); err != nil {
-					log.Error().Err(err).Msgf("unable to rollback transaction")
-				}
+	for _, metric := range histograms {
+		histogram, err := db.mergeHistogram(ctx, tx, metric.ID, metric.Labels, metric.Histogram)
+		if err != nil {
+			return nil, err
+		}
 
-				return err
-			}
+		updatedMetric := metrics.Metric{
+			ID:        metric.ID,
+			Labels:    metric.Labels,
+			MType:     metrics.MetricTypeHistogram,
+			Histogram: histogram,
 		}
+		updatedMetrics = append(updatedMetrics, &updatedMetric)
 	}
 
-	if err := tx.Commit(); err != nil {
-		return err
+	if err := db.recordHistory(ctx, tx, updatedMetrics...); err != nil {
+		return nil, err
 	}
 
-	return nil
+	return updatedMetrics, nil
 }
 
-// GetMetrics returns all of stored metrics
-func (db *DBStore) GetMetrics(ctx context.Context) (map[string]*metrics.Metric, error) {
+// GetMetrics returns all of stored metrics which satisfy label matchers
+func (db *DBStore) GetMetrics(ctx context.Context, matchers ...*metrics.Matcher) (map[string]*metrics.Metric, error) {
 	metricsMap := make(map[string]*metrics.Metric)
 
 	counters, err := db.connection.QueryContext(ctx,
-		"SELECT metric_id,metric_delta FROM counter")
+		"SELECT metric_id,labels,metric_delta FROM counter")
 
 	if err != nil {
 		return nil, err
@@ -226,12 +442,19 @@ func (db *DBStore) GetMetrics(ctx context.Context) (map[string]*metrics.Metric,
 			MType: metrics.MetricTypeCounter,
 			Delta: &counter,
 		}
-		err = counters.Scan(&metric.ID, metric.Delta)
+		var labels []byte
+		err = counters.Scan(&metric.ID, &labels, metric.Delta)
 		if err != nil {
 			return nil, err
 		}
 
-		metricsMap[metric.ID] = &metric
+		if metric.Labels, err = decodeLabels(labels); err != nil {
+			return nil, err
+		}
+
+		if metrics.MatchesAll(&metric, matchers) {
+			metricsMap[metric.Key()] = &metric
+		}
 	}
 
 	err = counters.Err()
@@ -240,7 +463,7 @@ func (db *DBStore) GetMetrics(ctx context.Context) (map[string]*metrics.Metric,
 	}
 
 	gauges, err := db.connection.QueryContext(ctx,
-		"SELECT metric_id,metric_value FROM gauge")
+		"SELECT metric_id,labels,metric_value FROM gauge")
 
 	if err != nil {
 		return nil, err
@@ -259,12 +482,19 @@ func (db *DBStore) GetMetrics(ctx context.Context) (map[string]*metrics.Metric,
 			Value: &gauge,
 		}
 
-		err = gauges.Scan(&metric.ID, metric.Value)
+		var labels []byte
+		err = gauges.Scan(&metric.ID, &labels, metric.Value)
 		if err != nil {
 			return nil, err
 		}
 
-		metricsMap[metric.ID] = &metric
+		if metric.Labels, err = decodeLabels(labels); err != nil {
+			return nil, err
+		}
+
+		if metrics.MatchesAll(&metric, matchers) {
+			metricsMap[metric.Key()] = &metric
+		}
 	}
 
 	err = gauges.Err()
@@ -272,16 +502,913 @@ func (db *DBStore) GetMetrics(ctx context.Context) (map[string]*metrics.Metric,
 		return nil, err
 	}
 
+	histograms, err := db.connection.QueryContext(ctx,
+		"SELECT metric_id,labels,metric_histogram FROM histogram")
+
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			log.Error().Err(err).Msgf("Couldn't close rows")
+		}
+	}(histograms)
+
+	for histograms.Next() {
+		metric := metrics.Metric{
+			MType: metrics.MetricTypeHistogram,
+		}
+
+		var labels, encodedHistogram []byte
+		err = histograms.Scan(&metric.ID, &labels, &encodedHistogram)
+		if err != nil {
+			return nil, err
+		}
+
+		if metric.Labels, err = decodeLabels(labels); err != nil {
+			return nil, err
+		}
+		if metric.Histogram, err = decodeHistogram(encodedHistogram); err != nil {
+			return nil, err
+		}
+
+		if metrics.MatchesAll(&metric, matchers) {
+			metricsMap[metric.Key()] = &metric
+		}
+	}
+
+	err = histograms.Err()
+	if err != nil {
+		return nil, err
+	}
+
 	return metricsMap, nil
 }
 
-// Ping checks that underlying store is alive
-func (db *DBStore) Ping(ctx context.Context) error {
-	return db.connection.PingContext(ctx)
+// ListMetrics returns the page of stored metrics which satisfy the filter. Metrics are selected by prefix, type
+// and cursor and ordered in database, names and labels as text are compared as bytes, so PostgreSQL orders them
+// by indexes regardless of collation of database. Regexp of names and label matchers are checked after select.
+func (db *DBStore) ListMetrics(ctx context.Context, filter *ListFilter) (*MetricsPage, error) {
+	cursor, err := filter.cursor()
+	if err != nil {
+		return nil, err
+	}
+
+	metricTypes := []string{metrics.MetricTypeCounter, metrics.MetricTypeGauge, metrics.MetricTypeHistogram}
+	if filter.Type != "" {
+		if _, ok := metricTables[filter.Type]; !ok {
+			return newMetricsPage(nil, filter), nil
+		}
+		metricTypes = []string{filter.Type}
+	}
+
+	var listed []*listedMetric
+	for _, metricType := range metricTypes {
+		if listed, err = db.listMetrics(ctx, listed, metricType, filter, cursor); err != nil {
+			return nil, err
+		}
+	}
+
+	return newMetricsPage(listed, filter), nil
 }
 
-// Close closes database connection
-func (db *DBStore) Close() error {
+// listMetrics appends metrics of the type which satisfy the filter and follow the cursor to listed metrics,
+// no more than the limit of filter and one more metric to detect the next page are appended
+func (db *DBStore) listMetrics(ctx context.Context, listed []*listedMetric, metricType string, filter *ListFilter,
+	cursor *listPosition) ([]*listedMetric, error) {
+	wanted, chunk := 0, maxBatchRows
+	if filter.Limit > 0 {
+		wanted = filter.Limit + 1
+		if filter.Name == nil && len(filter.Matchers) == 0 && wanted < chunk {
+			chunk = wanted
+		}
+	}
+
+	for {
+		query, args := db.listQuery(metricType, filter, cursor, chunk)
+		rows, err := db.connection.QueryContext(ctx, query, args...)
+		if err != nil {
+			return nil, err
+		}
+
+		appended := len(listed)
+		var scanned int
+		listed, scanned, cursor, err = scanListed(rows, metricType, filter, listed, wanted)
+		if err != nil {
+			return nil, err
+		}
+
+		if wanted > 0 {
+			wanted -= len(listed) - appended
+			if wanted == 0 {
+				return listed, nil
+			}
+		}
+		if scanned < chunk {
+			return listed, nil
+		}
+	}
+}
+
+// listQuery builds query of chunk of metrics of the type which have prefix of filter and follow the cursor
+func (db *DBStore) listQuery(metricType string, filter *ListFilter, cursor *listPosition,
+	chunk int) (string, []interface{}) {
+	name := "metric_id" + db.dialect.collate
+	labels := db.dialect.labelsText + db.dialect.collate
+	order, direction := ">", " ASC"
+	if filter.Descending {
+		order, direction = "<", " DESC"
+	}
+
+	var sb strings.Builder
+	args := make([]interface{}, 0, 4)
+	sb.WriteString("SELECT metric_id, " + db.dialect.labelsText + ", " + metricValueColumns[metricType] +
+		" FROM " + metricTables[metricType] + " WHERE 1 = 1")
+	if filter.Prefix != "" {
+		// prefix is a range of names which is served by index of names
+		args = append(args, filter.Prefix)
+		sb.WriteString(" AND " + name + " >= $1")
+		if upperBound, ok := prefixUpperBound(filter.Prefix); ok {
+			args = append(args, upperBound)
+			sb.WriteString(" AND " + name + " < $2")
+		}
+	}
+	if cursor != nil {
+		// metrics of the same name and labels follow the cursor if their type follows the type of cursor
+		labelsOrder := order
+		if filter.follows(&listPosition{Name: cursor.Name, Labels: cursor.Labels, Type: metricType}, cursor) {
+			labelsOrder += "="
+		}
+
+		args = append(args, cursor.Name, cursor.Labels)
+		nameArg, labelsArg := "$"+strconv.Itoa(len(args)-1), "$"+strconv.Itoa(len(args))
+		sb.WriteString(" AND (" + name + " " + order + " " + nameArg +
+			" OR (" + name + " = " + nameArg + " AND " + labels + " " + labelsOrder + " " + labelsArg + "))")
+	}
+	sb.WriteString(" ORDER BY " + name + direction + ", " + labels + direction + " LIMIT " + strconv.Itoa(chunk))
+
+	return sb.String(), args
+}
+
+// scanListed appends metrics of rows which satisfy the filter to listed metrics and closes rows, no more than wanted
+// metrics are appended if it's positive. It returns the number of scanned rows and position of the last one.
+func scanListed(rows *sql.Rows, metricType string, filter *ListFilter, listed []*listedMetric,
+	wanted int) ([]*listedMetric, int, *listPosition, error) {
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			log.Error().Err(err).Msgf("Couldn't close rows")
+		}
+	}(rows)
+
+	var scanned int
+	var position *listPosition
+	for rows.Next() {
+		metric := metrics.Metric{
+			MType: metricType,
+		}
+
+		var labels string
+		var err error
+		switch metricType {
+		case metrics.MetricTypeCounter:
+			metric.Delta = new(metrics.Counter)
+			err = rows.Scan(&metric.ID, &labels, metric.Delta)
+		case metrics.MetricTypeGauge:
+			metric.Value = new(metrics.Gauge)
+			err = rows.Scan(&metric.ID, &labels, metric.Value)
+		default:
+			var encodedHistogram []byte
+			if err = rows.Scan(&metric.ID, &labels, &encodedHistogram); err == nil {
+				metric.Histogram, err = decodeHistogram(encodedHistogram)
+			}
+		}
+		if err != nil {
+			return nil, 0, nil, err
+		}
+
+		if metric.Labels, err = decodeLabels([]byte(labels)); err != nil {
+			return nil, 0, nil, err
+		}
+
+		scanned++
+		position = &listPosition{Name: metric.ID, Labels: labels, Type: metricType}
+		if !filter.matches(&metric) {
+			continue
+		}
+
+		listed = append(listed, &listedMetric{position: *position, metric: &metric})
+		if wanted > 0 {
+			wanted--
+			if wanted == 0 {
+				break
+			}
+		}
+	}
+
+	return listed, scanned, position, rows.Err()
+}
+
+// DeleteMetric removes metric and its history from database
+func (db *DBStore) DeleteMetric(ctx context.Context, metricName string, metricType string) error {
+	name, labels, err := metrics.ParseKey(metricName)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.connection.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := db.deleteMetric(ctx, tx, &metrics.Metric{ID: name, Labels: labels, MType: metricType}, time.Time{})
+	if err == nil && deleted == nil {
+		err = fmt.Errorf("%w %s:%s", ErrMetricNotFound, metrics.Key(name, labels), metricType)
+	}
+	if err != nil {
+		if err := tx.Rollback(); err != nil {
+			log.Error().Err(err).Msg("unable to rollback transaction")
+		}
+
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	db.publish(ctx, EventDelete, deleted)
+
+	return nil
+}
+
+// DeleteMetrics removes all of metrics which satisfy label matchers in one transaction and returns their number
+func (db *DBStore) DeleteMetrics(ctx context.Context, matchers ...*metrics.Matcher) (int, error) {
+	metricsMap, err := db.GetMetrics(ctx, matchers...)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := db.connection.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	deletedMetrics := make([]*metrics.Metric, 0)
+	for _, metric := range sortedMetrics(metricsMap) {
+		deleted, err := db.deleteMetric(ctx, tx, metric, time.Time{})
+		if err != nil {
+			if err := tx.Rollback(); err != nil {
+				log.Error().Err(err).Msg("unable to rollback transaction")
+			}
+
+			return 0, err
+		}
+		if deleted != nil {
+			deletedMetrics = append(deletedMetrics, deleted)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	db.publish(ctx, EventDelete, deletedMetrics...)
+
+	return len(deletedMetrics), nil
+}
+
+// DeleteStaleMetrics removes metrics which aren't updated for their TTL in one transaction and returns their number,
+// metrics updated during the removal are kept
+func (db *DBStore) DeleteStaleMetrics(ctx context.Context, ttl *TTL, now time.Time) (int, error) {
+	staleMetrics := make([]*metrics.Metric, 0)
+	updateTimes := make([]time.Time, 0)
+	for _, metricType := range []string{metrics.MetricTypeCounter, metrics.MetricTypeGauge, metrics.MetricTypeHistogram} {
+		rows, err := db.connection.QueryContext(ctx,
+			"SELECT metric_id, labels, updated_at FROM "+metricTables[metricType])
+		if err != nil {
+			return 0, err
+		}
+
+		staleMetrics, updateTimes, err = scanStaleMetrics(rows, metricType, ttl, now, staleMetrics, updateTimes)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	tx, err := db.connection.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	deletedMetrics := make([]*metrics.Metric, 0)
+	for i, metric := range staleMetrics {
+		deleted, err := db.deleteMetric(ctx, tx, metric, updateTimes[i])
+		if err != nil {
+			if err := tx.Rollback(); err != nil {
+				log.Error().Err(err).Msg("unable to rollback transaction")
+			}
+
+			return 0, err
+		}
+		if deleted != nil {
+			deletedMetrics = append(deletedMetrics, deleted)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	db.publish(ctx, EventDelete, deletedMetrics...)
+
+	return len(deletedMetrics), nil
+}
+
+// scanStaleMetrics appends metrics from rows which are expired at now with moments of their updates and closes rows
+func scanStaleMetrics(rows *sql.Rows, metricType string, ttl *TTL, now time.Time,
+	staleMetrics []*metrics.Metric, updateTimes []time.Time) ([]*metrics.Metric, []time.Time, error) {
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			log.Error().Err(err).Msgf("Couldn't close rows")
+		}
+	}(rows)
+
+	for rows.Next() {
+		metric := metrics.Metric{
+			MType: metricType,
+		}
+
+		var labels []byte
+		var updatedAt time.Time
+		err := rows.Scan(&metric.ID, &labels, &updatedAt)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if !ttl.Expired(metric.ID, updatedAt, now) {
+			continue
+		}
+
+		if metric.Labels, err = decodeLabels(labels); err != nil {
+			return nil, nil, err
+		}
+
+		staleMetrics = append(staleMetrics, &metric)
+		updateTimes = append(updateTimes, updatedAt)
+	}
+
+	return staleMetrics, updateTimes, rows.Err()
+}
+
+// deleteMetric removes metric of its type and its history, it returns the deleted metric with its last value
+// or nil if the metric wasn't stored. Not zero updatedAt keeps the metric if it's updated after that moment.
+func (db *DBStore) deleteMetric(ctx context.Context, conn queryer, metric *metrics.Metric,
+	updatedAt time.Time) (*metrics.Metric, error) {
+	table, ok := metricTables[metric.MType]
+	if !ok {
+		return nil, nil
+	}
+
+	query := "DELETE FROM " + table + " WHERE metric_id = $1 AND labels = $2"
+	args := []interface{}{metric.ID, encodeLabels(metric.Labels)}
+	if !updatedAt.IsZero() {
+		query += " AND updated_at <= $3"
+		args = append(args, db.dialect.timeValue(updatedAt))
+	}
+	row := conn.QueryRowContext(ctx, query+" RETURNING "+metricValueColumns[metric.MType], args...)
+
+	deleted := metrics.Metric{
+		ID:     metric.ID,
+		Labels: metric.Labels,
+		MType:  metric.MType,
+	}
+
+	var err error
+	switch metric.MType {
+	case metrics.MetricTypeCounter:
+		deleted.Delta = new(metrics.Counter)
+		err = row.Scan(deleted.Delta)
+	case metrics.MetricTypeGauge:
+		deleted.Value = new(metrics.Gauge)
+		err = row.Scan(deleted.Value)
+	default:
+		var encodedHistogram []byte
+		if err = row.Scan(&encodedHistogram); err == nil {
+			deleted.Histogram, err = decodeHistogram(encodedHistogram)
+		}
+	}
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, nil
+	case !errors.Is(err, nil):
+		return nil, err
+	}
+
+	_, err = conn.ExecContext(ctx,
+		"DELETE FROM history WHERE metric_id = $1 AND labels = $2 AND metric_type = $3",
+		metric.ID, encodeLabels(metric.Labels), metric.MType)
+
+	return &deleted, err
+}
+
+// AppendSamples adds timestamped samples to the history of metric
+func (db *DBStore) AppendSamples(ctx context.Context, metricName string, metricType string,
+	samples ...metrics.Sample) error {
+	if db.historyRetention == 0 {
+		return ErrHistoryDisabled
+	}
+
+	name, labels, err := metrics.ParseKey(metricName)
+	if err != nil {
+		return err
+	}
+
+	if err := validateSamples(metricName, metricType, samples); err != nil {
+		return err
+	}
+
+	tx, err := db.connection.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, sample := range samples {
+		histogram, err := encodeHistogram(sample.Histogram)
+		if err == nil {
+			_, err = tx.ExecContext(ctx,
+				"INSERT INTO history (metric_id, labels, metric_type, metric_delta, metric_value, metric_histogram, "+
+					"created_at) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+				name, encodeLabels(labels), metricType, sample.Delta, sample.Value, histogram, db.dialect.timeValue(sample.Timestamp))
+		}
+		if err != nil {
+			if err := tx.Rollback(); err != nil {
+				log.Error().Err(err).Msg("unable to rollback transaction")
+			}
+
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetHistory returns samples of metric in the [from, to] range downsampled by step
+func (db *DBStore) GetHistory(ctx context.Context, metricName string, metricType string,
+	from time.Time, to time.Time, step time.Duration) ([]metrics.Sample, error) {
+	if db.historyRetention == 0 {
+		return nil, ErrHistoryDisabled
+	}
+
+	if err := validateTimeRange(from, to, step); err != nil {
+		return nil, err
+	}
+
+	name, labels, err := metrics.ParseKey(metricName)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.connection.QueryContext(ctx,
+		"SELECT created_at, metric_delta, metric_value, metric_histogram FROM history "+
+			"WHERE metric_id = $1 AND labels = $2 AND metric_type = $3 AND created_at BETWEEN $4 AND $5 "+
+			"ORDER BY created_at",
+		name, encodeLabels(labels), metricType, db.dialect.timeValue(from), db.dialect.timeValue(to))
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			log.Error().Err(err).Msgf("Couldn't close rows")
+		}
+	}(rows)
+
+	samples := make([]metrics.Sample, 0)
+	for rows.Next() {
+		var sample metrics.Sample
+		var encodedHistogram []byte
+		if err := rows.Scan(&sample.Timestamp, &sample.Delta, &sample.Value, &encodedHistogram); err != nil {
+			return nil, err
+		}
+
+		if encodedHistogram != nil {
+			if sample.Histogram, err = decodeHistogram(encodedHistogram); err != nil {
+				return nil, err
+			}
+		}
+
+		samples = append(samples, sample)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return downsample(samples, from, step), nil
+}
+
+// recordHistory appends the current values of metrics to their history by multi-row statements,
+// expired samples are dropped by PruneHistory
+func (db *DBStore) recordHistory(ctx context.Context, conn execer, updatedMetrics ...*metrics.Metric) error {
+	if db.historyRetention == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	for len(updatedMetrics) > 0 {
+		chunk := updatedMetrics
+		if len(chunk) > maxBatchRows {
+			chunk = chunk[:maxBatchRows]
+		}
+		updatedMetrics = updatedMetrics[len(chunk):]
+
+		args := make([]interface{}, 0, len(chunk)*7)
+		for _, metric := range chunk {
+			sample := metric.Sample(now)
+			histogram, err := encodeHistogram(sample.Histogram)
+			if err != nil {
+				return err
+			}
+
+			args = append(args, metric.ID, encodeLabels(metric.Labels), metric.MType, sample.Delta, sample.Value,
+				histogram, db.dialect.timeValue(now))
+		}
+
+		_, err := conn.ExecContext(ctx,
+			"INSERT INTO history (metric_id, labels, metric_type, metric_delta, metric_value, metric_histogram, "+
+				"created_at) VALUES "+valuesPlaceholders(len(chunk), 7),
+			args...)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PruneHistory drops samples of history which are older than retention of history and returns their number
+func (db *DBStore) PruneHistory(ctx context.Context, now time.Time) (int, error) {
+	if db.historyRetention == 0 {
+		return 0, nil
+	}
+
+	result, err := db.connection.ExecContext(ctx, "DELETE FROM history WHERE created_at < $1",
+		db.dialect.timeValue(now.Add(-db.historyRetention)))
+	if err != nil {
+		return 0, err
+	}
+
+	pruned, err := result.RowsAffected()
+
+	return int(pruned), err
+}
+
+// prefixUpperBound returns the least name which follows all of names with the prefix in byte order,
+// there is no such name if the prefix consists of the maximal runes only
+func prefixUpperBound(prefix string) (string, bool) {
+	runes := []rune(prefix)
+	for i := len(runes) - 1; i >= 0; i-- {
+		if runes[i] >= utf8.MaxRune {
+			continue
+		}
+
+		next := runes[i] + 1
+		if next >= surrogateMin && next <= surrogateMax {
+			next = surrogateMax + 1
+		}
+
+		return string(append(runes[:i], next)), true
+	}
+
+	return "", false
+}
+
+// splitMetricsBatch splits batch by types of metrics, gauges and counters are ordered by key
+// and the metrics with the same key are collapsed: the last value of gauge wins and deltas of counter are summed up
+func splitMetricsBatch(metricsBatch []*metrics.Metric) ([]*metrics.Metric, []*metrics.Metric, []*metrics.Metric, error) {
+	gaugesMap := make(map[string]*metrics.Metric)
+	countersMap := make(map[string]*metrics.Metric)
+	histograms := make([]*metrics.Metric, 0)
+
+	for _, metric := range metricsBatch {
+		metricKey := metric.Key()
+		switch {
+		case metric.MType == metrics.MetricTypeGauge:
+			if metric.Value == nil {
+				return nil, nil, nil, fmt.Errorf("%w %s:%s", ErrInvalidMetric, metricKey, metric.MType)
+			}
+			gaugesMap[metricKey] = metric
+		case metric.MType == metrics.MetricTypeCounter:
+			if metric.Delta == nil {
+				return nil, nil, nil, fmt.Errorf("%w %s:%s", ErrInvalidMetric, metricKey, metric.MType)
+			}
+			delta := *(metric.Delta)
+			if counter, ok := countersMap[metricKey]; ok {
+				delta += *(counter.Delta)
+			}
+			countersMap[metricKey] = &metrics.Metric{
+				ID:     metric.ID,
+				Labels: metric.Labels,
+				MType:  metrics.MetricTypeCounter,
+				Delta:  &delta,
+			}
+		case metric.MType == metrics.MetricTypeHistogram:
+			histograms = append(histograms, metric)
+		}
+	}
+
+	return sortedMetrics(gaugesMap), sortedMetrics(countersMap), histograms, nil
+}
+
+// sortedMetrics returns metrics of map ordered by key,
+// concurrent batches lock rows in the same order and don't deadlock each other
+func sortedMetrics(metricsMap map[string]*metrics.Metric) []*metrics.Metric {
+	keys := make([]string, 0, len(metricsMap))
+	for key := range metricsMap {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	metricsSlice := make([]*metrics.Metric, 0, len(keys))
+	for _, key := range keys {
+		metricsSlice = append(metricsSlice, metricsMap[key])
+	}
+
+	return metricsSlice
+}
+
+// upsertGauges sets values of gauges by multi-row statements, keys of gauges must be unique
+func (db *DBStore) upsertGauges(ctx context.Context, conn execer, gauges []*metrics.Metric) error {
+	for len(gauges) > 0 {
+		chunk := gauges
+		if len(chunk) > maxBatchRows {
+			chunk = chunk[:maxBatchRows]
+		}
+		gauges = gauges[len(chunk):]
+
+		args := make([]interface{}, 0, len(chunk)*3)
+		for _, gauge := range chunk {
+			args = append(args, gauge.ID, encodeLabels(gauge.Labels), *(gauge.Value))
+		}
+
+		_, err := conn.ExecContext(ctx,
+			"INSERT INTO gauge (metric_id, labels, metric_value) VALUES "+valuesPlaceholders(len(chunk), 3)+
+				" ON CONFLICT (metric_id, labels) DO UPDATE SET metric_value = EXCLUDED.metric_value,"+
+				" updated_at = "+db.dialect.now,
+			args...)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// incrementCounters adds deltas to counters by multi-row statements and returns the incremented counters,
+// keys of counters must be unique
+func (db *DBStore) incrementCounters(ctx context.Context, conn queryer,
+	counters []*metrics.Metric) ([]*metrics.Metric, error) {
+	incrementedCounters := make([]*metrics.Metric, 0, len(counters))
+
+	for len(counters) > 0 {
+		chunk := counters
+		if len(chunk) > maxBatchRows {
+			chunk = chunk[:maxBatchRows]
+		}
+		counters = counters[len(chunk):]
+
+		args := make([]interface{}, 0, len(chunk)*3)
+		for _, counter := range chunk {
+			args = append(args, counter.ID, encodeLabels(counter.Labels), *(counter.Delta))
+		}
+
+		rows, err := conn.QueryContext(ctx,
+			"INSERT INTO counter AS c (metric_id, labels, metric_delta) VALUES "+valuesPlaceholders(len(chunk), 3)+
+				" ON CONFLICT (metric_id, labels) DO UPDATE SET metric_delta = c.metric_delta + EXCLUDED.metric_delta,"+
+				" updated_at = "+db.dialect.now+
+				" RETURNING metric_id, labels, metric_delta",
+			args...)
+		if err != nil {
+			return nil, err
+		}
+
+		incrementedCounters, err = scanCounters(rows, incrementedCounters)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return incrementedCounters, nil
+}
+
+// scanCounters appends counters from rows to the slice and closes rows
+func scanCounters(rows *sql.Rows, counters []*metrics.Metric) ([]*metrics.Metric, error) {
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			log.Error().Err(err).Msgf("Couldn't close rows")
+		}
+	}(rows)
+
+	for rows.Next() {
+		var counter metrics.Counter
+		metric := metrics.Metric{
+			MType: metrics.MetricTypeCounter,
+			Delta: &counter,
+		}
+
+		var labels []byte
+		err := rows.Scan(&metric.ID, &labels, metric.Delta)
+		if err != nil {
+			return nil, err
+		}
+
+		if metric.Labels, err = decodeLabels(labels); err != nil {
+			return nil, err
+		}
+
+		counters = append(counters, &metric)
+	}
+
+	return counters, rows.Err()
+}
+
+// valuesPlaceholders builds placeholders for rows of VALUES clause, e.g. ($1, $2), ($3, $4)
+func valuesPlaceholders(rows int, columns int) string {
+	var sb strings.Builder
+
+	for i := 0; i < rows; i++ {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteByte('(')
+		for j := 0; j < columns; j++ {
+			if j > 0 {
+				sb.WriteString(", ")
+			}
+			sb.WriteByte('$')
+			sb.WriteString(strconv.Itoa(i*columns + j + 1))
+		}
+		sb.WriteByte(')')
+	}
+
+	return sb.String()
+}
+
+// mergeHistogram merges observations to the stored histogram and returns the result of merge
+func (db *DBStore) mergeHistogram(ctx context.Context, conn queryer, name string, labels metrics.Labels,
+	histogram *metrics.Histogram) (*metrics.Histogram, error) {
+	var encodedHistogram []byte
+	row := conn.QueryRowContext(ctx,
+		"SELECT metric_histogram FROM histogram WHERE metric_id = $1 AND labels = $2"+db.dialect.forUpdate,
+		name, encodeLabels(labels))
+
+	err := row.Scan(&encodedHistogram)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		if err := histogram.Validate(); err != nil {
+			return nil, err
+		}
+		histogram = histogram.Copy()
+	case !errors.Is(err, nil):
+		return nil, err
+	default:
+		currentHistogram, err := decodeHistogram(encodedHistogram)
+		if err != nil {
+			return nil, err
+		}
+		if err := currentHistogram.Merge(histogram); err != nil {
+			return nil, err
+		}
+		histogram = currentHistogram
+	}
+
+	encoded, err := encodeHistogram(histogram)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = conn.ExecContext(ctx,
+		"INSERT INTO histogram (metric_id, labels, metric_histogram) VALUES ($1, $2, $3) "+
+			"ON CONFLICT (metric_id, labels) DO UPDATE SET metric_histogram = $3, updated_at = "+db.dialect.now,
+		name, encodeLabels(labels), encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	return histogram, nil
+}
+
+// encodeHistogram encodes histogram to store it in jsonb or text column, nil histogram is stored as NULL
+func encodeHistogram(histogram *metrics.Histogram) (sql.NullString, error) {
+	if histogram == nil {
+		return sql.NullString{}, nil
+	}
+
+	encodedHistogram, err := json.Marshal(histogram)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+
+	return sql.NullString{String: string(encodedHistogram), Valid: true}, nil
+}
+
+// decodeHistogram decodes histogram from jsonb or text column
+func decodeHistogram(encodedHistogram []byte) (*metrics.Histogram, error) {
+	var histogram metrics.Histogram
+	if err := json.Unmarshal(encodedHistogram, &histogram); err != nil {
+		return nil, err
+	}
+
+	return &histogram, nil
+}
+
+// encodeLabels encodes labels to store them in jsonb or text column,
+// keys of labels are sorted, so equal labels are encoded to equal strings
+func encodeLabels(labels metrics.Labels) string {
+	if len(labels) == 0 {
+		return "{}"
+	}
+
+	encodedLabels, err := json.Marshal(labels)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to encode labels")
+	}
+
+	return string(encodedLabels)
+}
+
+// decodeLabels decodes labels from jsonb or text column
+func decodeLabels(encodedLabels []byte) (metrics.Labels, error) {
+	var labels metrics.Labels
+	if err := json.Unmarshal(encodedLabels, &labels); err != nil {
+		return nil, err
+	}
+
+	if len(labels) == 0 {
+		return nil, nil
+	}
+
+	return labels, nil
+}
+
+// SetMetadata sets metadata of metric by its name, empty metadata removes it
+func (db *DBStore) SetMetadata(ctx context.Context, metricName string, metadata *metrics.Metadata) error {
+	if err := validateMetadata(metricName, metadata); err != nil {
+		return err
+	}
+
+	if metadata.IsEmpty() {
+		_, err := db.connection.ExecContext(ctx, "DELETE FROM metadata WHERE metric_id = $1", metricName)
+
+		return err
+	}
+
+	_, err := db.connection.ExecContext(ctx,
+		"INSERT INTO metadata (metric_id, description, unit, owner, help_url) VALUES ($1, $2, $3, $4, $5) "+
+			"ON CONFLICT (metric_id) DO UPDATE SET description = $2, unit = $3, owner = $4, help_url = $5",
+		metricName, metadata.Description, metadata.Unit, metadata.Owner, metadata.HelpURL)
+
+	return err
+}
+
+// GetMetadata returns metadata of all metrics by their names
+func (db *DBStore) GetMetadata(ctx context.Context) (map[string]*metrics.Metadata, error) {
+	rows, err := db.connection.QueryContext(ctx,
+		"SELECT metric_id, description, unit, owner, help_url FROM metadata")
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			log.Error().Err(err).Msgf("Couldn't close rows")
+		}
+	}(rows)
+
+	metadata := make(map[string]*metrics.Metadata)
+	for rows.Next() {
+		var name string
+		var md metrics.Metadata
+		if err := rows.Scan(&name, &md.Description, &md.Unit, &md.Owner, &md.HelpURL); err != nil {
+			return nil, err
+		}
+
+		metadata[name] = &md
+	}
+
+	return metadata, rows.Err()
+}
+
+// Ping checks that underlying store is alive
+func (db *DBStore) Ping(ctx context.Context) error {
+	return db.connection.PingContext(ctx)
+}
+
+// Close stops listener of notifications and closes database connection, feeds of watchers are closed
+func (db *DBStore) Close() error {
+	db.stopListener()
+
 	log.Info().Msgf("Close database connection")
 
 	return db.connection.Close()
@@ -0,0 +1,306 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/itd27m01/go-metrics-service/internal/models/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testDatabaseDSN returns DSN of the database from DATABASE_DSN or of SQLite database in temporary directory
+func testDatabaseDSN(t *testing.T) string {
+	t.Helper()
+
+	if databaseDSN := os.Getenv("DATABASE_DSN"); databaseDSN != "" {
+		return databaseDSN
+	}
+
+	return SQLiteScheme + filepath.Join(t.TempDir(), "metrics.db")
+}
+
+// newTestDBStore connects to the database of DSN and migrates it
+func newTestDBStore(t *testing.T, databaseDSN string) *DBStore {
+	t.Helper()
+
+	db, err := NewDBStore(databaseDSN, 0)
+	require.NoError(t, err)
+	require.NoError(t, db.Ping(context.Background()))
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	return db
+}
+
+func Test_valuesPlaceholders(t *testing.T) {
+	assert.Equal(t, "($1, $2, $3)", valuesPlaceholders(1, 3))
+	assert.Equal(t, "($1, $2), ($3, $4), ($5, $6)", valuesPlaceholders(3, 2))
+	assert.Equal(t, "", valuesPlaceholders(0, 3))
+}
+
+func Test_prefixUpperBound(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		want   string
+		wantOk bool
+	}{
+		{
+			name:   "The last rune is incremented",
+			prefix: "Test",
+			want:   "Tesu",
+			wantOk: true,
+		},
+		{
+			name:   "Maximal runes are dropped",
+			prefix: "a\U0010FFFF",
+			want:   "b",
+			wantOk: true,
+		},
+		{
+			name:   "Surrogate halves are skipped",
+			prefix: "\uD7FF",
+			want:   "\uE000",
+			wantOk: true,
+		},
+		{
+			name:   "No bound of maximal runes",
+			prefix: "\U0010FFFF",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := prefixUpperBound(tt.prefix)
+			assert.Equal(t, tt.wantOk, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestDBStore_listQueryUsesIndex(t *testing.T) {
+	db := newTestDBStore(t, SQLiteScheme+filepath.Join(t.TempDir(), "metrics.db"))
+
+	query, args := db.listQuery(metrics.MetricTypeGauge, &ListFilter{Prefix: "Test"}, nil, 10)
+	rows, err := db.connection.QueryContext(context.Background(), "EXPLAIN QUERY PLAN "+query, args...)
+	require.NoError(t, err)
+	defer rows.Close()
+
+	var plan []string
+	for rows.Next() {
+		var id, parent, unused int
+		var detail string
+		require.NoError(t, rows.Scan(&id, &parent, &unused, &detail))
+		plan = append(plan, detail)
+	}
+	require.NoError(t, rows.Err())
+	require.NotEmpty(t, plan)
+	assert.Contains(t, plan[0], "SEARCH", "prefix must be searched by index of names")
+}
+
+func Test_splitMetricsBatch(t *testing.T) {
+	gauge1, gauge2 := metrics.Gauge(1), metrics.Gauge(2)
+	delta1, delta2 := metrics.Counter(1), metrics.Counter(2)
+
+	gauges, counters, histograms, err := splitMetricsBatch([]*metrics.Metric{
+		{ID: "b", MType: metrics.MetricTypeCounter, Delta: &delta1},
+		{ID: "a", MType: metrics.MetricTypeGauge, Value: &gauge1},
+		{ID: "a", MType: metrics.MetricTypeCounter, Delta: &delta2},
+		{ID: "a", MType: metrics.MetricTypeGauge, Value: &gauge2},
+		{ID: "b", MType: metrics.MetricTypeCounter, Delta: &delta2},
+		{ID: "h", MType: metrics.MetricTypeHistogram, Histogram: metrics.NewHistogram()},
+	})
+	require.NoError(t, err)
+
+	require.Len(t, gauges, 1)
+	assert.Equal(t, metrics.Gauge(2), *gauges[0].Value)
+
+	require.Len(t, counters, 2)
+	assert.Equal(t, "a", counters[0].ID)
+	assert.Equal(t, metrics.Counter(2), *counters[0].Delta)
+	assert.Equal(t, "b", counters[1].ID)
+	assert.Equal(t, metrics.Counter(3), *counters[1].Delta)
+	assert.Equal(t, metrics.Counter(1), delta1, "deltas of batch must not be modified")
+
+	assert.Len(t, histograms, 1)
+
+	_, _, _, err = splitMetricsBatch([]*metrics.Metric{{ID: "a", MType: metrics.MetricTypeCounter}})
+	assert.ErrorIs(t, err, ErrInvalidMetric)
+}
+
+func TestDBStore_ConcurrentCounterUpdates(t *testing.T) {
+	// Two stores act as server replicas which share one database
+	databaseDSN := testDatabaseDSN(t)
+	replicas := []*DBStore{newTestDBStore(t, databaseDSN), newTestDBStore(t, databaseDSN)}
+	ctx := context.Background()
+
+	counterName := fmt.Sprintf("TestCounter%d", time.Now().UnixNano())
+	labeledName := metrics.Key(counterName, metrics.Labels{"replica": "any"})
+	t.Cleanup(func() {
+		_, err := replicas[0].connection.Exec("DELETE FROM counter WHERE metric_id = $1", counterName)
+		assert.NoError(t, err)
+	})
+
+	const workers = 8
+	const iterations = 50
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(db *DBStore) {
+			defer wg.Done()
+
+			one, two := metrics.Counter(1), metrics.Counter(2)
+			for j := 0; j < iterations; j++ {
+				assert.NoError(t, db.UpdateCounterMetric(ctx, counterName, 1))
+				assert.NoError(t, db.UpdateMetrics(ctx, []*metrics.Metric{
+					{ID: counterName, MType: metrics.MetricTypeCounter, Delta: &one},
+					{ID: counterName, MType: metrics.MetricTypeCounter, Delta: &two},
+					{
+						ID:     counterName,
+						Labels: metrics.Labels{"replica": "any"},
+						MType:  metrics.MetricTypeCounter,
+						Delta:  &one,
+					},
+				}))
+			}
+		}(replicas[i%len(replicas)])
+	}
+	wg.Wait()
+
+	counter, err := replicas[0].GetMetric(ctx, counterName, metrics.MetricTypeCounter)
+	require.NoError(t, err)
+	assert.Equal(t, metrics.Counter(workers*iterations*4), *counter.Delta)
+
+	labeledCounter, err := replicas[1].GetMetric(ctx, labeledName, metrics.MetricTypeCounter)
+	require.NoError(t, err)
+	assert.Equal(t, metrics.Counter(workers*iterations), *labeledCounter.Delta)
+}
+
+func TestDBStore_DeleteMetrics(t *testing.T) {
+	db := newTestDBStore(t, testDatabaseDSN(t))
+	ctx := context.Background()
+
+	require.NoError(t, db.UpdateGaugeMetric(ctx, "TestDeleteAloc", 1))
+	require.NoError(t, db.UpdateGaugeMetric(ctx, `TestDeleteDecommissioned{host="db1"}`, 1))
+	require.NoError(t, db.UpdateCounterMetric(ctx, `TestDeleteDecommissioned{host="db2"}`, 1))
+
+	assert.ErrorIs(t, db.DeleteMetric(ctx, "TestDeleteAloc", metrics.MetricTypeCounter), ErrMetricNotFound)
+	require.NoError(t, db.DeleteMetric(ctx, "TestDeleteAloc", metrics.MetricTypeGauge))
+	_, err := db.GetMetric(ctx, "TestDeleteAloc", metrics.MetricTypeGauge)
+	assert.ErrorIs(t, err, ErrMetricNotFound)
+
+	deleted, err := db.DeleteMetrics(ctx, metrics.NewPrefixMatcher("TestDeleteDecommissioned"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+
+	metricsData, err := db.GetMetrics(ctx, metrics.NewPrefixMatcher("TestDelete"))
+	require.NoError(t, err)
+	assert.Empty(t, metricsData)
+}
+
+func TestDBStore_DeleteStaleMetrics(t *testing.T) {
+	db := newTestDBStore(t, testDatabaseDSN(t))
+	ctx := context.Background()
+	ttl := &TTL{
+		Overrides: map[string]time.Duration{"TestStale": time.Minute},
+	}
+
+	require.NoError(t, db.UpdateGaugeMetric(ctx, `TestStaleGauge{host="gone"}`, 1))
+	require.NoError(t, db.UpdateCounterMetric(ctx, "TestStaleCounter", 1))
+
+	deleted, err := db.DeleteStaleMetrics(ctx, ttl, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 0, deleted)
+
+	deleted, err = db.DeleteStaleMetrics(ctx, ttl, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+
+	metricsData, err := db.GetMetrics(ctx, metrics.NewPrefixMatcher("TestStale"))
+	require.NoError(t, err)
+	assert.Empty(t, metricsData)
+}
+
+func TestDBStore_History(t *testing.T) {
+	db, err := NewDBStore(testDatabaseDSN(t), time.Hour)
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, db.Close()) })
+	ctx := context.Background()
+
+	metricName := fmt.Sprintf(`TestHistory%d{host="db"}`, time.Now().UnixNano())
+	histogram := metrics.NewHistogram(1, 2)
+	histogram.Observe(1)
+	require.NoError(t, db.UpdateGaugeMetric(ctx, metricName, 1))
+	require.NoError(t, db.UpdateGaugeMetric(ctx, metricName, 2))
+	require.NoError(t, db.UpdateHistogramMetric(ctx, metricName, histogram))
+	require.NoError(t, db.UpdateHistogramMetric(ctx, metricName, histogram))
+
+	value := metrics.Gauge(0)
+	require.NoError(t, db.AppendSamples(ctx, metricName, metrics.MetricTypeGauge,
+		metrics.Sample{Timestamp: time.Now().Add(-time.Minute), Value: &value}))
+
+	samples, err := db.GetHistory(ctx, metricName, metrics.MetricTypeGauge, time.Time{}, time.Now(), 0)
+	require.NoError(t, err)
+	require.Len(t, samples, 3)
+	assert.Equal(t, metrics.Gauge(0), *samples[0].Value)
+	assert.Equal(t, metrics.Gauge(2), *samples[2].Value)
+
+	samples, err = db.GetHistory(ctx, metricName, metrics.MetricTypeHistogram, time.Time{}, time.Now(), 0)
+	require.NoError(t, err)
+	require.Len(t, samples, 2)
+	assert.Equal(t, metrics.Counter(2), samples[1].Histogram.Count)
+
+	require.NoError(t, db.DeleteMetric(ctx, metricName, metrics.MetricTypeGauge))
+	samples, err = db.GetHistory(ctx, metricName, metrics.MetricTypeGauge, time.Time{}, time.Now(), 0)
+	require.NoError(t, err)
+	assert.Empty(t, samples, "history must be deleted with metric")
+}
+
+func TestDBStore_HistoryOfBatchAndPrune(t *testing.T) {
+	db, err := NewDBStore(testDatabaseDSN(t), time.Hour)
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, db.Close()) })
+	ctx := context.Background()
+
+	prefix := fmt.Sprintf("TestHistoryBatch%d", time.Now().UnixNano())
+	value, delta := metrics.Gauge(1), metrics.Counter(1)
+	batch := make([]*metrics.Metric, 0, maxBatchRows+2)
+	for i := 0; i <= maxBatchRows; i++ {
+		batch = append(batch, &metrics.Metric{ID: fmt.Sprintf("%s_%d", prefix, i), MType: metrics.MetricTypeGauge,
+			Value: &value})
+	}
+	batch = append(batch, &metrics.Metric{ID: prefix, MType: metrics.MetricTypeCounter, Delta: &delta})
+	require.NoError(t, db.UpdateMetrics(ctx, batch))
+	t.Cleanup(func() {
+		_, err := db.DeleteMetrics(ctx, metrics.NewPrefixMatcher(prefix))
+		assert.NoError(t, err)
+	})
+
+	for _, metric := range []*metrics.Metric{batch[0], batch[maxBatchRows], batch[maxBatchRows+1]} {
+		samples, err := db.GetHistory(ctx, metric.ID, metric.MType, time.Time{}, time.Now(), 0)
+		require.NoError(t, err)
+		assert.Len(t, samples, 1, "every metric of batch must be recorded in history")
+	}
+
+	expired := metrics.Gauge(0)
+	require.NoError(t, db.AppendSamples(ctx, batch[0].ID, metrics.MetricTypeGauge,
+		metrics.Sample{Timestamp: time.Now().Add(-2 * time.Hour), Value: &expired}))
+
+	pruned, err := db.PruneHistory(ctx, time.Now())
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, pruned, 1)
+
+	samples, err := db.GetHistory(ctx, batch[0].ID, metrics.MetricTypeGauge, time.Time{}, time.Now(), 0)
+	require.NoError(t, err)
+	require.Len(t, samples, 1, "expired samples must be pruned")
+	assert.Equal(t, metrics.Gauge(1), *samples[0].Value)
+}
@@ -0,0 +1,258 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+
+	"github.com/itd27m01/go-metrics-service/internal/models/metrics"
+	"github.com/itd27m01/go-metrics-service/pkg/logging/log"
+)
+
+const (
+	// notifyChannel is the channel of notifications about updates of metrics in PostgreSQL
+	notifyChannel = "metrics_updates"
+	// maxNotifyPayload keeps payload of notification below the limit of PostgreSQL of 8000 bytes
+	maxNotifyPayload = 7900
+	// listenRetryInterval is the interval between attempts to listen notifications after failure
+	listenRetryInterval = time.Second
+)
+
+// dbListener receives notifications about updates of metrics by all of replicas and publishes them to watchers
+type dbListener struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// notification is a payload of notification about updated or deleted metrics, metrics which don't fit to payload
+// are sent without values and their values are loaded by receivers
+type notification struct {
+	Type    string            `json:"type"`
+	Metrics []*metrics.Metric `json:"metrics"`
+}
+
+// Watch returns change feed of metrics which satisfy the filter. Watchers of PostgreSQL database receive updates
+// of all replicas by LISTEN/NOTIFY, the feed is closed if connection of listener is lost.
+func (db *DBStore) Watch(ctx context.Context, filter *WatchFilter) (<-chan *Event, error) {
+	if db.dialect.notify {
+		if err := db.startListener(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	return db.watchers.watch(ctx, filter), nil
+}
+
+// publish delivers events of changed metrics to watchers: local watchers of SQLite database receive them directly,
+// updates of PostgreSQL database are sent to watchers of all replicas by NOTIFY. Failures are only logged,
+// metrics are already changed.
+func (db *DBStore) publish(ctx context.Context, eventType string, changed ...*metrics.Metric) {
+	if len(changed) == 0 {
+		return
+	}
+
+	if !db.dialect.notify {
+		db.watchers.publish(eventType, changed...)
+
+		return
+	}
+
+	payloads, err := notifyPayloads(eventType, changed)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to encode notification about updates of metrics")
+
+		return
+	}
+
+	for _, payload := range payloads {
+		if _, err := db.connection.ExecContext(ctx, "SELECT pg_notify($1, $2)", notifyChannel, payload); err != nil {
+			log.Error().Err(err).Msg("Failed to notify about updates of metrics")
+
+			return
+		}
+	}
+}
+
+// notifyPayloads packs events of changed metrics to as few payloads of notifications as possible
+func notifyPayloads(eventType string, changed []*metrics.Metric) ([]string, error) {
+	head, err := json.Marshal(eventType)
+	if err != nil {
+		return nil, err
+	}
+	prefix, suffix := `{"type":`+string(head)+`,"metrics":[`, "]}"
+
+	payloads := make([]string, 0, 1)
+	var sb strings.Builder
+	for _, metric := range changed {
+		encoded, err := json.Marshal(metric)
+		if err != nil {
+			return nil, err
+		}
+		if len(prefix)+len(encoded)+len(suffix) > maxNotifyPayload {
+			encoded, err = json.Marshal(&metrics.Metric{ID: metric.ID, Labels: metric.Labels, MType: metric.MType})
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if sb.Len() > 0 && sb.Len()+1+len(encoded)+len(suffix) > maxNotifyPayload {
+			sb.WriteString(suffix)
+			payloads = append(payloads, sb.String())
+			sb.Reset()
+		}
+		if sb.Len() == 0 {
+			sb.WriteString(prefix)
+		} else {
+			sb.WriteByte(',')
+		}
+		sb.Write(encoded)
+	}
+	if sb.Len() > 0 {
+		sb.WriteString(suffix)
+		payloads = append(payloads, sb.String())
+	}
+
+	return payloads, nil
+}
+
+// startListener starts listener of notifications if it isn't started yet, the first connection is made
+// synchronously, so updates made after Watch returns are delivered
+func (db *DBStore) startListener(ctx context.Context) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.listener != nil {
+		return nil
+	}
+
+	conn, err := db.connectListener(ctx)
+	if err != nil {
+		return err
+	}
+
+	listenCtx, cancel := context.WithCancel(context.Background())
+	db.listener = &dbListener{
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go db.listen(listenCtx, conn, db.listener.done)
+
+	return nil
+}
+
+// stopListener stops listener of notifications and closes feeds of watchers
+func (db *DBStore) stopListener() {
+	db.mu.Lock()
+	listener := db.listener
+	db.listener = nil
+	db.mu.Unlock()
+
+	if listener != nil {
+		listener.cancel()
+		<-listener.done
+	}
+
+	db.watchers.reset()
+}
+
+// connectListener opens dedicated connection to PostgreSQL database which listens notifications
+func (db *DBStore) connectListener(ctx context.Context) (*pgx.Conn, error) {
+	conn, err := pgx.Connect(ctx, db.dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := conn.Exec(ctx, "LISTEN "+notifyChannel); err != nil {
+		if err := conn.Close(ctx); err != nil {
+			log.Error().Err(err).Msg("Failed to close listener connection")
+		}
+
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// listen publishes notifications to watchers until the context is done, lost connection is reopened and
+// feeds of watchers are closed because they miss notifications
+func (db *DBStore) listen(ctx context.Context, conn *pgx.Conn, done chan struct{}) {
+	defer close(done)
+
+	for {
+		err := db.receiveNotifications(ctx, conn)
+		if err := conn.Close(context.Background()); err != nil {
+			log.Error().Err(err).Msg("Failed to close listener connection")
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		log.Error().Err(err).Msg("Lost notifications about updates of metrics")
+		db.watchers.reset()
+
+		if conn = db.reconnectListener(ctx); conn == nil {
+			return
+		}
+	}
+}
+
+// reconnectListener tries to listen notifications again until the context is done, it returns nil in that case
+func (db *DBStore) reconnectListener(ctx context.Context) *pgx.Conn {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(listenRetryInterval):
+		}
+
+		conn, err := db.connectListener(ctx)
+		if err == nil {
+			return conn
+		}
+
+		log.Error().Err(err).Msg("Failed to listen notifications about updates of metrics")
+	}
+}
+
+// receiveNotifications publishes received notifications to watchers until connection fails
+func (db *DBStore) receiveNotifications(ctx context.Context, conn *pgx.Conn) error {
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		db.publishNotification(ctx, n.Payload)
+	}
+}
+
+// publishNotification decodes notification and publishes its events to watchers, values of updated metrics
+// which are sent without them are loaded from database
+func (db *DBStore) publishNotification(ctx context.Context, payload string) {
+	var n notification
+	if err := json.Unmarshal([]byte(payload), &n); err != nil {
+		log.Error().Err(err).Msg("Failed to decode notification about updates of metrics")
+
+		return
+	}
+
+	changed := make([]*metrics.Metric, 0, len(n.Metrics))
+	for _, metric := range n.Metrics {
+		if n.Type == EventUpdate && metric.Delta == nil && metric.Value == nil && metric.Histogram == nil {
+			loaded, err := db.GetMetric(ctx, metric.Key(), metric.MType)
+			if err != nil {
+				log.Error().Err(err).Msgf("Failed to load updated metric %s", metric.Key())
+
+				continue
+			}
+			metric = loaded
+		}
+
+		changed = append(changed, metric)
+	}
+
+	db.watchers.publish(n.Type, changed...)
+}
@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"database/sql"
+	"embed"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v4/stdlib" // init postgresql driver
+	_ "modernc.org/sqlite"             // init sqlite driver
+
+	"github.com/itd27m01/go-metrics-service/db"
+)
+
+const (
+	psqlDriverName   = "pgx"
+	sqliteDriverName = "sqlite"
+	// SQLiteScheme is the scheme of DSN which selects SQLite database, e.g. sqlite:///var/lib/metrics.db
+	SQLiteScheme = "sqlite://"
+	// sqliteParams make concurrent writers wait for each other instead of failing with SQLITE_BUSY
+	sqliteParams = "_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate"
+	// sqliteTimeLayout is the layout of timestamps in SQLite, it matches strftime('%Y-%m-%d %H:%M:%f'),
+	// timestamps are stored in UTC with fixed width to compare them as strings
+	sqliteTimeLayout = "2006-01-02 15:04:05.000"
+)
+
+// sqlDialect defines differences of SQL databases which DBStore supports
+type sqlDialect struct {
+	driverName       string
+	migrations       embed.FS
+	migrationsDir    string
+	now              string                      // expression of the current moment to set updated_at
+	forUpdate        string                      // clause which locks selected rows till the end of transaction
+	collate          string                      // clause which compares text as bytes
+	labelsText       string                      // expression of labels as text to order metrics by them
+	lockMigrations   string                      // statement which serializes migrations of replicas, optional
+	unlockMigrations string                      // statement which releases lock of migrations, optional
+	notify           bool                        // updates are delivered to watchers of all replicas by NOTIFY
+	timeValue        func(time.Time) interface{} // converts time to the argument of statement
+}
+
+var postgresDialect = sqlDialect{
+	driverName:       psqlDriverName,
+	migrations:       db.Migrations,
+	migrationsDir:    "migrations",
+	now:              "now()",
+	forUpdate:        " FOR UPDATE",
+	collate:          ` COLLATE "C"`,
+	labelsText:       "(labels::text)",
+	lockMigrations:   "SELECT pg_advisory_lock($1)",
+	unlockMigrations: "SELECT pg_advisory_unlock($1)",
+	notify:           true,
+	timeValue:        func(t time.Time) interface{} { return t },
+}
+
+var sqliteDialect = sqlDialect{
+	driverName:    sqliteDriverName,
+	migrations:    db.SQLiteMigrations,
+	migrationsDir: "sqlite",
+	now:           "strftime('%Y-%m-%d %H:%M:%f', 'now')",
+	labelsText:    "labels",
+	timeValue:     func(t time.Time) interface{} { return t.UTC().Format(sqliteTimeLayout) },
+}
+
+// openDatabase opens database of DSN with its dialect, DSN with sqlite:// scheme selects SQLite database
+// and its path, any other DSN is passed to PostgreSQL driver
+func openDatabase(databaseDSN string) (*sql.DB, *sqlDialect, error) {
+	dialect := &postgresDialect
+	if strings.HasPrefix(databaseDSN, SQLiteScheme) {
+		dialect = &sqliteDialect
+		databaseDSN = strings.TrimPrefix(databaseDSN, SQLiteScheme)
+		if strings.Contains(databaseDSN, "?") {
+			databaseDSN += "&" + sqliteParams
+		} else {
+			databaseDSN += "?" + sqliteParams
+		}
+	}
+
+	conn, err := sql.Open(dialect.driverName, databaseDSN)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return conn, dialect, nil
+}
@@ -2,33 +2,48 @@ package repository
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/itd27m01/go-metrics-service/internal/models/metrics"
+	"github.com/itd27m01/go-metrics-service/pkg/encryption"
 	"github.com/itd27m01/go-metrics-service/pkg/logging/log"
 )
 
 var (
-	_ Store = (*FileStore)(nil)
+	_ Store        = (*FileStore)(nil)
+	_ HistoryStore = (*FileStore)(nil)
 )
 
 const (
 	fileMode = 0640
 )
 
-// FileStore implements Store interface to store metrics in file
+// FileStore implements Store interface to store metrics in file.
+// History of metrics is kept in memory only and isn't dumped to the file.
+// With enabled write-ahead log every update is appended to the log and the snapshot in file is its compaction.
+// Snapshots are replaced atomically and the previous snapshot is kept next to the file to fall back to.
 type FileStore struct {
+	filePath     string
 	file         *os.File
+	wal          *writeAheadLog
+	keys         *encryption.AESKeyRing
 	syncChannel  chan struct{}
 	metricsCache map[string]*metrics.Metric
+	history      *metricsHistory
+	updated      updateTimes
+	metadata     metadataRegistry
+	watchers     *watchHub
 	mu           sync.Mutex
 }
 
-// NewFileStore creates in file store
-func NewFileStore(filePath string, syncChannel chan struct{}) (*FileStore, error) {
+// NewFileStore creates in file store, zero historyRetention disables history of metrics
+func NewFileStore(filePath string, syncChannel chan struct{}, historyRetention time.Duration) (*FileStore, error) {
 	var fs FileStore
 
 	file, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE, fileMode)
@@ -38,183 +53,623 @@ func NewFileStore(filePath string, syncChannel chan struct{}) (*FileStore, error
 
 	metricsCache := make(map[string]*metrics.Metric)
 	fs = FileStore{
+		filePath:     filePath,
 		file:         file,
 		syncChannel:  syncChannel,
 		metricsCache: metricsCache,
+		history:      newMetricsHistory(historyRetention),
+		watchers:     newWatchHub(),
 	}
 
 	return &fs, nil
 }
 
+// EnableWAL opens write-ahead log, it should be enabled before metrics are loaded and updated
+func (fs *FileStore) EnableWAL(walPath string) error {
+	wal, err := openWriteAheadLog(walPath)
+	if err != nil {
+		return err
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.wal = wal
+
+	return nil
+}
+
+// EncryptSnapshots encrypts snapshots with the current key of keys and decrypts them with any of keys,
+// it should be enabled before metrics are loaded. Unencrypted snapshot is loaded and encrypted on the next save.
+func (fs *FileStore) EncryptSnapshots(keys *encryption.AESKeyRing) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.keys = keys
+}
+
 // UpdateCounterMetric updates counter metric type
 func (fs *FileStore) UpdateCounterMetric(_ context.Context, metricName string, metricData metrics.Counter) error {
+	metricKey, name, labels, err := parseMetricKey(metricName)
+	if err != nil {
+		return err
+	}
+
 	fs.mu.Lock()
 	defer fs.sync()
 	defer fs.mu.Unlock()
 
-	currentMetric, ok := fs.metricsCache[metricName]
+	var updatedMetric *metrics.Metric
+	currentMetric, ok := fs.metricsCache[metricKey]
 	switch {
 	case ok && currentMetric.Delta != nil:
-		*(currentMetric.Delta) += metricData
+		updatedMetric = currentMetric.Copy()
+		*(updatedMetric.Delta) += metricData
 	case ok && currentMetric.Delta == nil:
-		return fmt.Errorf("%w %s:%s", ErrMetricTypeMismatch, metricName, currentMetric.MType)
+		return fmt.Errorf("%w %s:%s", ErrMetricTypeMismatch, metricKey, currentMetric.MType)
 	default:
-		fs.metricsCache[metricName] = &metrics.Metric{
-			ID:    metricName,
-			MType: metrics.MetricTypeCounter,
-			Delta: &metricData,
+		updatedMetric = &metrics.Metric{
+			ID:     name,
+			Labels: labels,
+			MType:  metrics.MetricTypeCounter,
+			Delta:  &metricData,
 		}
 	}
 
-	return nil
+	return fs.commitMetrics(updatedMetric)
 }
 
 // ResetCounterMetric resets counter to default zero value
 func (fs *FileStore) ResetCounterMetric(_ context.Context, metricName string) error {
+	metricKey, name, labels, err := parseMetricKey(metricName)
+	if err != nil {
+		return err
+	}
+
 	fs.mu.Lock()
 	defer fs.sync()
 	defer fs.mu.Unlock()
 
 	var zero metrics.Counter
-	currentMetric, ok := fs.metricsCache[metricName]
+	var updatedMetric *metrics.Metric
+	currentMetric, ok := fs.metricsCache[metricKey]
 	switch {
 	case ok && currentMetric.Delta != nil:
-		*(currentMetric.Delta) = zero
+		updatedMetric = currentMetric.Copy()
+		*(updatedMetric.Delta) = zero
 	case ok && currentMetric.Delta == nil:
-		return fmt.Errorf("%w %s:%s", ErrMetricTypeMismatch, metricName, currentMetric.MType)
+		return fmt.Errorf("%w %s:%s", ErrMetricTypeMismatch, metricKey, currentMetric.MType)
 	default:
-		fs.metricsCache[metricName] = &metrics.Metric{
-			ID:    metricName,
-			MType: metrics.MetricTypeCounter,
-			Delta: &zero,
+		updatedMetric = &metrics.Metric{
+			ID:     name,
+			Labels: labels,
+			MType:  metrics.MetricTypeCounter,
+			Delta:  &zero,
 		}
 	}
 
-	return nil
+	return fs.commitMetrics(updatedMetric)
 }
 
 // UpdateGaugeMetric updates gauge type metric
 func (fs *FileStore) UpdateGaugeMetric(_ context.Context, metricName string, metricData metrics.Gauge) error {
+	metricKey, name, labels, err := parseMetricKey(metricName)
+	if err != nil {
+		return err
+	}
+
+	fs.mu.Lock()
+	defer fs.sync()
+	defer fs.mu.Unlock()
+
+	if currentMetric, ok := fs.metricsCache[metricKey]; ok && currentMetric.Value == nil {
+		return fmt.Errorf("%w %s:%s", ErrMetricTypeMismatch, metricKey, currentMetric.MType)
+	}
+
+	return fs.setGauge(metricKey, name, labels, metricData)
+}
+
+// AddGauge adds delta to gauge type metric and returns its new value
+func (fs *FileStore) AddGauge(_ context.Context, metricName string, delta metrics.Gauge) (metrics.Gauge, error) {
+	metricKey, name, labels, err := parseMetricKey(metricName)
+	if err != nil {
+		return 0, err
+	}
+
+	fs.mu.Lock()
+	defer fs.sync()
+	defer fs.mu.Unlock()
+
+	value, err := addGauge(metricKey, fs.metricsCache[metricKey], delta)
+	if err != nil {
+		return 0, err
+	}
+
+	return value, fs.setGauge(metricKey, name, labels, value)
+}
+
+// CompareAndSetGauge sets value of gauge type metric if its current value equals to expected one
+func (fs *FileStore) CompareAndSetGauge(_ context.Context, metricName string, expected *metrics.Gauge,
+	value metrics.Gauge) error {
+	metricKey, name, labels, err := parseMetricKey(metricName)
+	if err != nil {
+		return err
+	}
+
+	fs.mu.Lock()
+	defer fs.sync()
+	defer fs.mu.Unlock()
+
+	if err := compareGauge(metricKey, fs.metricsCache[metricKey], expected); err != nil {
+		return err
+	}
+
+	return fs.setGauge(metricKey, name, labels, value)
+}
+
+// setGauge does actual work to set value of gauge by its key and log the update, the lock of store must be held
+func (fs *FileStore) setGauge(metricKey string, name string, labels metrics.Labels, value metrics.Gauge) error {
+	updatedMetric := &metrics.Metric{
+		ID:     name,
+		Labels: labels,
+		MType:  metrics.MetricTypeGauge,
+		Value:  &value,
+	}
+	if currentMetric, ok := fs.metricsCache[metricKey]; ok {
+		updatedMetric = currentMetric.Copy()
+		*(updatedMetric.Value) = value
+	}
+
+	return fs.commitMetrics(updatedMetric)
+}
+
+// UpdateHistogramMetric merges observations to histogram type metric
+func (fs *FileStore) UpdateHistogramMetric(_ context.Context, metricName string, metricData *metrics.Histogram) error {
+	metricKey, name, labels, err := parseMetricKey(metricName)
+	if err != nil {
+		return err
+	}
+
 	fs.mu.Lock()
 	defer fs.sync()
 	defer fs.mu.Unlock()
 
-	currentMetric, ok := fs.metricsCache[metricName]
+	var updatedMetric *metrics.Metric
+	currentMetric, ok := fs.metricsCache[metricKey]
 	switch {
-	case ok && currentMetric.Value != nil:
-		*(currentMetric.Value) = metricData
-	case ok && currentMetric.Value == nil:
-		return fmt.Errorf("%w %s:%s", ErrMetricTypeMismatch, metricName, currentMetric.MType)
+	case ok && currentMetric.Histogram != nil:
+		updatedMetric = currentMetric.Copy()
+		if err := updatedMetric.Histogram.Merge(metricData); err != nil {
+			return err
+		}
+	case ok && currentMetric.Histogram == nil:
+		return fmt.Errorf("%w %s:%s", ErrMetricTypeMismatch, metricKey, currentMetric.MType)
 	default:
-		fs.metricsCache[metricName] = &metrics.Metric{
-			ID:    metricName,
-			MType: metrics.MetricTypeGauge,
-			Value: &metricData,
+		if err := metricData.Validate(); err != nil {
+			return err
+		}
+		updatedMetric = &metrics.Metric{
+			ID:        name,
+			Labels:    labels,
+			MType:     metrics.MetricTypeHistogram,
+			Histogram: metricData.Copy(),
 		}
 	}
 
-	return nil
+	return fs.commitMetrics(updatedMetric)
 }
 
-// UpdateMetrics update number of metrics
+// UpdateMetrics update number of metrics, observations of histograms are merged.
+// Metrics of batch which precede the failed one are updated.
 func (fs *FileStore) UpdateMetrics(_ context.Context, metricsBatch []*metrics.Metric) error {
+	if err := validateMetrics(metricsBatch); err != nil {
+		return err
+	}
+
 	fs.mu.Lock()
 	defer fs.sync()
 	defer fs.mu.Unlock()
 
+	updatedMetrics, err := fs.updateMetrics(metricsBatch)
+	if commitErr := fs.commitMetrics(updatedMetrics...); commitErr != nil {
+		return commitErr
+	}
+
+	return err
+}
+
+// updateMetrics does actual work to merge metrics of batch with the stored ones, it returns the resulting metrics
+// which aren't applied to the store yet. Metrics which precede the failed one are returned with the error.
+func (fs *FileStore) updateMetrics(metricsBatch []*metrics.Metric) ([]*metrics.Metric, error) {
+	updatedMetrics := make([]*metrics.Metric, 0, len(metricsBatch))
+	updatedKeys := make(map[string]*metrics.Metric, len(metricsBatch))
+
 	for _, metric := range metricsBatch {
-		currentMetric, ok := fs.metricsCache[metric.ID]
+		metricKey := metric.Key()
+		currentMetric, ok := updatedKeys[metricKey]
+		if !ok {
+			currentMetric, ok = fs.metricsCache[metricKey]
+			if ok {
+				currentMetric = currentMetric.Copy()
+			}
+		}
+
 		switch {
 		case ok && metric.MType == metrics.MetricTypeGauge && currentMetric.Value != nil:
-			currentMetric.Value = metric.Value
+			*(currentMetric.Value) = *(metric.Value)
 		case ok && metric.MType == metrics.MetricTypeGauge && currentMetric.Value == nil:
-			return fmt.Errorf("%w %s:%s", ErrMetricTypeMismatch, metric.ID, currentMetric.MType)
+			return updatedMetrics, fmt.Errorf("%w %s:%s", ErrMetricTypeMismatch, metricKey, currentMetric.MType)
 		case ok && metric.MType == metrics.MetricTypeCounter && currentMetric.Delta != nil:
 			*(currentMetric.Delta) += *(metric.Delta)
 		case ok && metric.MType == metrics.MetricTypeCounter && currentMetric.Delta == nil:
-			return fmt.Errorf("%w %s:%s", ErrMetricTypeMismatch, metric.ID, currentMetric.MType)
+			return updatedMetrics, fmt.Errorf("%w %s:%s", ErrMetricTypeMismatch, metricKey, currentMetric.MType)
+		case ok && metric.MType == metrics.MetricTypeHistogram && currentMetric.Histogram != nil:
+			if err := currentMetric.Histogram.Merge(metric.Histogram); err != nil {
+				return updatedMetrics, err
+			}
+		case ok && metric.MType == metrics.MetricTypeHistogram && currentMetric.Histogram == nil:
+			return updatedMetrics, fmt.Errorf("%w %s:%s", ErrMetricTypeMismatch, metricKey, currentMetric.MType)
+		case metric.MType == metrics.MetricTypeHistogram:
+			if err := metric.Histogram.Validate(); err != nil {
+				return updatedMetrics, err
+			}
+			currentMetric = metric.Copy()
 		default:
-			fs.metricsCache[metric.ID] = metric
+			currentMetric = metric.Copy()
 		}
+
+		if _, ok := updatedKeys[metricKey]; !ok {
+			updatedMetrics = append(updatedMetrics, currentMetric)
+		}
+		updatedKeys[metricKey] = currentMetric
+	}
+
+	return updatedMetrics, nil
+}
+
+// commitMetrics logs updated metrics to write-ahead log and then replaces the stored ones by them,
+// so update isn't visible until it's durable. The lock of store must be held.
+func (fs *FileStore) commitMetrics(updatedMetrics ...*metrics.Metric) error {
+	if err := fs.wal.append(updatedMetrics...); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, metric := range updatedMetrics {
+		metricKey := metric.Key()
+		fs.metricsCache[metricKey] = metric
+		fs.history.record(metric)
+		fs.watchers.publish(EventUpdate, metric)
+		fs.updated.touch(metricKey, now)
 	}
 
 	return nil
 }
 
-// GetMetric return metric by name
+// GetMetric return copy of metric by name
 func (fs *FileStore) GetMetric(_ context.Context, metricName string, _ string) (*metrics.Metric, error) {
-	metric, ok := fs.metricsCache[metricName]
+	metricKey, _, _, err := parseMetricKey(metricName)
+	if err != nil {
+		return nil, err
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	metric, ok := fs.metricsCache[metricKey]
 	if !ok {
 		return nil, ErrMetricNotFound
 	}
 
-	return metric, nil
+	return metric.Copy(), nil
+}
+
+// GetMetrics returns copies of all stored metrics which satisfy label matchers
+func (fs *FileStore) GetMetrics(_ context.Context, matchers ...*metrics.Matcher) (map[string]*metrics.Metric, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	metricsData := make(map[string]*metrics.Metric, len(fs.metricsCache))
+
+	for k, v := range fs.metricsCache {
+		if metrics.MatchesAll(v, matchers) {
+			metricsData[k] = v.Copy()
+		}
+	}
+
+	return metricsData, nil
 }
 
-// GetMetrics returns all of stored metrics
-func (fs *FileStore) GetMetrics(_ context.Context) (map[string]*metrics.Metric, error) {
-	return fs.metricsCache, nil
+// ListMetrics returns the page of copies of stored metrics which satisfy the filter
+func (fs *FileStore) ListMetrics(_ context.Context, filter *ListFilter) (*MetricsPage, error) {
+	cursor, err := filter.cursor()
+	if err != nil {
+		return nil, err
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return newMetricsPage(listMetrics(nil, fs.metricsCache, filter, cursor), filter), nil
 }
 
-// sync sends signal to flush data to disk
+// DeleteMetric removes metric and its history
+func (fs *FileStore) DeleteMetric(_ context.Context, metricName string, metricType string) error {
+	metricKey, _, _, err := parseMetricKey(metricName)
+	if err != nil {
+		return err
+	}
+
+	fs.mu.Lock()
+	defer fs.sync()
+	defer fs.mu.Unlock()
+
+	currentMetric, ok := fs.metricsCache[metricKey]
+	switch {
+	case !ok:
+		return fmt.Errorf("%w %s:%s", ErrMetricNotFound, metricKey, metricType)
+	case currentMetric.MType != metricType:
+		return fmt.Errorf("%w %s:%s", ErrMetricTypeMismatch, metricKey, currentMetric.MType)
+	}
+
+	return fs.commitDeleted(metricKey)
+}
+
+// DeleteMetrics removes all of metrics which satisfy label matchers and returns their number
+func (fs *FileStore) DeleteMetrics(_ context.Context, matchers ...*metrics.Matcher) (int, error) {
+	fs.mu.Lock()
+	defer fs.sync()
+	defer fs.mu.Unlock()
+
+	deletedKeys := make([]string, 0)
+	for k, v := range fs.metricsCache {
+		if metrics.MatchesAll(v, matchers) {
+			deletedKeys = append(deletedKeys, k)
+		}
+	}
+	if err := fs.commitDeleted(deletedKeys...); err != nil {
+		return 0, err
+	}
+
+	return len(deletedKeys), nil
+}
+
+// DeleteStaleMetrics removes metrics which aren't updated for their TTL and returns their number.
+// Metrics loaded from file are considered as updated at the moment of load.
+func (fs *FileStore) DeleteStaleMetrics(_ context.Context, ttl *TTL, now time.Time) (int, error) {
+	fs.mu.Lock()
+	defer fs.sync()
+	defer fs.mu.Unlock()
+
+	staleKeys := fs.updated.stale(fs.metricsCache, ttl, now)
+	if err := fs.commitDeleted(staleKeys...); err != nil {
+		return 0, err
+	}
+
+	return len(staleKeys), nil
+}
+
+// commitDeleted logs keys of deleted metrics to write-ahead log and then removes the metrics and their history,
+// so delete isn't visible until it's durable. The lock of store must be held.
+func (fs *FileStore) commitDeleted(metricKeys ...string) error {
+	if err := fs.wal.appendDeleted(metricKeys...); err != nil {
+		return err
+	}
+
+	for _, k := range metricKeys {
+		fs.watchers.publish(EventDelete, fs.metricsCache[k])
+		delete(fs.metricsCache, k)
+		fs.history.delete(k)
+		fs.updated.forget(k)
+	}
+
+	return nil
+}
+
+// Watch returns change feed of metrics which satisfy the filter
+func (fs *FileStore) Watch(ctx context.Context, filter *WatchFilter) (<-chan *Event, error) {
+	fs.mu.Lock()
+	if fs.watchers == nil {
+		fs.watchers = newWatchHub()
+	}
+	watchers := fs.watchers
+	fs.mu.Unlock()
+
+	return watchers.watch(ctx, filter), nil
+}
+
+// AppendSamples adds timestamped samples to the history of metric
+func (fs *FileStore) AppendSamples(_ context.Context, metricName string, metricType string,
+	samples ...metrics.Sample) error {
+	if fs.history == nil {
+		return ErrHistoryDisabled
+	}
+
+	metricKey, err := fs.checkMetricType(metricName, metricType)
+	if err != nil {
+		return err
+	}
+
+	if err := validateSamples(metricKey, metricType, samples); err != nil {
+		return err
+	}
+
+	fs.history.append(metricKey, samples...)
+
+	return nil
+}
+
+// GetHistory returns samples of metric in the [from, to] range downsampled by step
+func (fs *FileStore) GetHistory(_ context.Context, metricName string, metricType string,
+	from time.Time, to time.Time, step time.Duration) ([]metrics.Sample, error) {
+	if err := validateTimeRange(from, to, step); err != nil {
+		return nil, err
+	}
+
+	metricKey, err := fs.checkMetricType(metricName, metricType)
+	if err != nil {
+		return nil, err
+	}
+
+	return fs.history.query(metricKey, from, to, step)
+}
+
+// checkMetricType checks that stored metric has the same type and returns canonical key of metric
+func (fs *FileStore) checkMetricType(metricName string, metricType string) (string, error) {
+	metricKey, _, _, err := parseMetricKey(metricName)
+	if err != nil {
+		return "", err
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	currentMetric, ok := fs.metricsCache[metricKey]
+	if ok && currentMetric.MType != metricType {
+		return "", fmt.Errorf("%w %s:%s", ErrMetricTypeMismatch, metricKey, currentMetric.MType)
+	}
+
+	return metricKey, nil
+}
+
+// sync sends signal to flush data to disk, the signal is dropped if the previous one isn't handled yet
 func (fs *FileStore) sync() {
-	fs.syncChannel <- struct{}{}
+	select {
+	case fs.syncChannel <- struct{}{}:
+	default:
+	}
+}
+
+// SetMetadata sets metadata of metric by its name, empty metadata removes it
+func (fs *FileStore) SetMetadata(_ context.Context, metricName string, metadata *metrics.Metadata) error {
+	if err := validateMetadata(metricName, metadata); err != nil {
+		return err
+	}
+
+	fs.mu.Lock()
+	defer fs.sync()
+	defer fs.mu.Unlock()
+
+	if err := fs.wal.appendMetadata(metricName, metadata); err != nil {
+		return err
+	}
+
+	fs.metadata.set(metricName, metadata)
+
+	return nil
+}
+
+// GetMetadata returns copies of metadata of all metrics by their names
+func (fs *FileStore) GetMetadata(_ context.Context) (map[string]*metrics.Metadata, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return fs.metadata.all(), nil
 }
 
 // Ping checks that underlying store is alive
 func (fs *FileStore) Ping(_ context.Context) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
 	_, err := fs.file.Stat()
 
 	return err
 }
 
-// Close closes file descriptor
+// Close closes file descriptors
 func (fs *FileStore) Close() error {
 	if err := fs.SaveMetrics(); err != nil {
 		log.Error().Err(err).Msg("Something went wrong durin metrics preserve")
 	}
 
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
 	if err := fs.file.Sync(); err != nil {
 		log.Error().Err(err).Msg("Failed to sync metrics")
 	}
 
+	if err := fs.wal.close(); err != nil {
+		log.Error().Err(err).Msg("Failed to close write-ahead log")
+	}
+
 	return fs.file.Close()
 }
 
-// LoadMetrics helper utility to load metrics from file
+// LoadMetrics helper utility to load metrics from file, records of write-ahead log are replayed over them.
+// The previous snapshot is loaded if the current one is empty or corrupted.
+// ErrSnapshotKey is returned and nothing is loaded if snapshot is encrypted with unknown key.
 func (fs *FileStore) LoadMetrics() error {
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
-	jsonDecoder := json.NewDecoder(fs.file)
+	snapshotPath := fs.path()
+	log.Info().Msgf("Load metrics from %s", snapshotPath)
 
-	log.Info().Msgf("Load metrics from %s", fs.file.Name())
+	snapshot, err := readSnapshot(io.NewSectionReader(fs.file, 0, math.MaxInt64), fs.keys)
+	if errors.Is(err, io.EOF) || errors.Is(err, ErrCorruptedSnapshot) {
+		previousPath := snapshotPath + previousSnapshotSuffix
+		previousSnapshot, previousErr := readSnapshotFile(previousPath, fs.keys)
+		switch {
+		case previousErr == nil:
+			log.Error().Err(err).Msgf("Fall back to the previous snapshot %s", previousPath)
+			snapshot, err = previousSnapshot, nil
+		case !errors.Is(previousErr, os.ErrNotExist):
+			log.Error().Err(previousErr).Msgf("Failed to load the previous snapshot %s", previousPath)
+		}
+	}
+	if err == nil {
+		fs.metricsCache = snapshot.Metrics
+		fs.metadata = metadataRegistry{metadata: snapshot.Metadata}
+	}
+	defer fs.touchAll(time.Now())
 
-	return jsonDecoder.Decode(&(fs.metricsCache))
+	if fs.wal == nil || (!errors.Is(err, nil) && !errors.Is(err, io.EOF)) {
+		return err
+	}
+
+	return fs.wal.replay(fs.metricsCache, &fs.metadata)
 }
 
-// SaveMetrics dumps metrics to file
-func (fs *FileStore) SaveMetrics() (err error) {
-	log.Info().Msgf("Dump metrics to %s", fs.file.Name())
+// touchAll marks all of metrics as updated at the moment
+func (fs *FileStore) touchAll(updatedAt time.Time) {
+	fs.updated = updateTimes{}
+	for k := range fs.metricsCache {
+		fs.updated.touch(k, updatedAt)
+	}
+}
 
+// SaveMetrics atomically replaces snapshot in file with metrics and their metadata,
+// the write-ahead log is truncated when metrics are synced to disk
+func (fs *FileStore) SaveMetrics() error {
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
-	const (
-		offset     = 0
-		whence     = 0
-		truncateTo = 0
-	)
-	_, err = fs.file.Seek(offset, whence)
+	snapshotPath := fs.path()
+	log.Info().Msgf("Dump metrics to %s", snapshotPath)
+
+	file, err := writeSnapshot(snapshotPath, &snapshotData{Metrics: fs.metricsCache, Metadata: fs.metadata.metadata},
+		fs.keys)
+	if file != nil {
+		if err := fs.file.Close(); err != nil {
+			log.Error().Err(err).Msgf("Failed to close replaced snapshot %s", snapshotPath)
+		}
+		fs.file = file
+		fs.filePath = snapshotPath
+	}
 	if err != nil {
 		return err
 	}
 
-	if err := fs.file.Truncate(truncateTo); err != nil {
-		return err
-	}
+	return fs.wal.truncate()
+}
 
-	encoder := json.NewEncoder(fs.file)
+// path returns path of the snapshot file
+func (fs *FileStore) path() string {
+	if fs.filePath != "" {
+		return fs.filePath
+	}
 
-	return encoder.Encode(&fs.metricsCache)
+	return fs.file.Name()
 }
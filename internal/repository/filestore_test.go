@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/itd27m01/go-metrics-service/internal/models/metrics"
@@ -65,6 +66,7 @@ func TestFileStore_SaveMetrics(t *testing.T) {
 	f, _ := os.CreateTemp("", "tests")
 	defer f.Close()
 	defer os.Remove(f.Name())
+	defer os.Remove(f.Name() + previousSnapshotSuffix)
 
 	testMetricName := "Alloc"
 	testMetricValue := metrics.Gauge(testMetricValue)
@@ -120,9 +122,10 @@ func TestFileStore_SaveMetrics(t *testing.T) {
 			}
 			fs.SaveMetrics()
 
-			buf := make([]byte, len(tt.want))
-			if _, err := f.ReadAt(buf, 0); err != nil || string(buf) != tt.want {
-				t.Errorf("SaveMetrics() failed (error = %v), want %s, got %v", err, tt.want, string(buf))
+			data, err := os.ReadFile(f.Name())
+			snapshot := strings.SplitN(string(data), "\n", 2)
+			if err != nil || len(snapshot) != 2 || !strings.HasPrefix(snapshot[0], snapshotMagic) || !strings.HasPrefix(snapshot[1], tt.want) {
+				t.Errorf("SaveMetrics() failed (error = %v), want %s, got %v", err, tt.want, string(data))
 			}
 		})
 	}
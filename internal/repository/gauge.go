@@ -0,0 +1,39 @@
+package repository
+
+import (
+	"fmt"
+
+	"github.com/itd27m01/go-metrics-service/internal/models/metrics"
+)
+
+// addGauge returns the value of gauge with added delta, currentMetric is nil for a new gauge
+func addGauge(metricKey string, currentMetric *metrics.Metric, delta metrics.Gauge) (metrics.Gauge, error) {
+	switch {
+	case currentMetric == nil:
+		return delta, nil
+	case currentMetric.Value == nil:
+		return 0, fmt.Errorf("%w %s:%s", ErrMetricTypeMismatch, metricKey, currentMetric.MType)
+	default:
+		return *(currentMetric.Value) + delta, nil
+	}
+}
+
+// compareGauge checks that the current value of gauge equals to expected one, currentMetric is nil for
+// an absent gauge which matches nil expected value only
+func compareGauge(metricKey string, currentMetric *metrics.Metric, expected *metrics.Gauge) error {
+	switch {
+	case currentMetric != nil && currentMetric.Value == nil:
+		return fmt.Errorf("%w %s:%s", ErrMetricTypeMismatch, metricKey, currentMetric.MType)
+	case currentMetric == nil && expected == nil:
+		return nil
+	case currentMetric == nil:
+		return fmt.Errorf("%w %s: gauge doesn't exist, expected %v", ErrGaugeConflict, metricKey, *expected)
+	case expected == nil:
+		return fmt.Errorf("%w %s: gauge exists with value %v", ErrGaugeConflict, metricKey, *(currentMetric.Value))
+	case *(currentMetric.Value) != *expected:
+		return fmt.Errorf("%w %s: value is %v, expected %v", ErrGaugeConflict, metricKey,
+			*(currentMetric.Value), *expected)
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/itd27m01/go-metrics-service/internal/models/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gaugeStores(t *testing.T) map[string]Store {
+	t.Helper()
+
+	stores := testStores(t)
+	stores["DBStore"] = newTestDBStore(t, testDatabaseDSN(t))
+	stores["MirrorStore"] = NewMirrorStore(NewInMemoryStore(), false, Secondary{Name: "memory", Store: NewInMemoryStore()})
+
+	return stores
+}
+
+func TestStores_AddGauge(t *testing.T) {
+	for name, store := range gaugeStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			inFlight := `TestGaugeInFlight{host="a"}`
+
+			value, err := store.AddGauge(ctx, inFlight, 2)
+			require.NoError(t, err)
+			assert.Equal(t, metrics.Gauge(2), value, "absent gauge must be created with delta value")
+
+			value, err = store.AddGauge(ctx, inFlight, -0.5)
+			require.NoError(t, err)
+			assert.Equal(t, metrics.Gauge(1.5), value)
+
+			metric, err := store.GetMetric(ctx, inFlight, metrics.MetricTypeGauge)
+			require.NoError(t, err)
+			assert.Equal(t, metrics.Gauge(1.5), *metric.Value)
+			assert.Equal(t, metrics.Labels{"host": "a"}, metric.Labels)
+
+			if name != "DBStore" {
+				require.NoError(t, store.UpdateCounterMetric(ctx, "TestGaugeCounter", 1))
+				_, err = store.AddGauge(ctx, "TestGaugeCounter", 1)
+				assert.ErrorIs(t, err, ErrMetricTypeMismatch)
+			}
+		})
+	}
+}
+
+func TestStores_AddGaugeConcurrently(t *testing.T) {
+	const workers, adds = 8, 25
+
+	for name, store := range gaugeStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			var wg sync.WaitGroup
+			for i := 0; i < workers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+
+					for j := 0; j < adds; j++ {
+						_, err := store.AddGauge(ctx, "TestGaugeConcurrent", 1)
+						assert.NoError(t, err)
+					}
+				}()
+			}
+			wg.Wait()
+
+			metric, err := store.GetMetric(ctx, "TestGaugeConcurrent", metrics.MetricTypeGauge)
+			require.NoError(t, err)
+			assert.Equal(t, metrics.Gauge(workers*adds), *metric.Value, "concurrent adds must not be lost")
+		})
+	}
+}
+
+func TestStores_CompareAndSetGauge(t *testing.T) {
+	gauge := func(value metrics.Gauge) *metrics.Gauge { return &value }
+
+	for name, store := range gaugeStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			leader := "TestGaugeLeader"
+
+			assert.ErrorIs(t, store.CompareAndSetGauge(ctx, leader, gauge(0), 1), ErrGaugeConflict,
+				"absent gauge must not match expected value")
+			require.NoError(t, store.CompareAndSetGauge(ctx, leader, nil, 1))
+			assert.ErrorIs(t, store.CompareAndSetGauge(ctx, leader, nil, 2), ErrGaugeConflict,
+				"existing gauge must not match absent one")
+			assert.ErrorIs(t, store.CompareAndSetGauge(ctx, leader, gauge(2), 3), ErrGaugeConflict)
+			require.NoError(t, store.CompareAndSetGauge(ctx, leader, gauge(1), 3))
+
+			metric, err := store.GetMetric(ctx, leader, metrics.MetricTypeGauge)
+			require.NoError(t, err)
+			assert.Equal(t, metrics.Gauge(3), *metric.Value)
+
+			if name != "DBStore" {
+				require.NoError(t, store.UpdateCounterMetric(ctx, "TestGaugeCounter", 1))
+				assert.ErrorIs(t, store.CompareAndSetGauge(ctx, "TestGaugeCounter", nil, 1), ErrMetricTypeMismatch)
+			}
+		})
+	}
+}
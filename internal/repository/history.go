@@ -0,0 +1,157 @@
+package repository
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/itd27m01/go-metrics-service/internal/models/metrics"
+)
+
+// metricsHistory keeps timestamped samples of metrics in memory
+type metricsHistory struct {
+	samples   map[string][]metrics.Sample
+	retention time.Duration
+	mu        sync.RWMutex
+}
+
+// newMetricsHistory creates history which keeps samples not older than retention
+func newMetricsHistory(retention time.Duration) *metricsHistory {
+	if retention <= 0 {
+		return nil
+	}
+
+	return &metricsHistory{
+		samples:   make(map[string][]metrics.Sample),
+		retention: retention,
+	}
+}
+
+// append adds samples to the history of metric and drops expired ones
+func (h *metricsHistory) append(metricName string, samples ...metrics.Sample) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	history := h.samples[metricName]
+	for _, sample := range samples {
+		i := sort.Search(len(history), func(i int) bool {
+			return history[i].Timestamp.After(sample.Timestamp)
+		})
+
+		history = append(history, metrics.Sample{})
+		copy(history[i+1:], history[i:])
+		history[i] = sample
+	}
+
+	expired := time.Now().Add(-h.retention)
+	i := sort.Search(len(history), func(i int) bool {
+		return !history[i].Timestamp.Before(expired)
+	})
+
+	h.samples[metricName] = history[i:]
+}
+
+// record appends the current value of metric to its history
+func (h *metricsHistory) record(metric *metrics.Metric) {
+	if h == nil {
+		return
+	}
+
+	h.append(metric.Key(), metric.Sample(time.Now()))
+}
+
+// delete drops the history of metric
+func (h *metricsHistory) delete(metricName string) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.samples, metricName)
+}
+
+// query returns samples of metric in the [from, to] range downsampled by step
+func (h *metricsHistory) query(metricName string, from time.Time, to time.Time, step time.Duration) ([]metrics.Sample, error) {
+	if h == nil {
+		return nil, ErrHistoryDisabled
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	history := h.samples[metricName]
+	start := sort.Search(len(history), func(i int) bool {
+		return !history[i].Timestamp.Before(from)
+	})
+	end := sort.Search(len(history), func(i int) bool {
+		return history[i].Timestamp.After(to)
+	})
+
+	if start >= end {
+		return []metrics.Sample{}, nil
+	}
+
+	samples := make([]metrics.Sample, end-start)
+	copy(samples, history[start:end])
+
+	return downsample(samples, from, step), nil
+}
+
+// downsample keeps the last sample in every step-wide bucket starting from the beginning of the range.
+// Timestamps of the kept samples are aligned to the beginning of their buckets.
+// Samples must be ordered by timestamp, zero step leaves them untouched.
+func downsample(samples []metrics.Sample, from time.Time, step time.Duration) []metrics.Sample {
+	if step <= 0 || len(samples) == 0 {
+		return samples
+	}
+
+	result := make([]metrics.Sample, 0, len(samples))
+	for _, sample := range samples {
+		bucket := from.Add(sample.Timestamp.Sub(from) / step * step)
+		sample.Timestamp = bucket
+
+		if len(result) > 0 && result[len(result)-1].Timestamp.Equal(bucket) {
+			result[len(result)-1] = sample
+
+			continue
+		}
+
+		result = append(result, sample)
+	}
+
+	return result
+}
+
+// validateTimeRange checks that history can be requested for the range
+func validateTimeRange(from time.Time, to time.Time, step time.Duration) error {
+	if to.Before(from) || step < 0 {
+		return ErrInvalidTimeRange
+	}
+
+	return nil
+}
+
+// validateSamples checks that samples hold values of the metric type
+func validateSamples(metricName string, metricType string, samples []metrics.Sample) error {
+	for _, sample := range samples {
+		switch {
+		case metricType == metrics.MetricTypeGauge && sample.Value != nil:
+		case metricType == metrics.MetricTypeCounter && sample.Delta != nil:
+		case metricType == metrics.MetricTypeHistogram && sample.Histogram != nil:
+			if err := sample.Histogram.Validate(); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("%w %s:%s", ErrMetricTypeMismatch, metricName, metricType)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/itd27m01/go-metrics-service/internal/models/metrics"
+)
+
+func TestInMemoryStore_GetHistory(t *testing.T) {
+	m := NewInMemoryStoreWithHistory(time.Hour)
+	ctx := context.Background()
+
+	from := time.Now()
+	require.NoError(t, m.UpdateGaugeMetric(ctx, "Alloc", 1))
+	require.NoError(t, m.UpdateGaugeMetric(ctx, "Alloc", 2))
+	require.NoError(t, m.UpdateCounterMetric(ctx, "PollCount", 1))
+	require.NoError(t, m.UpdateCounterMetric(ctx, "PollCount", 1))
+	to := time.Now()
+
+	samples, err := m.GetHistory(ctx, "Alloc", metrics.MetricTypeGauge, from, to, 0)
+	require.NoError(t, err)
+	require.Len(t, samples, 2)
+	assert.Equal(t, metrics.Gauge(1), *samples[0].Value)
+	assert.Equal(t, metrics.Gauge(2), *samples[1].Value)
+
+	samples, err = m.GetHistory(ctx, "PollCount", metrics.MetricTypeCounter, from, to, time.Hour)
+	require.NoError(t, err)
+	require.Len(t, samples, 1)
+	assert.Equal(t, metrics.Counter(2), *samples[0].Delta)
+	assert.Equal(t, from, samples[0].Timestamp)
+
+	_, err = m.GetHistory(ctx, "Alloc", metrics.MetricTypeCounter, from, to, 0)
+	assert.ErrorIs(t, err, ErrMetricTypeMismatch)
+
+	_, err = m.GetHistory(ctx, "Alloc", metrics.MetricTypeGauge, to, from, 0)
+	assert.ErrorIs(t, err, ErrInvalidTimeRange)
+
+	_, err = NewInMemoryStore().GetHistory(ctx, "Alloc", metrics.MetricTypeGauge, from, to, 0)
+	assert.ErrorIs(t, err, ErrHistoryDisabled)
+}
+
+func TestInMemoryStore_AppendSamples(t *testing.T) {
+	m := NewInMemoryStoreWithHistory(time.Hour)
+	ctx := context.Background()
+
+	now := time.Now()
+	first, second, expired := metrics.Gauge(1), metrics.Gauge(2), metrics.Gauge(3)
+	require.NoError(t, m.AppendSamples(ctx, "Alloc", metrics.MetricTypeGauge,
+		metrics.Sample{Timestamp: now.Add(-time.Minute), Value: &second},
+		metrics.Sample{Timestamp: now.Add(-2 * time.Minute), Value: &first},
+		metrics.Sample{Timestamp: now.Add(-2 * time.Hour), Value: &expired},
+	))
+
+	samples, err := m.GetHistory(ctx, "Alloc", metrics.MetricTypeGauge, now.Add(-3*time.Hour), now, 0)
+	require.NoError(t, err)
+	require.Len(t, samples, 2)
+	assert.Equal(t, first, *samples[0].Value)
+	assert.Equal(t, second, *samples[1].Value)
+
+	err = m.AppendSamples(ctx, "Alloc", metrics.MetricTypeCounter, metrics.Sample{Timestamp: now, Value: &first})
+	assert.ErrorIs(t, err, ErrMetricTypeMismatch)
+}
+
+func Test_downsample(t *testing.T) {
+	from := time.Unix(0, 0)
+	values := []metrics.Gauge{1, 2, 3, 4}
+	samples := []metrics.Sample{
+		{Timestamp: from.Add(1 * time.Second), Value: &values[0]},
+		{Timestamp: from.Add(5 * time.Second), Value: &values[1]},
+		{Timestamp: from.Add(11 * time.Second), Value: &values[2]},
+		{Timestamp: from.Add(35 * time.Second), Value: &values[3]},
+	}
+
+	got := downsample(samples, from, 10*time.Second)
+
+	require.Len(t, got, 3)
+	assert.Equal(t, from, got[0].Timestamp)
+	assert.Equal(t, values[1], *got[0].Value)
+	assert.Equal(t, from.Add(10*time.Second), got[1].Timestamp)
+	assert.Equal(t, values[2], *got[1].Value)
+	assert.Equal(t, from.Add(30*time.Second), got[2].Timestamp)
+	assert.Equal(t, values[3], *got[2].Value)
+}
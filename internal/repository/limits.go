@@ -0,0 +1,411 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/itd27m01/go-metrics-service/internal/models/metrics"
+	"github.com/itd27m01/go-metrics-service/pkg/logging/log"
+)
+
+// Errors of limits of metrics
+var (
+	ErrMetricNameRejected = errors.New("metric name is rejected")
+	ErrCardinalityLimit   = errors.New("cardinality limit of metrics is exceeded")
+)
+
+// newMetricsWindow is the window of the limit of new metrics
+const newMetricsWindow = time.Minute
+
+var (
+	_ Store        = (*LimitedStore)(nil)
+	_ HistoryStore = (*LimitedStore)(nil)
+)
+
+// Limits defines limits of cardinality of metrics, zero or nil limit is disabled
+type Limits struct {
+	MaxMetrics      int            // Total number of metrics of tenant
+	MaxNewPerMinute int            // Number of metrics of tenant which are created per minute
+	MaxNameLength   int            // Length of the name of metric
+	AllowedNames    *regexp.Regexp // Names of metrics which are allowed
+}
+
+// Enabled checks if any of limits is set
+func (l *Limits) Enabled() bool {
+	return l != nil && (l.MaxMetrics > 0 || l.MaxNewPerMinute > 0 || l.MaxNameLength > 0 || l.AllowedNames != nil)
+}
+
+// checkName checks the name of metric against limits of names
+func (l *Limits) checkName(name string) error {
+	if l.MaxNameLength > 0 && len(name) > l.MaxNameLength {
+		return fmt.Errorf("%w %s: name is longer than %d chars", ErrMetricNameRejected, name, l.MaxNameLength)
+	}
+	if l.AllowedNames != nil && !l.AllowedNames.MatchString(name) {
+		return fmt.Errorf("%w %s: name doesn't match %s", ErrMetricNameRejected, name, l.AllowedNames)
+	}
+
+	return nil
+}
+
+// LimitedStore implements Store interface to check limits of names and cardinality before writes to the underlying
+// store. Cardinality is limited for every tenant separately, tenant of metric is its tenant label which is set by
+// TenantStore over limited store, so one tenant can't exhaust limits of others.
+// Keys of metrics are tracked in memory, so metrics which are written to the underlying store bypassing
+// limited store, e.g. by other replicas, are counted after the next bulk delete only.
+type LimitedStore struct {
+	store  Store
+	limits Limits
+	now    func() time.Time
+
+	mu      sync.Mutex
+	tenants map[string]*tenantCardinality
+}
+
+// tenantCardinality tracks keys of metrics of tenant and the number of metrics created in the current window
+type tenantCardinality struct {
+	known       map[string]struct{}
+	windowStart time.Time
+	windowNew   int
+}
+
+// NewLimitedStore creates limited store over the underlying store and loads keys of its metrics
+func NewLimitedStore(ctx context.Context, store Store, limits Limits) (*LimitedStore, error) {
+	ls := LimitedStore{
+		store:   store,
+		limits:  limits,
+		now:     time.Now,
+		tenants: make(map[string]*tenantCardinality),
+	}
+
+	if err := ls.loadKeys(ctx); err != nil {
+		return nil, err
+	}
+
+	return &ls, nil
+}
+
+// UpdateCounterMetric updates counter metric if it satisfies limits
+func (ls *LimitedStore) UpdateCounterMetric(ctx context.Context, metricName string, metricValue metrics.Counter) error {
+	admitted, err := ls.admit(metricName)
+	if err != nil {
+		return err
+	}
+
+	return ls.release(admitted, ls.store.UpdateCounterMetric(ctx, metricName, metricValue))
+}
+
+// ResetCounterMetric resets counter metric if it satisfies limits
+func (ls *LimitedStore) ResetCounterMetric(ctx context.Context, metricName string) error {
+	admitted, err := ls.admit(metricName)
+	if err != nil {
+		return err
+	}
+
+	return ls.release(admitted, ls.store.ResetCounterMetric(ctx, metricName))
+}
+
+// UpdateGaugeMetric updates gauge metric if it satisfies limits
+func (ls *LimitedStore) UpdateGaugeMetric(ctx context.Context, metricName string, metricValue metrics.Gauge) error {
+	admitted, err := ls.admit(metricName)
+	if err != nil {
+		return err
+	}
+
+	return ls.release(admitted, ls.store.UpdateGaugeMetric(ctx, metricName, metricValue))
+}
+
+// AddGauge adds delta to gauge metric if it satisfies limits
+func (ls *LimitedStore) AddGauge(ctx context.Context, metricName string, delta metrics.Gauge) (metrics.Gauge, error) {
+	admitted, err := ls.admit(metricName)
+	if err != nil {
+		return 0, err
+	}
+
+	value, err := ls.store.AddGauge(ctx, metricName, delta)
+
+	return value, ls.release(admitted, err)
+}
+
+// CompareAndSetGauge sets value of gauge metric if it satisfies limits and its current value equals to expected one
+func (ls *LimitedStore) CompareAndSetGauge(ctx context.Context, metricName string, expected *metrics.Gauge,
+	value metrics.Gauge) error {
+	admitted, err := ls.admit(metricName)
+	if err != nil {
+		return err
+	}
+
+	return ls.release(admitted, ls.store.CompareAndSetGauge(ctx, metricName, expected, value))
+}
+
+// UpdateHistogramMetric merges observations of histogram to the histogram metric if it satisfies limits
+func (ls *LimitedStore) UpdateHistogramMetric(ctx context.Context, metricName string,
+	metricValue *metrics.Histogram) error {
+	admitted, err := ls.admit(metricName)
+	if err != nil {
+		return err
+	}
+
+	return ls.release(admitted, ls.store.UpdateHistogramMetric(ctx, metricName, metricValue))
+}
+
+// UpdateMetrics updates batch of metrics if all of them satisfy limits
+func (ls *LimitedStore) UpdateMetrics(ctx context.Context, metricsBatch []*metrics.Metric) error {
+	keys := make([]string, 0, len(metricsBatch))
+	for _, metric := range metricsBatch {
+		keys = append(keys, metric.Key())
+	}
+
+	admitted, err := ls.admit(keys...)
+	if err != nil {
+		return err
+	}
+
+	return ls.release(admitted, ls.store.UpdateMetrics(ctx, metricsBatch))
+}
+
+// GetMetric returns metric from the underlying store
+func (ls *LimitedStore) GetMetric(ctx context.Context, metricName string, metricType string) (*metrics.Metric, error) {
+	return ls.store.GetMetric(ctx, metricName, metricType)
+}
+
+// GetMetrics returns metrics from the underlying store
+func (ls *LimitedStore) GetMetrics(ctx context.Context, matchers ...*metrics.Matcher) (map[string]*metrics.Metric, error) {
+	return ls.store.GetMetrics(ctx, matchers...)
+}
+
+// ListMetrics returns the page of metrics from the underlying store
+func (ls *LimitedStore) ListMetrics(ctx context.Context, filter *ListFilter) (*MetricsPage, error) {
+	return ls.store.ListMetrics(ctx, filter)
+}
+
+// Watch returns change feed of metrics from the underlying store
+func (ls *LimitedStore) Watch(ctx context.Context, filter *WatchFilter) (<-chan *Event, error) {
+	return ls.store.Watch(ctx, filter)
+}
+
+// DeleteMetric deletes metric from the underlying store and stops counting it
+func (ls *LimitedStore) DeleteMetric(ctx context.Context, metricName string, metricType string) error {
+	if err := ls.store.DeleteMetric(ctx, metricName, metricType); err != nil {
+		return err
+	}
+
+	if key, _, labels, err := parseMetricKey(metricName); err == nil {
+		ls.mu.Lock()
+		delete(ls.tenant(labels[metrics.TenantLabel]).known, key)
+		ls.mu.Unlock()
+	}
+
+	return nil
+}
+
+// DeleteMetrics deletes metrics from the underlying store and counts the rest of them again
+func (ls *LimitedStore) DeleteMetrics(ctx context.Context, matchers ...*metrics.Matcher) (int, error) {
+	deleted, err := ls.store.DeleteMetrics(ctx, matchers...)
+	if err != nil || deleted == 0 {
+		return deleted, err
+	}
+
+	ls.reloadKeys(ctx)
+
+	return deleted, nil
+}
+
+// DeleteStaleMetrics deletes stale metrics from the underlying store and counts the rest of them again
+func (ls *LimitedStore) DeleteStaleMetrics(ctx context.Context, ttl *TTL, now time.Time) (int, error) {
+	deleted, err := ls.store.DeleteStaleMetrics(ctx, ttl, now)
+	if err != nil || deleted == 0 {
+		return deleted, err
+	}
+
+	ls.reloadKeys(ctx)
+
+	return deleted, nil
+}
+
+// SetMetadata sets metadata of metric if its name satisfies limits
+func (ls *LimitedStore) SetMetadata(ctx context.Context, metricName string, metadata *metrics.Metadata) error {
+	name, _, err := metrics.ParseKey(metricName)
+	if err != nil {
+		return err
+	}
+	if err := ls.limits.checkName(name); err != nil {
+		return err
+	}
+
+	return ls.store.SetMetadata(ctx, metricName, metadata)
+}
+
+// GetMetadata returns metadata of metrics from the underlying store
+func (ls *LimitedStore) GetMetadata(ctx context.Context) (map[string]*metrics.Metadata, error) {
+	return ls.store.GetMetadata(ctx)
+}
+
+// Ping checks the underlying store
+func (ls *LimitedStore) Ping(ctx context.Context) error {
+	return ls.store.Ping(ctx)
+}
+
+// AppendSamples adds timestamped samples to the history of metric in the underlying store
+func (ls *LimitedStore) AppendSamples(ctx context.Context, metricName string, metricType string,
+	samples ...metrics.Sample) error {
+	historyStore, ok := ls.store.(HistoryStore)
+	if !ok {
+		return ErrHistoryDisabled
+	}
+
+	return historyStore.AppendSamples(ctx, metricName, metricType, samples...)
+}
+
+// GetHistory returns samples of metric from the underlying store
+func (ls *LimitedStore) GetHistory(ctx context.Context, metricName string, metricType string,
+	from time.Time, to time.Time, step time.Duration) ([]metrics.Sample, error) {
+	historyStore, ok := ls.store.(HistoryStore)
+	if !ok {
+		return nil, ErrHistoryDisabled
+	}
+
+	return historyStore.GetHistory(ctx, metricName, metricType, from, to, step)
+}
+
+// admit checks names of metrics and counts new ones against limits of cardinality of their tenants, it returns
+// keys of new metrics which are counted until their writes fail
+func (ls *LimitedStore) admit(keys ...string) ([]string, error) {
+	newKeys := make(map[string]map[string]struct{})
+	for _, key := range keys {
+		metricKey, name, labels, err := parseMetricKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if err := ls.limits.checkName(name); err != nil {
+			return nil, err
+		}
+
+		tenantName := labels[metrics.TenantLabel]
+		if newKeys[tenantName] == nil {
+			newKeys[tenantName] = make(map[string]struct{})
+		}
+		newKeys[tenantName][metricKey] = struct{}{}
+	}
+
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	now := ls.now()
+	for tenantName, tenantKeys := range newKeys {
+		if err := ls.tenant(tenantName).check(tenantKeys, &ls.limits, now); err != nil {
+			return nil, err
+		}
+	}
+
+	admitted := make([]string, 0, len(keys))
+	for tenantName, tenantKeys := range newKeys {
+		tc := ls.tenant(tenantName)
+		for key := range tenantKeys {
+			tc.known[key] = struct{}{}
+			admitted = append(admitted, key)
+		}
+		tc.windowNew += len(tenantKeys)
+	}
+
+	return admitted, nil
+}
+
+// check drops known keys from new keys of tenant and checks the rest of them against limits of cardinality
+func (tc *tenantCardinality) check(newKeys map[string]struct{}, limits *Limits, now time.Time) error {
+	for key := range newKeys {
+		if _, ok := tc.known[key]; ok {
+			delete(newKeys, key)
+		}
+	}
+	if len(newKeys) == 0 {
+		return nil
+	}
+
+	if limits.MaxMetrics > 0 && len(tc.known)+len(newKeys) > limits.MaxMetrics {
+		return fmt.Errorf("%w: %d metrics are stored, limit is %d", ErrCardinalityLimit, len(tc.known),
+			limits.MaxMetrics)
+	}
+
+	if now.Sub(tc.windowStart) >= newMetricsWindow {
+		tc.windowStart, tc.windowNew = now, 0
+	}
+	if limits.MaxNewPerMinute > 0 && tc.windowNew+len(newKeys) > limits.MaxNewPerMinute {
+		return fmt.Errorf("%w: %d new metrics are created in the last minute, limit is %d",
+			ErrCardinalityLimit, tc.windowNew, limits.MaxNewPerMinute)
+	}
+
+	return nil
+}
+
+// release stops counting of admitted metrics if their write failed and returns the error of write
+func (ls *LimitedStore) release(admitted []string, err error) error {
+	if err == nil || len(admitted) == 0 {
+		return err
+	}
+
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	for _, key := range admitted {
+		if _, labels, err := metrics.ParseKey(key); err == nil {
+			delete(ls.tenant(labels[metrics.TenantLabel]).known, key)
+		}
+	}
+
+	return err
+}
+
+// tenant returns cardinality of tenant, it has to be called under the lock
+func (ls *LimitedStore) tenant(tenantName string) *tenantCardinality {
+	tc, ok := ls.tenants[tenantName]
+	if !ok {
+		tc = &tenantCardinality{known: make(map[string]struct{})}
+		ls.tenants[tenantName] = tc
+	}
+
+	return tc
+}
+
+// loadKeys loads keys of metrics of the underlying store
+func (ls *LimitedStore) loadKeys(ctx context.Context) error {
+	metricsData, err := ls.store.GetMetrics(ctx)
+	if err != nil {
+		return err
+	}
+
+	known := make(map[string]map[string]struct{})
+	for key, metric := range metricsData {
+		tenantName := metric.Labels[metrics.TenantLabel]
+		if known[tenantName] == nil {
+			known[tenantName] = make(map[string]struct{})
+		}
+		known[tenantName][key] = struct{}{}
+	}
+
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	// windows of new metrics are kept, they don't depend on deleted metrics
+	for tenantName, tc := range ls.tenants {
+		tc.known = make(map[string]struct{})
+		if tc.windowNew == 0 {
+			delete(ls.tenants, tenantName)
+		}
+	}
+	for tenantName, keys := range known {
+		ls.tenant(tenantName).known = keys
+	}
+
+	return nil
+}
+
+// reloadKeys loads keys of metrics after bulk delete, the previous keys are kept if load fails
+func (ls *LimitedStore) reloadKeys(ctx context.Context) {
+	if err := ls.loadKeys(ctx); err != nil {
+		log.Error().Err(err).Msg("Failed to count metrics after delete")
+	}
+}
@@ -0,0 +1,179 @@
+package repository
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/itd27m01/go-metrics-service/internal/models/metrics"
+	"github.com/itd27m01/go-metrics-service/internal/tenant"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimitedStore_Names(t *testing.T) {
+	ctx := context.Background()
+	ls, err := NewLimitedStore(ctx, NewInMemoryStore(), Limits{
+		MaxNameLength: 10,
+		AllowedNames:  regexp.MustCompile("^(?:[A-Za-z]+)$"),
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		metricName string
+		wantErr    error
+	}{
+		{
+			name:       "Allowed name",
+			metricName: "Alloc",
+		},
+		{
+			name:       "Allowed name with labels",
+			metricName: `Alloc{request_id="1"}`,
+		},
+		{
+			name:       "Too long name",
+			metricName: "AllocOfHeapObjects",
+			wantErr:    ErrMetricNameRejected,
+		},
+		{
+			name:       "Not allowed name",
+			metricName: "Alloc_7f3a",
+			wantErr:    ErrMetricNameRejected,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ls.UpdateGaugeMetric(ctx, tt.metricName, 1)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				assert.ErrorIs(t, ls.SetMetadata(ctx, tt.metricName, &metrics.Metadata{Unit: "bytes"}), tt.wantErr)
+
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestLimitedStore_MaxMetrics(t *testing.T) {
+	ctx := context.Background()
+	store := NewInMemoryStore()
+	require.NoError(t, store.UpdateGaugeMetric(ctx, "Alloc", 1))
+
+	ls, err := NewLimitedStore(ctx, store, Limits{MaxMetrics: 3})
+	require.NoError(t, err)
+
+	require.NoError(t, ls.UpdateGaugeMetric(ctx, "Alloc", 2), "stored metrics must be counted")
+	require.NoError(t, ls.UpdateCounterMetric(ctx, "PollCount", 1))
+
+	gaugeValue := metrics.Gauge(1)
+	batch := []*metrics.Metric{
+		{ID: "Alloc", MType: metrics.MetricTypeGauge, Value: &gaugeValue},
+		{ID: "HeapAlloc", MType: metrics.MetricTypeGauge, Value: &gaugeValue},
+		{ID: "HeapIdle", MType: metrics.MetricTypeGauge, Value: &gaugeValue},
+	}
+	assert.ErrorIs(t, ls.UpdateMetrics(ctx, batch), ErrCardinalityLimit)
+	_, err = ls.GetMetric(ctx, "HeapAlloc", metrics.MetricTypeGauge)
+	assert.ErrorIs(t, err, ErrMetricNotFound, "rejected batch must not be written")
+
+	require.NoError(t, ls.UpdateMetrics(ctx, batch[:2]))
+	assert.ErrorIs(t, ls.UpdateGaugeMetric(ctx, `HeapAlloc{host="a"}`, 1), ErrCardinalityLimit)
+
+	require.NoError(t, ls.DeleteMetric(ctx, "HeapAlloc", metrics.MetricTypeGauge))
+	require.NoError(t, ls.UpdateGaugeMetric(ctx, `HeapAlloc{host="a"}`, 1), "deleted metric must not be counted")
+
+	deleted, err := ls.DeleteMetrics(ctx, metrics.NewPrefixMatcher("Heap"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	require.NoError(t, ls.UpdateGaugeMetric(ctx, "HeapIdle", 1), "deleted metrics must not be counted")
+}
+
+func TestLimitedStore_MaxNewPerMinute(t *testing.T) {
+	ctx := context.Background()
+	ls, err := NewLimitedStore(ctx, NewInMemoryStore(), Limits{MaxNewPerMinute: 2})
+	require.NoError(t, err)
+
+	now := time.Now()
+	ls.now = func() time.Time { return now }
+
+	require.NoError(t, ls.UpdateGaugeMetric(ctx, "Alloc", 1))
+	require.NoError(t, ls.UpdateGaugeMetric(ctx, "HeapAlloc", 1))
+	assert.ErrorIs(t, ls.UpdateGaugeMetric(ctx, "HeapIdle", 1), ErrCardinalityLimit)
+	require.NoError(t, ls.UpdateGaugeMetric(ctx, "Alloc", 2), "updates of stored metrics must not be limited")
+
+	now = now.Add(time.Minute)
+	require.NoError(t, ls.UpdateGaugeMetric(ctx, "HeapIdle", 1), "limit must be reset in the next minute")
+}
+
+func TestLimitedStore_Tenants(t *testing.T) {
+	ctx := context.Background()
+	ctxA := tenant.NewContext(ctx, &tenant.Config{Name: "team-a"})
+	ctxB := tenant.NewContext(ctx, &tenant.Config{Name: "team-b"})
+
+	store := NewTenantStore(NewInMemoryStore())
+	require.NoError(t, store.UpdateGaugeMetric(ctxA, "Alloc", 1))
+
+	ls, err := NewLimitedStore(ctx, store.store, Limits{MaxMetrics: 2, MaxNewPerMinute: 2})
+	require.NoError(t, err)
+	store.store = ls
+
+	require.NoError(t, store.UpdateGaugeMetric(ctxA, "HeapAlloc", 1))
+	assert.ErrorIs(t, store.UpdateGaugeMetric(ctxA, "HeapIdle", 1), ErrCardinalityLimit,
+		"stored metrics of tenant must be counted")
+
+	require.NoError(t, store.UpdateGaugeMetric(ctxB, "Alloc", 1), "limits of other tenant must not be exhausted")
+	require.NoError(t, store.UpdateGaugeMetric(ctxB, "HeapAlloc", 1))
+	assert.ErrorIs(t, store.UpdateGaugeMetric(ctxB, "HeapIdle", 1), ErrCardinalityLimit)
+	require.NoError(t, store.UpdateGaugeMetric(ctx, "Alloc", 1), "default tenant has its own limits")
+
+	require.NoError(t, store.DeleteMetric(ctxA, "HeapAlloc", metrics.MetricTypeGauge))
+	require.NoError(t, store.UpdateGaugeMetric(ctxA, "HeapIdle", 1), "deleted metric of tenant must not be counted")
+	assert.ErrorIs(t, store.UpdateGaugeMetric(ctxB, "HeapIdle", 1), ErrCardinalityLimit)
+
+	report, err := GetCardinality(ctxB, store, 4, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Series, "report must count metrics of tenant which are limited")
+}
+
+func TestLimitedStore_FailedWrite(t *testing.T) {
+	ctx := context.Background()
+	ls, err := NewLimitedStore(ctx, NewInMemoryStore(), Limits{MaxMetrics: 1})
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, ls.UpdateHistogramMetric(ctx, "Latency", &metrics.Histogram{Bounds: []float64{1}}),
+		metrics.ErrInvalidHistogram)
+	require.NoError(t, ls.UpdateGaugeMetric(ctx, "Alloc", 1), "failed write must not be counted")
+}
+
+func TestGetCardinality(t *testing.T) {
+	ctx := context.Background()
+	store := NewInMemoryStore()
+	for _, key := range []string{
+		"request_1", "request_2", "request_3{host=\"a\"}", "request_3{host=\"b\"}",
+		`CPUutilization{cpu="1"}`, `CPUutilization{cpu="2"}`, "Alloc",
+	} {
+		require.NoError(t, store.UpdateGaugeMetric(ctx, key, 1))
+	}
+
+	report, err := GetCardinality(ctx, store, 0, 2)
+	require.NoError(t, err)
+	assert.Equal(t, &Cardinality{
+		Series: 7,
+		Prefixes: []*PrefixCardinality{
+			{Prefix: "request", Series: 4, Names: 3},
+			{Prefix: "CPUutilization", Series: 2, Names: 1},
+		},
+	}, report)
+
+	report, err = GetCardinality(ctx, store, 3, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []*PrefixCardinality{
+		{Prefix: "req", Series: 4, Names: 3},
+		{Prefix: "CPU", Series: 2, Names: 1},
+		{Prefix: "All", Series: 1, Names: 1},
+	}, report.Prefixes)
+}
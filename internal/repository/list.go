@@ -0,0 +1,150 @@
+package repository
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/itd27m01/go-metrics-service/internal/models/metrics"
+)
+
+// ErrInvalidCursor is returned for cursor which isn't issued by ListMetrics
+var ErrInvalidCursor = errors.New("invalid cursor of metrics")
+
+// ListFilter selects and orders metrics listed by ListMetrics, zero fields don't filter metrics
+type ListFilter struct {
+	Prefix     string             // Prefix of names of metrics
+	Name       *regexp.Regexp     // Names of metrics
+	Type       string             // Type of metrics
+	Matchers   []*metrics.Matcher // Label matchers of metrics
+	Descending bool               // Metrics are listed in descending order
+	Limit      int                // Max number of metrics in page, all of metrics are listed if it isn't positive
+	Cursor     string             // Cursor of the next page returned by the previous call, empty for the first page
+}
+
+// MetricsPage is a page of listed metrics, NextCursor is empty for the last page
+type MetricsPage struct {
+	Metrics    []*metrics.Metric `json:"metrics"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+}
+
+// listPosition is the position of metric in the list: metrics are ordered by name, then by labels in the encoding
+// of store and then by type, so positions of different stores aren't comparable
+type listPosition struct {
+	Name   string `json:"n"`
+	Labels string `json:"l"`
+	Type   string `json:"t"`
+}
+
+// listedMetric is a metric selected by filter with its position in the list
+type listedMetric struct {
+	position listPosition
+	metric   *metrics.Metric
+}
+
+// matches checks if metric satisfies the filter
+func (f *ListFilter) matches(metric *metrics.Metric) bool {
+	return strings.HasPrefix(metric.ID, f.Prefix) &&
+		(f.Name == nil || f.Name.MatchString(metric.ID)) &&
+		(f.Type == "" || f.Type == metric.MType) &&
+		metrics.MatchesAll(metric, f.Matchers)
+}
+
+// cursor decodes position of the last listed metric from cursor of filter, it's nil for the first page
+func (f *ListFilter) cursor() (*listPosition, error) {
+	if f.Cursor == "" {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(f.Cursor)
+	if err != nil {
+		return nil, fmt.Errorf("%w %s: %s", ErrInvalidCursor, f.Cursor, err)
+	}
+
+	var position listPosition
+	if err := json.Unmarshal(data, &position); err != nil {
+		return nil, fmt.Errorf("%w %s: %s", ErrInvalidCursor, f.Cursor, err)
+	}
+
+	return &position, nil
+}
+
+// follows checks if position follows the cursor in the order of filter, every position follows nil cursor
+func (f *ListFilter) follows(position *listPosition, cursor *listPosition) bool {
+	if cursor == nil {
+		return true
+	}
+	if f.Descending {
+		return position.less(cursor)
+	}
+
+	return cursor.less(position)
+}
+
+// less compares positions of metrics of the same store
+func (p *listPosition) less(other *listPosition) bool {
+	if p.Name != other.Name {
+		return p.Name < other.Name
+	}
+	if p.Labels != other.Labels {
+		return p.Labels < other.Labels
+	}
+
+	return p.Type < other.Type
+}
+
+// encodeCursor encodes position of the last listed metric to the cursor of the next page
+func encodeCursor(position *listPosition) string {
+	data, err := json.Marshal(position)
+	if err != nil {
+		return ""
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// listMetrics appends copies of metrics which satisfy the filter and follow the cursor to listed metrics,
+// labels of metrics are encoded like in database
+func listMetrics(listed []*listedMetric, metricsData map[string]*metrics.Metric, filter *ListFilter,
+	cursor *listPosition) []*listedMetric {
+	for _, metric := range metricsData {
+		if !filter.matches(metric) {
+			continue
+		}
+
+		position := listPosition{Name: metric.ID, Labels: encodeLabels(metric.Labels), Type: metric.MType}
+		if filter.follows(&position, cursor) {
+			listed = append(listed, &listedMetric{position: position, metric: metric.Copy()})
+		}
+	}
+
+	return listed
+}
+
+// newMetricsPage orders listed metrics and returns the first page of them
+func newMetricsPage(listed []*listedMetric, filter *ListFilter) *MetricsPage {
+	sort.Slice(listed, func(i, j int) bool {
+		if filter.Descending {
+			return listed[j].position.less(&listed[i].position)
+		}
+
+		return listed[i].position.less(&listed[j].position)
+	})
+
+	page := MetricsPage{
+		Metrics: make([]*metrics.Metric, 0, len(listed)),
+	}
+	if filter.Limit > 0 && len(listed) > filter.Limit {
+		listed = listed[:filter.Limit]
+		page.NextCursor = encodeCursor(&listed[len(listed)-1].position)
+	}
+	for _, lm := range listed {
+		page.Metrics = append(page.Metrics, lm.metric)
+	}
+
+	return &page
+}
@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/itd27m01/go-metrics-service/internal/models/metrics"
+	"github.com/itd27m01/go-metrics-service/internal/tenant"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// listAll lists all of pages of metrics by filter and returns keys of metrics in the order of listing
+func listAll(t *testing.T, ctx context.Context, store Store, filter ListFilter) []string {
+	t.Helper()
+
+	keys := make([]string, 0)
+	for i := 0; ; i++ {
+		require.Less(t, i, 100, "pages must end")
+
+		page, err := store.ListMetrics(ctx, &filter)
+		require.NoError(t, err)
+		if filter.Limit > 0 {
+			require.LessOrEqual(t, len(page.Metrics), filter.Limit)
+		}
+		for _, metric := range page.Metrics {
+			keys = append(keys, metric.Key()+":"+metric.MType)
+		}
+
+		if page.NextCursor == "" {
+			return keys
+		}
+		filter.Cursor = page.NextCursor
+	}
+}
+
+func TestStores_ListMetrics(t *testing.T) {
+	stores := testStores(t)
+	stores["DBStore"] = newTestDBStore(t, testDatabaseDSN(t))
+	stores["TenantStore"] = NewTenantStore(NewInMemoryStore())
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := tenant.NewContext(context.Background(), &tenant.Config{Name: "team-a"})
+			histogram := metrics.NewHistogram(1)
+			histogram.Observe(1)
+			require.NoError(t, store.UpdateGaugeMetric(ctx, `TestListCPU{cpu="1"}`, 1))
+			require.NoError(t, store.UpdateGaugeMetric(ctx, `TestListCPU{cpu="0"}`, 2))
+			require.NoError(t, store.UpdateGaugeMetric(ctx, "TestListAlloc", 3))
+			require.NoError(t, store.UpdateCounterMetric(ctx, "TestListPollCount", 1))
+			require.NoError(t, store.UpdateHistogramMetric(ctx, "TestListLatency", histogram))
+			require.NoError(t, store.UpdateGaugeMetric(ctx, "OtherAlloc", 1))
+
+			all := []string{
+				"TestListAlloc:gauge",
+				`TestListCPU{cpu="0"}:gauge`,
+				`TestListCPU{cpu="1"}:gauge`,
+				"TestListLatency:histogram",
+				"TestListPollCount:counter",
+			}
+			assert.Equal(t, all, listAll(t, ctx, store, ListFilter{Prefix: "TestList"}))
+			assert.Equal(t, all, listAll(t, ctx, store, ListFilter{Prefix: "TestList", Limit: 2}))
+
+			descending := listAll(t, ctx, store, ListFilter{Prefix: "TestList", Descending: true, Limit: 3})
+			require.Len(t, descending, len(all))
+			for i := range all {
+				assert.Equal(t, all[len(all)-1-i], descending[i])
+			}
+
+			assert.Equal(t, []string{"TestListAlloc:gauge", `TestListCPU{cpu="0"}:gauge`, `TestListCPU{cpu="1"}:gauge`},
+				listAll(t, ctx, store, ListFilter{Prefix: "TestList", Type: metrics.MetricTypeGauge, Limit: 1}))
+			assert.Equal(t, []string{"OtherAlloc:gauge", "TestListAlloc:gauge"},
+				listAll(t, ctx, store, ListFilter{Name: regexp.MustCompile("Alloc$"), Limit: 1}))
+			assert.Equal(t, []string{`TestListCPU{cpu="1"}:gauge`},
+				listAll(t, ctx, store, ListFilter{
+					Prefix:   "TestList",
+					Matchers: []*metrics.Matcher{{Type: metrics.MatchEqual, Name: "cpu", Value: "1"}},
+				}))
+
+			page, err := store.ListMetrics(ctx, &ListFilter{Prefix: "TestList", Limit: 5})
+			require.NoError(t, err)
+			assert.Len(t, page.Metrics, 5)
+			assert.Empty(t, page.NextCursor, "cursor of the last page must be empty")
+			page.Metrics[0].ID = "changed"
+			metric, err := store.GetMetric(ctx, "TestListAlloc", metrics.MetricTypeGauge)
+			require.NoError(t, err)
+			assert.Equal(t, metrics.Gauge(3), *metric.Value, "listed metrics must be copied")
+
+			_, err = store.ListMetrics(ctx, &ListFilter{Cursor: "not a cursor"})
+			assert.ErrorIs(t, err, ErrInvalidCursor)
+		})
+	}
+}
+
+func TestDBStore_ListMetricsOfSameName(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDBStore(t, testDatabaseDSN(t))
+	require.NoError(t, db.UpdateGaugeMetric(ctx, "TestListSame", 1))
+	require.NoError(t, db.UpdateCounterMetric(ctx, "TestListSame", 1))
+	require.NoError(t, db.UpdateGaugeMetric(ctx, `TestListSame{host="a"}`, 1))
+
+	all := []string{`TestListSame{host="a"}:gauge`, "TestListSame:counter", "TestListSame:gauge"}
+	for limit := 0; limit <= len(all); limit++ {
+		assert.Equal(t, all, listAll(t, ctx, db, ListFilter{Prefix: "TestListSame", Limit: limit}))
+	}
+	assert.Equal(t, []string{"TestListSame:gauge", "TestListSame:counter", `TestListSame{host="a"}:gauge`},
+		listAll(t, ctx, db, ListFilter{Prefix: "TestListSame", Limit: 1, Descending: true}))
+}
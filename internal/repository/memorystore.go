@@ -4,17 +4,23 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/itd27m01/go-metrics-service/internal/models/metrics"
 )
 
 var (
-	_ Store = (*InMemoryStore)(nil)
+	_ Store        = (*InMemoryStore)(nil)
+	_ HistoryStore = (*InMemoryStore)(nil)
 )
 
 // InMemoryStore implements Store interface to store metrics in memory
 type InMemoryStore struct {
 	metricsCache map[string]*metrics.Metric
+	history      *metricsHistory
+	updated      updateTimes
+	metadata     metadataRegistry
+	watchers     *watchHub
 	lock         sync.RWMutex
 }
 
@@ -23,127 +29,498 @@ func NewInMemoryStore() *InMemoryStore {
 	var m InMemoryStore
 
 	m.metricsCache = make(map[string]*metrics.Metric)
+	m.watchers = newWatchHub()
 
 	return &m
 }
 
+// NewInMemoryStoreWithHistory creates in memory store which keeps history of metrics for retention period
+func NewInMemoryStoreWithHistory(historyRetention time.Duration) *InMemoryStore {
+	m := NewInMemoryStore()
+
+	m.history = newMetricsHistory(historyRetention)
+
+	return m
+}
+
 // UpdateCounterMetric updates counter metric type
 func (m *InMemoryStore) UpdateCounterMetric(_ context.Context, metricName string, metricData metrics.Counter) error {
+	metricKey, name, labels, err := parseMetricKey(metricName)
+	if err != nil {
+		return err
+	}
+
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
-	currentMetric, ok := m.metricsCache[metricName]
+	currentMetric, ok := m.metricsCache[metricKey]
 	switch {
 	case ok && currentMetric.Delta != nil:
 		*(currentMetric.Delta) += metricData
 	case ok && currentMetric.Delta == nil:
-		return fmt.Errorf("%w %s:%s", ErrMetricTypeMismatch, metricName, currentMetric.MType)
+		return fmt.Errorf("%w %s:%s", ErrMetricTypeMismatch, metricKey, currentMetric.MType)
 	default:
-		m.metricsCache[metricName] = &metrics.Metric{
-			ID:    metricName,
-			MType: metrics.MetricTypeCounter,
-			Delta: &metricData,
+		m.metricsCache[metricKey] = &metrics.Metric{
+			ID:     name,
+			Labels: labels,
+			MType:  metrics.MetricTypeCounter,
+			Delta:  &metricData,
 		}
 	}
 
+	m.history.record(m.metricsCache[metricKey])
+	m.watchers.publish(EventUpdate, m.metricsCache[metricKey])
+	m.updated.touch(metricKey, time.Now())
+
 	return nil
 }
 
 // ResetCounterMetric resets counter to default zero value
 func (m *InMemoryStore) ResetCounterMetric(_ context.Context, metricName string) error {
+	metricKey, name, labels, err := parseMetricKey(metricName)
+	if err != nil {
+		return err
+	}
+
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
 	var zero metrics.Counter
-	currentMetric, ok := m.metricsCache[metricName]
+	currentMetric, ok := m.metricsCache[metricKey]
 	switch {
 	case ok && currentMetric.Delta != nil:
 		*(currentMetric.Delta) = zero
 	case ok && currentMetric.Delta == nil:
-		return fmt.Errorf("%w %s:%s", ErrMetricTypeMismatch, metricName, currentMetric.MType)
+		return fmt.Errorf("%w %s:%s", ErrMetricTypeMismatch, metricKey, currentMetric.MType)
 	default:
-		m.metricsCache[metricName] = &metrics.Metric{
-			ID:    metricName,
-			MType: metrics.MetricTypeCounter,
-			Delta: &zero,
+		m.metricsCache[metricKey] = &metrics.Metric{
+			ID:     name,
+			Labels: labels,
+			MType:  metrics.MetricTypeCounter,
+			Delta:  &zero,
 		}
 	}
 
+	m.history.record(m.metricsCache[metricKey])
+	m.watchers.publish(EventUpdate, m.metricsCache[metricKey])
+	m.updated.touch(metricKey, time.Now())
+
 	return nil
 }
 
 // UpdateGaugeMetric updates gauge type metric
 func (m *InMemoryStore) UpdateGaugeMetric(_ context.Context, metricName string, metricData metrics.Gauge) error {
+	metricKey, name, labels, err := parseMetricKey(metricName)
+	if err != nil {
+		return err
+	}
+
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
-	currentMetric, ok := m.metricsCache[metricName]
+	currentMetric, ok := m.metricsCache[metricKey]
 	switch {
 	case ok && currentMetric.Value != nil:
 		*(currentMetric.Value) = metricData
 	case ok && currentMetric.Value == nil:
-		return fmt.Errorf("%w %s:%s", ErrMetricTypeMismatch, metricName, currentMetric.MType)
+		return fmt.Errorf("%w %s:%s", ErrMetricTypeMismatch, metricKey, currentMetric.MType)
+	default:
+		m.metricsCache[metricKey] = &metrics.Metric{
+			ID:     name,
+			Labels: labels,
+			MType:  metrics.MetricTypeGauge,
+			Value:  &metricData,
+		}
+	}
+
+	m.history.record(m.metricsCache[metricKey])
+	m.watchers.publish(EventUpdate, m.metricsCache[metricKey])
+	m.updated.touch(metricKey, time.Now())
+
+	return nil
+}
+
+// AddGauge adds delta to gauge type metric and returns its new value
+func (m *InMemoryStore) AddGauge(_ context.Context, metricName string, delta metrics.Gauge) (metrics.Gauge, error) {
+	metricKey, name, labels, err := parseMetricKey(metricName)
+	if err != nil {
+		return 0, err
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	value, err := addGauge(metricKey, m.metricsCache[metricKey], delta)
+	if err != nil {
+		return 0, err
+	}
+	m.setGauge(metricKey, name, labels, value)
+
+	return value, nil
+}
+
+// CompareAndSetGauge sets value of gauge type metric if its current value equals to expected one
+func (m *InMemoryStore) CompareAndSetGauge(_ context.Context, metricName string, expected *metrics.Gauge,
+	value metrics.Gauge) error {
+	metricKey, name, labels, err := parseMetricKey(metricName)
+	if err != nil {
+		return err
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if err := compareGauge(metricKey, m.metricsCache[metricKey], expected); err != nil {
+		return err
+	}
+	m.setGauge(metricKey, name, labels, value)
+
+	return nil
+}
+
+// setGauge does actual work to set value of gauge by its key, the lock of store must be held
+func (m *InMemoryStore) setGauge(metricKey string, name string, labels metrics.Labels, value metrics.Gauge) {
+	if currentMetric, ok := m.metricsCache[metricKey]; ok {
+		*(currentMetric.Value) = value
+	} else {
+		m.metricsCache[metricKey] = &metrics.Metric{
+			ID:     name,
+			Labels: labels,
+			MType:  metrics.MetricTypeGauge,
+			Value:  &value,
+		}
+	}
+
+	m.history.record(m.metricsCache[metricKey])
+	m.watchers.publish(EventUpdate, m.metricsCache[metricKey])
+	m.updated.touch(metricKey, time.Now())
+}
+
+// UpdateHistogramMetric merges observations to histogram type metric
+func (m *InMemoryStore) UpdateHistogramMetric(_ context.Context, metricName string, metricData *metrics.Histogram) error {
+	metricKey, name, labels, err := parseMetricKey(metricName)
+	if err != nil {
+		return err
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	currentMetric, ok := m.metricsCache[metricKey]
+	switch {
+	case ok && currentMetric.Histogram != nil:
+		if err := currentMetric.Histogram.Merge(metricData); err != nil {
+			return err
+		}
+	case ok && currentMetric.Histogram == nil:
+		return fmt.Errorf("%w %s:%s", ErrMetricTypeMismatch, metricKey, currentMetric.MType)
 	default:
-		m.metricsCache[metricName] = &metrics.Metric{
-			ID:    metricName,
-			MType: metrics.MetricTypeGauge,
-			Value: &metricData,
+		if err := metricData.Validate(); err != nil {
+			return err
+		}
+		m.metricsCache[metricKey] = &metrics.Metric{
+			ID:        name,
+			Labels:    labels,
+			MType:     metrics.MetricTypeHistogram,
+			Histogram: metricData.Copy(),
 		}
 	}
 
+	m.history.record(m.metricsCache[metricKey])
+	m.watchers.publish(EventUpdate, m.metricsCache[metricKey])
+	m.updated.touch(metricKey, time.Now())
+
 	return nil
 }
 
-// UpdateMetrics update number of metrics
+// UpdateMetrics update number of metrics, observations of histograms are merged
 func (m *InMemoryStore) UpdateMetrics(_ context.Context, metricsBatch []*metrics.Metric) error {
+	if err := validateMetrics(metricsBatch); err != nil {
+		return err
+	}
+
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
 	for _, metric := range metricsBatch {
-		currentMetric, ok := m.metricsCache[metric.ID]
-		switch {
-		case ok && metric.MType == metrics.MetricTypeGauge && currentMetric.Value != nil:
-			currentMetric.Value = metric.Value
-		case ok && metric.MType == metrics.MetricTypeGauge && currentMetric.Value == nil:
-			return fmt.Errorf("%w %s:%s", ErrMetricTypeMismatch, metric.ID, currentMetric.MType)
-		case ok && metric.MType == metrics.MetricTypeCounter && currentMetric.Delta != nil:
-			*(currentMetric.Delta) += *(metric.Delta)
-		case ok && metric.MType == metrics.MetricTypeCounter && currentMetric.Delta == nil:
-			return fmt.Errorf("%w %s:%s", ErrMetricTypeMismatch, metric.ID, currentMetric.MType)
-		default:
-			m.metricsCache[metric.ID] = metric
+		if err := m.updateMetric(metric.Key(), metric); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
-// GetMetric return metric by name
+// updateKeyedMetrics updates number of metrics by their keys
+func (m *InMemoryStore) updateKeyedMetrics(metricKeys []string, metricsBatch []*metrics.Metric) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for i, metric := range metricsBatch {
+		if err := m.updateMetric(metricKeys[i], metric); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// updateMetric does actual work to update metric by its key, the lock of store must be held
+func (m *InMemoryStore) updateMetric(metricKey string, metric *metrics.Metric) error {
+	currentMetric, ok := m.metricsCache[metricKey]
+	switch {
+	case ok && metric.MType == metrics.MetricTypeGauge && currentMetric.Value != nil:
+		*(currentMetric.Value) = *(metric.Value)
+	case ok && metric.MType == metrics.MetricTypeGauge && currentMetric.Value == nil:
+		return fmt.Errorf("%w %s:%s", ErrMetricTypeMismatch, metricKey, currentMetric.MType)
+	case ok && metric.MType == metrics.MetricTypeCounter && currentMetric.Delta != nil:
+		*(currentMetric.Delta) += *(metric.Delta)
+	case ok && metric.MType == metrics.MetricTypeCounter && currentMetric.Delta == nil:
+		return fmt.Errorf("%w %s:%s", ErrMetricTypeMismatch, metricKey, currentMetric.MType)
+	case ok && metric.MType == metrics.MetricTypeHistogram && currentMetric.Histogram != nil:
+		if err := currentMetric.Histogram.Merge(metric.Histogram); err != nil {
+			return err
+		}
+	case ok && metric.MType == metrics.MetricTypeHistogram && currentMetric.Histogram == nil:
+		return fmt.Errorf("%w %s:%s", ErrMetricTypeMismatch, metricKey, currentMetric.MType)
+	case metric.MType == metrics.MetricTypeHistogram:
+		if err := metric.Histogram.Validate(); err != nil {
+			return err
+		}
+		m.metricsCache[metricKey] = metric.Copy()
+	default:
+		m.metricsCache[metricKey] = metric.Copy()
+	}
+
+	m.history.record(m.metricsCache[metricKey])
+	m.watchers.publish(EventUpdate, m.metricsCache[metricKey])
+	m.updated.touch(metricKey, time.Now())
+
+	return nil
+}
+
+// GetMetric return copy of metric by name
 func (m *InMemoryStore) GetMetric(_ context.Context, metricName string, _ string) (*metrics.Metric, error) {
+	metricKey, _, _, err := parseMetricKey(metricName)
+	if err != nil {
+		return nil, err
+	}
+
 	m.lock.RLock()
 	defer m.lock.RUnlock()
 
-	metric, ok := m.metricsCache[metricName]
+	metric, ok := m.metricsCache[metricKey]
 	if !ok {
 		return nil, ErrMetricNotFound
 	}
 
-	return metric, nil
+	return metric.Copy(), nil
 }
 
-// GetMetrics returns all of stored metrics
-func (m *InMemoryStore) GetMetrics(_ context.Context) (map[string]*metrics.Metric, error) {
+// GetMetrics returns copies of all stored metrics which satisfy label matchers
+func (m *InMemoryStore) GetMetrics(_ context.Context, matchers ...*metrics.Matcher) (map[string]*metrics.Metric, error) {
 	m.lock.RLock()
 	defer m.lock.RUnlock()
 
 	metricsData := make(map[string]*metrics.Metric, len(m.metricsCache))
 
 	for k, v := range m.metricsCache {
-		metricsData[k] = v
+		if metrics.MatchesAll(v, matchers) {
+			metricsData[k] = v.Copy()
+		}
 	}
 
 	return metricsData, nil
 }
 
+// collectMetrics adds copies of stored metrics which satisfy label matchers to metricsData
+func (m *InMemoryStore) collectMetrics(metricsData map[string]*metrics.Metric, matchers []*metrics.Matcher) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	for k, v := range m.metricsCache {
+		if metrics.MatchesAll(v, matchers) {
+			metricsData[k] = v.Copy()
+		}
+	}
+}
+
+// ListMetrics returns the page of copies of stored metrics which satisfy the filter
+func (m *InMemoryStore) ListMetrics(_ context.Context, filter *ListFilter) (*MetricsPage, error) {
+	cursor, err := filter.cursor()
+	if err != nil {
+		return nil, err
+	}
+
+	return newMetricsPage(m.listMetrics(nil, filter, cursor), filter), nil
+}
+
+// listMetrics appends copies of stored metrics which satisfy the filter and follow the cursor to listed metrics
+func (m *InMemoryStore) listMetrics(listed []*listedMetric, filter *ListFilter, cursor *listPosition) []*listedMetric {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return listMetrics(listed, m.metricsCache, filter, cursor)
+}
+
+// DeleteMetric removes metric and its history
+func (m *InMemoryStore) DeleteMetric(_ context.Context, metricName string, metricType string) error {
+	metricKey, _, _, err := parseMetricKey(metricName)
+	if err != nil {
+		return err
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	currentMetric, ok := m.metricsCache[metricKey]
+	switch {
+	case !ok:
+		return fmt.Errorf("%w %s:%s", ErrMetricNotFound, metricKey, metricType)
+	case currentMetric.MType != metricType:
+		return fmt.Errorf("%w %s:%s", ErrMetricTypeMismatch, metricKey, currentMetric.MType)
+	}
+
+	m.deleteMetric(metricKey)
+
+	return nil
+}
+
+// DeleteMetrics removes all of metrics which satisfy label matchers and returns their number
+func (m *InMemoryStore) DeleteMetrics(_ context.Context, matchers ...*metrics.Matcher) (int, error) {
+	return len(m.deleteMetrics(matchers)), nil
+}
+
+// deleteMetrics removes all of metrics which satisfy label matchers and returns them by their keys
+func (m *InMemoryStore) deleteMetrics(matchers []*metrics.Matcher) map[string]*metrics.Metric {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	deleted := make(map[string]*metrics.Metric)
+	for k, v := range m.metricsCache {
+		if metrics.MatchesAll(v, matchers) {
+			deleted[k] = v
+			m.deleteMetric(k)
+		}
+	}
+
+	return deleted
+}
+
+// DeleteStaleMetrics removes metrics which aren't updated for their TTL and returns their number
+func (m *InMemoryStore) DeleteStaleMetrics(_ context.Context, ttl *TTL, now time.Time) (int, error) {
+	return len(m.deleteStaleMetrics(ttl, now)), nil
+}
+
+// deleteStaleMetrics removes metrics which aren't updated for their TTL and returns them by their keys
+func (m *InMemoryStore) deleteStaleMetrics(ttl *TTL, now time.Time) map[string]*metrics.Metric {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	deleted := make(map[string]*metrics.Metric)
+	for _, k := range m.updated.stale(m.metricsCache, ttl, now) {
+		deleted[k] = m.metricsCache[k]
+		m.deleteMetric(k)
+	}
+
+	return deleted
+}
+
+// deleteMetric does actual work to remove metric and its history by key, the lock of store must be held
+func (m *InMemoryStore) deleteMetric(metricKey string) {
+	m.watchers.publish(EventDelete, m.metricsCache[metricKey])
+	delete(m.metricsCache, metricKey)
+	m.history.delete(metricKey)
+	m.updated.forget(metricKey)
+}
+
+// Watch returns change feed of metrics which satisfy the filter
+func (m *InMemoryStore) Watch(ctx context.Context, filter *WatchFilter) (<-chan *Event, error) {
+	m.lock.Lock()
+	if m.watchers == nil {
+		m.watchers = newWatchHub()
+	}
+	watchers := m.watchers
+	m.lock.Unlock()
+
+	return watchers.watch(ctx, filter), nil
+}
+
+// SetMetadata sets metadata of metric by its name, empty metadata removes it
+func (m *InMemoryStore) SetMetadata(_ context.Context, metricName string, metadata *metrics.Metadata) error {
+	if err := validateMetadata(metricName, metadata); err != nil {
+		return err
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.metadata.set(metricName, metadata)
+
+	return nil
+}
+
+// GetMetadata returns copies of metadata of all metrics by their names
+func (m *InMemoryStore) GetMetadata(_ context.Context) (map[string]*metrics.Metadata, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.metadata.all(), nil
+}
+
 // Ping checks that underlying store is alive
 func (m *InMemoryStore) Ping(_ context.Context) error { return nil }
+
+// AppendSamples adds timestamped samples to the history of metric
+func (m *InMemoryStore) AppendSamples(_ context.Context, metricName string, metricType string,
+	samples ...metrics.Sample) error {
+	if m.history == nil {
+		return ErrHistoryDisabled
+	}
+
+	metricKey, err := m.checkMetricType(metricName, metricType)
+	if err != nil {
+		return err
+	}
+
+	if err := validateSamples(metricKey, metricType, samples); err != nil {
+		return err
+	}
+
+	m.history.append(metricKey, samples...)
+
+	return nil
+}
+
+// GetHistory returns samples of metric in the [from, to] range downsampled by step
+func (m *InMemoryStore) GetHistory(_ context.Context, metricName string, metricType string,
+	from time.Time, to time.Time, step time.Duration) ([]metrics.Sample, error) {
+	if err := validateTimeRange(from, to, step); err != nil {
+		return nil, err
+	}
+
+	metricKey, err := m.checkMetricType(metricName, metricType)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.history.query(metricKey, from, to, step)
+}
+
+// checkMetricType checks that stored metric has the same type and returns canonical key of metric
+func (m *InMemoryStore) checkMetricType(metricName string, metricType string) (string, error) {
+	metricKey, _, _, err := parseMetricKey(metricName)
+	if err != nil {
+		return "", err
+	}
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	currentMetric, ok := m.metricsCache[metricKey]
+	if ok && currentMetric.MType != metricType {
+		return "", fmt.Errorf("%w %s:%s", ErrMetricTypeMismatch, metricKey, currentMetric.MType)
+	}
+
+	return metricKey, nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/itd27m01/go-metrics-service/internal/models/metrics"
 	"github.com/stretchr/testify/assert"
@@ -280,3 +281,107 @@ func TestInMemoryStore_GetMetrics(t *testing.T) {
 		})
 	}
 }
+
+func TestInMemoryStore_Labels(t *testing.T) {
+	m := NewInMemoryStore()
+	ctx := context.Background()
+
+	assert.NoError(t, m.UpdateGaugeMetric(ctx, `CPUutilization{host="db1",cpu="1"}`, 10))
+	assert.NoError(t, m.UpdateGaugeMetric(ctx, `CPUutilization{cpu="2"}`, 20))
+	assert.NoError(t, m.UpdateMetrics(ctx, []*metrics.Metric{
+		{
+			ID:     "CPUutilization",
+			MType:  metrics.MetricTypeGauge,
+			Labels: metrics.Labels{"cpu": "1", "host": "db1"},
+			Value:  func(v metrics.Gauge) *metrics.Gauge { return &v }(30),
+		},
+	}))
+
+	got, err := m.GetMetric(ctx, `CPUutilization{cpu="1",host="db1"}`, metrics.MetricTypeGauge)
+	assert.NoError(t, err)
+	assert.Equal(t, metrics.Gauge(30), *got.Value)
+	assert.Equal(t, metrics.Labels{"cpu": "1", "host": "db1"}, got.Labels)
+
+	_, err = m.GetMetric(ctx, "CPUutilization", metrics.MetricTypeGauge)
+	assert.ErrorIs(t, err, ErrMetricNotFound)
+
+	matchers, err := metrics.ParseSelector(`CPUutilization{cpu=~"2|3"}`)
+	assert.NoError(t, err)
+
+	metricsData, err := m.GetMetrics(ctx, matchers...)
+	assert.NoError(t, err)
+	assert.Len(t, metricsData, 1)
+	assert.Contains(t, metricsData, `CPUutilization{cpu="2"}`)
+
+	assert.ErrorIs(t, m.UpdateCounterMetric(ctx, `CPUutilization{cpu="2"}`, 1), ErrMetricTypeMismatch)
+	assert.ErrorIs(t, m.UpdateCounterMetric(ctx, `CPUutilization{cpu=2}`, 1), metrics.ErrInvalidSelector)
+}
+
+func TestInMemoryStore_UpdateHistogramMetric(t *testing.T) {
+	m := NewInMemoryStore()
+	ctx := context.Background()
+
+	histogram := metrics.NewHistogram(0.1, 1)
+	histogram.Observe(0.5)
+	assert.NoError(t, m.UpdateHistogramMetric(ctx, "Latency", histogram))
+	assert.NoError(t, m.UpdateMetrics(ctx, []*metrics.Metric{
+		{
+			ID:    "Latency",
+			MType: metrics.MetricTypeHistogram,
+			Histogram: &metrics.Histogram{
+				Bounds: []float64{0.1, 1},
+				Counts: []metrics.Counter{1, 0, 1},
+				Sum:    5.05,
+				Count:  2,
+			},
+		},
+	}))
+
+	got, err := m.GetMetric(ctx, "Latency", metrics.MetricTypeHistogram)
+	assert.NoError(t, err)
+	assert.Equal(t, []metrics.Counter{1, 1, 1}, got.Histogram.Counts)
+	assert.Equal(t, metrics.Counter(3), got.Histogram.Count)
+	assert.Equal(t, []metrics.Counter{0, 1, 0}, histogram.Counts, "stored histogram must not alias the argument")
+
+	assert.ErrorIs(t, m.UpdateHistogramMetric(ctx, "Latency", metrics.NewHistogram(1)),
+		metrics.ErrHistogramBucketsMismatch)
+	assert.ErrorIs(t, m.UpdateHistogramMetric(ctx, "Invalid", &metrics.Histogram{Count: 1}),
+		metrics.ErrInvalidHistogram)
+
+	assert.NoError(t, m.UpdateGaugeMetric(ctx, "Alloc", 1))
+	assert.ErrorIs(t, m.UpdateHistogramMetric(ctx, "Alloc", histogram), ErrMetricTypeMismatch)
+	assert.ErrorIs(t, m.UpdateGaugeMetric(ctx, "Latency", 1), ErrMetricTypeMismatch)
+}
+
+func TestInMemoryStore_DeleteMetrics(t *testing.T) {
+	m := NewInMemoryStoreWithHistory(time.Hour)
+	ctx := context.Background()
+
+	assert.NoError(t, m.UpdateGaugeMetric(ctx, "Aloc", 1))
+	assert.NoError(t, m.UpdateGaugeMetric(ctx, `CPUutilization{host="db1"}`, 10))
+	assert.NoError(t, m.UpdateGaugeMetric(ctx, `CPUutilization{host="db2"}`, 20))
+	assert.NoError(t, m.UpdateCounterMetric(ctx, `CPUthrottled{host="db2"}`, 1))
+	assert.NoError(t, m.UpdateCounterMetric(ctx, "PollCount", 1))
+
+	assert.ErrorIs(t, m.DeleteMetric(ctx, "Aloc", metrics.MetricTypeCounter), ErrMetricTypeMismatch)
+	assert.NoError(t, m.DeleteMetric(ctx, "Aloc", metrics.MetricTypeGauge))
+	assert.ErrorIs(t, m.DeleteMetric(ctx, "Aloc", metrics.MetricTypeGauge), ErrMetricNotFound)
+
+	_, err := m.GetMetric(ctx, "Aloc", metrics.MetricTypeGauge)
+	assert.ErrorIs(t, err, ErrMetricNotFound)
+	samples, err := m.GetHistory(ctx, "Aloc", metrics.MetricTypeGauge, time.Time{}, time.Now(), 0)
+	assert.NoError(t, err)
+	assert.Empty(t, samples)
+
+	matchers, err := metrics.ParseSelector(`{host="db2"}`)
+	assert.NoError(t, err)
+	deleted, err := m.DeleteMetrics(ctx, append(matchers, metrics.NewPrefixMatcher("CPU"))...)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+
+	metricsData, err := m.GetMetrics(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, metricsData, 2)
+	assert.Contains(t, metricsData, `CPUutilization{host="db1"}`)
+	assert.Contains(t, metricsData, "PollCount")
+}
@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"fmt"
+
+	"github.com/itd27m01/go-metrics-service/internal/models/metrics"
+)
+
+// metadataRegistry keeps metadata of metrics by their names, zero value is ready to use
+type metadataRegistry struct {
+	metadata map[string]*metrics.Metadata
+}
+
+// set keeps copy of metadata for the name of metric, empty metadata removes it
+func (r *metadataRegistry) set(name string, metadata *metrics.Metadata) {
+	if metadata.IsEmpty() {
+		delete(r.metadata, name)
+
+		return
+	}
+
+	if r.metadata == nil {
+		r.metadata = make(map[string]*metrics.Metadata)
+	}
+
+	metadataCopy := *metadata
+	r.metadata[name] = &metadataCopy
+}
+
+// all returns copies of metadata of all metrics by their names
+func (r *metadataRegistry) all() map[string]*metrics.Metadata {
+	return copyMetadata(r.metadata)
+}
+
+// copyMetadata returns copies of metadata by names of metrics
+func copyMetadata(metadata map[string]*metrics.Metadata) map[string]*metrics.Metadata {
+	metadataCopy := make(map[string]*metrics.Metadata, len(metadata))
+	for name, md := range metadata {
+		mdCopy := *md
+		metadataCopy[name] = &mdCopy
+	}
+
+	return metadataCopy
+}
+
+// validateMetadata checks that metadata is valid and is set for the name of metric without labels,
+// the name can be scoped by tenant label only
+func validateMetadata(name string, metadata *metrics.Metadata) error {
+	metricName, labels, err := metrics.ParseKey(name)
+	if err != nil {
+		return err
+	}
+	_, scoped := labels[metrics.TenantLabel]
+	if len(labels) > 1 || (len(labels) == 1 && !scoped) || metrics.Key(metricName, labels) != name {
+		return fmt.Errorf("%w %s: metadata is kept by the name of metric without labels", metrics.ErrInvalidMetadata, name)
+	}
+
+	return metadata.Validate()
+}
@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/itd27m01/go-metrics-service/internal/models/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStores_Metadata(t *testing.T) {
+	stores := testStores(t)
+	stores["DBStore"] = newTestDBStore(t, testDatabaseDSN(t))
+	stores["MirrorStore"] = NewMirrorStore(NewInMemoryStore(), false)
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			alloc := &metrics.Metadata{Description: "Bytes of allocated heap objects", Unit: "bytes"}
+			require.NoError(t, store.SetMetadata(ctx, "TestMetadataAlloc", alloc))
+			require.NoError(t, store.SetMetadata(ctx, "TestMetadataPollCount", &metrics.Metadata{Owner: "agent"}))
+			require.NoError(t, store.SetMetadata(ctx, "TestMetadataPollCount", nil))
+			alloc.Unit = "changed"
+
+			assert.ErrorIs(t, store.SetMetadata(ctx, `TestMetadataAlloc{host="a"}`, alloc), metrics.ErrInvalidMetadata)
+			assert.ErrorIs(t, store.SetMetadata(ctx, "TestMetadataAlloc", &metrics.Metadata{HelpURL: "docs"}),
+				metrics.ErrInvalidMetadata)
+
+			metadata, err := store.GetMetadata(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, &metrics.Metadata{Description: "Bytes of allocated heap objects", Unit: "bytes"},
+				metadata["TestMetadataAlloc"])
+			assert.NotContains(t, metadata, "TestMetadataPollCount", "empty metadata must be removed")
+
+			metadata["TestMetadataAlloc"].Unit = "changed"
+			metadata, err = store.GetMetadata(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, "bytes", metadata["TestMetadataAlloc"].Unit, "metadata must be copied")
+		})
+	}
+}
+
+func TestFileStore_MetadataPersistence(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	alloc := &metrics.Metadata{Description: "Bytes of allocated heap objects", Unit: "bytes"}
+	pollCount := &metrics.Metadata{Description: "Number of polls"}
+
+	fs := newTestWALFileStore(t, dir)
+	require.NoError(t, fs.SetMetadata(ctx, "Alloc", alloc))
+	require.NoError(t, fs.SetMetadata(ctx, "Removed", pollCount))
+	require.NoError(t, fs.SaveMetrics())
+	require.NoError(t, fs.SetMetadata(ctx, "PollCount", pollCount))
+	require.NoError(t, fs.SetMetadata(ctx, "Removed", nil))
+	crashTestFileStore(t, fs)
+
+	fs = newTestWALFileStore(t, dir)
+	metadata, err := fs.GetMetadata(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]*metrics.Metadata{"Alloc": alloc, "PollCount": pollCount}, metadata,
+		"metadata must be loaded from snapshot and replayed from write-ahead log")
+	crashTestFileStore(t, fs)
+}
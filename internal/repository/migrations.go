@@ -0,0 +1,308 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/itd27m01/go-metrics-service/pkg/logging/log"
+)
+
+const (
+	// migrationsLockID is a key of the advisory lock which serializes migrations of replicas
+	migrationsLockID = 7_204_419_385_164_821
+	migrationBase    = 10
+	migrationBitSize = 64
+)
+
+// Errors for migrations
+var (
+	ErrInvalidMigration = errors.New("invalid migration")
+	ErrDirtyMigration   = errors.New("database schema is dirty, fix it manually and set the version")
+)
+
+// migrationFileName matches file of migration, e.g. 000001_create_gauge_table.up.sql
+var migrationFileName = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Migration defines versioned change of the database schema
+type Migration struct {
+	Version uint64
+	Title   string
+	up      string
+	down    string
+}
+
+// MigrationsStatus defines state of the database schema
+type MigrationsStatus struct {
+	Version uint64       // Version of the last applied migration, zero if there are no applied migrations
+	Dirty   bool         // Last migration failed and the schema must be fixed manually
+	Applied []*Migration // Migrations which are applied to the database
+	Pending []*Migration // Migrations which are waiting to be applied
+}
+
+// Migrator applies embedded migrations to the database,
+// version of the schema is tracked in schema_migrations table compatible with golang-migrate
+type Migrator struct {
+	connection *sql.DB
+	dialect    *sqlDialect
+	migrations []*Migration
+	owned      bool
+}
+
+// NewMigrator creates migrator with own connection to the database,
+// DSN with sqlite:// scheme selects SQLite database and its migrations
+func NewMigrator(databaseDSN string) (*Migrator, error) {
+	conn, dialect, err := openDatabase(databaseDSN)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := newMigrator(conn, dialect)
+	if err != nil {
+		if err := conn.Close(); err != nil {
+			log.Error().Err(err).Msg("Failed to close database connection")
+		}
+
+		return nil, err
+	}
+	m.owned = true
+
+	return m, nil
+}
+
+// newMigrator creates migrator of the dialect which uses the connection of store
+func newMigrator(conn *sql.DB, dialect *sqlDialect) (*Migrator, error) {
+	migrationsFS, err := fs.Sub(dialect.migrations, dialect.migrationsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations, err := loadMigrations(migrationsFS)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{
+		connection: conn,
+		dialect:    dialect,
+		migrations: migrations,
+	}, nil
+}
+
+// Up applies all of pending migrations
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		status, err := m.status(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range status.Pending {
+			log.Info().Msgf("Apply migration %d_%s", migration.Version, migration.Title)
+
+			if err := applyMigration(ctx, conn, migration.up, migration.Version); err != nil {
+				return fmt.Errorf("%w %d_%s: %s", ErrInvalidMigration, migration.Version, migration.Title, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// Down reverts steps of the last applied migrations
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		status, err := m.status(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(status.Applied) - 1; i >= 0 && steps > 0; i, steps = i-1, steps-1 {
+			migration := status.Applied[i]
+			if migration.down == "" {
+				return fmt.Errorf("%w %d_%s: no down migration", ErrInvalidMigration, migration.Version, migration.Title)
+			}
+
+			var version uint64
+			if i > 0 {
+				version = status.Applied[i-1].Version
+			}
+
+			log.Info().Msgf("Revert migration %d_%s", migration.Version, migration.Title)
+
+			if err := applyMigration(ctx, conn, migration.down, version); err != nil {
+				return fmt.Errorf("%w %d_%s: %s", ErrInvalidMigration, migration.Version, migration.Title, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// Status returns state of the database schema
+func (m *Migrator) Status(ctx context.Context) (*MigrationsStatus, error) {
+	var status *MigrationsStatus
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		var err error
+		status, err = m.status(ctx, conn)
+		if errors.Is(err, ErrDirtyMigration) {
+			return nil
+		}
+
+		return err
+	})
+
+	return status, err
+}
+
+// Close closes connection to the database if it's owned by migrator
+func (m *Migrator) Close() error {
+	if !m.owned {
+		return nil
+	}
+
+	return m.connection.Close()
+}
+
+// withLock runs f on the single connection which holds the lock of migrations if the dialect has one
+func (m *Migrator) withLock(ctx context.Context, f func(conn *sql.Conn) error) error {
+	conn, err := m.connection.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func(conn *sql.Conn) {
+		if err := conn.Close(); err != nil {
+			log.Error().Err(err).Msg("Failed to close connection")
+		}
+	}(conn)
+
+	if m.dialect.lockMigrations != "" {
+		if _, err := conn.ExecContext(ctx, m.dialect.lockMigrations, migrationsLockID); err != nil {
+			return err
+		}
+		defer func(conn *sql.Conn) {
+			_, err := conn.ExecContext(context.Background(), m.dialect.unlockMigrations, migrationsLockID)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to release migrations lock")
+			}
+		}(conn)
+	}
+
+	_, err = conn.ExecContext(ctx,
+		"CREATE TABLE IF NOT EXISTS schema_migrations (version BIGINT NOT NULL PRIMARY KEY, dirty BOOLEAN NOT NULL)")
+	if err != nil {
+		return err
+	}
+
+	return f(conn)
+}
+
+// status reads version of the schema, returns the status with ErrDirtyMigration for the dirty schema
+func (m *Migrator) status(ctx context.Context, conn *sql.Conn) (*MigrationsStatus, error) {
+	status := MigrationsStatus{
+		Applied: make([]*Migration, 0, len(m.migrations)),
+		Pending: make([]*Migration, 0, len(m.migrations)),
+	}
+
+	row := conn.QueryRowContext(ctx, "SELECT version, dirty FROM schema_migrations LIMIT 1")
+	err := row.Scan(&status.Version, &status.Dirty)
+	if !errors.Is(err, nil) && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	for _, migration := range m.migrations {
+		if migration.Version <= status.Version {
+			status.Applied = append(status.Applied, migration)
+		} else {
+			status.Pending = append(status.Pending, migration)
+		}
+	}
+
+	if status.Dirty {
+		return &status, fmt.Errorf("%w: version %d", ErrDirtyMigration, status.Version)
+	}
+
+	return &status, nil
+}
+
+// applyMigration executes statements of migration and sets the version of schema in one transaction
+func applyMigration(ctx context.Context, conn *sql.Conn, statements string, version uint64) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, statements); err == nil {
+		if _, err = tx.ExecContext(ctx, "DELETE FROM schema_migrations"); err == nil && version > 0 {
+			_, err = tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, dirty) VALUES ($1, false)", version)
+		}
+	}
+	if err != nil {
+		if err := tx.Rollback(); err != nil {
+			log.Error().Err(err).Msg("unable to rollback transaction")
+		}
+
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// loadMigrations reads migrations from the files of directory ordered by version
+func loadMigrations(migrationsFS fs.FS) ([]*Migration, error) {
+	entries, err := fs.ReadDir(migrationsFS, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	migrationsMap := make(map[uint64]*Migration)
+	for _, entry := range entries {
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseUint(match[1], migrationBase, migrationBitSize)
+		if err != nil {
+			return nil, fmt.Errorf("%w %s: %s", ErrInvalidMigration, entry.Name(), err)
+		}
+
+		statements, err := fs.ReadFile(migrationsFS, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := migrationsMap[version]
+		if !ok {
+			migration = &Migration{Version: version, Title: match[2]}
+			migrationsMap[version] = migration
+		}
+		if migration.Title != match[2] {
+			return nil, fmt.Errorf("%w %s: version %d is used by %s", ErrInvalidMigration, entry.Name(), version, migration.Title)
+		}
+
+		if match[3] == "up" {
+			migration.up = string(statements)
+		} else {
+			migration.down = string(statements)
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(migrationsMap))
+	for _, migration := range migrationsMap {
+		if migration.up == "" {
+			return nil, fmt.Errorf("%w %d_%s: no up migration", ErrInvalidMigration, migration.Version, migration.Title)
+		}
+
+		migrations = append(migrations, migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
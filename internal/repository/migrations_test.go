@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_loadMigrations(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    fstest.MapFS
+		versions []uint64
+		wantErr  bool
+	}{
+		{
+			name: "Migrations are ordered by version",
+			files: fstest.MapFS{
+				"000010_add_index.up.sql":      {Data: []byte("CREATE INDEX")},
+				"000002_create_table.up.sql":   {Data: []byte("CREATE TABLE")},
+				"000002_create_table.down.sql": {Data: []byte("DROP TABLE")},
+				"README.md":                    {Data: []byte("not a migration")},
+			},
+			versions: []uint64{2, 10},
+		},
+		{
+			name: "Down migration without up one",
+			files: fstest.MapFS{
+				"000001_create_table.down.sql": {Data: []byte("DROP TABLE")},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Version is used by two migrations",
+			files: fstest.MapFS{
+				"000001_create_table.up.sql": {Data: []byte("CREATE TABLE")},
+				"000001_add_index.up.sql":    {Data: []byte("CREATE INDEX")},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations, err := loadMigrations(tt.files)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidMigration)
+
+				return
+			}
+			require.NoError(t, err)
+
+			versions := make([]uint64, 0, len(migrations))
+			for _, migration := range migrations {
+				versions = append(versions, migration.Version)
+			}
+			assert.Equal(t, tt.versions, versions)
+		})
+	}
+}
+
+func Test_embeddedMigrations(t *testing.T) {
+	for _, dialect := range []*sqlDialect{&postgresDialect, &sqliteDialect} {
+		m, err := newMigrator(nil, dialect)
+		require.NoError(t, err)
+		require.NotEmpty(t, m.migrations)
+
+		for i, migration := range m.migrations {
+			assert.Equal(t, uint64(i+1), migration.Version)
+			assert.NotEmpty(t, migration.up)
+			assert.NotEmpty(t, migration.down)
+		}
+	}
+}
+
+func TestMigrator_UpDown(t *testing.T) {
+	databaseDSN := testDatabaseDSN(t)
+	newTestDBStore(t, databaseDSN)
+
+	migrator, err := NewMigrator(databaseDSN)
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, migrator.Close()) }()
+
+	ctx := context.Background()
+	status, err := migrator.Status(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, status.Pending, "store must apply migrations")
+	assert.Equal(t, migrator.migrations[len(migrator.migrations)-1].Version, status.Version)
+
+	require.NoError(t, migrator.Down(ctx, 1))
+	status, err = migrator.Status(ctx)
+	require.NoError(t, err)
+	assert.Len(t, status.Pending, 1)
+
+	require.NoError(t, migrator.Up(ctx))
+	status, err = migrator.Status(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, status.Pending)
+}
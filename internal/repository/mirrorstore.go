@@ -0,0 +1,421 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/itd27m01/go-metrics-service/internal/models/metrics"
+	"github.com/itd27m01/go-metrics-service/pkg/logging/log"
+)
+
+// Names of counters which are kept in the primary store to track failures of secondary stores,
+// the counters are labeled by the name of secondary store
+const (
+	MirrorFailuresMetric = "MirrorFailures"
+	MirrorDroppedMetric  = "MirrorDropped"
+)
+
+// ErrEmptyPrimary means that the primary store has no metrics and resync would wipe secondary stores
+var ErrEmptyPrimary = errors.New("primary store is empty, secondary stores aren't resynced")
+
+// mirrorQueueSize is the number of updates which are queued for secondary store in asynchronous mode
+const mirrorQueueSize = 1024
+
+var (
+	_ Store        = (*MirrorStore)(nil)
+	_ HistoryStore = (*MirrorStore)(nil)
+)
+
+// Secondary defines secondary store of mirror store, the name of secondary store is used in logs and labels
+// of metrics which are readable by clients, so it must not disclose DSN of the store
+type Secondary struct {
+	Name  string
+	Store Store
+}
+
+// MirrorStore implements Store interface to apply every update to the primary store and then to secondary stores.
+// Metrics are read from the primary store only and failures of secondary stores don't fail updates,
+// they are logged and counted in the primary store instead.
+// In asynchronous mode updates are queued for every secondary store and dropped if the queue is full.
+type MirrorStore struct {
+	primary     Store
+	secondaries []*mirror
+	wg          sync.WaitGroup
+}
+
+// mirror keeps secondary store with the queue of its updates
+type mirror struct {
+	Secondary
+	queue chan mirrorUpdate
+}
+
+// mirrorUpdate applies update to secondary store
+type mirrorUpdate func(ctx context.Context, store Store) error
+
+// NewMirrorStore creates mirror store, in asynchronous mode workers of secondary stores are run until Close
+func NewMirrorStore(primary Store, async bool, secondaries ...Secondary) *MirrorStore {
+	ms := MirrorStore{
+		primary:     primary,
+		secondaries: make([]*mirror, 0, len(secondaries)),
+	}
+
+	for _, secondary := range secondaries {
+		m := mirror{Secondary: secondary}
+		if async {
+			m.queue = make(chan mirrorUpdate, mirrorQueueSize)
+
+			ms.wg.Add(1)
+			go ms.runMirror(&m)
+		}
+
+		ms.secondaries = append(ms.secondaries, &m)
+	}
+
+	return &ms
+}
+
+// Resync replaces metrics and metadata of secondary stores by the ones of the primary store.
+// Empty primary store may be lost one, so secondary stores are kept as is and ErrEmptyPrimary is returned
+// unless resync from empty primary store is allowed.
+func (ms *MirrorStore) Resync(ctx context.Context, allowEmpty bool) error {
+	metricsData, err := ms.primary.GetMetrics(ctx)
+	if err != nil {
+		return err
+	}
+	if len(metricsData) == 0 && !allowEmpty {
+		return ErrEmptyPrimary
+	}
+
+	metadata, err := ms.primary.GetMetadata(ctx)
+	if err != nil {
+		return err
+	}
+
+	metricsBatch := make([]*metrics.Metric, 0, len(metricsData))
+	for _, metric := range metricsData {
+		metricsBatch = append(metricsBatch, metric)
+	}
+
+	ms.mirror(ctx, func(ctx context.Context, store Store) error {
+		if _, err := store.DeleteMetrics(ctx); err != nil {
+			return err
+		}
+		if err := store.UpdateMetrics(ctx, metricsBatch); err != nil {
+			return err
+		}
+
+		return resyncMetadata(ctx, store, metadata)
+	})
+
+	return nil
+}
+
+// resyncMetadata replaces metadata of the store by the metadata of metrics by their names
+func resyncMetadata(ctx context.Context, store Store, metadata map[string]*metrics.Metadata) error {
+	storedMetadata, err := store.GetMetadata(ctx)
+	if err != nil {
+		return err
+	}
+
+	for name := range storedMetadata {
+		if _, ok := metadata[name]; ok {
+			continue
+		}
+		if err := store.SetMetadata(ctx, name, nil); err != nil {
+			return err
+		}
+	}
+	for name, md := range metadata {
+		if err := store.SetMetadata(ctx, name, md); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UpdateCounterMetric updates counter metric type
+func (ms *MirrorStore) UpdateCounterMetric(ctx context.Context, metricName string, metricData metrics.Counter) error {
+	if err := ms.primary.UpdateCounterMetric(ctx, metricName, metricData); err != nil {
+		return err
+	}
+
+	ms.mirror(ctx, func(ctx context.Context, store Store) error {
+		return store.UpdateCounterMetric(ctx, metricName, metricData)
+	})
+
+	return nil
+}
+
+// ResetCounterMetric resets counter to default zero value
+func (ms *MirrorStore) ResetCounterMetric(ctx context.Context, metricName string) error {
+	if err := ms.primary.ResetCounterMetric(ctx, metricName); err != nil {
+		return err
+	}
+
+	ms.mirror(ctx, func(ctx context.Context, store Store) error {
+		return store.ResetCounterMetric(ctx, metricName)
+	})
+
+	return nil
+}
+
+// UpdateGaugeMetric updates gauge type metric
+func (ms *MirrorStore) UpdateGaugeMetric(ctx context.Context, metricName string, metricData metrics.Gauge) error {
+	if err := ms.primary.UpdateGaugeMetric(ctx, metricName, metricData); err != nil {
+		return err
+	}
+
+	ms.mirror(ctx, func(ctx context.Context, store Store) error {
+		return store.UpdateGaugeMetric(ctx, metricName, metricData)
+	})
+
+	return nil
+}
+
+// AddGauge adds delta to gauge type metric in the primary store and sets its new value in secondary stores
+func (ms *MirrorStore) AddGauge(ctx context.Context, metricName string, delta metrics.Gauge) (metrics.Gauge, error) {
+	value, err := ms.primary.AddGauge(ctx, metricName, delta)
+	if err != nil {
+		return 0, err
+	}
+
+	ms.mirror(ctx, func(ctx context.Context, store Store) error {
+		return store.UpdateGaugeMetric(ctx, metricName, value)
+	})
+
+	return value, nil
+}
+
+// CompareAndSetGauge sets value of gauge type metric if its current value in the primary store equals to expected
+// one, secondary stores get the value without comparison
+func (ms *MirrorStore) CompareAndSetGauge(ctx context.Context, metricName string, expected *metrics.Gauge,
+	value metrics.Gauge) error {
+	if err := ms.primary.CompareAndSetGauge(ctx, metricName, expected, value); err != nil {
+		return err
+	}
+
+	ms.mirror(ctx, func(ctx context.Context, store Store) error {
+		return store.UpdateGaugeMetric(ctx, metricName, value)
+	})
+
+	return nil
+}
+
+// UpdateHistogramMetric merges observations to histogram type metric
+func (ms *MirrorStore) UpdateHistogramMetric(ctx context.Context, metricName string, metricData *metrics.Histogram) error {
+	if err := ms.primary.UpdateHistogramMetric(ctx, metricName, metricData); err != nil {
+		return err
+	}
+
+	histogram := metricData.Copy()
+	ms.mirror(ctx, func(ctx context.Context, store Store) error {
+		return store.UpdateHistogramMetric(ctx, metricName, histogram)
+	})
+
+	return nil
+}
+
+// UpdateMetrics update number of metrics, observations of histograms are merged
+func (ms *MirrorStore) UpdateMetrics(ctx context.Context, metricsBatch []*metrics.Metric) error {
+	if err := ms.primary.UpdateMetrics(ctx, metricsBatch); err != nil {
+		return err
+	}
+
+	batch := make([]*metrics.Metric, 0, len(metricsBatch))
+	for _, metric := range metricsBatch {
+		batch = append(batch, metric.Copy())
+	}
+	ms.mirror(ctx, func(ctx context.Context, store Store) error {
+		return store.UpdateMetrics(ctx, batch)
+	})
+
+	return nil
+}
+
+// GetMetric return copy of metric by name from the primary store
+func (ms *MirrorStore) GetMetric(ctx context.Context, metricName string, metricType string) (*metrics.Metric, error) {
+	return ms.primary.GetMetric(ctx, metricName, metricType)
+}
+
+// GetMetrics returns copies of all metrics of the primary store which satisfy label matchers
+func (ms *MirrorStore) GetMetrics(ctx context.Context, matchers ...*metrics.Matcher) (map[string]*metrics.Metric, error) {
+	return ms.primary.GetMetrics(ctx, matchers...)
+}
+
+// ListMetrics returns the page of metrics of the primary store which satisfy the filter
+func (ms *MirrorStore) ListMetrics(ctx context.Context, filter *ListFilter) (*MetricsPage, error) {
+	return ms.primary.ListMetrics(ctx, filter)
+}
+
+// Watch returns change feed of metrics of the primary store which satisfy the filter
+func (ms *MirrorStore) Watch(ctx context.Context, filter *WatchFilter) (<-chan *Event, error) {
+	return ms.primary.Watch(ctx, filter)
+}
+
+// DeleteMetric removes metric and its history
+func (ms *MirrorStore) DeleteMetric(ctx context.Context, metricName string, metricType string) error {
+	if err := ms.primary.DeleteMetric(ctx, metricName, metricType); err != nil {
+		return err
+	}
+
+	ms.mirror(ctx, func(ctx context.Context, store Store) error {
+		return store.DeleteMetric(ctx, metricName, metricType)
+	})
+
+	return nil
+}
+
+// DeleteMetrics removes all of metrics which satisfy label matchers and returns their number in the primary store
+func (ms *MirrorStore) DeleteMetrics(ctx context.Context, matchers ...*metrics.Matcher) (int, error) {
+	deleted, err := ms.primary.DeleteMetrics(ctx, matchers...)
+	if err != nil {
+		return deleted, err
+	}
+
+	ms.mirror(ctx, func(ctx context.Context, store Store) error {
+		_, err := store.DeleteMetrics(ctx, matchers...)
+
+		return err
+	})
+
+	return deleted, nil
+}
+
+// DeleteStaleMetrics removes metrics which aren't updated for their TTL and returns their number in the primary store
+func (ms *MirrorStore) DeleteStaleMetrics(ctx context.Context, ttl *TTL, now time.Time) (int, error) {
+	deleted, err := ms.primary.DeleteStaleMetrics(ctx, ttl, now)
+	if err != nil {
+		return deleted, err
+	}
+
+	ms.mirror(ctx, func(ctx context.Context, store Store) error {
+		_, err := store.DeleteStaleMetrics(ctx, ttl, now)
+
+		return err
+	})
+
+	return deleted, nil
+}
+
+// SetMetadata sets metadata of metric by its name
+func (ms *MirrorStore) SetMetadata(ctx context.Context, metricName string, metadata *metrics.Metadata) error {
+	if err := ms.primary.SetMetadata(ctx, metricName, metadata); err != nil {
+		return err
+	}
+
+	var metadataCopy *metrics.Metadata
+	if metadata != nil {
+		md := *metadata
+		metadataCopy = &md
+	}
+	ms.mirror(ctx, func(ctx context.Context, store Store) error {
+		return store.SetMetadata(ctx, metricName, metadataCopy)
+	})
+
+	return nil
+}
+
+// GetMetadata returns copies of metadata of all metrics of the primary store by their names
+func (ms *MirrorStore) GetMetadata(ctx context.Context) (map[string]*metrics.Metadata, error) {
+	return ms.primary.GetMetadata(ctx)
+}
+
+// Ping checks that the primary store is alive
+func (ms *MirrorStore) Ping(ctx context.Context) error {
+	return ms.primary.Ping(ctx)
+}
+
+// AppendSamples adds timestamped samples to the history of metric,
+// the samples are mirrored to secondary stores which keep history
+func (ms *MirrorStore) AppendSamples(ctx context.Context, metricName string, metricType string,
+	samples ...metrics.Sample) error {
+	historyStore, ok := ms.primary.(HistoryStore)
+	if !ok {
+		return ErrHistoryDisabled
+	}
+
+	if err := historyStore.AppendSamples(ctx, metricName, metricType, samples...); err != nil {
+		return err
+	}
+
+	ms.mirror(ctx, func(ctx context.Context, store Store) error {
+		historyStore, ok := store.(HistoryStore)
+		if !ok {
+			return nil
+		}
+
+		return historyStore.AppendSamples(ctx, metricName, metricType, samples...)
+	})
+
+	return nil
+}
+
+// GetHistory returns samples of metric from the primary store in the [from, to] range downsampled by step
+func (ms *MirrorStore) GetHistory(ctx context.Context, metricName string, metricType string,
+	from time.Time, to time.Time, step time.Duration) ([]metrics.Sample, error) {
+	historyStore, ok := ms.primary.(HistoryStore)
+	if !ok {
+		return nil, ErrHistoryDisabled
+	}
+
+	return historyStore.GetHistory(ctx, metricName, metricType, from, to, step)
+}
+
+// Close waits for queued updates to be applied to secondary stores in asynchronous mode,
+// the stores themselves aren't closed
+func (ms *MirrorStore) Close() error {
+	for _, m := range ms.secondaries {
+		if m.queue != nil {
+			close(m.queue)
+		}
+	}
+	ms.wg.Wait()
+
+	return nil
+}
+
+// mirror applies update to secondary stores at once or queues it in asynchronous mode
+func (ms *MirrorStore) mirror(ctx context.Context, update mirrorUpdate) {
+	for _, m := range ms.secondaries {
+		if m.queue == nil {
+			ms.apply(ctx, m, update)
+
+			continue
+		}
+
+		select {
+		case m.queue <- update:
+		default:
+			log.Error().Msgf("Queue of secondary store %q is full, update is dropped", m.Name)
+			ms.count(MirrorDroppedMetric, m.Name)
+		}
+	}
+}
+
+// runMirror applies queued updates to secondary store until the queue is closed
+func (ms *MirrorStore) runMirror(m *mirror) {
+	defer ms.wg.Done()
+
+	for update := range m.queue {
+		ms.apply(context.Background(), m, update)
+	}
+}
+
+// apply applies update to secondary store and tracks its failure
+func (ms *MirrorStore) apply(ctx context.Context, m *mirror, update mirrorUpdate) {
+	if err := update(ctx, m.Store); err != nil {
+		log.Error().Err(err).Msgf("Failed to mirror update to secondary store %q", m.Name)
+		ms.count(MirrorFailuresMetric, m.Name)
+	}
+}
+
+// count increments counter of failures of secondary store in the primary store
+func (ms *MirrorStore) count(metricName string, secondaryName string) {
+	metricKey := metrics.Key(metricName, metrics.Labels{"store": secondaryName})
+	if err := ms.primary.UpdateCounterMetric(context.Background(), metricKey, 1); err != nil {
+		log.Error().Err(err).Msgf("Failed to count %s of secondary store %q", metricName, secondaryName)
+	}
+}
@@ -0,0 +1,122 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/itd27m01/go-metrics-service/internal/models/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// updateTestMirrorStore applies every kind of update to the store
+func updateTestMirrorStore(t *testing.T, store Store) {
+	t.Helper()
+	ctx := context.Background()
+
+	histogram := metrics.NewHistogram(1, 2)
+	histogram.Observe(1)
+	require.NoError(t, store.UpdateCounterMetric(ctx, "PollCount", 1))
+	require.NoError(t, store.UpdateGaugeMetric(ctx, "Alloc", 1))
+	require.NoError(t, store.UpdateHistogramMetric(ctx, "Latency", histogram))
+	require.NoError(t, store.UpdateMetrics(ctx, []*metrics.Metric{
+		{ID: "Latency", MType: metrics.MetricTypeHistogram, Histogram: histogram},
+		{ID: "Deleted", MType: metrics.MetricTypeGauge, Value: new(metrics.Gauge)},
+		{ID: "Reset", MType: metrics.MetricTypeCounter, Labels: metrics.Labels{"agent": "1"}, Delta: new(metrics.Counter)},
+	}))
+	require.NoError(t, store.UpdateCounterMetric(ctx, `Reset{agent="1"}`, 5))
+	require.NoError(t, store.ResetCounterMetric(ctx, `Reset{agent="1"}`))
+	require.NoError(t, store.DeleteMetric(ctx, "Deleted", metrics.MetricTypeGauge))
+	histogram.Observe(2)
+}
+
+func TestMirrorStore(t *testing.T) {
+	tests := []struct {
+		name  string
+		async bool
+	}{
+		{name: "Synchronous mirroring"},
+		{name: "Asynchronous mirroring", async: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			primary := NewInMemoryStoreWithHistory(time.Hour)
+			secondary := NewInMemoryStore()
+			require.NoError(t, secondary.UpdateGaugeMetric(ctx, "Outdated", 1))
+			require.NoError(t, primary.UpdateCounterMetric(ctx, "PollCount", 10))
+
+			ms := NewMirrorStore(primary, tt.async, Secondary{Name: "memory", Store: secondary})
+			require.NoError(t, ms.Resync(ctx, false))
+			updateTestMirrorStore(t, ms)
+			assert.ErrorIs(t, ms.UpdateGaugeMetric(ctx, "PollCount", 1), ErrMetricTypeMismatch)
+			require.NoError(t, ms.Close())
+
+			want, err := primary.GetMetrics(ctx)
+			require.NoError(t, err)
+			got, err := secondary.GetMetrics(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, want, got)
+			assert.Equal(t, metrics.Counter(11), *got["PollCount"].Delta)
+			assert.Equal(t, metrics.Counter(2), got["Latency"].Histogram.Count)
+			assert.NotContains(t, got, "Outdated", "secondary store must be resynced with the primary one")
+		})
+	}
+}
+
+func TestMirrorStore_SecondaryFailures(t *testing.T) {
+	ctx := context.Background()
+	primary := NewInMemoryStore()
+	failing := &unavailableStore{InMemoryStore: NewInMemoryStore(), unavailable: true}
+	secondary := NewInMemoryStore()
+
+	ms := NewMirrorStore(primary, false,
+		Secondary{Name: "failing", Store: failing}, Secondary{Name: "memory", Store: secondary})
+	updateTestMirrorStore(t, ms)
+
+	failures, err := ms.GetMetric(ctx, `MirrorFailures{store="failing"}`, metrics.MetricTypeCounter)
+	require.NoError(t, err)
+	assert.Equal(t, metrics.Counter(3), *failures.Delta, "update, reset and delete must fail")
+	_, err = ms.GetMetric(ctx, `MirrorFailures{store="memory"}`, metrics.MetricTypeCounter)
+	assert.ErrorIs(t, err, ErrMetricNotFound)
+
+	got, err := secondary.GetMetrics(ctx)
+	require.NoError(t, err)
+	assert.Len(t, got, 4, "failures of one secondary store must not affect others")
+}
+
+func TestMirrorStore_ResyncEmptyPrimary(t *testing.T) {
+	tests := []struct {
+		name       string
+		allowEmpty bool
+		wantErr    error
+		wantLen    int
+	}{
+		{
+			name:    "Secondary store is kept",
+			wantErr: ErrEmptyPrimary,
+			wantLen: 1,
+		},
+		{
+			name:       "Secondary store is resynced if it's allowed",
+			allowEmpty: true,
+			wantLen:    0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			secondary := NewInMemoryStore()
+			require.NoError(t, secondary.UpdateGaugeMetric(ctx, "Alloc", 1))
+
+			ms := NewMirrorStore(NewInMemoryStore(), false, Secondary{Name: "memory", Store: secondary})
+			assert.ErrorIs(t, ms.Resync(ctx, tt.allowEmpty), tt.wantErr)
+			require.NoError(t, ms.Close())
+
+			got, err := secondary.GetMetrics(ctx)
+			require.NoError(t, err)
+			assert.Len(t, got, tt.wantLen)
+		})
+	}
+}
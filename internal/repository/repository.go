@@ -3,6 +3,8 @@ package repository
 import (
 	"context"
 	"errors"
+	"fmt"
+	"time"
 
 	"github.com/itd27m01/go-metrics-service/internal/models/metrics"
 )
@@ -11,18 +13,92 @@ import (
 var (
 	ErrMetricTypeMismatch = errors.New("possible metric type mismatch")
 	ErrMetricNotFound     = errors.New("metric not found in repository")
+	ErrInvalidMetric      = errors.New("metric has no value of its type")
+	ErrHistoryDisabled    = errors.New("history of metrics is disabled for repository")
+	ErrInvalidTimeRange   = errors.New("invalid time range")
+	ErrGaugeConflict      = errors.New("gauge value doesn't match expected one")
 )
 
-// Store defines interface type for metrics store
+// Store defines interface type for metrics store.
+// Metrics returned by GetMetric, GetMetrics and ListMetrics are point-in-time copies owned by caller,
+// they aren't changed by the following updates of store and can be modified.
+// ListMetrics orders metrics by name, then by labels and type, pages of metrics are chained by cursors.
+// Metadata is kept by the name of metric without labels, it isn't removed with metrics and setting of empty metadata
+// removes it. GetMetadata returns copies of metadata by names of metrics.
+// AddGauge adds delta to the gauge atomically and returns its new value, absent gauge is created with delta value.
+// CompareAndSetGauge sets value of the gauge atomically if its current value equals to expected one,
+// nil expected value means that the gauge must not exist, otherwise ErrGaugeConflict is returned.
+// Watch returns change feed of metrics which satisfy the filter, the channel of events is closed when the context
+// is done or the consumer falls behind, so the consumer must read metrics again to catch up with store.
 type Store interface {
 	UpdateCounterMetric(ctx context.Context, name string, value metrics.Counter) error
 	ResetCounterMetric(ctx context.Context, name string) error
 	UpdateGaugeMetric(ctx context.Context, name string, value metrics.Gauge) error
+	AddGauge(ctx context.Context, name string, delta metrics.Gauge) (metrics.Gauge, error)
+	CompareAndSetGauge(ctx context.Context, name string, expected *metrics.Gauge, value metrics.Gauge) error
+	UpdateHistogramMetric(ctx context.Context, name string, value *metrics.Histogram) error
 
 	UpdateMetrics(ctx context.Context, metricsBatch []*metrics.Metric) error
 
 	GetMetric(ctx context.Context, name string, metricType string) (*metrics.Metric, error)
-	GetMetrics(ctx context.Context) (map[string]*metrics.Metric, error)
+	GetMetrics(ctx context.Context, matchers ...*metrics.Matcher) (map[string]*metrics.Metric, error)
+	ListMetrics(ctx context.Context, filter *ListFilter) (*MetricsPage, error)
+
+	DeleteMetric(ctx context.Context, name string, metricType string) error
+	DeleteMetrics(ctx context.Context, matchers ...*metrics.Matcher) (int, error)
+	DeleteStaleMetrics(ctx context.Context, ttl *TTL, now time.Time) (int, error)
+
+	Watch(ctx context.Context, filter *WatchFilter) (<-chan *Event, error)
+
+	SetMetadata(ctx context.Context, name string, metadata *metrics.Metadata) error
+	GetMetadata(ctx context.Context) (map[string]*metrics.Metadata, error)
 
 	Ping(ctx context.Context) error
 }
+
+// HistoryStore defines interface type for metrics store which keeps timestamped values of metrics.
+// Every update of a metric in such store appends a sample to the history of the metric.
+type HistoryStore interface {
+	AppendSamples(ctx context.Context, name string, metricType string, samples ...metrics.Sample) error
+	GetHistory(ctx context.Context, name string, metricType string,
+		from time.Time, to time.Time, step time.Duration) ([]metrics.Sample, error)
+}
+
+// HistoryPruner defines interface type for history store which drops expired samples periodically
+// instead of on every update
+type HistoryPruner interface {
+	PruneHistory(ctx context.Context, now time.Time) (int, error)
+}
+
+// parseMetricKey splits metric key to the name and labels and returns canonical key of metric
+func parseMetricKey(key string) (string, string, metrics.Labels, error) {
+	name, labels, err := metrics.ParseKey(key)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	return metrics.Key(name, labels), name, labels, nil
+}
+
+// validateMetric checks that metric has value of its type
+func validateMetric(metric *metrics.Metric) error {
+	if (metric.MType == metrics.MetricTypeGauge && metric.Value == nil) ||
+		(metric.MType == metrics.MetricTypeCounter && metric.Delta == nil) ||
+		(metric.MType == metrics.MetricTypeHistogram && metric.Histogram == nil) {
+		return fmt.Errorf("%w %s:%s", ErrInvalidMetric, metric.Key(), metric.MType)
+	}
+
+	return nil
+}
+
+// validateMetrics checks every metric of batch before any update, so invalid metric doesn't leave batch applied
+// partially
+func validateMetrics(metricsBatch []*metrics.Metric) error {
+	for _, metric := range metricsBatch {
+		if err := validateMetric(metric); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,280 @@
+package repository
+
+import (
+	"context"
+	"hash/fnv"
+	"time"
+
+	"github.com/itd27m01/go-metrics-service/internal/models/metrics"
+)
+
+const defaultShardsCount = 32
+
+var (
+	_ Store        = (*ShardedStore)(nil)
+	_ HistoryStore = (*ShardedStore)(nil)
+)
+
+// ShardedStore implements Store interface to store metrics in memory shards,
+// metrics are spread over shards by hash of their key and every shard is guarded by its own lock
+type ShardedStore struct {
+	shards   []*InMemoryStore
+	watchers *watchHub
+}
+
+// NewShardedStore creates sharded in memory store, zero historyRetention disables history of metrics.
+// Default number of shards is used if shardsCount isn't positive.
+func NewShardedStore(shardsCount int, historyRetention time.Duration) *ShardedStore {
+	if shardsCount <= 0 {
+		shardsCount = defaultShardsCount
+	}
+
+	s := ShardedStore{
+		shards:   make([]*InMemoryStore, shardsCount),
+		watchers: newWatchHub(),
+	}
+	for i := range s.shards {
+		s.shards[i] = NewInMemoryStoreWithHistory(historyRetention)
+		s.shards[i].watchers = s.watchers
+	}
+
+	return &s
+}
+
+// UpdateCounterMetric updates counter metric type
+func (s *ShardedStore) UpdateCounterMetric(ctx context.Context, metricName string, metricData metrics.Counter) error {
+	shard, err := s.shardOf(metricName)
+	if err != nil {
+		return err
+	}
+
+	return shard.UpdateCounterMetric(ctx, metricName, metricData)
+}
+
+// ResetCounterMetric resets counter metric type to default value
+func (s *ShardedStore) ResetCounterMetric(ctx context.Context, metricName string) error {
+	shard, err := s.shardOf(metricName)
+	if err != nil {
+		return err
+	}
+
+	return shard.ResetCounterMetric(ctx, metricName)
+}
+
+// UpdateGaugeMetric updates gauge type metric
+func (s *ShardedStore) UpdateGaugeMetric(ctx context.Context, metricName string, metricData metrics.Gauge) error {
+	shard, err := s.shardOf(metricName)
+	if err != nil {
+		return err
+	}
+
+	return shard.UpdateGaugeMetric(ctx, metricName, metricData)
+}
+
+// AddGauge adds delta to gauge type metric and returns its new value
+func (s *ShardedStore) AddGauge(ctx context.Context, metricName string, delta metrics.Gauge) (metrics.Gauge, error) {
+	shard, err := s.shardOf(metricName)
+	if err != nil {
+		return 0, err
+	}
+
+	return shard.AddGauge(ctx, metricName, delta)
+}
+
+// CompareAndSetGauge sets value of gauge type metric if its current value equals to expected one
+func (s *ShardedStore) CompareAndSetGauge(ctx context.Context, metricName string, expected *metrics.Gauge,
+	value metrics.Gauge) error {
+	shard, err := s.shardOf(metricName)
+	if err != nil {
+		return err
+	}
+
+	return shard.CompareAndSetGauge(ctx, metricName, expected, value)
+}
+
+// UpdateHistogramMetric merges observations to histogram type metric
+func (s *ShardedStore) UpdateHistogramMetric(ctx context.Context, metricName string, metricData *metrics.Histogram) error {
+	shard, err := s.shardOf(metricName)
+	if err != nil {
+		return err
+	}
+
+	return shard.UpdateHistogramMetric(ctx, metricName, metricData)
+}
+
+// UpdateMetrics update number of metrics, the batch is split by shards and every shard is locked once
+func (s *ShardedStore) UpdateMetrics(_ context.Context, metricsBatch []*metrics.Metric) error {
+	if err := validateMetrics(metricsBatch); err != nil {
+		return err
+	}
+
+	shardsKeys := make([][]string, len(s.shards))
+	shardsBatches := make([][]*metrics.Metric, len(s.shards))
+	for _, metric := range metricsBatch {
+		metricKey := metric.Key()
+		i := s.shardIndex(metricKey)
+		shardsKeys[i] = append(shardsKeys[i], metricKey)
+		shardsBatches[i] = append(shardsBatches[i], metric)
+	}
+
+	for i, shardBatch := range shardsBatches {
+		if len(shardBatch) == 0 {
+			continue
+		}
+
+		if err := s.shards[i].updateKeyedMetrics(shardsKeys[i], shardBatch); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetMetric returns metric by name
+func (s *ShardedStore) GetMetric(ctx context.Context, metricName string, metricType string) (*metrics.Metric, error) {
+	shard, err := s.shardOf(metricName)
+	if err != nil {
+		return nil, err
+	}
+
+	return shard.GetMetric(ctx, metricName, metricType)
+}
+
+// GetMetrics returns all of stored metrics which satisfy label matchers
+func (s *ShardedStore) GetMetrics(_ context.Context, matchers ...*metrics.Matcher) (map[string]*metrics.Metric, error) {
+	metricsData := make(map[string]*metrics.Metric)
+
+	for _, shard := range s.shards {
+		shard.collectMetrics(metricsData, matchers)
+	}
+
+	return metricsData, nil
+}
+
+// ListMetrics returns the page of copies of stored metrics of all shards which satisfy the filter
+func (s *ShardedStore) ListMetrics(_ context.Context, filter *ListFilter) (*MetricsPage, error) {
+	cursor, err := filter.cursor()
+	if err != nil {
+		return nil, err
+	}
+
+	var listed []*listedMetric
+	for _, shard := range s.shards {
+		listed = shard.listMetrics(listed, filter, cursor)
+	}
+
+	return newMetricsPage(listed, filter), nil
+}
+
+// DeleteMetric removes metric and its history
+func (s *ShardedStore) DeleteMetric(ctx context.Context, metricName string, metricType string) error {
+	shard, err := s.shardOf(metricName)
+	if err != nil {
+		return err
+	}
+
+	return shard.DeleteMetric(ctx, metricName, metricType)
+}
+
+// DeleteMetrics removes all of metrics which satisfy label matchers and returns their number
+func (s *ShardedStore) DeleteMetrics(ctx context.Context, matchers ...*metrics.Matcher) (int, error) {
+	deleted := 0
+	for _, shard := range s.shards {
+		shardDeleted, err := shard.DeleteMetrics(ctx, matchers...)
+		deleted += shardDeleted
+		if err != nil {
+			return deleted, err
+		}
+	}
+
+	return deleted, nil
+}
+
+// DeleteStaleMetrics removes metrics which aren't updated for their TTL and returns their number
+func (s *ShardedStore) DeleteStaleMetrics(ctx context.Context, ttl *TTL, now time.Time) (int, error) {
+	deleted := 0
+	for _, shard := range s.shards {
+		shardDeleted, err := shard.DeleteStaleMetrics(ctx, ttl, now)
+		deleted += shardDeleted
+		if err != nil {
+			return deleted, err
+		}
+	}
+
+	return deleted, nil
+}
+
+// Watch returns change feed of metrics of all shards which satisfy the filter
+func (s *ShardedStore) Watch(ctx context.Context, filter *WatchFilter) (<-chan *Event, error) {
+	return s.watchers.watch(ctx, filter), nil
+}
+
+// SetMetadata sets metadata of metric by its name in the shard of metric without labels
+func (s *ShardedStore) SetMetadata(ctx context.Context, metricName string, metadata *metrics.Metadata) error {
+	shard, err := s.shardOf(metricName)
+	if err != nil {
+		return err
+	}
+
+	return shard.SetMetadata(ctx, metricName, metadata)
+}
+
+// GetMetadata returns copies of metadata of all metrics by their names
+func (s *ShardedStore) GetMetadata(ctx context.Context) (map[string]*metrics.Metadata, error) {
+	metadata := make(map[string]*metrics.Metadata)
+	for _, shard := range s.shards {
+		shardMetadata, err := shard.GetMetadata(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for name, md := range shardMetadata {
+			metadata[name] = md
+		}
+	}
+
+	return metadata, nil
+}
+
+// Ping checks that underlying store is alive
+func (s *ShardedStore) Ping(_ context.Context) error { return nil }
+
+// AppendSamples adds timestamped samples to the history of metric
+func (s *ShardedStore) AppendSamples(ctx context.Context, metricName string, metricType string,
+	samples ...metrics.Sample) error {
+	shard, err := s.shardOf(metricName)
+	if err != nil {
+		return err
+	}
+
+	return shard.AppendSamples(ctx, metricName, metricType, samples...)
+}
+
+// GetHistory returns samples of metric in the [from, to] range downsampled by step
+func (s *ShardedStore) GetHistory(ctx context.Context, metricName string, metricType string,
+	from time.Time, to time.Time, step time.Duration) ([]metrics.Sample, error) {
+	shard, err := s.shardOf(metricName)
+	if err != nil {
+		return nil, err
+	}
+
+	return shard.GetHistory(ctx, metricName, metricType, from, to, step)
+}
+
+// shardOf returns shard of metric by its name with labels
+func (s *ShardedStore) shardOf(metricName string) (*InMemoryStore, error) {
+	metricKey, _, _, err := parseMetricKey(metricName)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.shards[s.shardIndex(metricKey)], nil
+}
+
+// shardIndex returns index of shard by canonical key of metric
+func (s *ShardedStore) shardIndex(metricKey string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(metricKey))
+
+	return int(h.Sum32() % uint32(len(s.shards)))
+}
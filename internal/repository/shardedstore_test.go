@@ -0,0 +1,144 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/itd27m01/go-metrics-service/internal/models/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShardedStore(t *testing.T) {
+	s := NewShardedStore(4, time.Hour)
+	ctx := context.Background()
+
+	metricsBatch := make([]*metrics.Metric, 0, 100)
+	for i := 0; i < 100; i++ {
+		delta := metrics.Counter(i)
+		metricsBatch = append(metricsBatch, &metrics.Metric{
+			ID:     "PollCount",
+			MType:  metrics.MetricTypeCounter,
+			Labels: metrics.Labels{"agent": fmt.Sprint(i)},
+			Delta:  &delta,
+		})
+	}
+	require.NoError(t, s.UpdateMetrics(ctx, metricsBatch))
+	require.NoError(t, s.UpdateMetrics(ctx, metricsBatch))
+	require.NoError(t, s.UpdateGaugeMetric(ctx, "Alloc", 1))
+
+	for _, shard := range s.shards {
+		assert.NotEmpty(t, shard.metricsCache, "metrics must be spread over all of shards")
+	}
+
+	got, err := s.GetMetric(ctx, `PollCount{agent="42"}`, metrics.MetricTypeCounter)
+	require.NoError(t, err)
+	assert.Equal(t, metrics.Counter(84), *got.Delta)
+	assert.ErrorIs(t, s.UpdateGaugeMetric(ctx, `PollCount{agent="42"}`, 1), ErrMetricTypeMismatch)
+
+	matchers, err := metrics.ParseSelector(`PollCount{agent=~"1."}`)
+	require.NoError(t, err)
+	metricsData, err := s.GetMetrics(ctx, matchers...)
+	require.NoError(t, err)
+	assert.Len(t, metricsData, 10)
+
+	samples, err := s.GetHistory(ctx, `PollCount{agent="42"}`, metrics.MetricTypeCounter, time.Time{}, time.Now(), 0)
+	require.NoError(t, err)
+	assert.Len(t, samples, 2)
+
+	require.NoError(t, s.DeleteMetric(ctx, "Alloc", metrics.MetricTypeGauge))
+	deleted, err := s.DeleteMetrics(ctx, matchers...)
+	require.NoError(t, err)
+	assert.Equal(t, 10, deleted)
+	deleted, err = s.DeleteStaleMetrics(ctx, &TTL{Default: time.Minute}, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 90, deleted)
+
+	metricsData, err = s.GetMetrics(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, metricsData)
+}
+
+// benchmarkStores runs benchmark against the single lock and the sharded memory stores
+func benchmarkStores(b *testing.B, bench func(b *testing.B, store Store)) {
+	stores := []struct {
+		name  string
+		store func() Store
+	}{
+		{name: "InMemoryStore", store: func() Store { return NewInMemoryStore() }},
+		{name: "ShardedStore", store: func() Store { return NewShardedStore(0, 0) }},
+	}
+	for _, s := range stores {
+		b.Run(s.name, func(b *testing.B) {
+			bench(b, s.store())
+		})
+	}
+}
+
+// newBenchMetricsBatch makes batch of gauges and counters reported by agent
+func newBenchMetricsBatch(agent int, size int) []*metrics.Metric {
+	metricsBatch := make([]*metrics.Metric, 0, size)
+	for i := 0; i < size; i++ {
+		labels := metrics.Labels{"agent": fmt.Sprint(agent)}
+		if i%2 == 0 {
+			value := metrics.Gauge(i)
+			metricsBatch = append(metricsBatch, &metrics.Metric{
+				ID: fmt.Sprintf("Gauge%d", i), MType: metrics.MetricTypeGauge, Labels: labels, Value: &value,
+			})
+		} else {
+			delta := metrics.Counter(i)
+			metricsBatch = append(metricsBatch, &metrics.Metric{
+				ID: fmt.Sprintf("Counter%d", i), MType: metrics.MetricTypeCounter, Labels: labels, Delta: &delta,
+			})
+		}
+	}
+
+	return metricsBatch
+}
+
+func BenchmarkStores_UpdateMetrics(b *testing.B) {
+	benchmarkStores(b, func(b *testing.B, store Store) {
+		ctx := context.Background()
+		var agents int64
+
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			metricsBatch := newBenchMetricsBatch(int(atomic.AddInt64(&agents, 1)), 30)
+			for pb.Next() {
+				if err := store.UpdateMetrics(ctx, metricsBatch); err != nil {
+					b.Error(err)
+				}
+			}
+		})
+	})
+}
+
+func BenchmarkStores_UpdateAndGetMetrics(b *testing.B) {
+	benchmarkStores(b, func(b *testing.B, store Store) {
+		ctx := context.Background()
+		for agent := 0; agent < 100; agent++ {
+			require.NoError(b, store.UpdateMetrics(ctx, newBenchMetricsBatch(agent, 30)))
+		}
+		var agents int64
+
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			agent := int(atomic.AddInt64(&agents, 1))
+			metricsBatch := newBenchMetricsBatch(agent, 30)
+			for i := 0; pb.Next(); i++ {
+				var err error
+				if i%10 == 0 {
+					_, err = store.GetMetrics(ctx)
+				} else {
+					err = store.UpdateMetrics(ctx, metricsBatch)
+				}
+				if err != nil {
+					b.Error(err)
+				}
+			}
+		})
+	})
+}
@@ -0,0 +1,302 @@
+package repository
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/itd27m01/go-metrics-service/internal/models/metrics"
+	"github.com/itd27m01/go-metrics-service/pkg/encryption"
+	"github.com/itd27m01/go-metrics-service/pkg/logging/log"
+)
+
+const (
+	snapshotMagic          = "metrics-snapshot"
+	snapshotVersion        = 2
+	snapshotHeaderFormat   = snapshotMagic + " v%d crc32c=%08x length=%d\n"
+	previousSnapshotSuffix = ".prev"
+	// encrypted snapshot is the header with ID of key followed by the sealed snapshot, the header is authenticated
+	encryptedSnapshotMagic        = "metrics-encrypted"
+	encryptedSnapshotHeaderFormat = encryptedSnapshotMagic + " v1 aes-gcm key=%s\n"
+)
+
+// gzipMagic starts compressed snapshot
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// Errors of snapshots
+var (
+	ErrCorruptedSnapshot = errors.New("corrupted snapshot of metrics")
+	ErrSnapshotKey       = errors.New("snapshot of metrics is encrypted with unknown key")
+)
+
+// snapshotData defines content of snapshot, payload of the first version of snapshot is a plain JSON of metrics
+type snapshotData struct {
+	Metrics  map[string]*metrics.Metric   `json:"metrics"`
+	Metadata map[string]*metrics.Metadata `json:"metadata,omitempty"`
+}
+
+// encodeSnapshot encodes metrics to the snapshot: the header with version, checksum and length of JSON payload.
+// Snapshot without metadata is encoded in the first version to keep it readable by the previous releases.
+func encodeSnapshot(data *snapshotData) ([]byte, error) {
+	version := snapshotVersion
+	var payload []byte
+	var err error
+	if len(data.Metadata) == 0 {
+		version = 1
+		payload, err = json.Marshal(data.Metrics)
+	} else {
+		payload, err = json.Marshal(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, snapshotHeaderFormat, version, crc32.Checksum(payload, walTable), len(payload))
+	buf.Write(payload)
+	buf.WriteByte('\n')
+
+	return buf.Bytes(), nil
+}
+
+// sealSnapshot encrypts encoded snapshot with the current key
+func sealSnapshot(data []byte, keys *encryption.AESKeyRing) ([]byte, error) {
+	header := fmt.Sprintf(encryptedSnapshotHeaderFormat, keys.KeyID())
+
+	sealed, err := keys.Seal(data, []byte(header))
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(header), sealed...), nil
+}
+
+// openSnapshot decrypts encrypted snapshot with the key of its header, ErrSnapshotKey is returned
+// if the key isn't one of keys
+func openSnapshot(bufReader *bufio.Reader, keys *encryption.AESKeyRing) ([]byte, error) {
+	header, err := bufReader.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("%w: partial header", ErrCorruptedSnapshot)
+	}
+
+	var keyID string
+	if _, err := fmt.Sscanf(header, encryptedSnapshotHeaderFormat, &keyID); err != nil {
+		return nil, fmt.Errorf("%w: invalid header %q", ErrCorruptedSnapshot, strings.TrimSpace(header))
+	}
+	if keys == nil {
+		return nil, fmt.Errorf("%w %s: keys of snapshots aren't configured", ErrSnapshotKey, keyID)
+	}
+
+	sealed, err := io.ReadAll(bufReader)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := keys.Open(keyID, sealed, []byte(header))
+	switch {
+	case errors.Is(err, encryption.ErrUnknownAESKey):
+		return nil, fmt.Errorf("%w %s", ErrSnapshotKey, keyID)
+	case err != nil:
+		return nil, fmt.Errorf("%w: %s", ErrCorruptedSnapshot, err)
+	}
+
+	return data, nil
+}
+
+// readSnapshot reads, decrypts, decompresses and verifies snapshot, snapshot without header is decoded as plain JSON
+// of the old format. Unencrypted snapshot is read even if keys are given. Empty snapshot returns io.EOF.
+func readSnapshot(reader io.Reader, keys *encryption.AESKeyRing) (*snapshotData, error) {
+	bufReader := bufio.NewReader(reader)
+
+	prefix, err := bufReader.Peek(len(encryptedSnapshotMagic))
+	if len(prefix) == 0 && errors.Is(err, io.EOF) {
+		return nil, io.EOF
+	}
+	if string(prefix) == encryptedSnapshotMagic {
+		data, err := openSnapshot(bufReader, keys)
+		if err != nil {
+			return nil, err
+		}
+
+		bufReader = bufio.NewReader(bytes.NewReader(data))
+	}
+	if prefix, _ = bufReader.Peek(len(gzipMagic)); bytes.Equal(prefix, gzipMagic) {
+		gzipReader, err := gzip.NewReader(bufReader)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrCorruptedSnapshot, err)
+		}
+
+		bufReader = bufio.NewReader(gzipReader)
+	}
+
+	prefix, _ = bufReader.Peek(len(snapshotMagic))
+
+	metricsCache := make(map[string]*metrics.Metric)
+	if string(prefix) != snapshotMagic {
+		if err := json.NewDecoder(bufReader).Decode(&metricsCache); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrCorruptedSnapshot, err)
+		}
+
+		return &snapshotData{Metrics: metricsCache}, nil
+	}
+
+	header, err := bufReader.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("%w: partial header", ErrCorruptedSnapshot)
+	}
+
+	var version int
+	var checksum uint32
+	var length int
+	if _, err := fmt.Sscanf(header, snapshotHeaderFormat, &version, &checksum, &length); err != nil {
+		return nil, fmt.Errorf("%w: invalid header %q", ErrCorruptedSnapshot, strings.TrimSpace(header))
+	}
+	if version < 1 || version > snapshotVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrCorruptedSnapshot, version)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(bufReader, payload); err != nil {
+		return nil, fmt.Errorf("%w: partial payload", ErrCorruptedSnapshot)
+	}
+	if crc32.Checksum(payload, walTable) != checksum {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrCorruptedSnapshot)
+	}
+
+	data := snapshotData{Metrics: metricsCache}
+	if version == 1 {
+		err = json.Unmarshal(payload, &data.Metrics)
+	} else {
+		err = json.Unmarshal(payload, &data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrCorruptedSnapshot, err)
+	}
+	if data.Metrics == nil {
+		data.Metrics = metricsCache
+	}
+
+	return &data, nil
+}
+
+// readSnapshotFile reads, decrypts, decompresses and verifies snapshot from file by path
+func readSnapshotFile(snapshotPath string, keys *encryption.AESKeyRing) (*snapshotData, error) {
+	file, err := os.Open(snapshotPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func(file *os.File) {
+		if err := file.Close(); err != nil {
+			log.Error().Err(err).Msgf("Failed to close snapshot %s", snapshotPath)
+		}
+	}(file)
+
+	return readSnapshot(file, keys)
+}
+
+// writeSnapshot atomically replaces snapshot by path with data and returns opened file of the new snapshot.
+// Snapshot is encrypted with the current key if keys are given.
+// Snapshot is written to the temporary file which is synced and renamed over the target,
+// the current snapshot is kept as the previous one to fall back to.
+func writeSnapshot(snapshotPath string, snapshot *snapshotData, keys *encryption.AESKeyRing) (*os.File, error) {
+	data, err := encodeSnapshot(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	if keys != nil {
+		if data, err = sealSnapshot(data, keys); err != nil {
+			return nil, err
+		}
+	}
+
+	dir, base := filepath.Split(snapshotPath)
+	if dir == "" {
+		dir = "."
+	}
+
+	file, err := os.CreateTemp(dir, base+".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeSnapshotFile(file, data); err != nil {
+		if err := file.Close(); err != nil {
+			log.Error().Err(err).Msgf("Failed to close snapshot %s", file.Name())
+		}
+		if err := os.Remove(file.Name()); err != nil {
+			log.Error().Err(err).Msgf("Failed to remove snapshot %s", file.Name())
+		}
+
+		return nil, err
+	}
+
+	keepPreviousSnapshot(snapshotPath)
+
+	if err := os.Rename(file.Name(), snapshotPath); err != nil {
+		if err := file.Close(); err != nil {
+			log.Error().Err(err).Msgf("Failed to close snapshot %s", file.Name())
+		}
+
+		return nil, err
+	}
+
+	return file, syncDir(dir)
+}
+
+// writeSnapshotFile writes data of snapshot to the file and syncs it to disk
+func writeSnapshotFile(file *os.File, data []byte) error {
+	if err := file.Chmod(fileMode); err != nil {
+		return err
+	}
+
+	if _, err := file.Write(data); err != nil {
+		return err
+	}
+
+	return file.Sync()
+}
+
+// keepPreviousSnapshot links the current non-empty snapshot as the previous one
+func keepPreviousSnapshot(snapshotPath string) {
+	info, err := os.Stat(snapshotPath)
+	if err != nil || info.Size() == 0 {
+		return
+	}
+
+	previousPath := snapshotPath + previousSnapshotSuffix
+	if err := os.Remove(previousPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Error().Err(err).Msgf("Failed to remove previous snapshot %s", previousPath)
+
+		return
+	}
+
+	if err := os.Link(snapshotPath, previousPath); err != nil {
+		log.Error().Err(err).Msgf("Failed to keep previous snapshot %s", previousPath)
+	}
+}
+
+// syncDir syncs directory to persist renames of files in it
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	if err := d.Sync(); err != nil {
+		if err := d.Close(); err != nil {
+			log.Error().Err(err).Msgf("Failed to close directory %s", dir)
+		}
+
+		return err
+	}
+
+	return d.Close()
+}
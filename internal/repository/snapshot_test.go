@@ -0,0 +1,313 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itd27m01/go-metrics-service/internal/models/metrics"
+	"github.com/itd27m01/go-metrics-service/pkg/encryption"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestSnapshotFileStore opens file store in the directory and loads metrics
+func newTestSnapshotFileStore(t *testing.T, dir string) (*FileStore, error) {
+	t.Helper()
+
+	fs, err := NewFileStore(filepath.Join(dir, "metrics.json"), newTestSyncChannel(t), 0)
+	require.NoError(t, err)
+	t.Cleanup(func() { fs.file.Close() })
+
+	return fs, fs.LoadMetrics()
+}
+
+func TestFileStore_SnapshotRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	fs, err := newTestSnapshotFileStore(t, dir)
+	require.ErrorIs(t, err, io.EOF, "new snapshot is empty")
+	require.NoError(t, fs.UpdateGaugeMetric(ctx, "Alloc", 1))
+	require.NoError(t, fs.UpdateCounterMetric(ctx, "PollCount", 2))
+	require.NoError(t, fs.SaveMetrics())
+
+	info, err := os.Stat(filepath.Join(dir, "metrics.json"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(fileMode), info.Mode().Perm())
+
+	leftovers, err := filepath.Glob(filepath.Join(dir, "metrics.json.tmp-*"))
+	require.NoError(t, err)
+	assert.Empty(t, leftovers, "temporary snapshots must be renamed")
+
+	want, err := fs.GetMetrics(ctx)
+	require.NoError(t, err)
+
+	fs, err = newTestSnapshotFileStore(t, dir)
+	require.NoError(t, err)
+	got, err := fs.GetMetrics(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+}
+
+func TestFileStore_PingDuringSave(t *testing.T) {
+	ctx := context.Background()
+
+	fs, err := newTestSnapshotFileStore(t, t.TempDir())
+	require.ErrorIs(t, err, io.EOF, "new snapshot is empty")
+	require.NoError(t, fs.UpdateGaugeMetric(ctx, "Alloc", 1))
+
+	saved := make(chan struct{})
+	go func() {
+		defer close(saved)
+
+		for i := 0; i < 10; i++ {
+			assert.NoError(t, fs.SaveMetrics())
+		}
+	}()
+	for {
+		select {
+		case <-saved:
+			return
+		default:
+			require.NoError(t, fs.Ping(ctx), "ping must not see closed snapshot file")
+		}
+	}
+}
+
+func TestFileStore_SnapshotFallback(t *testing.T) {
+	tests := []struct {
+		name      string
+		corrupt   func(t *testing.T, snapshotPath string)
+		wantAlloc metrics.Gauge
+		wantErr   error
+	}{
+		{
+			name:      "Intact snapshot",
+			corrupt:   func(t *testing.T, snapshotPath string) {},
+			wantAlloc: 2,
+		},
+		{
+			name: "Truncated snapshot",
+			corrupt: func(t *testing.T, snapshotPath string) {
+				info, err := os.Stat(snapshotPath)
+				require.NoError(t, err)
+				require.NoError(t, os.Truncate(snapshotPath, info.Size()-5))
+			},
+			wantAlloc: 1,
+		},
+		{
+			name: "Empty snapshot",
+			corrupt: func(t *testing.T, snapshotPath string) {
+				require.NoError(t, os.Truncate(snapshotPath, 0))
+			},
+			wantAlloc: 1,
+		},
+		{
+			name: "Corrupted payload of snapshot",
+			corrupt: func(t *testing.T, snapshotPath string) {
+				data, err := os.ReadFile(snapshotPath)
+				require.NoError(t, err)
+				data[len(data)-5] ^= 0xff
+				require.NoError(t, os.WriteFile(snapshotPath, data, fileMode))
+			},
+			wantAlloc: 1,
+		},
+		{
+			name: "Missing snapshot after crash between renames",
+			corrupt: func(t *testing.T, snapshotPath string) {
+				require.NoError(t, os.Remove(snapshotPath))
+			},
+			wantAlloc: 1,
+		},
+		{
+			name: "Corrupted snapshot and previous snapshot",
+			corrupt: func(t *testing.T, snapshotPath string) {
+				require.NoError(t, os.WriteFile(snapshotPath, []byte(snapshotMagic+" v1"), fileMode))
+				require.NoError(t, os.WriteFile(snapshotPath+previousSnapshotSuffix, []byte("{"), fileMode))
+			},
+			wantErr: ErrCorruptedSnapshot,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			ctx := context.Background()
+			snapshotPath := filepath.Join(dir, "metrics.json")
+
+			fs, err := newTestSnapshotFileStore(t, dir)
+			require.ErrorIs(t, err, io.EOF, "new snapshot is empty")
+			require.NoError(t, fs.UpdateGaugeMetric(ctx, "Alloc", 1))
+			require.NoError(t, fs.SaveMetrics())
+			require.NoError(t, fs.UpdateGaugeMetric(ctx, "Alloc", 2))
+			require.NoError(t, fs.SaveMetrics())
+
+			tt.corrupt(t, snapshotPath)
+
+			fs, err = newTestSnapshotFileStore(t, dir)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+
+				return
+			}
+			require.NoError(t, err)
+
+			alloc, err := fs.GetMetric(ctx, "Alloc", metrics.MetricTypeGauge)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantAlloc, *alloc.Value)
+		})
+	}
+}
+
+func TestFileStore_LegacySnapshot(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "metrics.json"), []byte(testMetrics), fileMode))
+
+	fs, err := newTestSnapshotFileStore(t, dir)
+	require.NoError(t, err)
+
+	alloc, err := fs.GetMetric(ctx, "Alloc", metrics.MetricTypeGauge)
+	require.NoError(t, err)
+	assert.Equal(t, metrics.Gauge(testMetricValue), *alloc.Value)
+}
+
+// newTestKeyRing creates key ring of AES keys filled with the bytes, the first key is the current one
+func newTestKeyRing(t *testing.T, keyBytes ...byte) *encryption.AESKeyRing {
+	t.Helper()
+
+	encodedKeys := make([]string, 0, len(keyBytes))
+	for _, b := range keyBytes {
+		encodedKeys = append(encodedKeys, base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32)))
+	}
+
+	keys, err := encryption.NewAESKeyRing(encodedKeys...)
+	require.NoError(t, err)
+
+	return keys
+}
+
+// newTestEncryptedFileStore opens file store in the directory with keys of snapshots and loads metrics
+func newTestEncryptedFileStore(t *testing.T, dir string, keys *encryption.AESKeyRing) (*FileStore, error) {
+	t.Helper()
+
+	fs, err := NewFileStore(filepath.Join(dir, "metrics.json"), newTestSyncChannel(t), 0)
+	require.NoError(t, err)
+	t.Cleanup(func() { fs.file.Close() })
+	fs.EncryptSnapshots(keys)
+
+	return fs, fs.LoadMetrics()
+}
+
+func TestFileStore_EncryptedSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	snapshotPath := filepath.Join(dir, "metrics.json")
+
+	fs, err := newTestEncryptedFileStore(t, dir, newTestKeyRing(t, 1))
+	require.ErrorIs(t, err, io.EOF, "new snapshot is empty")
+	require.NoError(t, fs.UpdateGaugeMetric(ctx, "Alloc", 1))
+	require.NoError(t, fs.SaveMetrics())
+	require.NoError(t, fs.UpdateGaugeMetric(ctx, "Alloc", 2))
+	require.NoError(t, fs.SaveMetrics())
+
+	data, err := os.ReadFile(snapshotPath)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "Alloc", "snapshot must be encrypted")
+
+	want, err := fs.GetMetrics(ctx)
+	require.NoError(t, err)
+
+	fs, err = newTestEncryptedFileStore(t, dir, newTestKeyRing(t, 1))
+	require.NoError(t, err)
+	got, err := fs.GetMetrics(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	data[len(data)-5] ^= 0xff
+	require.NoError(t, os.WriteFile(snapshotPath, data, fileMode))
+
+	fs, err = newTestEncryptedFileStore(t, dir, newTestKeyRing(t, 1))
+	require.NoError(t, err, "tampered snapshot must fall back to the previous one")
+	alloc, err := fs.GetMetric(ctx, "Alloc", metrics.MetricTypeGauge)
+	require.NoError(t, err)
+	assert.Equal(t, metrics.Gauge(1), *alloc.Value)
+}
+
+func TestFileStore_EncryptedSnapshotKeys(t *testing.T) {
+	tests := []struct {
+		name      string
+		keyBytes  []byte
+		wantErr   error
+		wantAlloc metrics.Gauge
+	}{
+		{
+			name:      "Same key",
+			keyBytes:  []byte{1},
+			wantAlloc: 1,
+		},
+		{
+			name:      "Rotated key",
+			keyBytes:  []byte{2, 1},
+			wantAlloc: 1,
+		},
+		{
+			name:     "Wrong key",
+			keyBytes: []byte{2},
+			wantErr:  ErrSnapshotKey,
+		},
+		{
+			name:    "No keys",
+			wantErr: ErrSnapshotKey,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			ctx := context.Background()
+
+			fs, err := newTestEncryptedFileStore(t, dir, newTestKeyRing(t, 1))
+			require.ErrorIs(t, err, io.EOF, "new snapshot is empty")
+			require.NoError(t, fs.UpdateGaugeMetric(ctx, "Alloc", 1))
+			require.NoError(t, fs.SaveMetrics())
+
+			fs, err = newTestEncryptedFileStore(t, dir, newTestKeyRing(t, tt.keyBytes...))
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				assert.NotErrorIs(t, err, ErrCorruptedSnapshot)
+
+				return
+			}
+			require.NoError(t, err)
+
+			alloc, err := fs.GetMetric(ctx, "Alloc", metrics.MetricTypeGauge)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantAlloc, *alloc.Value)
+
+			require.NoError(t, fs.SaveMetrics())
+			fs, err = newTestEncryptedFileStore(t, dir, newTestKeyRing(t, tt.keyBytes[0]))
+			require.NoError(t, err, "snapshot must be encrypted with the current key on save")
+		})
+	}
+}
+
+func TestFileStore_UnencryptedSnapshotWithKeys(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	fs, err := newTestSnapshotFileStore(t, dir)
+	require.ErrorIs(t, err, io.EOF, "new snapshot is empty")
+	require.NoError(t, fs.UpdateGaugeMetric(ctx, "Alloc", 1))
+	require.NoError(t, fs.SaveMetrics())
+
+	fs, err = newTestEncryptedFileStore(t, dir, newTestKeyRing(t, 1))
+	require.NoError(t, err)
+	alloc, err := fs.GetMetric(ctx, "Alloc", metrics.MetricTypeGauge)
+	require.NoError(t, err)
+	assert.Equal(t, metrics.Gauge(1), *alloc.Value)
+}
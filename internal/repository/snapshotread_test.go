@@ -0,0 +1,174 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/itd27m01/go-metrics-service/internal/models/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testStores returns memory based stores which must return point-in-time copies of metrics
+func testStores(t *testing.T) map[string]Store {
+	t.Helper()
+
+	fs, err := NewFileStore(filepath.Join(t.TempDir(), "metrics.json"), newTestSyncChannel(t), time.Hour)
+	require.NoError(t, err)
+	t.Cleanup(func() { fs.file.Close() })
+
+	bs, err := NewBoltStore(filepath.Join(t.TempDir(), "metrics.db"), time.Hour)
+	require.NoError(t, err)
+	t.Cleanup(func() { bs.Close() })
+
+	return map[string]Store{
+		"InMemoryStore": NewInMemoryStoreWithHistory(time.Hour),
+		"ShardedStore":  NewShardedStore(4, time.Hour),
+		"FileStore":     fs,
+		"TieredStore":   NewTieredStore(NewInMemoryStore()),
+		"BoltStore":     bs,
+	}
+}
+
+func TestStores_GetMetricsSnapshot(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			histogram := metrics.NewHistogram(1, 2)
+			histogram.Observe(1)
+			require.NoError(t, store.UpdateGaugeMetric(ctx, "Alloc", 1))
+			require.NoError(t, store.UpdateCounterMetric(ctx, "PollCount", 1))
+			require.NoError(t, store.UpdateHistogramMetric(ctx, "Latency", histogram))
+
+			alloc, err := store.GetMetric(ctx, "Alloc", metrics.MetricTypeGauge)
+			require.NoError(t, err)
+			snapshot, err := store.GetMetrics(ctx)
+			require.NoError(t, err)
+
+			require.NoError(t, store.UpdateGaugeMetric(ctx, "Alloc", 2))
+			require.NoError(t, store.UpdateCounterMetric(ctx, "PollCount", 1))
+			require.NoError(t, store.UpdateHistogramMetric(ctx, "Latency", histogram))
+			histogram.Observe(2)
+
+			assert.Equal(t, metrics.Gauge(1), *alloc.Value, "metric must not be changed by updates")
+			assert.Equal(t, metrics.Gauge(1), *snapshot["Alloc"].Value, "snapshot must not be changed by updates")
+			assert.Equal(t, metrics.Counter(1), *snapshot["PollCount"].Delta, "snapshot must not be changed by updates")
+			assert.Equal(t, metrics.Counter(1), snapshot["Latency"].Histogram.Count, "snapshot must not be changed by updates")
+
+			*alloc.Value = 100
+			snapshot["PollCount"].Labels = metrics.Labels{"agent": "1"}
+			snapshot["Latency"].Histogram.Observe(1)
+
+			got, err := store.GetMetrics(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, metrics.Gauge(2), *got["Alloc"].Value, "store must not be changed by callers")
+			assert.Equal(t, metrics.Counter(2), *got["PollCount"].Delta)
+			assert.Empty(t, got["PollCount"].Labels, "store must not be changed by callers")
+			assert.Equal(t, metrics.Counter(2), got["Latency"].Histogram.Count, "store must not be changed by callers")
+		})
+	}
+}
+
+// TestStores_ConcurrentReads is meant to be run with -race to catch data races between updates and readers
+func TestStores_ConcurrentReads(t *testing.T) {
+	const (
+		writers    = 4
+		readers    = 4
+		iterations = 200
+	)
+
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			var wg sync.WaitGroup
+
+			for w := 0; w < writers; w++ {
+				wg.Add(1)
+				go func(agent int) {
+					defer wg.Done()
+
+					for i := 0; i < iterations; i++ {
+						histogram := metrics.NewHistogram(1, 2)
+						histogram.Observe(float64(i % 3))
+						assert.NoError(t, store.UpdateMetrics(ctx, newBenchMetricsBatch(agent, 10)))
+						assert.NoError(t, store.UpdateHistogramMetric(ctx, "Latency", histogram))
+					}
+				}(w)
+			}
+
+			for r := 0; r < readers; r++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+
+					for i := 0; i < iterations; i++ {
+						metricsData, err := store.GetMetrics(ctx)
+						if !assert.NoError(t, err) {
+							return
+						}
+						for _, metric := range metricsData {
+							metric.SetHash("key")
+							_ = metric.String()
+						}
+
+						metric, err := store.GetMetric(ctx, `Gauge0{agent="0"}`, metrics.MetricTypeGauge)
+						if err == nil {
+							metric.SetHash("key")
+							*metric.Value++
+						}
+					}
+				}()
+			}
+
+			wg.Wait()
+
+			metricsData, err := store.GetMetrics(ctx)
+			require.NoError(t, err)
+			assert.Len(t, metricsData, writers*10+1)
+			for agent := 0; agent < writers; agent++ {
+				gauge := metricsData[fmt.Sprintf(`Gauge0{agent="%d"}`, agent)]
+				require.NotNil(t, gauge)
+				assert.Equal(t, metrics.Gauge(0), *gauge.Value, "readers must not change stored metrics")
+			}
+		})
+	}
+}
+
+func TestStores_UpdateMetricsWithoutValue(t *testing.T) {
+	stores := testStores(t)
+	stores["DBStore"] = newTestDBStore(t, testDatabaseDSN(t))
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			require.NoError(t, store.UpdateGaugeMetric(ctx, "TestInvalidAlloc", 1))
+			require.NoError(t, store.UpdateCounterMetric(ctx, "TestInvalidPollCount", 1))
+			want, err := store.GetMetrics(ctx, metrics.NewPrefixMatcher("TestInvalid"))
+			require.NoError(t, err)
+
+			value := metrics.Gauge(2)
+			for _, invalid := range []*metrics.Metric{
+				{ID: "TestInvalidAlloc", MType: metrics.MetricTypeGauge},
+				{ID: "TestInvalidPollCount", MType: metrics.MetricTypeCounter},
+				{ID: "TestInvalidHeapAlloc", MType: metrics.MetricTypeGauge},
+			} {
+				err := store.UpdateMetrics(ctx, []*metrics.Metric{
+					{ID: "TestInvalidAlloc", MType: metrics.MetricTypeGauge, Value: &value},
+					invalid,
+				})
+				assert.ErrorIs(t, err, ErrInvalidMetric)
+			}
+
+			got, err := store.GetMetrics(ctx, metrics.NewPrefixMatcher("TestInvalid"))
+			require.NoError(t, err)
+			assert.Equal(t, want, got, "batch with metric without value must not be applied")
+			for _, metric := range got {
+				assert.NotPanics(t, func() { _ = metric.String() })
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package storetest
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// postgresBinDirs are the usual directories of PostgreSQL binaries which aren't in PATH
+var postgresBinDirs = []string{
+	"/usr/lib/postgresql/*/bin",
+	"/usr/local/pgsql/bin",
+	"/opt/homebrew/opt/postgresql*/bin",
+}
+
+// PostgresDSN returns DSN of PostgreSQL database for test: DATABASE_DSN if it's set,
+// otherwise a throwaway cluster is started in temporary directory and stopped by cleanup of test.
+// Test is skipped if PostgreSQL binaries aren't found or can't be run.
+func PostgresDSN(t *testing.T) string {
+	t.Helper()
+
+	if databaseDSN := os.Getenv("DATABASE_DSN"); databaseDSN != "" {
+		return databaseDSN
+	}
+
+	initdb, pgctl := postgresBinary("initdb"), postgresBinary("pg_ctl")
+	if initdb == "" || pgctl == "" {
+		t.Skip("PostgreSQL isn't available: set DATABASE_DSN or install initdb and pg_ctl")
+	}
+	if os.Geteuid() == 0 {
+		t.Skip("PostgreSQL can't be started by root: set DATABASE_DSN")
+	}
+
+	port, err := freePort()
+	if err != nil {
+		t.Skipf("PostgreSQL can't be started: %s", err)
+	}
+
+	dir := t.TempDir()
+	dataDir := filepath.Join(dir, "data")
+	if output, err := exec.Command(initdb, "-D", dataDir, "-U", "postgres", "-A", "trust", "--no-sync").
+		CombinedOutput(); err != nil {
+		t.Skipf("PostgreSQL can't be initialized: %s: %s", err, output)
+	}
+
+	options := fmt.Sprintf("-p %d -k %s -c listen_addresses='' -c fsync=off", port, dir)
+	if output, err := exec.Command(pgctl, "-D", dataDir, "-o", options, "-l", filepath.Join(dir, "postgres.log"),
+		"-w", "start").CombinedOutput(); err != nil {
+		t.Skipf("PostgreSQL can't be started: %s: %s", err, output)
+	}
+	t.Cleanup(func() {
+		if output, err := exec.Command(pgctl, "-D", dataDir, "-m", "immediate", "-w", "stop").
+			CombinedOutput(); err != nil {
+			t.Errorf("Failed to stop PostgreSQL: %s: %s", err, output)
+		}
+	})
+
+	return fmt.Sprintf("host=%s port=%d user=postgres dbname=postgres sslmode=disable", dir, port)
+}
+
+// postgresBinary looks for PostgreSQL binary in PATH and in the usual directories, it's empty if nothing is found
+func postgresBinary(name string) string {
+	if path, err := exec.LookPath(name); err == nil {
+		return path
+	}
+
+	for _, dir := range postgresBinDirs {
+		matches, err := filepath.Glob(filepath.Join(dir, name))
+		if err == nil && len(matches) > 0 {
+			return matches[len(matches)-1]
+		}
+	}
+
+	return ""
+}
+
+// freePort returns TCP port which isn't used at the moment
+func freePort() (int, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
@@ -0,0 +1,366 @@
+// Package storetest implements conformance suite of implementations of repository.Store
+package storetest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/itd27m01/go-metrics-service/internal/models/metrics"
+	"github.com/itd27m01/go-metrics-service/internal/repository"
+)
+
+// Behavior describes the documented differences of stores, zero value describes stores which keep metrics in memory
+type Behavior struct {
+	// SeparateTypes means that metrics of different types with the same name and labels are different metrics.
+	// Otherwise the type of metric is fixed by its first update: updates of other types fail with
+	// ErrMetricTypeMismatch and GetMetric returns the stored metric whatever type is requested.
+	SeparateTypes bool
+	// AtomicBatches means that UpdateMetrics applies all of metrics of batch or none of them.
+	// Otherwise metrics are applied in the order of batch until the failed one.
+	AtomicBatches bool
+	// HonorsContext means that operations with done context fail with its error and don't change metrics.
+	// Otherwise operations are completed regardless of context.
+	HonorsContext bool
+}
+
+// Factory creates empty store for test, the store may be shared by tests and keep metrics of other tests
+type Factory func(t *testing.T) repository.Store
+
+// Run runs conformance suite against stores created by the factory, every case uses metrics with its own prefix
+func Run(t *testing.T, newStore Factory, behavior Behavior) {
+	cases := []struct {
+		name string
+		test func(t *testing.T, store repository.Store, prefix string, behavior Behavior)
+	}{
+		{name: "NotFound", test: testNotFound},
+		{name: "TypeMismatch", test: testTypeMismatch},
+		{name: "CounterAccumulation", test: testCounterAccumulation},
+		{name: "GaugeAndHistogram", test: testGaugeAndHistogram},
+		{name: "Labels", test: testLabels},
+		{name: "Batch", test: testBatch},
+		{name: "BatchFailure", test: testBatchFailure},
+		{name: "ConcurrentWriters", test: testConcurrentWriters},
+		{name: "ContextCancellation", test: testContextCancellation},
+		{name: "Delete", test: testDelete},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			store := newStore(t)
+			prefix := fmt.Sprintf("Conformance%s%d", tc.name, time.Now().UnixNano())
+			t.Cleanup(func() {
+				_, err := store.DeleteMetrics(context.Background(), metrics.NewPrefixMatcher(prefix))
+				assert.NoError(t, err)
+			})
+
+			tc.test(t, store, prefix, behavior)
+		})
+	}
+}
+
+// counter creates counter metric for batch
+func counter(name string, delta metrics.Counter, labels metrics.Labels) *metrics.Metric {
+	return &metrics.Metric{ID: name, Labels: labels, MType: metrics.MetricTypeCounter, Delta: &delta}
+}
+
+// gauge creates gauge metric for batch
+func gauge(name string, value metrics.Gauge) *metrics.Metric {
+	return &metrics.Metric{ID: name, MType: metrics.MetricTypeGauge, Value: &value}
+}
+
+// histogram creates histogram metric for batch with observed values
+func histogram(name string, bounds []float64, values ...float64) *metrics.Metric {
+	h := metrics.NewHistogram(bounds...)
+	for _, value := range values {
+		h.Observe(value)
+	}
+
+	return &metrics.Metric{ID: name, MType: metrics.MetricTypeHistogram, Histogram: h}
+}
+
+// requireCounter checks the stored value of counter
+func requireCounter(t *testing.T, store repository.Store, name string, expected metrics.Counter) {
+	t.Helper()
+
+	metric, err := store.GetMetric(context.Background(), name, metrics.MetricTypeCounter)
+	require.NoError(t, err)
+	require.NotNil(t, metric.Delta, "%s must be counter", name)
+	assert.Equal(t, expected, *metric.Delta, name)
+}
+
+// requireGauge checks the stored value of gauge
+func requireGauge(t *testing.T, store repository.Store, name string, expected metrics.Gauge) {
+	t.Helper()
+
+	metric, err := store.GetMetric(context.Background(), name, metrics.MetricTypeGauge)
+	require.NoError(t, err)
+	require.NotNil(t, metric.Value, "%s must be gauge", name)
+	assert.Equal(t, expected, *metric.Value, name)
+}
+
+// requireAbsent checks that metric of the type isn't stored
+func requireAbsent(t *testing.T, store repository.Store, name string, metricType string) {
+	t.Helper()
+
+	_, err := store.GetMetric(context.Background(), name, metricType)
+	require.ErrorIs(t, err, repository.ErrMetricNotFound, name)
+}
+
+func testNotFound(t *testing.T, store repository.Store, prefix string, _ Behavior) {
+	ctx := context.Background()
+	for _, metricType := range []string{metrics.MetricTypeCounter, metrics.MetricTypeGauge, metrics.MetricTypeHistogram} {
+		requireAbsent(t, store, prefix+"Absent", metricType)
+	}
+
+	require.NoError(t, store.UpdateGaugeMetric(ctx, prefix+"Alloc", 1))
+	requireAbsent(t, store, prefix+`Alloc{host="a"}`, metrics.MetricTypeGauge)
+	requireAbsent(t, store, prefix+"Allo", metrics.MetricTypeGauge)
+
+	assert.ErrorIs(t, store.DeleteMetric(ctx, prefix+"Absent", metrics.MetricTypeCounter), repository.ErrMetricNotFound)
+}
+
+func testTypeMismatch(t *testing.T, store repository.Store, prefix string, behavior Behavior) {
+	ctx := context.Background()
+	name := prefix + "Mixed"
+	require.NoError(t, store.UpdateGaugeMetric(ctx, name, 1))
+
+	errs := map[string]error{
+		"UpdateCounterMetric":   store.UpdateCounterMetric(ctx, name, 1),
+		"ResetCounterMetric":    store.ResetCounterMetric(ctx, name),
+		"UpdateMetrics":         store.UpdateMetrics(ctx, []*metrics.Metric{counter(name, 2, nil)}),
+		"UpdateHistogramMetric": store.UpdateHistogramMetric(ctx, name, histogram(name, []float64{1}, 1).Histogram),
+	}
+
+	if behavior.SeparateTypes {
+		for operation, err := range errs {
+			assert.NoError(t, err, operation)
+		}
+		requireGauge(t, store, name, 1)
+		requireCounter(t, store, name, 2)
+
+		require.NoError(t, store.DeleteMetric(ctx, name, metrics.MetricTypeCounter))
+		requireAbsent(t, store, name, metrics.MetricTypeCounter)
+		requireGauge(t, store, name, 1)
+
+		return
+	}
+
+	for operation, err := range errs {
+		assert.ErrorIs(t, err, repository.ErrMetricTypeMismatch, operation)
+	}
+	requireGauge(t, store, name, 1)
+
+	metric, err := store.GetMetric(ctx, name, metrics.MetricTypeCounter)
+	require.NoError(t, err)
+	assert.Equal(t, metrics.MetricTypeGauge, metric.MType, "stored metric must be returned whatever type is requested")
+
+	assert.ErrorIs(t, store.DeleteMetric(ctx, name, metrics.MetricTypeCounter), repository.ErrMetricTypeMismatch)
+	requireGauge(t, store, name, 1)
+}
+
+func testCounterAccumulation(t *testing.T, store repository.Store, prefix string, _ Behavior) {
+	ctx := context.Background()
+	name := prefix + "PollCount"
+
+	for _, delta := range []metrics.Counter{1, 2, 3} {
+		require.NoError(t, store.UpdateCounterMetric(ctx, name, delta))
+	}
+	requireCounter(t, store, name, 6)
+
+	require.NoError(t, store.ResetCounterMetric(ctx, name))
+	requireCounter(t, store, name, 0)
+
+	require.NoError(t, store.UpdateCounterMetric(ctx, name, 5))
+	requireCounter(t, store, name, 5)
+
+	require.NoError(t, store.ResetCounterMetric(ctx, prefix+"Reset"))
+	requireCounter(t, store, prefix+"Reset", 0)
+}
+
+func testGaugeAndHistogram(t *testing.T, store repository.Store, prefix string, _ Behavior) {
+	ctx := context.Background()
+	alloc, latency := prefix+"Alloc", prefix+"Latency"
+
+	require.NoError(t, store.UpdateGaugeMetric(ctx, alloc, 1))
+	require.NoError(t, store.UpdateGaugeMetric(ctx, alloc, 2.5))
+	requireGauge(t, store, alloc, 2.5)
+
+	require.NoError(t, store.UpdateHistogramMetric(ctx, latency, histogram(latency, []float64{1, 2}, 0.5).Histogram))
+	require.NoError(t, store.UpdateHistogramMetric(ctx, latency, histogram(latency, []float64{1, 2}, 1.5, 3).Histogram))
+	metric, err := store.GetMetric(ctx, latency, metrics.MetricTypeHistogram)
+	require.NoError(t, err)
+	require.NotNil(t, metric.Histogram)
+	assert.Equal(t, metrics.Counter(3), metric.Histogram.Count, "observations must be merged")
+
+	err = store.UpdateHistogramMetric(ctx, latency, histogram(latency, []float64{5}, 1).Histogram)
+	assert.ErrorIs(t, err, metrics.ErrHistogramBucketsMismatch)
+}
+
+func testLabels(t *testing.T, store repository.Store, prefix string, _ Behavior) {
+	ctx := context.Background()
+	name := prefix + "CPU"
+
+	require.NoError(t, store.UpdateGaugeMetric(ctx, name+`{core="1",host="a"}`, 1))
+	require.NoError(t, store.UpdateGaugeMetric(ctx, name+`{host="a",core="1"}`, 2))
+	require.NoError(t, store.UpdateGaugeMetric(ctx, name+`{host="b",core="1"}`, 3))
+
+	metric, err := store.GetMetric(ctx, name+`{host="a",core="1"}`, metrics.MetricTypeGauge)
+	require.NoError(t, err)
+	assert.Equal(t, name, metric.ID)
+	assert.Equal(t, metrics.Labels{"core": "1", "host": "a"}, metric.Labels)
+	assert.Equal(t, metrics.Gauge(2), *metric.Value, "keys with the same labels must be the same metric")
+
+	selected, err := store.GetMetrics(ctx, metrics.NewPrefixMatcher(prefix),
+		&metrics.Matcher{Type: metrics.MatchEqual, Name: "host", Value: "b"})
+	require.NoError(t, err)
+	require.Len(t, selected, 1)
+	for _, metric := range selected {
+		assert.Equal(t, metrics.Gauge(3), *metric.Value)
+	}
+}
+
+func testBatch(t *testing.T, store repository.Store, prefix string, _ Behavior) {
+	ctx := context.Background()
+	pollCount, alloc, latency := prefix+"PollCount", prefix+"Alloc", prefix+"Latency"
+
+	batch := []*metrics.Metric{
+		gauge(alloc, 1),
+		counter(pollCount, 1, nil),
+		histogram(latency, []float64{1}, 0.5),
+		counter(pollCount, 2, nil),
+		gauge(alloc, 3),
+		counter(pollCount, 4, metrics.Labels{"agent": "1"}),
+		histogram(latency, []float64{1}, 2),
+	}
+	require.NoError(t, store.UpdateMetrics(ctx, batch))
+	require.NoError(t, store.UpdateMetrics(ctx, nil), "empty batch must be accepted")
+
+	requireCounter(t, store, pollCount, 3)
+	requireCounter(t, store, pollCount+`{agent="1"}`, 4)
+	requireGauge(t, store, alloc, 3)
+	metric, err := store.GetMetric(ctx, latency, metrics.MetricTypeHistogram)
+	require.NoError(t, err)
+	assert.Equal(t, metrics.Counter(2), metric.Histogram.Count)
+
+	assert.Equal(t, metrics.Counter(1), *batch[1].Delta, "batch must not be modified")
+	assert.Equal(t, metrics.Counter(1), batch[2].Histogram.Count, "batch must not be modified")
+
+	require.NoError(t, store.UpdateMetrics(ctx, batch[1:2]))
+	requireCounter(t, store, pollCount, 4)
+}
+
+func testBatchFailure(t *testing.T, store repository.Store, prefix string, behavior Behavior) {
+	ctx := context.Background()
+	before, latency, after := prefix+"Before", prefix+"Latency", prefix+"After"
+	require.NoError(t, store.UpdateHistogramMetric(ctx, latency, histogram(latency, []float64{1, 2}).Histogram))
+
+	err := store.UpdateMetrics(ctx, []*metrics.Metric{
+		counter(before, 1, nil),
+		histogram(latency, []float64{5}, 1),
+		counter(after, 1, nil),
+	})
+	require.ErrorIs(t, err, metrics.ErrHistogramBucketsMismatch)
+
+	if behavior.AtomicBatches {
+		requireAbsent(t, store, before, metrics.MetricTypeCounter)
+	} else {
+		requireCounter(t, store, before, 1)
+	}
+	requireAbsent(t, store, after, metrics.MetricTypeCounter)
+
+	metric, err := store.GetMetric(ctx, latency, metrics.MetricTypeHistogram)
+	require.NoError(t, err)
+	assert.Equal(t, metrics.Counter(0), metric.Histogram.Count, "failed metric must not be changed")
+}
+
+func testConcurrentWriters(t *testing.T, store repository.Store, prefix string, _ Behavior) {
+	const workers, iterations = 8, 25
+
+	ctx := context.Background()
+	pollCount, alloc := prefix+"PollCount", prefix+"Alloc"
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+
+			for j := 0; j < iterations; j++ {
+				assert.NoError(t, store.UpdateCounterMetric(ctx, pollCount, 1))
+				assert.NoError(t, store.UpdateGaugeMetric(ctx, alloc, metrics.Gauge(worker)))
+				assert.NoError(t, store.UpdateMetrics(ctx, []*metrics.Metric{
+					counter(pollCount, 1, nil),
+					counter(pollCount, 2, metrics.Labels{"worker": "any"}),
+				}))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	requireCounter(t, store, pollCount, workers*iterations*2)
+	requireCounter(t, store, pollCount+`{worker="any"}`, workers*iterations*2)
+
+	metric, err := store.GetMetric(ctx, alloc, metrics.MetricTypeGauge)
+	require.NoError(t, err)
+	assert.True(t, *metric.Value >= 0 && *metric.Value < workers, "gauge must have value of one of writers")
+}
+
+func testContextCancellation(t *testing.T, store repository.Store, prefix string, behavior Behavior) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	alloc, pollCount := prefix+"Alloc", prefix+"PollCount"
+
+	errs := map[string]error{
+		"UpdateGaugeMetric": store.UpdateGaugeMetric(ctx, alloc, 1),
+		"UpdateMetrics":     store.UpdateMetrics(ctx, []*metrics.Metric{counter(pollCount, 1, nil)}),
+	}
+	_, err := store.GetMetric(ctx, alloc, metrics.MetricTypeGauge)
+	errs["GetMetric"] = err
+	_, err = store.GetMetrics(ctx)
+	errs["GetMetrics"] = err
+
+	if !behavior.HonorsContext {
+		for operation, err := range errs {
+			assert.NoError(t, err, operation)
+		}
+		requireGauge(t, store, alloc, 1)
+		requireCounter(t, store, pollCount, 1)
+
+		return
+	}
+
+	for operation, err := range errs {
+		assert.ErrorIs(t, err, context.Canceled, operation)
+	}
+	requireAbsent(t, store, alloc, metrics.MetricTypeGauge)
+	requireAbsent(t, store, pollCount, metrics.MetricTypeCounter)
+}
+
+func testDelete(t *testing.T, store repository.Store, prefix string, _ Behavior) {
+	ctx := context.Background()
+	require.NoError(t, store.UpdateMetrics(ctx, []*metrics.Metric{
+		gauge(prefix+"Alloc", 1),
+		counter(prefix+"PollCount", 1, metrics.Labels{"host": "a"}),
+		counter(prefix+"PollCount", 1, metrics.Labels{"host": "b"}),
+	}))
+
+	require.NoError(t, store.DeleteMetric(ctx, prefix+"Alloc", metrics.MetricTypeGauge))
+	requireAbsent(t, store, prefix+"Alloc", metrics.MetricTypeGauge)
+
+	deleted, err := store.DeleteMetrics(ctx, metrics.NewPrefixMatcher(prefix),
+		&metrics.Matcher{Type: metrics.MatchEqual, Name: "host", Value: "a"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	requireAbsent(t, store, prefix+`PollCount{host="a"}`, metrics.MetricTypeCounter)
+	requireCounter(t, store, prefix+`PollCount{host="b"}`, 1)
+
+	require.NoError(t, store.UpdateCounterMetric(ctx, prefix+`PollCount{host="a"}`, 2))
+	requireCounter(t, store, prefix+`PollCount{host="a"}`, 2)
+}
@@ -0,0 +1,325 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/itd27m01/go-metrics-service/internal/models/metrics"
+	"github.com/itd27m01/go-metrics-service/internal/tenant"
+)
+
+var (
+	_ Store        = (*TenantStore)(nil)
+	_ HistoryStore = (*TenantStore)(nil)
+)
+
+// TenantStore implements Store interface to isolate metrics of tenants in the underlying store.
+// Tenant is taken from context, its metrics and metadata are kept under keys scoped by the tenant label
+// which is hidden from tenant. Keys of the default tenant aren't scoped, so it sees metrics stored before tenants.
+// Stale metrics are deleted for all of tenants.
+type TenantStore struct {
+	store Store
+}
+
+// NewTenantStore creates tenant store over the underlying store
+func NewTenantStore(store Store) *TenantStore {
+	return &TenantStore{store: store}
+}
+
+// UpdateCounterMetric updates counter metric of tenant
+func (ts *TenantStore) UpdateCounterMetric(ctx context.Context, metricName string, metricValue metrics.Counter) error {
+	key, err := scopeKey(ctx, metricName)
+	if err != nil {
+		return err
+	}
+
+	return ts.store.UpdateCounterMetric(ctx, key, metricValue)
+}
+
+// ResetCounterMetric resets counter metric of tenant
+func (ts *TenantStore) ResetCounterMetric(ctx context.Context, metricName string) error {
+	key, err := scopeKey(ctx, metricName)
+	if err != nil {
+		return err
+	}
+
+	return ts.store.ResetCounterMetric(ctx, key)
+}
+
+// UpdateGaugeMetric updates gauge metric of tenant
+func (ts *TenantStore) UpdateGaugeMetric(ctx context.Context, metricName string, metricValue metrics.Gauge) error {
+	key, err := scopeKey(ctx, metricName)
+	if err != nil {
+		return err
+	}
+
+	return ts.store.UpdateGaugeMetric(ctx, key, metricValue)
+}
+
+// AddGauge adds delta to gauge metric of tenant and returns its new value
+func (ts *TenantStore) AddGauge(ctx context.Context, metricName string, delta metrics.Gauge) (metrics.Gauge, error) {
+	key, err := scopeKey(ctx, metricName)
+	if err != nil {
+		return 0, err
+	}
+
+	return ts.store.AddGauge(ctx, key, delta)
+}
+
+// CompareAndSetGauge sets value of gauge metric of tenant if its current value equals to expected one
+func (ts *TenantStore) CompareAndSetGauge(ctx context.Context, metricName string, expected *metrics.Gauge,
+	value metrics.Gauge) error {
+	key, err := scopeKey(ctx, metricName)
+	if err != nil {
+		return err
+	}
+
+	return ts.store.CompareAndSetGauge(ctx, key, expected, value)
+}
+
+// UpdateHistogramMetric merges observations of histogram to the histogram metric of tenant
+func (ts *TenantStore) UpdateHistogramMetric(ctx context.Context, metricName string,
+	metricValue *metrics.Histogram) error {
+	key, err := scopeKey(ctx, metricName)
+	if err != nil {
+		return err
+	}
+
+	return ts.store.UpdateHistogramMetric(ctx, key, metricValue)
+}
+
+// UpdateMetrics updates batch of metrics of tenant
+func (ts *TenantStore) UpdateMetrics(ctx context.Context, metricsBatch []*metrics.Metric) error {
+	tenantName := tenant.FromContext(ctx)
+
+	scopedBatch := make([]*metrics.Metric, 0, len(metricsBatch))
+	for _, metric := range metricsBatch {
+		scopedMetric := metric.Copy()
+		scopedMetric.Labels = scopeLabels(tenantName, metric.Labels)
+		scopedBatch = append(scopedBatch, scopedMetric)
+	}
+
+	return ts.store.UpdateMetrics(ctx, scopedBatch)
+}
+
+// GetMetric returns metric of tenant by its key
+func (ts *TenantStore) GetMetric(ctx context.Context, metricName string, metricType string) (*metrics.Metric, error) {
+	key, err := scopeKey(ctx, metricName)
+	if err != nil {
+		return nil, err
+	}
+
+	metric, err := ts.store.GetMetric(ctx, key, metricType)
+	if err != nil {
+		return nil, err
+	}
+
+	return unscopeMetric(metric), nil
+}
+
+// GetMetrics returns metrics of tenant which satisfy all of matchers
+func (ts *TenantStore) GetMetrics(ctx context.Context, matchers ...*metrics.Matcher) (map[string]*metrics.Metric, error) {
+	metricsData, err := ts.store.GetMetrics(ctx, scopeMatchers(ctx, matchers)...)
+	if err != nil {
+		return nil, err
+	}
+
+	tenantMetrics := make(map[string]*metrics.Metric, len(metricsData))
+	for _, metric := range metricsData {
+		metric = unscopeMetric(metric)
+		tenantMetrics[metric.Key()] = metric
+	}
+
+	return tenantMetrics, nil
+}
+
+// ListMetrics returns the page of metrics of tenant which satisfy the filter
+func (ts *TenantStore) ListMetrics(ctx context.Context, filter *ListFilter) (*MetricsPage, error) {
+	scopedFilter := *filter
+	scopedFilter.Matchers = scopeMatchers(ctx, filter.Matchers)
+
+	page, err := ts.store.ListMetrics(ctx, &scopedFilter)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, metric := range page.Metrics {
+		page.Metrics[i] = unscopeMetric(metric)
+	}
+
+	return page, nil
+}
+
+// Watch returns change feed of metrics of tenant which satisfy the filter,
+// the feed is closed when the feed of the underlying store is closed
+func (ts *TenantStore) Watch(ctx context.Context, filter *WatchFilter) (<-chan *Event, error) {
+	var scopedFilter WatchFilter
+	if filter != nil {
+		scopedFilter = *filter
+	}
+	scopedFilter.Matchers = scopeMatchers(ctx, scopedFilter.Matchers)
+
+	scopedEvents, err := ts.store.Watch(ctx, &scopedFilter)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan *Event, watchBufferSize)
+	go func() {
+		defer close(events)
+
+		for event := range scopedEvents {
+			event.Metric = unscopeMetric(event.Metric)
+
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+// DeleteMetric deletes metric of tenant by its key and type
+func (ts *TenantStore) DeleteMetric(ctx context.Context, metricName string, metricType string) error {
+	key, err := scopeKey(ctx, metricName)
+	if err != nil {
+		return err
+	}
+
+	return ts.store.DeleteMetric(ctx, key, metricType)
+}
+
+// DeleteMetrics deletes metrics of tenant which satisfy all of matchers and returns the number of deleted metrics
+func (ts *TenantStore) DeleteMetrics(ctx context.Context, matchers ...*metrics.Matcher) (int, error) {
+	return ts.store.DeleteMetrics(ctx, scopeMatchers(ctx, matchers)...)
+}
+
+// DeleteStaleMetrics deletes metrics of all of tenants which weren't updated during their TTL
+func (ts *TenantStore) DeleteStaleMetrics(ctx context.Context, ttl *TTL, now time.Time) (int, error) {
+	return ts.store.DeleteStaleMetrics(ctx, ttl, now)
+}
+
+// SetMetadata sets metadata of metric of tenant by its name
+func (ts *TenantStore) SetMetadata(ctx context.Context, metricName string, metadata *metrics.Metadata) error {
+	name, labels, err := metrics.ParseKey(metricName)
+	if err != nil {
+		return err
+	}
+	if len(labels) > 0 || name != metricName {
+		return fmt.Errorf("%w %s: metadata is kept by the name of metric without labels",
+			metrics.ErrInvalidMetadata, metricName)
+	}
+
+	return ts.store.SetMetadata(ctx, metrics.Key(metricName, scopeLabels(tenant.FromContext(ctx), nil)), metadata)
+}
+
+// GetMetadata returns metadata of metrics of tenant by their names
+func (ts *TenantStore) GetMetadata(ctx context.Context) (map[string]*metrics.Metadata, error) {
+	metadata, err := ts.store.GetMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tenantName := tenant.FromContext(ctx)
+	tenantMetadata := make(map[string]*metrics.Metadata)
+	for key, md := range metadata {
+		metricName, labels, err := metrics.ParseKey(key)
+		if err != nil || labels[metrics.TenantLabel] != tenantName {
+			continue
+		}
+
+		tenantMetadata[metricName] = md
+	}
+
+	return tenantMetadata, nil
+}
+
+// Ping checks the underlying store
+func (ts *TenantStore) Ping(ctx context.Context) error {
+	return ts.store.Ping(ctx)
+}
+
+// AppendSamples adds timestamped samples to the history of metric of tenant
+func (ts *TenantStore) AppendSamples(ctx context.Context, metricName string, metricType string,
+	samples ...metrics.Sample) error {
+	historyStore, ok := ts.store.(HistoryStore)
+	if !ok {
+		return ErrHistoryDisabled
+	}
+
+	key, err := scopeKey(ctx, metricName)
+	if err != nil {
+		return err
+	}
+
+	return historyStore.AppendSamples(ctx, key, metricType, samples...)
+}
+
+// GetHistory returns samples of metric of tenant in the [from, to] range downsampled by step
+func (ts *TenantStore) GetHistory(ctx context.Context, metricName string, metricType string,
+	from time.Time, to time.Time, step time.Duration) ([]metrics.Sample, error) {
+	historyStore, ok := ts.store.(HistoryStore)
+	if !ok {
+		return nil, ErrHistoryDisabled
+	}
+
+	key, err := scopeKey(ctx, metricName)
+	if err != nil {
+		return nil, err
+	}
+
+	return historyStore.GetHistory(ctx, key, metricType, from, to, step)
+}
+
+// scopeKey returns storage key of metric of the tenant of context
+func scopeKey(ctx context.Context, key string) (string, error) {
+	metricName, labels, err := metrics.ParseKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	return metrics.Key(metricName, scopeLabels(tenant.FromContext(ctx), labels)), nil
+}
+
+// scopeLabels returns copy of labels with the tenant label, tenant label set by client is replaced
+func scopeLabels(tenantName string, labels metrics.Labels) metrics.Labels {
+	scoped := make(metrics.Labels, len(labels)+1)
+	for name, value := range labels {
+		if name != metrics.TenantLabel {
+			scoped[name] = value
+		}
+	}
+	if tenantName != "" {
+		scoped[metrics.TenantLabel] = tenantName
+	}
+
+	if len(scoped) == 0 {
+		return nil
+	}
+
+	return scoped
+}
+
+// scopeMatchers returns matchers with the matcher of the tenant of context, absent label of the default tenant
+// matches the empty value
+func scopeMatchers(ctx context.Context, matchers []*metrics.Matcher) []*metrics.Matcher {
+	scoped := make([]*metrics.Matcher, 0, len(matchers)+1)
+	scoped = append(scoped, matchers...)
+
+	return append(scoped, &metrics.Matcher{
+		Type:  metrics.MatchEqual,
+		Name:  metrics.TenantLabel,
+		Value: tenant.FromContext(ctx),
+	})
+}
+
+// unscopeMetric removes the tenant label from metric
+func unscopeMetric(metric *metrics.Metric) *metrics.Metric {
+	metric.Labels = scopeLabels("", metric.Labels)
+
+	return metric
+}
@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/itd27m01/go-metrics-service/internal/models/metrics"
+	"github.com/itd27m01/go-metrics-service/internal/tenant"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTenantStore_Isolation(t *testing.T) {
+	stores := testStores(t)
+	stores["DBStore"] = newTestDBStore(t, testDatabaseDSN(t))
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ts := NewTenantStore(store)
+			ctx := context.Background()
+			ctxA := tenant.NewContext(ctx, &tenant.Config{Name: "team-a"})
+			ctxB := tenant.NewContext(ctx, &tenant.Config{Name: "team-b"})
+
+			require.NoError(t, ts.UpdateGaugeMetric(ctx, "TenantAlloc", 1))
+			require.NoError(t, ts.UpdateGaugeMetric(ctxA, "TenantAlloc", 2))
+			require.NoError(t, ts.UpdateGaugeMetric(ctxB, `TenantAlloc{__tenant__="team-a"}`, 3))
+			gaugeValue := metrics.Gauge(4)
+			require.NoError(t, ts.UpdateMetrics(ctxA, []*metrics.Metric{
+				{ID: "TenantAlloc", MType: metrics.MetricTypeGauge, Value: &gaugeValue, Labels: metrics.Labels{"host": "a"}},
+			}))
+
+			for tenantCtx, want := range map[context.Context]metrics.Gauge{ctx: 1, ctxA: 2, ctxB: 3} {
+				metric, err := ts.GetMetric(tenantCtx, "TenantAlloc", metrics.MetricTypeGauge)
+				require.NoError(t, err)
+				assert.Equal(t, want, *metric.Value)
+				assert.Nil(t, metric.Labels, "tenant label must be hidden")
+			}
+
+			metricsA, err := ts.GetMetrics(ctxA, &metrics.Matcher{Type: metrics.MatchEqual, Name: metrics.NameLabel,
+				Value: "TenantAlloc"})
+			require.NoError(t, err)
+			assert.Len(t, metricsA, 2)
+			assert.Contains(t, metricsA, `TenantAlloc{host="a"}`)
+
+			metricsB, err := ts.GetMetrics(ctxB)
+			require.NoError(t, err)
+			assert.Len(t, metricsB, 1, "tenant must not see metrics of another tenant")
+
+			deleted, err := ts.DeleteMetrics(ctxA, metrics.NewPrefixMatcher("Tenant"))
+			require.NoError(t, err)
+			assert.Equal(t, 2, deleted)
+			_, err = ts.GetMetric(ctxB, "TenantAlloc", metrics.MetricTypeGauge)
+			assert.NoError(t, err, "metrics of another tenant must not be deleted")
+			_, err = ts.GetMetric(ctx, "TenantAlloc", metrics.MetricTypeGauge)
+			assert.NoError(t, err, "metrics of the default tenant must not be deleted")
+		})
+	}
+}
+
+func TestTenantStore_Metadata(t *testing.T) {
+	ts := NewTenantStore(NewInMemoryStore())
+	ctx := context.Background()
+	ctxA := tenant.NewContext(ctx, &tenant.Config{Name: "team-a"})
+
+	require.NoError(t, ts.SetMetadata(ctx, "Alloc", &metrics.Metadata{Unit: "bytes"}))
+	require.NoError(t, ts.SetMetadata(ctxA, "Alloc", &metrics.Metadata{Unit: "kilobytes"}))
+	assert.ErrorIs(t, ts.SetMetadata(ctx, `Alloc{__tenant__="team-a"}`, &metrics.Metadata{Unit: "bits"}),
+		metrics.ErrInvalidMetadata)
+
+	metadata, err := ts.GetMetadata(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]*metrics.Metadata{"Alloc": {Unit: "bytes"}}, metadata)
+
+	metadata, err = ts.GetMetadata(ctxA)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]*metrics.Metadata{"Alloc": {Unit: "kilobytes"}}, metadata)
+}
+
+func TestTenantStore_History(t *testing.T) {
+	ts := NewTenantStore(NewInMemoryStoreWithHistory(time.Hour))
+	ctxA := tenant.NewContext(context.Background(), &tenant.Config{Name: "team-a"})
+	require.NoError(t, ts.UpdateGaugeMetric(ctxA, "Alloc", 1))
+
+	history, err := ts.GetHistory(ctxA, "Alloc", metrics.MetricTypeGauge, time.Time{}, time.Now(), 0)
+	require.NoError(t, err)
+	assert.Len(t, history, 1)
+
+	history, err = ts.GetHistory(context.Background(), "Alloc", metrics.MetricTypeGauge, time.Time{}, time.Now(), 0)
+	require.NoError(t, err)
+	assert.Empty(t, history, "history of another tenant must be hidden")
+
+	_, err = NewTenantStore(NewInMemoryStore()).GetHistory(ctxA, "Alloc", metrics.MetricTypeGauge,
+		time.Time{}, time.Now(), 0)
+	assert.ErrorIs(t, err, ErrHistoryDisabled)
+}
@@ -0,0 +1,442 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/itd27m01/go-metrics-service/internal/models/metrics"
+)
+
+var (
+	_ Store         = (*TieredStore)(nil)
+	_ HistoryStore  = (*TieredStore)(nil)
+	_ HistoryPruner = (*TieredStore)(nil)
+)
+
+// TieredStore implements Store interface to serve metrics from memory and write them behind to the back store.
+// Updates are applied to memory at once and the changes of dirty metrics are flushed to the back store in batches.
+// If the back store is unavailable, the changes are kept in memory and retried by the next flush,
+// so they are lost only if the last flush on shutdown fails. Metadata is rarely changed, so it's written through.
+type TieredStore struct {
+	front   *InMemoryStore
+	back    Store
+	pending map[string]*pendingMetric
+	mu      sync.Mutex // guards updates of front store together with pending changes
+	flushMu sync.Mutex // serializes flushes to keep order of changes in back store
+}
+
+// pendingMetric collects changes of metric which aren't written to the back store yet,
+// the changes are applied in order: deletes, reset of counter and then the update
+type pendingMetric struct {
+	deleted []string        // types of metric to delete
+	reset   bool            // counter must be reset
+	metric  *metrics.Metric // accumulated update, nil if there is nothing to update
+}
+
+// NewTieredStore creates tiered store over the back store
+func NewTieredStore(back Store) *TieredStore {
+	ts := TieredStore{
+		front:   NewInMemoryStore(),
+		back:    back,
+		pending: make(map[string]*pendingMetric),
+	}
+
+	return &ts
+}
+
+// LoadMetrics loads metrics and their metadata from the back store to memory
+func (ts *TieredStore) LoadMetrics(ctx context.Context) error {
+	metricsData, err := ts.back.GetMetrics(ctx)
+	if err != nil {
+		return err
+	}
+
+	metadata, err := ts.back.GetMetadata(ctx)
+	if err != nil {
+		return err
+	}
+	for name, md := range metadata {
+		if err := ts.front.SetMetadata(ctx, name, md); err != nil {
+			return err
+		}
+	}
+
+	metricsBatch := make([]*metrics.Metric, 0, len(metricsData))
+	for _, metric := range metricsData {
+		metricsBatch = append(metricsBatch, metric)
+	}
+
+	return ts.front.UpdateMetrics(ctx, metricsBatch)
+}
+
+// UpdateCounterMetric updates counter metric type
+func (ts *TieredStore) UpdateCounterMetric(_ context.Context, metricName string, metricData metrics.Counter) error {
+	return ts.updateMetric(metricName, &metrics.Metric{MType: metrics.MetricTypeCounter, Delta: &metricData})
+}
+
+// ResetCounterMetric resets counter to default zero value
+func (ts *TieredStore) ResetCounterMetric(ctx context.Context, metricName string) error {
+	metricKey, _, _, err := parseMetricKey(metricName)
+	if err != nil {
+		return err
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if err := ts.front.ResetCounterMetric(ctx, metricKey); err != nil {
+		return err
+	}
+	ts.record(metricKey, &pendingMetric{reset: true})
+
+	return nil
+}
+
+// UpdateGaugeMetric updates gauge type metric
+func (ts *TieredStore) UpdateGaugeMetric(_ context.Context, metricName string, metricData metrics.Gauge) error {
+	return ts.updateMetric(metricName, &metrics.Metric{MType: metrics.MetricTypeGauge, Value: &metricData})
+}
+
+// AddGauge adds delta to gauge type metric in memory and returns its new value, the new value is flushed
+func (ts *TieredStore) AddGauge(ctx context.Context, metricName string, delta metrics.Gauge) (metrics.Gauge, error) {
+	metricKey, name, labels, err := parseMetricKey(metricName)
+	if err != nil {
+		return 0, err
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	value, err := ts.front.AddGauge(ctx, metricKey, delta)
+	if err != nil {
+		return 0, err
+	}
+	ts.recordGauge(metricKey, name, labels, value)
+
+	return value, nil
+}
+
+// CompareAndSetGauge sets value of gauge type metric in memory if its current value equals to expected one.
+// Values are compared in memory only, so the back store must not be updated bypassing tiered store.
+func (ts *TieredStore) CompareAndSetGauge(ctx context.Context, metricName string, expected *metrics.Gauge,
+	value metrics.Gauge) error {
+	metricKey, name, labels, err := parseMetricKey(metricName)
+	if err != nil {
+		return err
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if err := ts.front.CompareAndSetGauge(ctx, metricKey, expected, value); err != nil {
+		return err
+	}
+	ts.recordGauge(metricKey, name, labels, value)
+
+	return nil
+}
+
+// recordGauge marks gauge as dirty with its new value, the lock of tiered store must be held
+func (ts *TieredStore) recordGauge(metricKey string, name string, labels metrics.Labels, value metrics.Gauge) {
+	ts.record(metricKey, &pendingMetric{
+		metric: &metrics.Metric{
+			ID:     name,
+			Labels: labels,
+			MType:  metrics.MetricTypeGauge,
+			Value:  &value,
+		},
+	})
+}
+
+// UpdateHistogramMetric merges observations to histogram type metric
+func (ts *TieredStore) UpdateHistogramMetric(_ context.Context, metricName string, metricData *metrics.Histogram) error {
+	return ts.updateMetric(metricName, &metrics.Metric{MType: metrics.MetricTypeHistogram, Histogram: metricData})
+}
+
+// UpdateMetrics update number of metrics, observations of histograms are merged
+func (ts *TieredStore) UpdateMetrics(_ context.Context, metricsBatch []*metrics.Metric) error {
+	metricKeys := make([]string, 0, len(metricsBatch))
+	for _, metric := range metricsBatch {
+		metricKeys = append(metricKeys, metric.Key())
+	}
+
+	return ts.updateKeyedMetrics(metricKeys, metricsBatch)
+}
+
+// updateMetric updates metric by name, the name and labels of metric are taken from the name
+func (ts *TieredStore) updateMetric(metricName string, metric *metrics.Metric) error {
+	metricKey, name, labels, err := parseMetricKey(metricName)
+	if err != nil {
+		return err
+	}
+	metric.ID, metric.Labels = name, labels
+
+	return ts.updateKeyedMetrics([]string{metricKey}, []*metrics.Metric{metric})
+}
+
+// updateKeyedMetrics updates number of metrics by their keys in memory and marks them as dirty
+func (ts *TieredStore) updateKeyedMetrics(metricKeys []string, metricsBatch []*metrics.Metric) error {
+	if err := validateMetrics(metricsBatch); err != nil {
+		return err
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.front.lock.Lock()
+	defer ts.front.lock.Unlock()
+
+	for i, metric := range metricsBatch {
+		if err := ts.front.updateMetric(metricKeys[i], metric); err != nil {
+			return err
+		}
+		ts.record(metricKeys[i], &pendingMetric{metric: metric.Copy()})
+	}
+
+	return nil
+}
+
+// GetMetric return copy of metric by name
+func (ts *TieredStore) GetMetric(ctx context.Context, metricName string, metricType string) (*metrics.Metric, error) {
+	return ts.front.GetMetric(ctx, metricName, metricType)
+}
+
+// GetMetrics returns copies of all stored metrics which satisfy label matchers
+func (ts *TieredStore) GetMetrics(ctx context.Context, matchers ...*metrics.Matcher) (map[string]*metrics.Metric, error) {
+	return ts.front.GetMetrics(ctx, matchers...)
+}
+
+// ListMetrics returns the page of copies of metrics in memory which satisfy the filter
+func (ts *TieredStore) ListMetrics(ctx context.Context, filter *ListFilter) (*MetricsPage, error) {
+	return ts.front.ListMetrics(ctx, filter)
+}
+
+// Watch returns change feed of metrics in memory which satisfy the filter
+func (ts *TieredStore) Watch(ctx context.Context, filter *WatchFilter) (<-chan *Event, error) {
+	return ts.front.Watch(ctx, filter)
+}
+
+// DeleteMetric removes metric and its history
+func (ts *TieredStore) DeleteMetric(ctx context.Context, metricName string, metricType string) error {
+	metricKey, _, _, err := parseMetricKey(metricName)
+	if err != nil {
+		return err
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if err := ts.front.DeleteMetric(ctx, metricKey, metricType); err != nil {
+		return err
+	}
+	ts.record(metricKey, &pendingMetric{deleted: []string{metricType}})
+
+	return nil
+}
+
+// DeleteMetrics removes all of metrics which satisfy label matchers and returns their number
+func (ts *TieredStore) DeleteMetrics(_ context.Context, matchers ...*metrics.Matcher) (int, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	return ts.recordDeleted(ts.front.deleteMetrics(matchers)), nil
+}
+
+// DeleteStaleMetrics removes metrics which aren't updated for their TTL and returns their number,
+// metrics loaded from the back store are aged from the moment of load
+func (ts *TieredStore) DeleteStaleMetrics(_ context.Context, ttl *TTL, now time.Time) (int, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	return ts.recordDeleted(ts.front.deleteStaleMetrics(ttl, now)), nil
+}
+
+// SetMetadata sets metadata of metric by its name in the back store and then in memory
+func (ts *TieredStore) SetMetadata(ctx context.Context, metricName string, metadata *metrics.Metadata) error {
+	if err := validateMetadata(metricName, metadata); err != nil {
+		return err
+	}
+
+	if err := ts.back.SetMetadata(ctx, metricName, metadata); err != nil {
+		return err
+	}
+
+	return ts.front.SetMetadata(ctx, metricName, metadata)
+}
+
+// GetMetadata returns copies of metadata of all metrics by their names
+func (ts *TieredStore) GetMetadata(ctx context.Context) (map[string]*metrics.Metadata, error) {
+	return ts.front.GetMetadata(ctx)
+}
+
+// Ping checks that the back store is alive
+func (ts *TieredStore) Ping(ctx context.Context) error {
+	return ts.back.Ping(ctx)
+}
+
+// AppendSamples adds timestamped samples to the history of metric in the back store
+func (ts *TieredStore) AppendSamples(ctx context.Context, metricName string, metricType string,
+	samples ...metrics.Sample) error {
+	historyStore, ok := ts.back.(HistoryStore)
+	if !ok {
+		return ErrHistoryDisabled
+	}
+
+	return historyStore.AppendSamples(ctx, metricName, metricType, samples...)
+}
+
+// GetHistory returns samples of metric from the back store in the [from, to] range downsampled by step,
+// the history is recorded by flushes, so it doesn't include changes which aren't flushed yet
+func (ts *TieredStore) GetHistory(ctx context.Context, metricName string, metricType string,
+	from time.Time, to time.Time, step time.Duration) ([]metrics.Sample, error) {
+	historyStore, ok := ts.back.(HistoryStore)
+	if !ok {
+		return nil, ErrHistoryDisabled
+	}
+
+	return historyStore.GetHistory(ctx, metricName, metricType, from, to, step)
+}
+
+// PruneHistory drops expired samples of history in the back store and returns their number
+func (ts *TieredStore) PruneHistory(ctx context.Context, now time.Time) (int, error) {
+	historyPruner, ok := ts.back.(HistoryPruner)
+	if !ok {
+		return 0, nil
+	}
+
+	return historyPruner.PruneHistory(ctx, now)
+}
+
+// Flush writes changes of dirty metrics to the back store. If the back store fails,
+// the changes which aren't written are kept to be retried by the next flush and the error is returned.
+func (ts *TieredStore) Flush(ctx context.Context) error {
+	ts.flushMu.Lock()
+	defer ts.flushMu.Unlock()
+
+	ts.mu.Lock()
+	pending := ts.pending
+	ts.pending = make(map[string]*pendingMetric)
+	ts.mu.Unlock()
+
+	err := ts.writeBack(ctx, pending)
+	if err == nil {
+		return nil
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	for metricKey, p := range pending {
+		if len(p.deleted) == 0 && !p.reset && p.metric == nil {
+			continue
+		}
+
+		if newer, ok := ts.pending[metricKey]; ok {
+			p.merge(newer)
+		}
+		ts.pending[metricKey] = p
+	}
+
+	return err
+}
+
+// writeBack writes pending changes to the back store, the changes are removed from pending as they are written
+func (ts *TieredStore) writeBack(ctx context.Context, pending map[string]*pendingMetric) error {
+	metricsBatch := make([]*metrics.Metric, 0, len(pending))
+	for metricKey, p := range pending {
+		for len(p.deleted) > 0 {
+			err := ts.back.DeleteMetric(ctx, metricKey, p.deleted[0])
+			if err != nil && !errors.Is(err, ErrMetricNotFound) && !errors.Is(err, ErrMetricTypeMismatch) {
+				return err
+			}
+			p.deleted = p.deleted[1:]
+		}
+
+		if p.reset {
+			if err := ts.back.ResetCounterMetric(ctx, metricKey); err != nil {
+				return err
+			}
+			p.reset = false
+		}
+
+		if p.metric != nil {
+			metricsBatch = append(metricsBatch, p.metric)
+		}
+	}
+
+	if len(metricsBatch) == 0 {
+		return nil
+	}
+
+	if err := ts.back.UpdateMetrics(ctx, metricsBatch); err != nil {
+		return err
+	}
+	for _, p := range pending {
+		p.metric = nil
+	}
+
+	return nil
+}
+
+// record adds change of metric to pending changes, the lock of store must be held
+func (ts *TieredStore) record(metricKey string, change *pendingMetric) {
+	p, ok := ts.pending[metricKey]
+	if !ok {
+		ts.pending[metricKey] = change
+
+		return
+	}
+
+	p.merge(change)
+}
+
+// recordDeleted adds deletes of metrics by their keys to pending changes and returns their number,
+// the lock of store must be held
+func (ts *TieredStore) recordDeleted(deleted map[string]*metrics.Metric) int {
+	for metricKey, metric := range deleted {
+		ts.record(metricKey, &pendingMetric{deleted: []string{metric.MType}})
+	}
+
+	return len(deleted)
+}
+
+// merge applies newer changes of metric over the pending ones
+func (p *pendingMetric) merge(newer *pendingMetric) {
+	switch {
+	case len(newer.deleted) > 0:
+		for _, metricType := range newer.deleted {
+			if !containsString(p.deleted, metricType) {
+				p.deleted = append(p.deleted, metricType)
+			}
+		}
+		p.reset, p.metric = newer.reset, newer.metric
+	case newer.reset:
+		p.reset, p.metric = true, newer.metric
+	case p.metric == nil:
+		p.metric = newer.metric
+	case newer.metric == nil:
+	case newer.metric.MType == metrics.MetricTypeCounter:
+		delta := *(p.metric.Delta) + *(newer.metric.Delta)
+		p.metric.Delta = &delta
+	case newer.metric.MType == metrics.MetricTypeHistogram:
+		// Buckets of histogram can't change without delete, the memory store has already merged the same observations
+		if err := p.metric.Histogram.Merge(newer.metric.Histogram); err != nil {
+			p.metric = newer.metric
+		}
+	default:
+		p.metric = newer.metric
+	}
+}
+
+// containsString checks if the slice contains the string
+func containsString(slice []string, s string) bool {
+	for _, v := range slice {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,180 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/itd27m01/go-metrics-service/internal/models/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errTestUnavailable = errors.New("store is unavailable")
+
+// unavailableStore is memory store which fails every write while it is unavailable
+type unavailableStore struct {
+	*InMemoryStore
+	unavailable bool
+}
+
+func (s *unavailableStore) ResetCounterMetric(ctx context.Context, metricName string) error {
+	if s.unavailable {
+		return errTestUnavailable
+	}
+
+	return s.InMemoryStore.ResetCounterMetric(ctx, metricName)
+}
+
+func (s *unavailableStore) UpdateMetrics(ctx context.Context, metricsBatch []*metrics.Metric) error {
+	if s.unavailable {
+		return errTestUnavailable
+	}
+
+	return s.InMemoryStore.UpdateMetrics(ctx, metricsBatch)
+}
+
+func (s *unavailableStore) DeleteMetric(ctx context.Context, metricName string, metricType string) error {
+	if s.unavailable {
+		return errTestUnavailable
+	}
+
+	return s.InMemoryStore.DeleteMetric(ctx, metricName, metricType)
+}
+
+func TestTieredStore_Flush(t *testing.T) {
+	ctx := context.Background()
+	back := NewInMemoryStore()
+	require.NoError(t, back.UpdateGaugeMetric(ctx, "Alloc", 1))
+	require.NoError(t, back.UpdateCounterMetric(ctx, "PollCount", 10))
+	require.NoError(t, back.UpdateGaugeMetric(ctx, "Stale", 1))
+
+	ts := NewTieredStore(back)
+	require.NoError(t, ts.LoadMetrics(ctx))
+
+	histogram := metrics.NewHistogram(1, 2)
+	histogram.Observe(1)
+	require.NoError(t, ts.UpdateCounterMetric(ctx, "PollCount", 1))
+	require.NoError(t, ts.UpdateCounterMetric(ctx, "PollCount", 2))
+	require.NoError(t, ts.UpdateGaugeMetric(ctx, "Alloc", 2))
+	require.NoError(t, ts.UpdateGaugeMetric(ctx, "Alloc", 3))
+	require.NoError(t, ts.UpdateHistogramMetric(ctx, `Latency{handler="update"}`, histogram))
+	require.NoError(t, ts.UpdateMetrics(ctx, []*metrics.Metric{
+		{ID: "Latency", Labels: metrics.Labels{"handler": "update"}, MType: metrics.MetricTypeHistogram, Histogram: histogram},
+	}))
+	require.NoError(t, ts.DeleteMetric(ctx, "Stale", metrics.MetricTypeGauge))
+	require.NoError(t, ts.UpdateCounterMetric(ctx, "Reset", 5))
+	require.NoError(t, ts.ResetCounterMetric(ctx, "Reset"))
+	require.NoError(t, ts.UpdateCounterMetric(ctx, "Reset", 1))
+	assert.ErrorIs(t, ts.UpdateGaugeMetric(ctx, "PollCount", 1), ErrMetricTypeMismatch)
+
+	pollCount, err := ts.GetMetric(ctx, "PollCount", metrics.MetricTypeCounter)
+	require.NoError(t, err)
+	assert.Equal(t, metrics.Counter(13), *pollCount.Delta, "updates must be served from memory")
+	pollCount, err = back.GetMetric(ctx, "PollCount", metrics.MetricTypeCounter)
+	require.NoError(t, err)
+	assert.Equal(t, metrics.Counter(10), *pollCount.Delta, "updates must not be written before flush")
+
+	require.NoError(t, ts.Flush(ctx))
+	assert.Empty(t, ts.pending)
+
+	want, err := ts.GetMetrics(ctx)
+	require.NoError(t, err)
+	got, err := back.GetMetrics(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+	assert.Equal(t, metrics.Counter(13), *got["PollCount"].Delta)
+	assert.Equal(t, metrics.Counter(1), *got["Reset"].Delta)
+	assert.Equal(t, metrics.Counter(2), got[`Latency{handler="update"}`].Histogram.Count)
+	assert.NotContains(t, got, "Stale")
+
+	require.NoError(t, ts.Flush(ctx), "flush without changes")
+	got, err = back.GetMetrics(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, want, got, "changes must be written once")
+}
+
+func TestTieredStore_FlushUnavailable(t *testing.T) {
+	ctx := context.Background()
+	back := &unavailableStore{InMemoryStore: NewInMemoryStore()}
+	ts := NewTieredStore(back)
+
+	require.NoError(t, ts.UpdateCounterMetric(ctx, "PollCount", 1))
+	require.NoError(t, ts.UpdateCounterMetric(ctx, "Deleted", 1))
+	require.NoError(t, ts.Flush(ctx))
+
+	back.unavailable = true
+	require.NoError(t, ts.UpdateCounterMetric(ctx, "PollCount", 2))
+	require.NoError(t, ts.ResetCounterMetric(ctx, "Deleted"))
+	require.NoError(t, ts.DeleteMetric(ctx, "Deleted", metrics.MetricTypeCounter))
+	assert.ErrorIs(t, ts.Flush(ctx), errTestUnavailable)
+
+	pollCount, err := ts.GetMetric(ctx, "PollCount", metrics.MetricTypeCounter)
+	require.NoError(t, err)
+	assert.Equal(t, metrics.Counter(3), *pollCount.Delta, "store must be served while back store is unavailable")
+
+	require.NoError(t, ts.UpdateCounterMetric(ctx, "PollCount", 4))
+	require.NoError(t, ts.UpdateCounterMetric(ctx, "Deleted", 7))
+	assert.ErrorIs(t, ts.Flush(ctx), errTestUnavailable)
+
+	back.unavailable = false
+	require.NoError(t, ts.Flush(ctx))
+	assert.Empty(t, ts.pending)
+
+	want, err := ts.GetMetrics(ctx)
+	require.NoError(t, err)
+	got, err := back.GetMetrics(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+	assert.Equal(t, metrics.Counter(7), *got["PollCount"].Delta, "counter increments must be written once")
+	assert.Equal(t, metrics.Counter(7), *got["Deleted"].Delta, "metric must be deleted before it is updated")
+}
+
+func TestPendingMetric_merge(t *testing.T) {
+	delta := func(v metrics.Counter) *metrics.Metric {
+		return &metrics.Metric{ID: "PollCount", MType: metrics.MetricTypeCounter, Delta: &v}
+	}
+	tests := []struct {
+		name    string
+		pending *pendingMetric
+		newer   *pendingMetric
+		want    *pendingMetric
+	}{
+		{
+			name:    "Counter deltas are summed",
+			pending: &pendingMetric{metric: delta(1)},
+			newer:   &pendingMetric{metric: delta(2)},
+			want:    &pendingMetric{metric: delta(3)},
+		},
+		{
+			name:    "Reset drops previous deltas",
+			pending: &pendingMetric{metric: delta(1)},
+			newer:   &pendingMetric{reset: true},
+			want:    &pendingMetric{reset: true},
+		},
+		{
+			name:    "Delta after reset",
+			pending: &pendingMetric{reset: true},
+			newer:   &pendingMetric{metric: delta(2)},
+			want:    &pendingMetric{reset: true, metric: delta(2)},
+		},
+		{
+			name:    "Delete drops previous changes",
+			pending: &pendingMetric{reset: true, metric: delta(1)},
+			newer:   &pendingMetric{deleted: []string{metrics.MetricTypeCounter}},
+			want:    &pendingMetric{deleted: []string{metrics.MetricTypeCounter}},
+		},
+		{
+			name:    "Deletes are kept",
+			pending: &pendingMetric{deleted: []string{metrics.MetricTypeCounter}, metric: delta(1)},
+			newer:   &pendingMetric{deleted: []string{metrics.MetricTypeGauge}, metric: delta(2)},
+			want:    &pendingMetric{deleted: []string{metrics.MetricTypeCounter, metrics.MetricTypeGauge}, metric: delta(2)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.pending.merge(tt.newer)
+			assert.Equal(t, tt.want, tt.pending)
+		})
+	}
+}
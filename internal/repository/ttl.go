@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"strings"
+	"time"
+
+	"github.com/itd27m01/go-metrics-service/internal/models/metrics"
+)
+
+// TTL defines how long metrics live after the last update before they are stale.
+// TTL of the longest prefix of metric name overrides the default one, zero TTL means that metrics never expire.
+type TTL struct {
+	Default   time.Duration
+	Overrides map[string]time.Duration
+}
+
+// Enabled checks if any of metrics can expire
+func (t *TTL) Enabled() bool {
+	if t == nil {
+		return false
+	}
+	if t.Default > 0 {
+		return true
+	}
+
+	for _, ttl := range t.Overrides {
+		if ttl > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Of returns TTL of metric by its name
+func (t *TTL) Of(metricName string) time.Duration {
+	if t == nil {
+		return 0
+	}
+
+	ttl, matched := t.Default, -1
+	for prefix, prefixTTL := range t.Overrides {
+		if len(prefix) > matched && strings.HasPrefix(metricName, prefix) {
+			ttl, matched = prefixTTL, len(prefix)
+		}
+	}
+
+	return ttl
+}
+
+// Expired checks if metric updated at the moment is stale at now
+func (t *TTL) Expired(metricName string, updatedAt time.Time, now time.Time) bool {
+	ttl := t.Of(metricName)
+
+	return ttl > 0 && !updatedAt.Add(ttl).After(now)
+}
+
+// updateTimes keeps moments of the last updates of metrics by their keys, zero value is ready to use
+type updateTimes struct {
+	times map[string]time.Time
+}
+
+// touch marks metric as updated at the moment
+func (u *updateTimes) touch(metricKey string, updatedAt time.Time) {
+	if u.times == nil {
+		u.times = make(map[string]time.Time)
+	}
+
+	u.times[metricKey] = updatedAt
+}
+
+// forget drops the moment of the last update of metric
+func (u *updateTimes) forget(metricKey string) {
+	delete(u.times, metricKey)
+}
+
+// stale returns keys of metrics which are expired at now, metrics which were never touched are not stale
+func (u *updateTimes) stale(metricsCache map[string]*metrics.Metric, ttl *TTL, now time.Time) []string {
+	staleKeys := make([]string, 0)
+	for metricKey, metric := range metricsCache {
+		updatedAt, ok := u.times[metricKey]
+		if ok && ttl.Expired(metric.ID, updatedAt, now) {
+			staleKeys = append(staleKeys, metricKey)
+		}
+	}
+
+	return staleKeys
+}
@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/itd27m01/go-metrics-service/internal/models/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTTL_Of(t *testing.T) {
+	ttl := &TTL{
+		Default: time.Hour,
+		Overrides: map[string]time.Duration{
+			"CPU":            time.Minute,
+			"CPUutilization": 10 * time.Minute,
+			"PollCount":      0,
+		},
+	}
+
+	tests := []struct {
+		name       string
+		ttl        *TTL
+		metricName string
+		want       time.Duration
+	}{
+		{name: "Default TTL", ttl: ttl, metricName: "Alloc", want: time.Hour},
+		{name: "TTL of prefix", ttl: ttl, metricName: "CPUthrottled", want: time.Minute},
+		{name: "TTL of the longest prefix", ttl: ttl, metricName: "CPUutilization1", want: 10 * time.Minute},
+		{name: "Zero TTL of prefix", ttl: ttl, metricName: "PollCount", want: 0},
+		{name: "Nil TTL", ttl: nil, metricName: "Alloc", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.ttl.Of(tt.metricName))
+		})
+	}
+}
+
+func TestTTL_Enabled(t *testing.T) {
+	assert.False(t, (*TTL)(nil).Enabled())
+	assert.False(t, (&TTL{Overrides: map[string]time.Duration{"CPU": 0}}).Enabled())
+	assert.True(t, (&TTL{Overrides: map[string]time.Duration{"CPU": time.Minute}}).Enabled())
+	assert.True(t, (&TTL{Default: time.Minute}).Enabled())
+}
+
+func TestInMemoryStore_DeleteStaleMetrics(t *testing.T) {
+	m := NewInMemoryStore()
+	ctx := context.Background()
+	ttl := &TTL{
+		Default:   time.Minute,
+		Overrides: map[string]time.Duration{"PollCount": 0},
+	}
+
+	require.NoError(t, m.UpdateGaugeMetric(ctx, `Alloc{host="gone"}`, 1))
+	require.NoError(t, m.UpdateCounterMetric(ctx, "PollCount", 1))
+
+	deleted, err := m.DeleteStaleMetrics(ctx, ttl, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 0, deleted, "fresh metrics must be kept")
+
+	deleted, err = m.DeleteStaleMetrics(ctx, ttl, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+
+	metricsData, err := m.GetMetrics(ctx)
+	require.NoError(t, err)
+	assert.Len(t, metricsData, 1)
+	assert.Contains(t, metricsData, "PollCount")
+}
+
+func TestFileStore_DeleteStaleMetrics(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	ttl := &TTL{
+		Default:   time.Minute,
+		Overrides: map[string]time.Duration{"Alloc": 0},
+	}
+
+	fs := newTestWALFileStore(t, dir)
+	require.NoError(t, fs.UpdateGaugeMetric(ctx, "Alloc", 1))
+	require.NoError(t, fs.UpdateGaugeMetric(ctx, "Stale", 1))
+	require.NoError(t, fs.SaveMetrics())
+	crashTestFileStore(t, fs)
+
+	// Loaded metrics are considered as updated at the moment of load
+	fs = newTestWALFileStore(t, dir)
+	loadedAt := time.Now()
+	deleted, err := fs.DeleteStaleMetrics(ctx, ttl, loadedAt)
+	require.NoError(t, err)
+	assert.Equal(t, 0, deleted)
+
+	require.NoError(t, fs.UpdateGaugeMetric(ctx, "Alloc", 2))
+	deleted, err = fs.DeleteStaleMetrics(ctx, ttl, loadedAt.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	crashTestFileStore(t, fs)
+
+	fs = newTestWALFileStore(t, dir)
+	_, err = fs.GetMetric(ctx, "Stale", metrics.MetricTypeGauge)
+	assert.ErrorIs(t, err, ErrMetricNotFound, "eviction must be replayed from write-ahead log")
+	alloc, err := fs.GetMetric(ctx, "Alloc", metrics.MetricTypeGauge)
+	require.NoError(t, err)
+	assert.Equal(t, metrics.Gauge(2), *alloc.Value)
+	crashTestFileStore(t, fs)
+}
@@ -0,0 +1,220 @@
+package repository
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+
+	"github.com/itd27m01/go-metrics-service/internal/models/metrics"
+	"github.com/itd27m01/go-metrics-service/pkg/logging/log"
+)
+
+const (
+	walHeaderSize    = 8
+	walMaxRecordSize = 64 << 20
+)
+
+// ErrTornRecord means that the record of write-ahead log is written partially or corrupted
+var ErrTornRecord = errors.New("torn record of write-ahead log")
+
+var walTable = crc32.MakeTable(crc32.Castagnoli)
+
+// writeAheadLog is an append-only log of updated and deleted metrics and of set metadata.
+// Every record is a length and a checksum of payload followed by JSON of the record.
+// Records keep the resulting values of metrics, so replay of the log is idempotent.
+type writeAheadLog struct {
+	file *os.File
+}
+
+// walRecord is a payload of the log record, records of the first version of log are plain JSON lists of metrics
+type walRecord struct {
+	Metrics  []*metrics.Metric            `json:"metrics,omitempty"`
+	Deleted  []string                     `json:"deleted,omitempty"`
+	Metadata map[string]*metrics.Metadata `json:"metadata,omitempty"` // null metadata is removed
+}
+
+// openWriteAheadLog opens or creates log for appending records
+func openWriteAheadLog(walPath string) (*writeAheadLog, error) {
+	file, err := os.OpenFile(walPath, os.O_RDWR|os.O_CREATE|os.O_APPEND, fileMode)
+	if err != nil {
+		return nil, err
+	}
+
+	return &writeAheadLog{file: file}, nil
+}
+
+// append writes metrics to the log as a single record, it does nothing for disabled log
+func (w *writeAheadLog) append(metricsBatch ...*metrics.Metric) error {
+	if w == nil || len(metricsBatch) == 0 {
+		return nil
+	}
+
+	return w.write(&walRecord{Metrics: metricsBatch})
+}
+
+// appendDeleted writes keys of deleted metrics to the log as a single record, it does nothing for disabled log
+func (w *writeAheadLog) appendDeleted(metricKeys ...string) error {
+	if w == nil || len(metricKeys) == 0 {
+		return nil
+	}
+
+	return w.write(&walRecord{Deleted: metricKeys})
+}
+
+// appendMetadata writes metadata of metric to the log as a single record, it does nothing for disabled log
+func (w *writeAheadLog) appendMetadata(name string, metadata *metrics.Metadata) error {
+	if w == nil {
+		return nil
+	}
+	if metadata.IsEmpty() {
+		metadata = nil
+	}
+
+	return w.write(&walRecord{Metadata: map[string]*metrics.Metadata{name: metadata}})
+}
+
+// write writes the record to the log and syncs it to disk, the record is dropped if it isn't written completely,
+// so the next records aren't lost behind the torn one on replay
+func (w *writeAheadLog) write(walRecord *walRecord) error {
+	payload, err := json.Marshal(walRecord)
+	if err != nil {
+		return err
+	}
+
+	record := make([]byte, walHeaderSize, walHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(record[:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:walHeaderSize], crc32.Checksum(payload, walTable))
+	record = append(record, payload...)
+
+	info, err := w.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if _, err = w.file.Write(record); err == nil {
+		err = w.file.Sync()
+	}
+	if err != nil {
+		if err := w.file.Truncate(info.Size()); err != nil {
+			log.Error().Err(err).Msgf("Failed to drop torn record of write-ahead log %s", w.file.Name())
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// replay applies records of the log to metrics and metadata, the torn tail of log is dropped
+func (w *writeAheadLog) replay(metricsCache map[string]*metrics.Metric, metadata *metadataRegistry) error {
+	info, err := w.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	reader := io.NewSectionReader(w.file, 0, info.Size())
+
+	var offset int64
+	var records int
+	for {
+		record, size, err := readRecord(reader)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if errors.Is(err, ErrTornRecord) {
+			log.Error().Err(err).Msgf("Drop tail of write-ahead log %s from offset %d", w.file.Name(), offset)
+
+			return w.file.Truncate(offset)
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, metric := range record.Metrics {
+			metricsCache[metric.Key()] = metric
+		}
+		for _, metricKey := range record.Deleted {
+			delete(metricsCache, metricKey)
+		}
+		for name, md := range record.Metadata {
+			metadata.set(name, md)
+		}
+
+		offset += size
+		records++
+	}
+
+	log.Info().Msgf("Replay %d records of write-ahead log %s", records, w.file.Name())
+
+	return nil
+}
+
+// readRecord reads the next record of log and returns it with its size
+func readRecord(reader io.Reader) (*walRecord, int64, error) {
+	header := make([]byte, walHeaderSize)
+	_, err := io.ReadFull(reader, header)
+	switch {
+	case errors.Is(err, io.EOF):
+		return nil, 0, io.EOF
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return nil, 0, fmt.Errorf("%w: partial header", ErrTornRecord)
+	case err != nil:
+		return nil, 0, err
+	}
+
+	size := binary.BigEndian.Uint32(header[:4])
+	if size > walMaxRecordSize {
+		return nil, 0, fmt.Errorf("%w: record size %d is too big", ErrTornRecord, size)
+	}
+
+	payload := make([]byte, size)
+	_, err = io.ReadFull(reader, payload)
+	switch {
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return nil, 0, fmt.Errorf("%w: partial payload", ErrTornRecord)
+	case err != nil:
+		return nil, 0, err
+	}
+
+	if crc32.Checksum(payload, walTable) != binary.BigEndian.Uint32(header[4:walHeaderSize]) {
+		return nil, 0, fmt.Errorf("%w: checksum mismatch", ErrTornRecord)
+	}
+
+	var record walRecord
+	if len(payload) > 0 && payload[0] == '[' {
+		err = json.Unmarshal(payload, &record.Metrics)
+	} else {
+		err = json.Unmarshal(payload, &record)
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %s", ErrTornRecord, err)
+	}
+
+	return &record, int64(walHeaderSize + size), nil
+}
+
+// truncate drops all of records after the metrics are saved to snapshot
+func (w *writeAheadLog) truncate() error {
+	if w == nil {
+		return nil
+	}
+
+	return w.file.Truncate(0)
+}
+
+// close syncs and closes file of log
+func (w *writeAheadLog) close() error {
+	if w == nil {
+		return nil
+	}
+
+	if err := w.file.Sync(); err != nil {
+		log.Error().Err(err).Msg("Failed to sync write-ahead log")
+	}
+
+	return w.file.Close()
+}
@@ -0,0 +1,270 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itd27m01/go-metrics-service/internal/models/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestSyncChannel makes sync channel of file store which is drained until the end of test
+func newTestSyncChannel(t *testing.T) chan struct{} {
+	t.Helper()
+
+	syncChannel := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-syncChannel:
+			case <-done:
+				return
+			}
+		}
+	}()
+	t.Cleanup(func() { close(done) })
+
+	return syncChannel
+}
+
+// newTestWALFileStore opens file store with write-ahead log in the directory and loads metrics
+func newTestWALFileStore(t *testing.T, dir string) *FileStore {
+	t.Helper()
+
+	fs, err := NewFileStore(filepath.Join(dir, "metrics.json"), newTestSyncChannel(t), 0)
+	require.NoError(t, err)
+	require.NoError(t, fs.EnableWAL(filepath.Join(dir, "metrics.json.wal")))
+	require.NoError(t, fs.LoadMetrics())
+
+	return fs
+}
+
+// crashTestFileStore closes files of store without saving metrics
+func crashTestFileStore(t *testing.T, fs *FileStore) {
+	t.Helper()
+
+	require.NoError(t, fs.wal.file.Close())
+	require.NoError(t, fs.file.Close())
+}
+
+func walSize(t *testing.T, dir string) int64 {
+	t.Helper()
+
+	info, err := os.Stat(filepath.Join(dir, "metrics.json.wal"))
+	require.NoError(t, err)
+
+	return info.Size()
+}
+
+func TestFileStore_WALReplay(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	fs := newTestWALFileStore(t, dir)
+	require.NoError(t, fs.UpdateGaugeMetric(ctx, "Alloc", 1))
+	require.NoError(t, fs.UpdateCounterMetric(ctx, "PollCount", 2))
+	require.NoError(t, fs.UpdateCounterMetric(ctx, "PollCount", 3))
+
+	delta := metrics.Counter(5)
+	histogram := metrics.NewHistogram(1)
+	histogram.Observe(0.5)
+	require.NoError(t, fs.UpdateMetrics(ctx, []*metrics.Metric{
+		{ID: "PollCount", MType: metrics.MetricTypeCounter, Delta: &delta, Labels: metrics.Labels{"agent": "1"}},
+		{ID: "Latency", MType: metrics.MetricTypeHistogram, Histogram: histogram},
+	}))
+	require.NoError(t, fs.ResetCounterMetric(ctx, "PollCount"))
+
+	want, err := fs.GetMetrics(ctx)
+	require.NoError(t, err)
+	crashTestFileStore(t, fs)
+
+	fs = newTestWALFileStore(t, dir)
+	got, err := fs.GetMetrics(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+	assert.Equal(t, metrics.Counter(0), *got["PollCount"].Delta)
+	assert.Equal(t, metrics.Counter(5), *got[`PollCount{agent="1"}`].Delta)
+	crashTestFileStore(t, fs)
+}
+
+func TestFileStore_WALReplayDeleted(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	fs := newTestWALFileStore(t, dir)
+	require.NoError(t, fs.UpdateGaugeMetric(ctx, "Aloc", 1))
+	require.NoError(t, fs.UpdateGaugeMetric(ctx, `Decommissioned{host="db1"}`, 1))
+	require.NoError(t, fs.UpdateGaugeMetric(ctx, `Decommissioned{host="db2"}`, 2))
+	require.NoError(t, fs.UpdateCounterMetric(ctx, "PollCount", 1))
+	require.NoError(t, fs.SaveMetrics())
+
+	require.NoError(t, fs.DeleteMetric(ctx, "Aloc", metrics.MetricTypeGauge))
+	deleted, err := fs.DeleteMetrics(ctx, metrics.NewPrefixMatcher("Decommissioned"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+	crashTestFileStore(t, fs)
+
+	fs = newTestWALFileStore(t, dir)
+	got, err := fs.GetMetrics(ctx)
+	require.NoError(t, err)
+	assert.Len(t, got, 1)
+	assert.Contains(t, got, "PollCount")
+	crashTestFileStore(t, fs)
+}
+
+func TestFileStore_WALFailedAppend(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	fs := newTestWALFileStore(t, dir)
+	require.NoError(t, fs.UpdateGaugeMetric(ctx, "Alloc", 1))
+	require.NoError(t, fs.UpdateCounterMetric(ctx, "PollCount", 1))
+	require.NoError(t, fs.SetMetadata(ctx, "Alloc", &metrics.Metadata{Unit: "bytes"}))
+	want, err := fs.GetMetrics(ctx)
+	require.NoError(t, err)
+
+	// writes to the closed log fail like writes to the failed disk
+	require.NoError(t, fs.wal.file.Close())
+
+	gauge, delta := metrics.Gauge(2), metrics.Counter(2)
+	assert.Error(t, fs.UpdateGaugeMetric(ctx, "Alloc", 2))
+	assert.Error(t, fs.UpdateCounterMetric(ctx, "PollCount", 2))
+	assert.Error(t, fs.ResetCounterMetric(ctx, "PollCount"))
+	_, err = fs.AddGauge(ctx, "Alloc", 1)
+	assert.Error(t, err)
+	assert.Error(t, fs.CompareAndSetGauge(ctx, "Alloc", nil, 2))
+	assert.Error(t, fs.UpdateHistogramMetric(ctx, "Latency", metrics.NewHistogram(1)))
+	assert.Error(t, fs.UpdateMetrics(ctx, []*metrics.Metric{
+		{ID: "Alloc", MType: metrics.MetricTypeGauge, Value: &gauge},
+		{ID: "PollCount", MType: metrics.MetricTypeCounter, Delta: &delta},
+	}))
+	assert.Error(t, fs.DeleteMetric(ctx, "Alloc", metrics.MetricTypeGauge))
+	_, err = fs.DeleteMetrics(ctx)
+	assert.Error(t, err)
+	assert.Error(t, fs.SetMetadata(ctx, "Alloc", &metrics.Metadata{Unit: "kilobytes"}))
+
+	got, err := fs.GetMetrics(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, want, got, "updates which aren't logged must not be visible")
+	metadata, err := fs.GetMetadata(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "bytes", metadata["Alloc"].Unit)
+	require.NoError(t, fs.file.Close())
+}
+
+func TestFileStore_WALTornWrite(t *testing.T) {
+	tests := []struct {
+		name      string
+		tear      func(t *testing.T, walPath string, lastRecord int64)
+		wantAlloc metrics.Gauge
+	}{
+		{
+			name: "Partial payload of the last record",
+			tear: func(t *testing.T, walPath string, lastRecord int64) {
+				info, err := os.Stat(walPath)
+				require.NoError(t, err)
+				require.NoError(t, os.Truncate(walPath, info.Size()-3))
+			},
+			wantAlloc: 1,
+		},
+		{
+			name: "Partial header of the last record",
+			tear: func(t *testing.T, walPath string, lastRecord int64) {
+				require.NoError(t, os.Truncate(walPath, lastRecord+walHeaderSize/2))
+			},
+			wantAlloc: 1,
+		},
+		{
+			name: "Corrupted payload of the last record",
+			tear: func(t *testing.T, walPath string, lastRecord int64) {
+				f, err := os.OpenFile(walPath, os.O_RDWR, fileMode)
+				require.NoError(t, err)
+				defer f.Close()
+
+				_, err = f.WriteAt([]byte("X"), lastRecord+walHeaderSize+2)
+				require.NoError(t, err)
+			},
+			wantAlloc: 1,
+		},
+		{
+			name: "Garbage after the last record",
+			tear: func(t *testing.T, walPath string, lastRecord int64) {
+				f, err := os.OpenFile(walPath, os.O_WRONLY|os.O_APPEND, fileMode)
+				require.NoError(t, err)
+				defer f.Close()
+
+				_, err = f.Write([]byte{0xff, 0xff, 0xff, 0xff, 0x00})
+				require.NoError(t, err)
+			},
+			wantAlloc: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			ctx := context.Background()
+
+			fs := newTestWALFileStore(t, dir)
+			require.NoError(t, fs.UpdateCounterMetric(ctx, "PollCount", 1))
+			require.NoError(t, fs.UpdateGaugeMetric(ctx, "Alloc", 1))
+			lastRecord := walSize(t, dir)
+			require.NoError(t, fs.UpdateGaugeMetric(ctx, "Alloc", 2))
+			crashTestFileStore(t, fs)
+
+			tt.tear(t, filepath.Join(dir, "metrics.json.wal"), lastRecord)
+
+			fs = newTestWALFileStore(t, dir)
+			alloc, err := fs.GetMetric(ctx, "Alloc", metrics.MetricTypeGauge)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantAlloc, *alloc.Value)
+			if tt.wantAlloc == 1 {
+				assert.Equal(t, lastRecord, walSize(t, dir), "torn tail must be dropped")
+			}
+
+			pollCount, err := fs.GetMetric(ctx, "PollCount", metrics.MetricTypeCounter)
+			require.NoError(t, err)
+			assert.Equal(t, metrics.Counter(1), *pollCount.Delta)
+
+			// The log is writable after recovery
+			require.NoError(t, fs.UpdateGaugeMetric(ctx, "Alloc", 3))
+			crashTestFileStore(t, fs)
+
+			fs = newTestWALFileStore(t, dir)
+			alloc, err = fs.GetMetric(ctx, "Alloc", metrics.MetricTypeGauge)
+			require.NoError(t, err)
+			assert.Equal(t, metrics.Gauge(3), *alloc.Value)
+			crashTestFileStore(t, fs)
+		})
+	}
+}
+
+func TestFileStore_WALCompaction(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	walPath := filepath.Join(dir, "metrics.json.wal")
+
+	fs := newTestWALFileStore(t, dir)
+	require.NoError(t, fs.UpdateCounterMetric(ctx, "PollCount", 2))
+	require.NoError(t, fs.UpdateCounterMetric(ctx, "PollCount", 3))
+
+	records, err := os.ReadFile(walPath)
+	require.NoError(t, err)
+
+	require.NoError(t, fs.SaveMetrics())
+	assert.Equal(t, int64(0), walSize(t, dir))
+	crashTestFileStore(t, fs)
+
+	// Crash between the snapshot and truncation of log leaves records which are already in snapshot
+	require.NoError(t, os.WriteFile(walPath, records, fileMode))
+
+	fs = newTestWALFileStore(t, dir)
+	pollCount, err := fs.GetMetric(ctx, "PollCount", metrics.MetricTypeCounter)
+	require.NoError(t, err)
+	assert.Equal(t, metrics.Counter(5), *pollCount.Delta, "replay of log must be idempotent")
+	require.NoError(t, fs.Close())
+	assert.Equal(t, int64(0), walSize(t, dir))
+}
@@ -0,0 +1,131 @@
+package repository
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/itd27m01/go-metrics-service/internal/models/metrics"
+)
+
+// Types of events of the change feed of metrics
+const (
+	EventUpdate = "update"
+	EventDelete = "delete"
+)
+
+// watchBufferSize is the number of events which are buffered for watcher, watcher which falls behind is closed
+const watchBufferSize = 256
+
+// WatchFilter selects metrics of the change feed, zero fields don't filter metrics
+type WatchFilter struct {
+	Prefix   string             // Prefix of names of metrics
+	Name     *regexp.Regexp     // Names of metrics
+	Type     string             // Type of metrics
+	Matchers []*metrics.Matcher // Label matchers of metrics
+}
+
+// Event is a change of metric, the metric has its value after update or the last value before delete
+type Event struct {
+	Type   string          `json:"type"`
+	Metric *metrics.Metric `json:"metric"`
+}
+
+// matches checks if metric satisfies the filter, nil filter is satisfied by all of metrics
+func (f *WatchFilter) matches(metric *metrics.Metric) bool {
+	return f == nil ||
+		strings.HasPrefix(metric.ID, f.Prefix) &&
+			(f.Name == nil || f.Name.MatchString(metric.ID)) &&
+			(f.Type == "" || f.Type == metric.MType) &&
+			metrics.MatchesAll(metric, f.Matchers)
+}
+
+// watchHub fans out events of store to its watchers in process
+type watchHub struct {
+	mu       sync.Mutex
+	watchers map[*watcher]struct{}
+}
+
+// watcher receives events of metrics which satisfy its filter
+type watcher struct {
+	filter *WatchFilter
+	events chan *Event
+}
+
+// newWatchHub creates hub without watchers
+func newWatchHub() *watchHub {
+	return &watchHub{watchers: make(map[*watcher]struct{})}
+}
+
+// watch subscribes to events of metrics which satisfy the filter until the context is done.
+// The channel of events is closed when the context is done or the watcher falls behind by watchBufferSize events,
+// so the consumer knows that events are lost and can read the current metrics again.
+func (h *watchHub) watch(ctx context.Context, filter *WatchFilter) <-chan *Event {
+	w := watcher{
+		filter: filter,
+		events: make(chan *Event, watchBufferSize),
+	}
+
+	h.mu.Lock()
+	h.watchers[&w] = struct{}{}
+	h.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		h.close(&w)
+	}()
+
+	return w.events
+}
+
+// publish sends events of changed metrics to watchers, every watcher receives its own copies of metrics.
+// Nil hub has no watchers.
+func (h *watchHub) publish(eventType string, changed ...*metrics.Metric) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for w := range h.watchers {
+		for _, metric := range changed {
+			if !w.filter.matches(metric) {
+				continue
+			}
+
+			select {
+			case w.events <- &Event{Type: eventType, Metric: metric.Copy()}:
+				continue
+			default:
+			}
+
+			h.close(w)
+
+			break
+		}
+	}
+}
+
+// reset closes channels of events of all watchers, e.g. when they miss events
+func (h *watchHub) reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for w := range h.watchers {
+		h.close(w)
+	}
+}
+
+// close removes watcher and closes its channel of events, the lock of hub must be held
+func (h *watchHub) close(w *watcher) {
+	if _, ok := h.watchers[w]; ok {
+		delete(h.watchers, w)
+		close(w.events)
+	}
+}
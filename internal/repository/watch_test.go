@@ -0,0 +1,155 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/itd27m01/go-metrics-service/internal/models/metrics"
+	"github.com/itd27m01/go-metrics-service/internal/tenant"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// gaugeMetric creates gauge metric without labels
+func gaugeMetric(name string, value metrics.Gauge) *metrics.Metric {
+	return &metrics.Metric{ID: name, MType: metrics.MetricTypeGauge, Value: &value}
+}
+
+// nextEvent waits for the next event of the feed, nil is returned if the feed is closed
+func nextEvent(t *testing.T, events <-chan *Event) *Event {
+	t.Helper()
+
+	select {
+	case event, ok := <-events:
+		if !ok {
+			return nil
+		}
+
+		return event
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "no event in time")
+
+		return nil
+	}
+}
+
+func TestStores_Watch(t *testing.T) {
+	stores := testStores(t)
+	stores["DBStore"] = newTestDBStore(t, testDatabaseDSN(t))
+	stores["TenantStore"] = NewTenantStore(NewInMemoryStore())
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(tenant.NewContext(context.Background(), &tenant.Config{Name: "team-a"}))
+			defer cancel()
+
+			events, err := store.Watch(ctx, &WatchFilter{Prefix: "TestWatch"})
+			require.NoError(t, err)
+			gauges, err := store.Watch(ctx, &WatchFilter{
+				Type:     metrics.MetricTypeGauge,
+				Matchers: []*metrics.Matcher{{Type: metrics.MatchEqual, Name: "cpu", Value: "1"}},
+			})
+			require.NoError(t, err)
+
+			delta := metrics.Counter(1)
+			require.NoError(t, store.UpdateGaugeMetric(ctx, "OtherAlloc", 1))
+			require.NoError(t, store.UpdateGaugeMetric(ctx, `TestWatchCPU{cpu="1"}`, 2))
+			require.NoError(t, store.UpdateCounterMetric(ctx, "TestWatchPollCount", 3))
+			require.NoError(t, store.UpdateMetrics(ctx, []*metrics.Metric{
+				{ID: "TestWatchPollCount", MType: metrics.MetricTypeCounter, Delta: &delta},
+			}))
+			require.NoError(t, store.DeleteMetric(ctx, `TestWatchCPU{cpu="1"}`, metrics.MetricTypeGauge))
+
+			event := nextEvent(t, events)
+			require.NotNil(t, event)
+			assert.Equal(t, EventUpdate, event.Type)
+			assert.Equal(t, `TestWatchCPU{cpu="1"}`, event.Metric.Key())
+			assert.Equal(t, metrics.Gauge(2), *event.Metric.Value)
+
+			event = nextEvent(t, events)
+			require.NotNil(t, event)
+			assert.Equal(t, EventUpdate, event.Type)
+			assert.Equal(t, "TestWatchPollCount", event.Metric.Key())
+			assert.Equal(t, metrics.Counter(3), *event.Metric.Delta)
+
+			event = nextEvent(t, events)
+			require.NotNil(t, event)
+			assert.Equal(t, metrics.Counter(4), *event.Metric.Delta, "event must have value after update")
+
+			event = nextEvent(t, events)
+			require.NotNil(t, event)
+			assert.Equal(t, EventDelete, event.Type)
+			assert.Equal(t, `TestWatchCPU{cpu="1"}`, event.Metric.Key())
+			assert.Equal(t, metrics.Gauge(2), *event.Metric.Value, "event must have the last value before delete")
+
+			event = nextEvent(t, gauges)
+			require.NotNil(t, event)
+			assert.Equal(t, EventUpdate, event.Type)
+			assert.Equal(t, `TestWatchCPU{cpu="1"}`, event.Metric.Key())
+			event = nextEvent(t, gauges)
+			require.NotNil(t, event)
+			assert.Equal(t, EventDelete, event.Type)
+
+			cancel()
+			for event := nextEvent(t, events); event != nil; event = nextEvent(t, events) {
+				assert.Fail(t, "unexpected event", "%v", event)
+			}
+		})
+	}
+}
+
+func TestWatchHub_SlowWatcher(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := newWatchHub()
+	slow := hub.watch(ctx, nil)
+	for i := 0; i <= watchBufferSize; i++ {
+		hub.publish(EventUpdate, gaugeMetric("TestWatchSlow", metrics.Gauge(i)))
+	}
+
+	received := 0
+	for range slow {
+		received++
+	}
+	assert.Equal(t, watchBufferSize, received, "feed of watcher which falls behind must be closed")
+
+	fast := hub.watch(ctx, nil)
+	hub.publish(EventUpdate, gaugeMetric("TestWatchSlow", 1))
+	assert.NotNil(t, nextEvent(t, fast), "other watchers must receive events")
+}
+
+func TestNotifyPayloads(t *testing.T) {
+	changed := make([]*metrics.Metric, 0)
+	for i := 0; i < 500; i++ {
+		changed = append(changed, gaugeMetric(fmt.Sprintf("TestNotifyGauge%d", i), metrics.Gauge(i)))
+	}
+	bounds := make([]float64, 0, 2000)
+	for i := 0; i < 2000; i++ {
+		bounds = append(bounds, float64(i))
+	}
+	changed = append(changed, &metrics.Metric{ID: "TestNotifyHistogram", MType: metrics.MetricTypeHistogram,
+		Histogram: metrics.NewHistogram(bounds...)})
+
+	payloads, err := notifyPayloads(EventUpdate, changed)
+	require.NoError(t, err)
+	require.Greater(t, len(payloads), 1)
+
+	decoded := make([]*metrics.Metric, 0, len(changed))
+	for _, payload := range payloads {
+		assert.LessOrEqual(t, len(payload), maxNotifyPayload)
+
+		var n notification
+		require.NoError(t, json.Unmarshal([]byte(payload), &n))
+		assert.Equal(t, EventUpdate, n.Type)
+		decoded = append(decoded, n.Metrics...)
+	}
+
+	require.Len(t, decoded, len(changed))
+	assert.Equal(t, changed[:500], decoded[:500])
+	assert.Equal(t, "TestNotifyHistogram", decoded[500].ID)
+	assert.Nil(t, decoded[500].Histogram, "value of metric which doesn't fit to payload must be dropped")
+}
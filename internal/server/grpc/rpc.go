@@ -1,12 +1,17 @@
 package grpc
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"regexp"
+	"time"
 
 	"github.com/itd27m01/go-metrics-service/internal/models/metrics"
 	pb "github.com/itd27m01/go-metrics-service/internal/proto"
+	"github.com/itd27m01/go-metrics-service/internal/repository"
+	"github.com/itd27m01/go-metrics-service/internal/tenant"
 	"github.com/itd27m01/go-metrics-service/pkg/logging/log"
 )
 
@@ -14,7 +19,6 @@ import (
 func (s *Server) UpdateMetrics(stream pb.Metrics_UpdateMetricsServer) error {
 	log.Info().Msg("GRPC: Start to update metrics")
 
-	var metric metrics.Metric
 	metricsSlice := make([]*metrics.Metric, 0)
 	for {
 		message, err := stream.Recv()
@@ -25,22 +29,34 @@ func (s *Server) UpdateMetrics(stream pb.Metrics_UpdateMetricsServer) error {
 			return err
 		}
 
+		var metric metrics.Metric
+
 		switch message.Metric.Type {
 		case metrics.MetricTypeGauge:
 			gaugeValue := metrics.Gauge(message.Metric.Value)
 			metric = metrics.Metric{
-				ID:    message.Metric.ID,
-				MType: message.Metric.Type,
-				Value: &gaugeValue,
-				Hash:  message.Metric.Hash,
+				ID:     message.Metric.ID,
+				MType:  message.Metric.Type,
+				Value:  &gaugeValue,
+				Hash:   message.Metric.Hash,
+				Labels: message.Metric.Labels,
 			}
 		case metrics.MetricTypeCounter:
 			counterValue := metrics.Counter(message.Metric.Delta)
 			metric = metrics.Metric{
-				ID:    message.Metric.ID,
-				MType: message.Metric.Type,
-				Delta: &counterValue,
-				Hash:  message.Metric.Hash,
+				ID:     message.Metric.ID,
+				MType:  message.Metric.Type,
+				Delta:  &counterValue,
+				Hash:   message.Metric.Hash,
+				Labels: message.Metric.Labels,
+			}
+		case metrics.MetricTypeHistogram:
+			metric = metrics.Metric{
+				ID:        message.Metric.ID,
+				MType:     message.Metric.Type,
+				Histogram: message.Metric.Histogram.ToModel(),
+				Hash:      message.Metric.Hash,
+				Labels:    message.Metric.Labels,
 			}
 		default:
 			err := fmt.Errorf("unknown metric type: %s", message.Metric.Type)
@@ -61,3 +77,166 @@ func (s *Server) UpdateMetrics(stream pb.Metrics_UpdateMetricsServer) error {
 
 	return stream.SendAndClose(&pb.UpdateMetricResponse{Error: "Metrics are updated"})
 }
+
+// GetHistory returns samples of metric in time range
+func (s *Server) GetHistory(ctx context.Context, request *pb.GetHistoryRequest) (*pb.GetHistoryResponse, error) {
+	historyStore, ok := s.metricsStore.(repository.HistoryStore)
+	if !ok {
+		return &pb.GetHistoryResponse{Error: "history of metrics is not supported by storage"}, nil
+	}
+
+	samples, err := historyStore.GetHistory(ctx, request.ID, request.Type,
+		time.Unix(0, request.From), time.Unix(0, request.To), time.Duration(request.Step))
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to get history of metric %s", request.ID)
+		return &pb.GetHistoryResponse{Error: err.Error()}, nil
+	}
+
+	response := pb.GetHistoryResponse{
+		Samples: make([]*pb.Sample, 0, len(samples)),
+	}
+	for _, sample := range samples {
+		pbSample := pb.Sample{
+			Timestamp: sample.Timestamp.UnixNano(),
+			Histogram: pb.NewHistogram(sample.Histogram),
+		}
+		if sample.Delta != nil {
+			pbSample.Delta = int64(*sample.Delta)
+		}
+		if sample.Value != nil {
+			pbSample.Value = float64(*sample.Value)
+		}
+
+		response.Samples = append(response.Samples, &pbSample)
+	}
+
+	return &response, nil
+}
+
+// DeleteMetrics deletes the metric by ID and Type or all of metrics matched by selector and prefix of name
+func (s *Server) DeleteMetrics(ctx context.Context, request *pb.DeleteMetricsRequest) (*pb.DeleteMetricsResponse, error) {
+	if request.ID != "" {
+		if err := s.metricsStore.DeleteMetric(ctx, request.ID, request.Type); err != nil {
+			log.Error().Err(err).Msgf("Failed to delete metric %s", request.ID)
+			return &pb.DeleteMetricsResponse{Error: err.Error()}, nil
+		}
+
+		return &pb.DeleteMetricsResponse{Deleted: 1}, nil
+	}
+
+	matchers, err := metrics.ParseSelector(request.Selector)
+	if err != nil {
+		return &pb.DeleteMetricsResponse{Error: err.Error()}, nil
+	}
+	if request.Prefix != "" {
+		matchers = append(matchers, metrics.NewPrefixMatcher(request.Prefix))
+	}
+	if len(matchers) == 0 {
+		return &pb.DeleteMetricsResponse{Error: "selector or prefix of metrics is required"}, nil
+	}
+
+	deleted, err := s.metricsStore.DeleteMetrics(ctx, matchers...)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to delete metrics %s", request.Selector)
+		return &pb.DeleteMetricsResponse{Error: err.Error()}, nil
+	}
+
+	return &pb.DeleteMetricsResponse{Deleted: int64(deleted)}, nil
+}
+
+// UpdateMetadata sets metadata of metrics by their names, empty metadata removes it
+func (s *Server) UpdateMetadata(ctx context.Context,
+	request *pb.UpdateMetadataRequest) (*pb.UpdateMetadataResponse, error) {
+	for name, metadata := range request.Metadata {
+		if err := s.metricsStore.SetMetadata(ctx, name, metadata.ToModel()); err != nil {
+			log.Error().Err(err).Msgf("Failed to update metadata of metric %s", name)
+			return &pb.UpdateMetadataResponse{Error: err.Error()}, nil
+		}
+	}
+
+	return &pb.UpdateMetadataResponse{}, nil
+}
+
+// GetMetadata returns metadata of all metrics by their names
+func (s *Server) GetMetadata(ctx context.Context, _ *pb.GetMetadataRequest) (*pb.GetMetadataResponse, error) {
+	metadata, err := s.metricsStore.GetMetadata(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get metadata of metrics")
+		return &pb.GetMetadataResponse{Error: err.Error()}, nil
+	}
+
+	return &pb.GetMetadataResponse{Metadata: pb.NewMetadata(metadata)}, nil
+}
+
+// AddGauge adds delta to the gauge and returns its new value
+func (s *Server) AddGauge(ctx context.Context, request *pb.AddGaugeRequest) (*pb.AddGaugeResponse, error) {
+	value, err := s.metricsStore.AddGauge(ctx, metrics.Key(request.ID, request.Labels), metrics.Gauge(request.Delta))
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to add to gauge %s", request.ID)
+		return &pb.AddGaugeResponse{Error: err.Error()}, nil
+	}
+
+	return &pb.AddGaugeResponse{Value: float64(value)}, nil
+}
+
+// CompareAndSetGauge sets value of the gauge if it has the expected value, the mismatch is reported as conflict
+func (s *Server) CompareAndSetGauge(ctx context.Context,
+	request *pb.CompareAndSetGaugeRequest) (*pb.CompareAndSetGaugeResponse, error) {
+	var expected *metrics.Gauge
+	if !request.Absent {
+		expectedValue := metrics.Gauge(request.Expected)
+		expected = &expectedValue
+	}
+
+	err := s.metricsStore.CompareAndSetGauge(ctx, metrics.Key(request.ID, request.Labels), expected,
+		metrics.Gauge(request.Value))
+	switch {
+	case errors.Is(err, repository.ErrGaugeConflict):
+		return &pb.CompareAndSetGaugeResponse{Conflict: true, Error: err.Error()}, nil
+	case err != nil:
+		log.Error().Err(err).Msgf("Failed to compare and set gauge %s", request.ID)
+		return &pb.CompareAndSetGaugeResponse{Error: err.Error()}, nil
+	}
+
+	return &pb.CompareAndSetGaugeResponse{}, nil
+}
+
+// ListMetrics returns the page of metrics selected by filter of request
+func (s *Server) ListMetrics(ctx context.Context, request *pb.ListMetricsRequest) (*pb.ListMetricsResponse, error) {
+	matchers, err := metrics.ParseSelector(request.Selector)
+	if err != nil {
+		return &pb.ListMetricsResponse{Error: err.Error()}, nil
+	}
+
+	filter := repository.ListFilter{
+		Prefix:     request.Prefix,
+		Type:       request.Type,
+		Matchers:   matchers,
+		Descending: request.Descending,
+		Limit:      int(request.Limit),
+		Cursor:     request.Cursor,
+	}
+	if request.Name != "" {
+		if filter.Name, err = regexp.Compile("^(?:" + request.Name + ")$"); err != nil {
+			return &pb.ListMetricsResponse{Error: err.Error()}, nil
+		}
+	}
+
+	page, err := s.metricsStore.ListMetrics(ctx, &filter)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list metrics")
+		return &pb.ListMetricsResponse{Error: err.Error()}, nil
+	}
+
+	response := pb.ListMetricsResponse{
+		Metrics:    make([]*pb.Metric, 0, len(page.Metrics)),
+		NextCursor: page.NextCursor,
+	}
+	signKey := tenant.SignKey(ctx, s.SignKey)
+	for _, metric := range page.Metrics {
+		metric.SetHash(signKey)
+		response.Metrics = append(response.Metrics, pb.NewMetric(metric))
+	}
+
+	return &response, nil
+}
@@ -2,15 +2,29 @@ package grpc
 
 import (
 	"context"
+	"crypto/subtle"
 	"net"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 
 	pb "github.com/itd27m01/go-metrics-service/internal/proto" // import protobufs
 	"github.com/itd27m01/go-metrics-service/internal/repository"
+	"github.com/itd27m01/go-metrics-service/internal/tenant"
 	"github.com/itd27m01/go-metrics-service/pkg/logging/log"
 )
 
+// adminTokenMetadata is the metadata key of the token of administrative calls, it's separate from authorization
+// metadata which carries the token of tenant
+const adminTokenMetadata = "x-admin-token"
+
+// adminMethods are methods which are authorized by the admin token
+var adminMethods = map[string]bool{
+	"/proto.Metrics/DeleteMetrics": true,
+}
+
 // Config is a config for grpc server
 type Config struct {
 	Address string `yaml:"address" env:"GRPC_ADDRESS"`
@@ -20,6 +34,8 @@ type Config struct {
 type Server struct {
 	Cfg          *Config
 	SignKey      string
+	AdminToken   string
+	Tenants      *tenant.Registry
 	metricsStore repository.Store
 	pb.UnimplementedMetricsServer
 }
@@ -33,8 +49,7 @@ func (s *Server) Start(ctx context.Context, storage repository.Store) error {
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to start GRPC server")
 	}
-	grpcServer := grpc.NewServer()
-	pb.RegisterMetricsServer(grpcServer, s)
+	grpcServer := s.newServer()
 	go func() {
 		<-ctx.Done()
 		grpcServer.GracefulStop()
@@ -42,3 +57,37 @@ func (s *Server) Start(ctx context.Context, storage repository.Store) error {
 
 	return grpcServer.Serve(listen)
 }
+
+// newServer creates grpc server with interceptors of tenants and admin token and registers metrics service
+func (s *Server) newServer() *grpc.Server {
+	grpcServer := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(s.checkAdminToken, tenant.UnaryServerInterceptor(s.Tenants)),
+		grpc.StreamInterceptor(tenant.StreamServerInterceptor(s.Tenants)),
+	)
+	pb.RegisterMetricsServer(grpcServer, s)
+
+	return grpcServer
+}
+
+// checkAdminToken authorizes administrative calls by x-admin-token metadata,
+// all of them are forbidden if admin token isn't configured
+func (s *Server) checkAdminToken(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+	if !adminMethods[info.FullMethod] {
+		return handler(ctx, req)
+	}
+	if s.AdminToken == "" {
+		return nil, status.Error(codes.PermissionDenied, "access forbidden: token isn't configured")
+	}
+
+	var token string
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(adminTokenMetadata); len(values) > 0 {
+		token = values[0]
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.AdminToken)) != 1 {
+		return nil, status.Error(codes.Unauthenticated, "access unauthorized: invalid token")
+	}
+
+	return handler(ctx, req)
+}
@@ -0,0 +1,106 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/itd27m01/go-metrics-service/internal/models/metrics"
+	pb "github.com/itd27m01/go-metrics-service/internal/proto"
+	"github.com/itd27m01/go-metrics-service/internal/repository"
+	"github.com/itd27m01/go-metrics-service/internal/tenant"
+)
+
+const testAdminToken = "admin-token"
+
+func TestServer_DeleteMetricsAuthorization(t *testing.T) {
+	tests := []struct {
+		name        string
+		adminToken  string
+		metadata    []string
+		wantCode    codes.Code
+		wantDeleted bool
+	}{
+		{
+			name:        "Authorized call",
+			adminToken:  testAdminToken,
+			metadata:    []string{adminTokenMetadata, testAdminToken},
+			wantCode:    codes.OK,
+			wantDeleted: true,
+		},
+		{
+			name:       "BAD call without token",
+			adminToken: testAdminToken,
+			wantCode:   codes.Unauthenticated,
+		},
+		{
+			name:       "BAD call with wrong token",
+			adminToken: testAdminToken,
+			metadata:   []string{adminTokenMetadata, "wrong-token"},
+			wantCode:   codes.Unauthenticated,
+		},
+		{
+			name:     "BAD call when admin token isn't configured",
+			metadata: []string{adminTokenMetadata, ""},
+			wantCode: codes.PermissionDenied,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			metricsStore := repository.NewInMemoryStore()
+			require.NoError(t, metricsStore.UpdateGaugeMetric(ctx, "Decommissioned", 1))
+
+			tenants, err := tenant.NewRegistry(nil)
+			require.NoError(t, err)
+			client := newTestClient(t, &Server{
+				AdminToken:   tt.adminToken,
+				Tenants:      tenants,
+				metricsStore: repository.NewTenantStore(metricsStore),
+			})
+
+			callCtx := metadata.AppendToOutgoingContext(ctx, tt.metadata...)
+			_, err = client.DeleteMetrics(callCtx, &pb.DeleteMetricsRequest{Selector: `{__name__=~".*"}`})
+			assert.Equal(t, tt.wantCode, status.Code(err))
+
+			_, err = metricsStore.GetMetric(ctx, "Decommissioned", metrics.MetricTypeGauge)
+			if tt.wantDeleted {
+				assert.ErrorIs(t, err, repository.ErrMetricNotFound)
+			} else {
+				assert.NoError(t, err, "unauthorized call must not delete metrics")
+			}
+		})
+	}
+}
+
+// newTestClient serves the server on in-memory listener and returns the client connected to it
+func newTestClient(t *testing.T, s *Server) pb.MetricsClient {
+	t.Helper()
+
+	listener := bufconn.Listen(1 << 20)
+	grpcServer := s.newServer()
+	go func() {
+		_ = grpcServer.Serve(listener)
+	}()
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return pb.NewMetricsClient(conn)
+}
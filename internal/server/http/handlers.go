@@ -9,31 +9,66 @@ import (
 	"fmt"
 	"html/template"
 	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/itd27m01/go-metrics-service/internal/models/metrics"
 	"github.com/itd27m01/go-metrics-service/internal/repository"
+	"github.com/itd27m01/go-metrics-service/internal/tenant"
 	"github.com/itd27m01/go-metrics-service/pkg/logging/log"
+	"github.com/itd27m01/go-metrics-service/pkg/security"
 )
 
 //go:embed assets/index.gohtml
 var metricsTemplateFile string
 
 const (
-	requestTimeout = 1 * time.Second
-	gaugeBitSize   = 64
-	counterBase    = 10
-	counterBitSize = 64
+	requestTimeout      = 1 * time.Second
+	gaugeBitSize        = 64
+	counterBase         = 10
+	counterBitSize      = 64
+	timestampBase       = 10
+	timestampBitSize    = 64
+	defaultHistoryRange = 1 * time.Hour
+	// defaultCardinalityLimit is the default number of prefixes in the report of cardinality
+	defaultCardinalityLimit = 10
+	// defaultListLimit and maxListLimit are the default and max numbers of metrics in the page of list
+	defaultListLimit = 100
+	maxListLimit     = 1000
 )
 
-// RegisterHandlers registers metrics server handlers
-func RegisterHandlers(router *chi.Mux, metricsStore repository.Store, signKey string) {
+// metricsPage is the data of the page with metrics
+type metricsPage struct {
+	Metrics  map[string]*metrics.Metric
+	Metadata map[string]*metrics.Metadata
+}
+
+// gaugeRequest is the body of arithmetic and compare-and-set updates of gauge,
+// absent expected value means that the gauge must not exist
+type gaugeRequest struct {
+	ID       string         `json:"id"`
+	Labels   metrics.Labels `json:"labels,omitempty"`
+	Delta    *metrics.Gauge `json:"delta,omitempty"`
+	Expected *metrics.Gauge `json:"expected,omitempty"`
+	Value    *metrics.Gauge `json:"value,omitempty"`
+}
+
+// RegisterHandlers registers metrics server handlers, administrative handlers require admin token
+func RegisterHandlers(router *chi.Mux, metricsStore repository.Store, signKey string, adminToken string) {
 	router.Route("/ping", PingHandler(metricsStore))
 	router.Route("/update/", UpdateHandler(metricsStore, signKey))
 	router.Route("/updates/", UpdatesHandler(metricsStore))
+	router.Route("/gauge/", GaugeHandler(metricsStore, signKey))
 	router.Route("/value/", GetMetricHandler(metricsStore, signKey))
+	router.Route("/values", ListMetricsHandler(metricsStore, signKey))
+	router.Route("/history/", GetHistoryHandler(metricsStore))
+	router.Route("/admin/", AdminHandler(metricsStore, adminToken))
+	router.Route("/metadata/", MetadataHandler(metricsStore))
 	router.Route("/", GetMetricsHandler(metricsStore))
 }
 
@@ -70,15 +105,58 @@ func UpdatesHandler(metricsStore repository.Store) func(r chi.Router) {
 	}
 }
 
+// GaugeHandler is used to add to gauges and to compare and set them atomically
+func GaugeHandler(metricsStore repository.Store, signKey string) func(r chi.Router) {
+	return func(r chi.Router) {
+		r.Post("/add", addGaugeHandlerJSON(metricsStore, signKey))
+		r.Post("/cas", compareAndSetGaugeHandlerJSON(metricsStore, signKey))
+	}
+}
+
 // GetMetricHandler is a handler for retrieving a metric
 func GetMetricHandler(metricsStore repository.Store, signKey string) func(r chi.Router) {
 	return func(r chi.Router) {
 		r.Post("/", retrieveHandlerJSON(metricsStore, signKey))
 		r.Get("/{metricType}/{metricName}", getHandlerPlain(metricsStore))
+		r.Delete("/{metricType}/{metricName}", deleteHandlerPlain(metricsStore))
+	}
+}
+
+// ListMetricsHandler is a handler for listing pages of metrics selected by filter
+func ListMetricsHandler(metricsStore repository.Store, signKey string) func(r chi.Router) {
+	return func(r chi.Router) {
+		r.Get("/", listMetricsHandlerJSON(metricsStore, signKey))
+	}
+}
+
+// GetHistoryHandler is a handler for retrieving a history of metric in time range
+func GetHistoryHandler(metricsStore repository.Store) func(r chi.Router) {
+	return func(r chi.Router) {
+		r.Get("/{metricType}/{metricName}", getHistoryHandlerJSON(metricsStore))
+	}
+}
+
+// AdminHandler is a handler for administrative operations with metrics, requests are authorized by admin token
+func AdminHandler(metricsStore repository.Store, adminToken string) func(r chi.Router) {
+	return func(r chi.Router) {
+		r.Use(security.CheckAdminToken(adminToken))
+		r.Post("/delete", deleteMetricsHandler(metricsStore))
+		r.Get("/cardinality", cardinalityHandler(metricsStore))
+	}
+}
+
+// MetadataHandler is a handler for retrieving and updating metadata of metrics
+func MetadataHandler(metricsStore repository.Store) func(r chi.Router) {
+	return func(r chi.Router) {
+		r.Get("/", getMetadataHandlerJSON(metricsStore))
+		r.Get("/{metricName}", getMetricMetadataHandlerJSON(metricsStore))
+		r.Post("/", updateMetadataBatchHandler(metricsStore))
+		r.Post("/{metricName}", updateMetadataHandlerJSON(metricsStore))
 	}
 }
 
-// GetMetricsHandler is a handler for retrieving a beauty html of metrics
+// GetMetricsHandler is a handler for retrieving a beauty html of metrics,
+// metrics can be filtered by selector in match query param
 func GetMetricsHandler(metricsStore repository.Store) func(r chi.Router) {
 	var tmpl = template.Must(template.New("index.html").Parse(metricsTemplateFile))
 
@@ -87,7 +165,14 @@ func GetMetricsHandler(metricsStore repository.Store) func(r chi.Router) {
 			requestContext, requestCancel := context.WithTimeout(r.Context(), requestTimeout)
 			defer requestCancel()
 
-			metricsData, err := metricsStore.GetMetrics(requestContext)
+			matchers, err := metrics.ParseSelector(r.URL.Query().Get("match"))
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Cannot parse metric selector: %q", err), http.StatusBadRequest)
+
+				return
+			}
+
+			metricsData, err := metricsStore.GetMetrics(requestContext, matchers...)
 			if err != nil {
 				http.Error(
 					w,
@@ -96,8 +181,19 @@ func GetMetricsHandler(metricsStore repository.Store) func(r chi.Router) {
 				)
 			}
 
+			metadata, err := metricsStore.GetMetadata(requestContext)
+			if err != nil {
+				http.Error(
+					w,
+					fmt.Sprintf("Something went wrong during metadata get: %q", err),
+					http.StatusInternalServerError,
+				)
+
+				return
+			}
+
 			w.Header().Set("Content-Type", "text/html")
-			err = tmpl.Execute(w, metricsData)
+			err = tmpl.Execute(w, metricsPage{Metrics: metricsData, Metadata: metadata})
 			if err != nil {
 				http.Error(
 					w,
@@ -123,7 +219,7 @@ func updateHandlerJSON(metricsStore repository.Store, signKey string) func(w htt
 			return
 		}
 
-		if !metric.IsHashValid(signKey) {
+		if !metric.IsHashValid(tenant.SignKey(r.Context(), signKey)) {
 			log.Error().Msg("Wrong hash provided for metric")
 
 			http.Error(w, "Wrong hash provided for metric", http.StatusBadRequest)
@@ -140,13 +236,11 @@ func updateHandlerJSON(metricsStore repository.Store, signKey string) func(w htt
 					http.StatusBadRequest,
 				)
 			}
-			err := metricsStore.UpdateGaugeMetric(requestContext, metric.ID, *metric.Value)
+			err := metricsStore.UpdateGaugeMetric(requestContext, metric.Key(), *metric.Value)
 			if err != nil {
-				http.Error(
-					w,
-					fmt.Sprintf("Failed to update metric: %q", err),
-					http.StatusBadRequest,
-				)
+				writeUpdateError(w, err, fmt.Sprintf("Failed to update metric: %q", err))
+
+				return
 			}
 			w.WriteHeader(http.StatusOK)
 		case metric.MType == metrics.MetricTypeCounter:
@@ -157,13 +251,24 @@ func updateHandlerJSON(metricsStore repository.Store, signKey string) func(w htt
 					http.StatusBadRequest,
 				)
 			}
-			err := metricsStore.UpdateCounterMetric(requestContext, metric.ID, *(metric.Delta))
+			err := metricsStore.UpdateCounterMetric(requestContext, metric.Key(), *(metric.Delta))
 			if err != nil {
-				http.Error(
-					w,
-					fmt.Sprintf("Failed to update metric: %q", err),
-					http.StatusBadRequest,
-				)
+				writeUpdateError(w, err, fmt.Sprintf("Failed to update metric: %q", err))
+
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		case metric.MType == metrics.MetricTypeHistogram:
+			if metric.Histogram == nil {
+				http.Error(w, "Histogram is required field", http.StatusBadRequest)
+
+				return
+			}
+			err := metricsStore.UpdateHistogramMetric(requestContext, metric.Key(), metric.Histogram)
+			if err != nil {
+				writeUpdateError(w, err, fmt.Sprintf("Failed to update metric: %q", err))
+
+				return
 			}
 			w.WriteHeader(http.StatusOK)
 		default:
@@ -192,11 +297,9 @@ func updatesBatchHandler(metricsStore repository.Store) func(w http.ResponseWrit
 
 		err = metricsStore.UpdateMetrics(requestContext, metricsSlice)
 		if err != nil {
-			http.Error(
-				w,
-				fmt.Sprintf("Failed to update metrics: %q", err),
-				http.StatusBadRequest,
-			)
+			writeUpdateError(w, err, fmt.Sprintf("Failed to update metrics: %q", err))
+
+			return
 		}
 
 		w.WriteHeader(http.StatusOK)
@@ -207,18 +310,24 @@ func updatesBatchHandler(metricsStore repository.Store) func(w http.ResponseWrit
 func updateHandlerPlain(metricsStore repository.Store) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		metricType := chi.URLParam(r, "metricType")
-		metricName := chi.URLParam(r, "metricName")
 		metricData := chi.URLParam(r, "metricData")
+		metricName, err := urlParam(r, "metricName")
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Cannot parse metric name: %q", err), http.StatusBadRequest)
+
+			return
+		}
 
 		requestContext, requestCancel := context.WithTimeout(r.Context(), requestTimeout)
 		defer requestCancel()
 
-		var err error
 		switch {
 		case metricType == metrics.MetricTypeGauge:
 			err = updateGauge(requestContext, metricName, metricData, metricsStore)
 		case metricType == metrics.MetricTypeCounter:
 			err = updateCounter(requestContext, metricName, metricData, metricsStore)
+		case metricType == metrics.MetricTypeHistogram:
+			err = updateHistogram(requestContext, metricName, metricData, metricsStore)
 		default:
 			http.Error(
 				w,
@@ -227,13 +336,78 @@ func updateHandlerPlain(metricsStore repository.Store) func(w http.ResponseWrite
 			)
 		}
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Cannot save provided data: %s", metricData), http.StatusBadRequest)
+			writeUpdateError(w, err, fmt.Sprintf("Cannot save provided data: %s", metricData))
+
+			return
 		}
 
 		w.WriteHeader(http.StatusOK)
 	}
 }
 
+// addGaugeHandlerJSON does actual work to add delta to the gauge, the gauge with its new value is returned
+func addGaugeHandlerJSON(metricsStore repository.Store, signKey string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request gaugeRequest
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Cannot decode provided data: %q", err), http.StatusBadRequest)
+
+			return
+		}
+		if request.Delta == nil {
+			http.Error(w, "Delta is required field", http.StatusBadRequest)
+
+			return
+		}
+
+		requestContext, requestCancel := context.WithTimeout(r.Context(), requestTimeout)
+		defer requestCancel()
+
+		value, err := metricsStore.AddGauge(requestContext, metrics.Key(request.ID, request.Labels), *request.Delta)
+		if err != nil {
+			writeUpdateError(w, err, fmt.Sprintf("Failed to add to gauge: %q", err))
+
+			return
+		}
+
+		writeGauge(w, &request, value, tenant.SignKey(r.Context(), signKey))
+	}
+}
+
+// compareAndSetGaugeHandlerJSON does actual work to set value of the gauge if it has the expected value,
+// the gauge with its new value is returned and the mismatch of values is reported as conflict
+func compareAndSetGaugeHandlerJSON(metricsStore repository.Store,
+	signKey string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request gaugeRequest
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Cannot decode provided data: %q", err), http.StatusBadRequest)
+
+			return
+		}
+		if request.Value == nil {
+			http.Error(w, "Value is required field", http.StatusBadRequest)
+
+			return
+		}
+
+		requestContext, requestCancel := context.WithTimeout(r.Context(), requestTimeout)
+		defer requestCancel()
+
+		err = metricsStore.CompareAndSetGauge(requestContext, metrics.Key(request.ID, request.Labels),
+			request.Expected, *request.Value)
+		if err != nil {
+			writeUpdateError(w, err, fmt.Sprintf("Failed to compare and set gauge: %q", err))
+
+			return
+		}
+
+		writeGauge(w, &request, *request.Value, tenant.SignKey(r.Context(), signKey))
+	}
+}
+
 // retrieveHandlerJSON does actual work to get JSON metric
 func retrieveHandlerJSON(metricsStore repository.Store, signKey string) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -248,12 +422,12 @@ func retrieveHandlerJSON(metricsStore repository.Store, signKey string) func(w h
 		requestContext, requestCancel := context.WithTimeout(r.Context(), requestTimeout)
 		defer requestCancel()
 
-		metricData, err := metricsStore.GetMetric(requestContext, metric.ID, metric.MType)
+		metricData, err := metricsStore.GetMetric(requestContext, metric.Key(), metric.MType)
 		switch {
 		case errors.Is(err, repository.ErrMetricNotFound):
 			http.Error(
 				w,
-				fmt.Sprintf("Metric not found: %s", metric.ID),
+				fmt.Sprintf("Metric not found: %s", metric.Key()),
 				http.StatusNotFound,
 			)
 
@@ -268,7 +442,7 @@ func retrieveHandlerJSON(metricsStore repository.Store, signKey string) func(w h
 			return
 		}
 
-		metricData.SetHash(signKey)
+		metricData.SetHash(tenant.SignKey(r.Context(), signKey))
 
 		encodedMetric, err := json.Marshal(metricData)
 		if err != nil {
@@ -284,13 +458,55 @@ func retrieveHandlerJSON(metricsStore repository.Store, signKey string) func(w h
 	}
 }
 
-// getHandlerPlain does actual work to get metric by url params
+// listMetricsHandlerJSON does actual work to list the page of metrics, metrics are filtered by prefix, name regexp,
+// type and selector in match query params and ordered by order query param which is asc or desc.
+// The next page is listed by cursor query param which is taken from the previous page.
+func listMetricsHandlerJSON(metricsStore repository.Store, signKey string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parseListFilter(r)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Cannot parse filter of metrics: %q", err), http.StatusBadRequest)
+
+			return
+		}
+
+		requestContext, requestCancel := context.WithTimeout(r.Context(), requestTimeout)
+		defer requestCancel()
+
+		page, err := metricsStore.ListMetrics(requestContext, filter)
+		switch {
+		case errors.Is(err, repository.ErrInvalidCursor):
+			http.Error(w, fmt.Sprintf("Cannot list metrics: %q", err), http.StatusBadRequest)
+
+			return
+		case err != nil:
+			http.Error(w, fmt.Sprintf("Failed to list metrics: %q", err), http.StatusInternalServerError)
+
+			return
+		}
+
+		key := tenant.SignKey(r.Context(), signKey)
+		for _, metric := range page.Metrics {
+			metric.SetHash(key)
+		}
+
+		writeJSON(w, page)
+	}
+}
+
+// getHandlerPlain does actual work to get metric by url params,
+// the name of metric can be a selector with label matchers, e.g. CPUutilization{cpu=~"1|2"}
 func getHandlerPlain(metricsStore repository.Store) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		metricType := chi.URLParam(r, "metricType")
-		metricName := chi.URLParam(r, "metricName")
+		metricName, err := urlParam(r, "metricName")
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Cannot parse metric name: %q", err), http.StatusBadRequest)
+
+			return
+		}
 
-		if metricType != metrics.MetricTypeGauge && metricType != metrics.MetricTypeCounter {
+		if !isSupportedMetricType(metricType) {
 			http.Error(
 				w,
 				fmt.Sprintf("Metric type not implemented: %s", metricType),
@@ -305,32 +521,550 @@ func getHandlerPlain(metricsStore repository.Store) func(w http.ResponseWriter,
 
 		metricData, err := metricsStore.GetMetric(requestContext, metricName, metricType)
 		switch {
+		case errors.Is(err, repository.ErrMetricNotFound), errors.Is(err, metrics.ErrInvalidSelector):
+			getMatchedMetricsPlain(requestContext, w, metricsStore, metricName, metricType)
+
+			return
+		case !errors.Is(err, nil):
+			http.Error(
+				w,
+				fmt.Sprintf("Filed to get metric: %q", err),
+				http.StatusInternalServerError,
+			)
+
+			return
+		}
+
+		_, err = w.Write([]byte(metricData.String()))
+		if err != nil {
+			http.Error(
+				w,
+				fmt.Sprintf("Something went wrong during metric get: %s", metricName),
+				http.StatusInternalServerError,
+			)
+		}
+	}
+}
+
+// getMatchedMetricsPlain writes the value of the only metric matched by selector
+// or the list of matched metrics keys and values line by line
+func getMatchedMetricsPlain(ctx context.Context, w http.ResponseWriter, metricsStore repository.Store,
+	selector string, metricType string) {
+	matchers, err := metrics.ParseSelector(selector)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Cannot parse metric selector: %q", err), http.StatusBadRequest)
+
+		return
+	}
+
+	metricsData, err := metricsStore.GetMetrics(ctx, matchers...)
+	if err != nil {
+		http.Error(
+			w,
+			fmt.Sprintf("Filed to get metric: %q", err),
+			http.StatusInternalServerError,
+		)
+
+		return
+	}
+
+	keys := make([]string, 0, len(metricsData))
+	for key, metric := range metricsData {
+		if metric.MType == metricType {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var body strings.Builder
+	switch len(keys) {
+	case 0:
+		http.Error(
+			w,
+			fmt.Sprintf("Metric not found: %s", selector),
+			http.StatusNotFound,
+		)
+
+		return
+	case 1:
+		body.WriteString(metricsData[keys[0]].String())
+	default:
+		for _, key := range keys {
+			body.WriteString(fmt.Sprintf("%s %s\n", key, metricsData[key].String()))
+		}
+	}
+
+	_, err = w.Write([]byte(body.String()))
+	if err != nil {
+		http.Error(
+			w,
+			fmt.Sprintf("Something went wrong during metric get: %s", selector),
+			http.StatusInternalServerError,
+		)
+	}
+}
+
+// deleteHandlerPlain does actual work to delete metric by url params
+func deleteHandlerPlain(metricsStore repository.Store) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		metricType := chi.URLParam(r, "metricType")
+		metricName, err := urlParam(r, "metricName")
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Cannot parse metric name: %q", err), http.StatusBadRequest)
+
+			return
+		}
+
+		if !isSupportedMetricType(metricType) {
+			http.Error(
+				w,
+				fmt.Sprintf("Metric type not implemented: %s", metricType),
+				http.StatusNotImplemented,
+			)
+
+			return
+		}
+
+		requestContext, requestCancel := context.WithTimeout(r.Context(), requestTimeout)
+		defer requestCancel()
+
+		err = metricsStore.DeleteMetric(requestContext, metricName, metricType)
+		switch {
 		case errors.Is(err, repository.ErrMetricNotFound):
+			http.Error(w, fmt.Sprintf("Metric not found: %s", metricName), http.StatusNotFound)
+		case errors.Is(err, repository.ErrMetricTypeMismatch), errors.Is(err, metrics.ErrInvalidSelector):
+			http.Error(w, fmt.Sprintf("Cannot delete metric: %q", err), http.StatusBadRequest)
+		case !errors.Is(err, nil):
 			http.Error(
 				w,
-				fmt.Sprintf("Metric not found: %s", metricName),
-				http.StatusNotFound,
+				fmt.Sprintf("Filed to delete metric: %q", err),
+				http.StatusInternalServerError,
+			)
+		}
+	}
+}
+
+// deleteMetricsHandler does actual work to delete metrics matched by selector in match query param
+// and by prefix of name in prefix query param, one of them is required
+func deleteMetricsHandler(metricsStore repository.Store) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		matchers, err := metrics.ParseSelector(r.URL.Query().Get("match"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Cannot parse metric selector: %q", err), http.StatusBadRequest)
+
+			return
+		}
+		if prefix := r.URL.Query().Get("prefix"); prefix != "" {
+			matchers = append(matchers, metrics.NewPrefixMatcher(prefix))
+		}
+		if len(matchers) == 0 {
+			http.Error(w, "Selector or prefix of metrics is required", http.StatusBadRequest)
+
+			return
+		}
+
+		requestContext, requestCancel := context.WithTimeout(r.Context(), requestTimeout)
+		defer requestCancel()
+
+		deleted, err := metricsStore.DeleteMetrics(requestContext, matchers...)
+		if err != nil {
+			http.Error(
+				w,
+				fmt.Sprintf("Filed to delete metrics: %q", err),
+				http.StatusInternalServerError,
+			)
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, err = fmt.Fprintf(w, `{"deleted":%d}`, deleted)
+		if err != nil {
+			log.Error().Err(err).Msg("Cannot send request")
+		}
+	}
+}
+
+// getHistoryHandlerJSON does actual work to get history of metric by url params and query
+func getHistoryHandlerJSON(metricsStore repository.Store) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		metricType := chi.URLParam(r, "metricType")
+		metricName, err := urlParam(r, "metricName")
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Cannot parse metric name: %q", err), http.StatusBadRequest)
+
+			return
+		}
+
+		historyStore, ok := metricsStore.(repository.HistoryStore)
+		if !ok {
+			http.Error(w, "History of metrics is not supported by storage", http.StatusNotImplemented)
+
+			return
+		}
+
+		if !isSupportedMetricType(metricType) {
+			http.Error(
+				w,
+				fmt.Sprintf("Metric type not implemented: %s", metricType),
+				http.StatusNotImplemented,
 			)
 
+			return
+		}
+
+		from, to, step, err := parseHistoryRange(r)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Cannot parse time range: %q", err), http.StatusBadRequest)
+
+			return
+		}
+
+		requestContext, requestCancel := context.WithTimeout(r.Context(), requestTimeout)
+		defer requestCancel()
+
+		samples, err := historyStore.GetHistory(requestContext, metricName, metricType, from, to, step)
+		switch {
+		case errors.Is(err, repository.ErrHistoryDisabled):
+			http.Error(w, "History of metrics is disabled", http.StatusNotImplemented)
+
+			return
+		case errors.Is(err, repository.ErrInvalidTimeRange), errors.Is(err, repository.ErrMetricTypeMismatch):
+			http.Error(w, fmt.Sprintf("Cannot get history of metric: %q", err), http.StatusBadRequest)
+
 			return
 		case !errors.Is(err, nil):
 			http.Error(
 				w,
-				fmt.Sprintf("Filed to get metric: %q", err),
+				fmt.Sprintf("Filed to get history of metric: %q", err),
 				http.StatusInternalServerError,
 			)
 
 			return
 		}
 
-		_, err = w.Write([]byte(metricData.String()))
+		encodedSamples, err := json.Marshal(samples)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Cannot encode history data: %q", err), http.StatusInternalServerError)
+
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(encodedSamples)
+		if err != nil {
+			log.Error().Err(err).Msg("Cannot send request")
+		}
+	}
+}
+
+// cardinalityHandler does actual work to report top prefixes of names of metrics by the number of metrics,
+// the number of prefixes is limited by limit query param and their length can be set by prefix_length query param
+func cardinalityHandler(metricsStore repository.Store) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit, err := intQueryParam(r, "limit", defaultCardinalityLimit)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Cannot parse limit: %q", err), http.StatusBadRequest)
+
+			return
+		}
+		prefixLength, err := intQueryParam(r, "prefix_length", 0)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Cannot parse length of prefix: %q", err), http.StatusBadRequest)
+
+			return
+		}
+
+		requestContext, requestCancel := context.WithTimeout(r.Context(), requestTimeout)
+		defer requestCancel()
+
+		report, err := repository.GetCardinality(requestContext, metricsStore, prefixLength, limit)
 		if err != nil {
 			http.Error(
 				w,
-				fmt.Sprintf("Something went wrong during metric get: %s", metricName),
+				fmt.Sprintf("Filed to get cardinality of metrics: %q", err),
+				http.StatusInternalServerError,
+			)
+
+			return
+		}
+
+		writeJSON(w, report)
+	}
+}
+
+// getMetadataHandlerJSON does actual work to get metadata of all metrics by their names
+func getMetadataHandlerJSON(metricsStore repository.Store) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestContext, requestCancel := context.WithTimeout(r.Context(), requestTimeout)
+		defer requestCancel()
+
+		metadata, err := metricsStore.GetMetadata(requestContext)
+		if err != nil {
+			http.Error(
+				w,
+				fmt.Sprintf("Filed to get metadata of metrics: %q", err),
 				http.StatusInternalServerError,
 			)
+
+			return
 		}
+
+		writeJSON(w, metadata)
+	}
+}
+
+// getMetricMetadataHandlerJSON does actual work to get metadata of metric by name from url params
+func getMetricMetadataHandlerJSON(metricsStore repository.Store) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		metricName, err := urlParam(r, "metricName")
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Cannot parse metric name: %q", err), http.StatusBadRequest)
+
+			return
+		}
+
+		requestContext, requestCancel := context.WithTimeout(r.Context(), requestTimeout)
+		defer requestCancel()
+
+		metadata, err := metricsStore.GetMetadata(requestContext)
+		if err != nil {
+			http.Error(
+				w,
+				fmt.Sprintf("Filed to get metadata of metrics: %q", err),
+				http.StatusInternalServerError,
+			)
+
+			return
+		}
+
+		metricMetadata, ok := metadata[metricName]
+		if !ok {
+			http.Error(w, fmt.Sprintf("Metadata not found: %s", metricName), http.StatusNotFound)
+
+			return
+		}
+
+		writeJSON(w, metricMetadata)
+	}
+}
+
+// updateMetadataHandlerJSON does actual work to update metadata of metric by name from url params,
+// empty metadata removes it
+func updateMetadataHandlerJSON(metricsStore repository.Store) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		metricName, err := urlParam(r, "metricName")
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Cannot parse metric name: %q", err), http.StatusBadRequest)
+
+			return
+		}
+
+		var metadata metrics.Metadata
+		if err := json.NewDecoder(r.Body).Decode(&metadata); err != nil {
+			http.Error(w, fmt.Sprintf("Cannot decode provided data: %q", err), http.StatusBadRequest)
+
+			return
+		}
+
+		requestContext, requestCancel := context.WithTimeout(r.Context(), requestTimeout)
+		defer requestCancel()
+
+		updateMetadata(requestContext, w, metricsStore, map[string]*metrics.Metadata{metricName: &metadata})
+	}
+}
+
+// updateMetadataBatchHandler does actual work to update metadata of metrics by their names
+func updateMetadataBatchHandler(metricsStore repository.Store) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var metadata map[string]*metrics.Metadata
+		if err := json.NewDecoder(r.Body).Decode(&metadata); err != nil {
+			http.Error(w, fmt.Sprintf("Cannot decode provided data: %q", err), http.StatusBadRequest)
+
+			return
+		}
+
+		requestContext, requestCancel := context.WithTimeout(r.Context(), requestTimeout)
+		defer requestCancel()
+
+		updateMetadata(requestContext, w, metricsStore, metadata)
+	}
+}
+
+// updateMetadata sets metadata of metrics in the store and writes status of the update
+func updateMetadata(ctx context.Context, w http.ResponseWriter, metricsStore repository.Store,
+	metadata map[string]*metrics.Metadata) {
+	for name, md := range metadata {
+		err := metricsStore.SetMetadata(ctx, name, md)
+		switch {
+		case errors.Is(err, metrics.ErrInvalidMetadata):
+			http.Error(w, fmt.Sprintf("Cannot update metadata of metric: %q", err), http.StatusBadRequest)
+
+			return
+		case !errors.Is(err, nil):
+			http.Error(
+				w,
+				fmt.Sprintf("Failed to update metadata of metric: %q", err),
+				http.StatusInternalServerError,
+			)
+
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// writeJSON encodes data to JSON and writes it to the response
+func writeJSON(w http.ResponseWriter, data interface{}) {
+	encodedData, err := json.Marshal(data)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Cannot encode data: %q", err), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(encodedData)
+	if err != nil {
+		log.Error().Err(err).Msg("Cannot send request")
+	}
+}
+
+// parseHistoryRange parses from, to and step query params, by default it's the last hour without downsampling
+func parseHistoryRange(r *http.Request) (from time.Time, to time.Time, step time.Duration, err error) {
+	query := r.URL.Query()
+
+	to = time.Now()
+	if query.Get("to") != "" {
+		if to, err = parseTimestamp(query.Get("to")); err != nil {
+			return from, to, step, err
+		}
+	}
+
+	from = to.Add(-defaultHistoryRange)
+	if query.Get("from") != "" {
+		if from, err = parseTimestamp(query.Get("from")); err != nil {
+			return from, to, step, err
+		}
+	}
+
+	if query.Get("step") != "" {
+		if step, err = time.ParseDuration(query.Get("step")); err != nil {
+			return from, to, step, err
+		}
+	}
+
+	return from, to, step, nil
+}
+
+// parseListFilter parses filter of metrics from query params, the limit of page is capped by maxListLimit
+func parseListFilter(r *http.Request) (*repository.ListFilter, error) {
+	query := r.URL.Query()
+
+	matchers, err := metrics.ParseSelector(query.Get("match"))
+	if err != nil {
+		return nil, err
+	}
+
+	limit, err := intQueryParam(r, "limit", defaultListLimit)
+	switch {
+	case err != nil:
+		return nil, err
+	case limit <= 0 || limit > maxListLimit:
+		return nil, fmt.Errorf("limit must be in range from 1 to %d", maxListLimit)
+	}
+
+	filter := repository.ListFilter{
+		Prefix:   query.Get("prefix"),
+		Type:     query.Get("type"),
+		Matchers: matchers,
+		Limit:    limit,
+		Cursor:   query.Get("cursor"),
+	}
+	if filter.Type != "" && !isSupportedMetricType(filter.Type) {
+		return nil, fmt.Errorf("unknown type of metrics %s", filter.Type)
+	}
+	if name := query.Get("name"); name != "" {
+		if filter.Name, err = regexp.Compile("^(?:" + name + ")$"); err != nil {
+			return nil, err
+		}
+	}
+	switch query.Get("order") {
+	case "", "asc":
+	case "desc":
+		filter.Descending = true
+	default:
+		return nil, fmt.Errorf("unknown order of metrics %s", query.Get("order"))
+	}
+
+	return &filter, nil
+}
+
+// intQueryParam parses integer query param, absent param has the default value
+func intQueryParam(r *http.Request, key string, defaultValue int) (int, error) {
+	value := r.URL.Query().Get(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	return strconv.Atoi(value)
+}
+
+// writeGauge writes the gauge of request with its value as signed JSON metric
+func writeGauge(w http.ResponseWriter, request *gaugeRequest, value metrics.Gauge, signKey string) {
+	metric := metrics.Metric{
+		ID:     request.ID,
+		Labels: request.Labels,
+		MType:  metrics.MetricTypeGauge,
+		Value:  &value,
+	}
+	metric.SetHash(signKey)
+
+	writeJSON(w, &metric)
+}
+
+// writeUpdateError writes error of update of metrics, metrics rejected by limits are reported with the reason
+// and the mismatch of gauge with expected value is reported as conflict
+func writeUpdateError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, repository.ErrGaugeConflict):
+		http.Error(w, fmt.Sprintf("Gauge is changed: %q", err), http.StatusConflict)
+	case errors.Is(err, repository.ErrCardinalityLimit):
+		http.Error(w, fmt.Sprintf("Metrics are rejected: %q", err), http.StatusTooManyRequests)
+	case errors.Is(err, repository.ErrMetricNameRejected):
+		http.Error(w, fmt.Sprintf("Metrics are rejected: %q", err), http.StatusBadRequest)
+	default:
+		http.Error(w, message, http.StatusBadRequest)
+	}
+}
+
+// urlParam returns unescaped url param, chi routes by the raw path when the request has it
+func urlParam(r *http.Request, key string) (string, error) {
+	param := chi.URLParam(r, key)
+	if r.URL.RawPath == "" {
+		return param, nil
+	}
+
+	return url.PathUnescape(param)
+}
+
+// parseTimestamp parses timestamp in RFC3339 format or as unix seconds
+func parseTimestamp(timestamp string) (time.Time, error) {
+	seconds, err := strconv.ParseInt(timestamp, timestampBase, timestampBitSize)
+	if err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+
+	return time.Parse(time.RFC3339, timestamp)
+}
+
+// isSupportedMetricType checks if handlers support the type of metric
+func isSupportedMetricType(metricType string) bool {
+	switch metricType {
+	case metrics.MetricTypeGauge, metrics.MetricTypeCounter, metrics.MetricTypeHistogram:
+		return true
+	default:
+		return false
 	}
 }
 
@@ -355,3 +1089,24 @@ func updateCounter(ctx context.Context, metricName string, metricData string, me
 
 	return err
 }
+
+// updateHistogram adds observation to histogram metric, buckets of the stored histogram are used if it exists
+// BUG(igortiunov): it brakes single responsibility
+func updateHistogram(ctx context.Context, metricName string, metricData string, metricsStore repository.Store) error {
+	parsedData, err := strconv.ParseFloat(metricData, gaugeBitSize)
+	if err != nil {
+		return err
+	}
+
+	histogram := metrics.NewHistogram()
+	currentMetric, err := metricsStore.GetMetric(ctx, metricName, metrics.MetricTypeHistogram)
+	if err == nil && currentMetric.Histogram != nil {
+		histogram = &metrics.Histogram{
+			Bounds: currentMetric.Histogram.Bounds,
+			Counts: make([]metrics.Counter, len(currentMetric.Histogram.Bounds)+1),
+		}
+	}
+	histogram.Observe(parsedData)
+
+	return metricsStore.UpdateHistogramMetric(ctx, metricName, histogram)
+}
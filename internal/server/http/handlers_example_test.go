@@ -16,7 +16,7 @@ import (
 
 func ExamplePingHandler() {
 	mux := chi.NewRouter()
-	http2.RegisterHandlers(mux, repository.NewInMemoryStore(), "")
+	http2.RegisterHandlers(mux, repository.NewInMemoryStore(), "", "")
 	ts := httptest.NewServer(mux)
 	defer ts.Close()
 
@@ -34,7 +34,7 @@ func ExamplePingHandler() {
 
 func ExampleUpdateHandler() {
 	mux := chi.NewRouter()
-	http2.RegisterHandlers(mux, repository.NewInMemoryStore(), "")
+	http2.RegisterHandlers(mux, repository.NewInMemoryStore(), "", "")
 	ts := httptest.NewServer(mux)
 	defer ts.Close()
 
@@ -71,7 +71,7 @@ func ExampleUpdateHandler() {
 
 func ExampleUpdatesHandler() {
 	mux := chi.NewRouter()
-	http2.RegisterHandlers(mux, repository.NewInMemoryStore(), "")
+	http2.RegisterHandlers(mux, repository.NewInMemoryStore(), "", "")
 	ts := httptest.NewServer(mux)
 	defer ts.Close()
 
@@ -101,7 +101,7 @@ func ExampleUpdatesHandler() {
 
 func ExampleGetMetricHandler() {
 	mux := chi.NewRouter()
-	http2.RegisterHandlers(mux, repository.NewInMemoryStore(), "")
+	http2.RegisterHandlers(mux, repository.NewInMemoryStore(), "", "")
 	ts := httptest.NewServer(mux)
 	defer ts.Close()
 
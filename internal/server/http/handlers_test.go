@@ -1,9 +1,14 @@
 package http_test
 
 import (
+	"context"
+	"encoding/json"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
 	"testing"
 
 	http2 "github.com/itd27m01/go-metrics-service/internal/server/http"
@@ -11,10 +16,14 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/itd27m01/go-metrics-service/internal/models/metrics"
 	"github.com/itd27m01/go-metrics-service/internal/repository"
+	"github.com/itd27m01/go-metrics-service/internal/tenant"
+	"github.com/itd27m01/go-metrics-service/pkg/security"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+const testAdminToken = "admin-token"
+
 const metricsHTML = `<!DOCTYPE html>
 <html lang="en">
 <body>
@@ -23,26 +32,41 @@ const metricsHTML = `<!DOCTYPE html>
         <th>Type</th>
         <th>Name</th>
         <th>Value</th>
+        <th>Unit</th>
+        <th>Description</th>
+        <th>Owner</th>
     </tr>
     <tr>
         <td style='text-align:center; vertical-align:middle'>gauge</td>
         <td style='text-align:center; vertical-align:middle'>test1</td>
         <td style='text-align:center; vertical-align:middle'>100</td>
+        <td style='text-align:center; vertical-align:middle'></td>
+        <td style='text-align:center; vertical-align:middle'></td>
+        <td style='text-align:center; vertical-align:middle'></td>
     </tr>
     <tr>
         <td style='text-align:center; vertical-align:middle'>counter</td>
         <td style='text-align:center; vertical-align:middle'>test2</td>
         <td style='text-align:center; vertical-align:middle'>100</td>
+        <td style='text-align:center; vertical-align:middle'></td>
+        <td style='text-align:center; vertical-align:middle'></td>
+        <td style='text-align:center; vertical-align:middle'></td>
     </tr>
     <tr>
         <td style='text-align:center; vertical-align:middle'>gauge</td>
         <td style='text-align:center; vertical-align:middle'>testSetGet134</td>
         <td style='text-align:center; vertical-align:middle'>96969.519</td>
+        <td style='text-align:center; vertical-align:middle'></td>
+        <td style='text-align:center; vertical-align:middle'></td>
+        <td style='text-align:center; vertical-align:middle'></td>
     </tr>
     <tr>
         <td style='text-align:center; vertical-align:middle'>gauge</td>
         <td style='text-align:center; vertical-align:middle'>testSetGet135</td>
         <td style='text-align:center; vertical-align:middle'>156519.255</td>
+        <td style='text-align:center; vertical-align:middle'></td>
+        <td style='text-align:center; vertical-align:middle'></td>
+        <td style='text-align:center; vertical-align:middle'></td>
     </tr>
     </table>
 </body>
@@ -98,6 +122,39 @@ var testsJSON = []testJSON{
 			data: "{\"id\":\"Alloc\",\"type\":\"gauge\",\"value\":96969.519}\n",
 		},
 	},
+	{
+		name:   "Post JSON histogram",
+		method: http.MethodPost,
+		url:    "/update/",
+		metric: &metrics.Metric{
+			ID:    "Latency",
+			MType: metrics.MetricTypeHistogram,
+			Histogram: &metrics.Histogram{
+				Bounds: []float64{0.1, 1},
+				Counts: []metrics.Counter{1, 2, 0},
+				Sum:    1.5,
+				Count:  3,
+			},
+		},
+		want: want{
+			code: http.StatusOK,
+			data: "",
+		},
+	},
+	{
+		name:   "Get JSON histogram",
+		method: http.MethodPost,
+		url:    "/value/",
+		metric: &metrics.Metric{
+			ID:    "Latency",
+			MType: metrics.MetricTypeHistogram,
+		},
+		want: want{
+			code: http.StatusOK,
+			data: "{\"id\":\"Latency\",\"type\":\"histogram\"," +
+				"\"histogram\":{\"bounds\":[0.1,1],\"counts\":[1,2,0],\"sum\":1.5,\"count\":3}}\n",
+		},
+	},
 }
 
 var tests = []test{
@@ -227,11 +284,178 @@ var tests = []test{
 			data: "Metric not found: unknown\n",
 		},
 	},
+	{
+		name:   "Labeled gauge update 1",
+		metric: "/update/gauge/CPUutilization%7Bcpu=%221%22%7D/10",
+		method: http.MethodPost,
+		want: want{
+			code: http.StatusOK,
+		},
+	},
+	{
+		name:   "Labeled gauge update 2",
+		metric: "/update/gauge/CPUutilization%7Bcpu=%222%22%7D/20",
+		method: http.MethodPost,
+		want: want{
+			code: http.StatusOK,
+		},
+	},
+	{
+		name:   "Get labeled gauge",
+		metric: "/value/gauge/CPUutilization%7Bcpu=%222%22%7D",
+		method: http.MethodGet,
+		want: want{
+			code: http.StatusOK,
+			data: "20",
+		},
+	},
+	{
+		name:   "Get gauges by selector",
+		metric: "/value/gauge/CPUutilization",
+		method: http.MethodGet,
+		want: want{
+			code: http.StatusOK,
+			data: "CPUutilization{cpu=\"1\"} 10\nCPUutilization{cpu=\"2\"} 20\n",
+		},
+	},
+	{
+		name:   "Get gauge by regexp selector",
+		metric: "/value/gauge/CPUutilization%7Bcpu=~%221%7C3%22%7D",
+		method: http.MethodGet,
+		want: want{
+			code: http.StatusOK,
+			data: "10",
+		},
+	},
+	{
+		name:   "Histogram update 1",
+		metric: "/update/histogram/RequestDuration/0.3",
+		method: http.MethodPost,
+		want: want{
+			code: http.StatusOK,
+		},
+	},
+	{
+		name:   "Histogram update 2",
+		metric: "/update/histogram/RequestDuration/30",
+		method: http.MethodPost,
+		want: want{
+			code: http.StatusOK,
+		},
+	},
+	{
+		name:   "BAD histogram update",
+		metric: "/update/histogram/RequestDuration/none",
+		method: http.MethodPost,
+		want: want{
+			code: http.StatusBadRequest,
+		},
+	},
+	{
+		name:   "Get histogram",
+		metric: "/value/histogram/RequestDuration",
+		method: http.MethodGet,
+		want: want{
+			code: http.StatusOK,
+			data: "count=2 sum=30.3 buckets=[0.005:0 0.01:0 0.025:0 0.05:0 0.1:0 0.25:0 0.5:1 1:0 2.5:0 5:0 10:0 +Inf:1]",
+		},
+	},
+	{
+		name:   "Get history of metric",
+		metric: "/history/gauge/test1?step=1m",
+		method: http.MethodGet,
+		want: want{
+			code: http.StatusNotImplemented,
+			data: "History of metrics is disabled\n",
+		},
+	},
+	{
+		name:   "Typo gauge update",
+		metric: "/update/gauge/Aloc/1",
+		method: http.MethodPost,
+		want: want{
+			code: http.StatusOK,
+		},
+	},
+	{
+		name:   "BAD type of metric delete",
+		metric: "/value/counter/Aloc",
+		method: http.MethodDelete,
+		want: want{
+			code: http.StatusBadRequest,
+		},
+	},
+	{
+		name:   "Delete gauge",
+		metric: "/value/gauge/Aloc",
+		method: http.MethodDelete,
+		want: want{
+			code: http.StatusOK,
+		},
+	},
+	{
+		name:   "Get deleted gauge",
+		metric: "/value/gauge/Aloc",
+		method: http.MethodGet,
+		want: want{
+			code: http.StatusNotFound,
+			data: "Metric not found: Aloc\n",
+		},
+	},
+	{
+		name:   "Delete deleted gauge",
+		metric: "/value/gauge/Aloc",
+		method: http.MethodDelete,
+		want: want{
+			code: http.StatusNotFound,
+		},
+	},
+	{
+		name:   "Decommissioned gauge update 1",
+		metric: "/update/gauge/Decommissioned1/1",
+		method: http.MethodPost,
+		want: want{
+			code: http.StatusOK,
+		},
+	},
+	{
+		name:   "Decommissioned gauge update 2",
+		metric: "/update/gauge/Decommissioned2/2",
+		method: http.MethodPost,
+		want: want{
+			code: http.StatusOK,
+		},
+	},
+	{
+		name:   "BAD bulk delete without selector",
+		metric: "/admin/delete",
+		method: http.MethodPost,
+		want: want{
+			code: http.StatusBadRequest,
+		},
+	},
+	{
+		name:   "Bulk delete by prefix",
+		metric: "/admin/delete?prefix=Decommissioned",
+		method: http.MethodPost,
+		want: want{
+			code: http.StatusOK,
+		},
+	},
+	{
+		name:   "Get deleted by prefix gauge",
+		metric: "/value/gauge/Decommissioned2",
+		method: http.MethodGet,
+		want: want{
+			code: http.StatusNotFound,
+			data: "Metric not found: Decommissioned2\n",
+		},
+	},
 }
 
 func TestRouter(t *testing.T) {
 	mux := chi.NewRouter()
-	http2.RegisterHandlers(mux, repository.NewInMemoryStore(), "")
+	http2.RegisterHandlers(mux, repository.NewInMemoryStore(), "", testAdminToken)
 	ts := httptest.NewServer(mux)
 	defer ts.Close()
 
@@ -248,9 +472,666 @@ func TestRouter(t *testing.T) {
 	}
 }
 
+func TestMetadataRouter(t *testing.T) {
+	mux := chi.NewRouter()
+	http2.RegisterHandlers(mux, repository.NewInMemoryStore(), "", testAdminToken)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		body   string
+		want   want
+	}{
+		{
+			name:   "Update metadata of metric",
+			method: http.MethodPost,
+			url:    "/metadata/Alloc",
+			body:   `{"description":"Bytes of allocated heap objects","unit":"bytes"}`,
+			want:   want{code: http.StatusOK},
+		},
+		{
+			name:   "Update batch of metadata",
+			method: http.MethodPost,
+			url:    "/metadata/",
+			body:   `{"PollCount":{"owner":"agent"},"RandomValue":{"help_url":"https://example.com/random"}}`,
+			want:   want{code: http.StatusOK},
+		},
+		{
+			name:   "Remove metadata by empty one",
+			method: http.MethodPost,
+			url:    "/metadata/RandomValue",
+			body:   `{}`,
+			want:   want{code: http.StatusOK},
+		},
+		{
+			name:   "BAD metadata with relative help URL",
+			method: http.MethodPost,
+			url:    "/metadata/Alloc",
+			body:   `{"help_url":"docs/alloc"}`,
+			want:   want{code: http.StatusBadRequest},
+		},
+		{
+			name:   "BAD metadata of labeled metric",
+			method: http.MethodPost,
+			url:    "/metadata/" + url.PathEscape(`Alloc{host="a"}`),
+			body:   `{"unit":"bytes"}`,
+			want:   want{code: http.StatusBadRequest},
+		},
+		{
+			name:   "BAD metadata data",
+			method: http.MethodPost,
+			url:    "/metadata/",
+			body:   `[]`,
+			want:   want{code: http.StatusBadRequest},
+		},
+		{
+			name:   "Get metadata of metric",
+			method: http.MethodGet,
+			url:    "/metadata/Alloc",
+			want: want{
+				code: http.StatusOK,
+				data: `{"description":"Bytes of allocated heap objects","unit":"bytes"}`,
+			},
+		},
+		{
+			name:   "Get removed metadata of metric",
+			method: http.MethodGet,
+			url:    "/metadata/RandomValue",
+			want: want{
+				code: http.StatusNotFound,
+				data: "Metadata not found: RandomValue\n",
+			},
+		},
+		{
+			name:   "Get metadata of metrics",
+			method: http.MethodGet,
+			url:    "/metadata/",
+			want: want{
+				code: http.StatusOK,
+				data: `{"Alloc":{"description":"Bytes of allocated heap objects","unit":"bytes"},"PollCount":{"owner":"agent"}}`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, ts.URL+tt.url, strings.NewReader(tt.body))
+			require.NoError(t, err)
+			req.Header.Set(security.AdminTokenHeader, testAdminToken)
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer func() { _ = resp.Body.Close() }()
+			assert.Equal(t, tt.want.code, resp.StatusCode)
+
+			if tt.method == http.MethodGet {
+				respBody, err := ioutil.ReadAll(resp.Body)
+				require.NoError(t, err)
+				assert.Equal(t, tt.want.data, string(respBody))
+			}
+		})
+	}
+
+	resp, err := http.Post(ts.URL+"/update/gauge/Alloc/1", "text/plain", nil)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	resp, err = http.Get(ts.URL + "/")
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+	page, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(page), "<td style='text-align:center; vertical-align:middle'>bytes</td>")
+	assert.Contains(t, string(page), "Bytes of allocated heap objects")
+}
+
+func TestRouter_Tenants(t *testing.T) {
+	tenants, err := tenant.NewRegistry([]tenant.Config{{Name: "team-a", Token: "token-a", SignKey: "key-a"}})
+	require.NoError(t, err)
+
+	mux := chi.NewRouter()
+	mux.Use(tenant.Middleware(tenants))
+	http2.RegisterHandlers(mux, repository.NewTenantStore(repository.NewInMemoryStore()), "server-key",
+		testAdminToken)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	update := func(token string, signKey string, value metrics.Gauge) int {
+		metric := &metrics.Metric{ID: "Alloc", MType: metrics.MetricTypeGauge, Value: &value}
+		metric.SetHash(signKey)
+		body, err := metric.EncodeMetric()
+		require.NoError(t, err)
+
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/update/", body)
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+
+		return resp.StatusCode
+	}
+	get := func(token string) (int, string) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/value/gauge/Alloc", nil)
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer func() { _ = resp.Body.Close() }()
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		return resp.StatusCode, string(body)
+	}
+
+	assert.Equal(t, http.StatusBadRequest, update("token-a", "server-key", 1), "tenant must sign by its own key")
+	assert.Equal(t, http.StatusOK, update("token-a", "key-a", 1))
+	assert.Equal(t, http.StatusBadRequest, update("", "key-a", 2), "default tenant must sign by the server key")
+	assert.Equal(t, http.StatusOK, update("", "server-key", 2))
+	assert.Equal(t, http.StatusUnauthorized, update("token-b", "key-a", 3))
+
+	code, value := get("token-a")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "1", value)
+
+	code, value = get("")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "2", value)
+}
+
+func TestListMetricsRouter(t *testing.T) {
+	metricsStore := repository.NewInMemoryStore()
+	mux := chi.NewRouter()
+	http2.RegisterHandlers(mux, metricsStore, "", testAdminToken)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	ctx := context.Background()
+	require.NoError(t, metricsStore.UpdateGaugeMetric(ctx, "Alloc", 1))
+	require.NoError(t, metricsStore.UpdateGaugeMetric(ctx, `CPUutilization{cpu="0"}`, 2))
+	require.NoError(t, metricsStore.UpdateGaugeMetric(ctx, `CPUutilization{cpu="1"}`, 3))
+	require.NoError(t, metricsStore.UpdateCounterMetric(ctx, "PollCount", 4))
+
+	tests := []struct {
+		name  string
+		query string
+		want  want
+	}{
+		{
+			name:  "List metrics by prefix",
+			query: "?prefix=CPU",
+			want: want{
+				code: http.StatusOK,
+				data: `{"metrics":[` +
+					`{"id":"CPUutilization","type":"gauge","value":2,"labels":{"cpu":"0"}},` +
+					`{"id":"CPUutilization","type":"gauge","value":3,"labels":{"cpu":"1"}}]}`,
+			},
+		},
+		{
+			name:  "List metrics by type in descending order",
+			query: "?type=gauge&order=desc&limit=1&match=" + url.QueryEscape(`{cpu="1"}`),
+			want: want{
+				code: http.StatusOK,
+				data: `{"metrics":[{"id":"CPUutilization","type":"gauge","value":3,"labels":{"cpu":"1"}}]}`,
+			},
+		},
+		{
+			name:  "List metrics by name",
+			query: "?name=" + url.QueryEscape("Alloc|Poll.*"),
+			want: want{
+				code: http.StatusOK,
+				data: `{"metrics":[{"id":"Alloc","type":"gauge","value":1},{"id":"PollCount","type":"counter","delta":4}]}`,
+			},
+		},
+		{
+			name:  "BAD limit of page",
+			query: "?limit=0",
+			want: want{
+				code: http.StatusBadRequest,
+				data: "Cannot parse filter of metrics: \"limit must be in range from 1 to 1000\"\n",
+			},
+		},
+		{
+			name:  "BAD type of metrics",
+			query: "?type=summary",
+			want: want{
+				code: http.StatusBadRequest,
+				data: "Cannot parse filter of metrics: \"unknown type of metrics summary\"\n",
+			},
+		},
+		{
+			name:  "BAD cursor",
+			query: "?cursor=bad",
+			want: want{
+				code: http.StatusBadRequest,
+				data: "Cannot list metrics: \"invalid cursor of metrics bad: invalid character 'm' looking for beginning of value\"\n",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Get(ts.URL + "/values" + tt.query)
+			require.NoError(t, err)
+			defer func() { _ = resp.Body.Close() }()
+			assert.Equal(t, tt.want.code, resp.StatusCode)
+
+			respBody, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want.data, string(respBody))
+		})
+	}
+
+	var page repository.MetricsPage
+	keys := make([]string, 0)
+	for cursor := ""; ; cursor = page.NextCursor {
+		resp, err := http.Get(ts.URL + "/values?limit=1&cursor=" + cursor)
+		require.NoError(t, err)
+		page = repository.MetricsPage{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&page))
+		require.NoError(t, resp.Body.Close())
+
+		require.Len(t, page.Metrics, 1)
+		keys = append(keys, page.Metrics[0].Key())
+		if page.NextCursor == "" {
+			break
+		}
+	}
+	assert.Equal(t, []string{"Alloc", `CPUutilization{cpu="0"}`, `CPUutilization{cpu="1"}`, "PollCount"}, keys)
+}
+
+func TestGaugeRouter(t *testing.T) {
+	mux := chi.NewRouter()
+	http2.RegisterHandlers(mux, repository.NewInMemoryStore(), "", testAdminToken)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	tests := []struct {
+		name string
+		url  string
+		body string
+		want want
+	}{
+		{
+			name: "Add to absent gauge",
+			url:  "/gauge/add",
+			body: `{"id":"InFlight","labels":{"host":"a"},"delta":2}`,
+			want: want{
+				code: http.StatusOK,
+				data: `{"id":"InFlight","type":"gauge","value":2,"labels":{"host":"a"}}`,
+			},
+		},
+		{
+			name: "Subtract from gauge",
+			url:  "/gauge/add",
+			body: `{"id":"InFlight","labels":{"host":"a"},"delta":-1.5}`,
+			want: want{
+				code: http.StatusOK,
+				data: `{"id":"InFlight","type":"gauge","value":0.5,"labels":{"host":"a"}}`,
+			},
+		},
+		{
+			name: "BAD add without delta",
+			url:  "/gauge/add",
+			body: `{"id":"InFlight"}`,
+			want: want{code: http.StatusBadRequest, data: "Delta is required field\n"},
+		},
+		{
+			name: "Set absent gauge",
+			url:  "/gauge/cas",
+			body: `{"id":"Leader","value":1}`,
+			want: want{code: http.StatusOK, data: `{"id":"Leader","type":"gauge","value":1}`},
+		},
+		{
+			name: "Compare and set gauge",
+			url:  "/gauge/cas",
+			body: `{"id":"Leader","expected":1,"value":2}`,
+			want: want{code: http.StatusOK, data: `{"id":"Leader","type":"gauge","value":2}`},
+		},
+		{
+			name: "BAD compare and set of changed gauge",
+			url:  "/gauge/cas",
+			body: `{"id":"Leader","expected":1,"value":3}`,
+			want: want{
+				code: http.StatusConflict,
+				data: "Gauge is changed: \"gauge value doesn't match expected one Leader: value is 2, expected 1\"\n",
+			},
+		},
+		{
+			name: "BAD set of existing gauge",
+			url:  "/gauge/cas",
+			body: `{"id":"Leader","value":3}`,
+			want: want{
+				code: http.StatusConflict,
+				data: "Gauge is changed: \"gauge value doesn't match expected one Leader: gauge exists with value 2\"\n",
+			},
+		},
+		{
+			name: "BAD compare and set without value",
+			url:  "/gauge/cas",
+			body: `{"id":"Leader","expected":2}`,
+			want: want{code: http.StatusBadRequest, data: "Value is required field\n"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Post(ts.URL+tt.url, "application/json", strings.NewReader(tt.body))
+			require.NoError(t, err)
+			defer func() { _ = resp.Body.Close() }()
+			assert.Equal(t, tt.want.code, resp.StatusCode)
+
+			respBody, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want.data, string(respBody))
+		})
+	}
+}
+
+func TestUpdatesRouter(t *testing.T) {
+	mux := chi.NewRouter()
+	http2.RegisterHandlers(mux, repository.NewInMemoryStore(), "", testAdminToken)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	tests := []struct {
+		name string
+		body string
+		want want
+	}{
+		{
+			name: "Update batch of metrics",
+			body: `[{"id":"Alloc","type":"gauge","value":1},{"id":"PollCount","type":"counter","delta":1}]`,
+			want: want{code: http.StatusOK},
+		},
+		{
+			name: "BAD update of existing gauge without value",
+			body: `[{"id":"Alloc","type":"gauge","value":2},{"id":"Alloc","type":"gauge"}]`,
+			want: want{
+				code: http.StatusBadRequest,
+				data: "Failed to update metrics: \"metric has no value of its type Alloc:gauge\"\n",
+			},
+		},
+		{
+			name: "BAD update of existing counter without delta",
+			body: `[{"id":"PollCount","type":"counter"}]`,
+			want: want{
+				code: http.StatusBadRequest,
+				data: "Failed to update metrics: \"metric has no value of its type PollCount:counter\"\n",
+			},
+		},
+		{
+			name: "BAD update of new gauge without value",
+			body: `[{"id":"HeapAlloc","type":"gauge"}]`,
+			want: want{
+				code: http.StatusBadRequest,
+				data: "Failed to update metrics: \"metric has no value of its type HeapAlloc:gauge\"\n",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Post(ts.URL+"/updates/", "application/json", strings.NewReader(tt.body))
+			require.NoError(t, err)
+			defer func() { _ = resp.Body.Close() }()
+			assert.Equal(t, tt.want.code, resp.StatusCode)
+
+			respBody, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want.data, string(respBody))
+		})
+	}
+
+	for url, want := range map[string]string{"/value/gauge/Alloc": "1", "/value/counter/PollCount": "1"} {
+		resp, err := http.Get(ts.URL + url)
+		require.NoError(t, err)
+		respBody, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, want, string(respBody), "rejected batches must not change %s", url)
+	}
+
+	resp, err := http.Get(ts.URL + "/value/gauge/HeapAlloc")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestAdminRouter_Authorization(t *testing.T) {
+	tests := []struct {
+		name        string
+		tenants     []tenant.Config
+		adminToken  string
+		headers     map[string]string
+		wantCode    int
+		wantDeleted bool
+	}{
+		{
+			name:        "Authorized request",
+			adminToken:  testAdminToken,
+			headers:     map[string]string{security.AdminTokenHeader: testAdminToken},
+			wantCode:    http.StatusOK,
+			wantDeleted: true,
+		},
+		{
+			name:       "Authorized request of tenant",
+			tenants:    []tenant.Config{{Name: "team-a", Token: "token-a"}},
+			adminToken: testAdminToken,
+			headers: map[string]string{
+				security.AdminTokenHeader: testAdminToken,
+				"Authorization":           "Bearer token-a",
+			},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:       "BAD request without token",
+			adminToken: testAdminToken,
+			wantCode:   http.StatusUnauthorized,
+		},
+		{
+			name:       "BAD request with wrong token",
+			adminToken: testAdminToken,
+			headers:    map[string]string{security.AdminTokenHeader: "wrong-token"},
+			wantCode:   http.StatusUnauthorized,
+		},
+		{
+			name:       "BAD request with admin token as token of tenant",
+			adminToken: testAdminToken,
+			headers:    map[string]string{"Authorization": "Bearer " + testAdminToken},
+			wantCode:   http.StatusUnauthorized,
+		},
+		{
+			name:     "BAD request when admin token isn't configured",
+			headers:  map[string]string{security.AdminTokenHeader: ""},
+			wantCode: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			metricsStore := repository.NewInMemoryStore()
+			require.NoError(t, metricsStore.UpdateGaugeMetric(ctx, "Decommissioned", 1))
+
+			tenants, err := tenant.NewRegistry(tt.tenants)
+			require.NoError(t, err)
+			router := http2.NewRouter(&http2.Config{}, repository.NewTenantStore(metricsStore), "", tt.adminToken,
+				tenants, nil)
+			ts := httptest.NewServer(router)
+			defer ts.Close()
+
+			for _, url := range []string{"/admin/cardinality", "/admin/delete?prefix=Decommissioned"} {
+				method := http.MethodGet
+				if strings.HasPrefix(url, "/admin/delete") {
+					method = http.MethodPost
+				}
+				req, err := http.NewRequest(method, ts.URL+url, nil)
+				require.NoError(t, err)
+				for name, value := range tt.headers {
+					req.Header.Set(name, value)
+				}
+
+				resp, err := http.DefaultClient.Do(req)
+				require.NoError(t, err)
+				require.NoError(t, resp.Body.Close())
+				assert.Equal(t, tt.wantCode, resp.StatusCode, url)
+			}
+
+			_, err = metricsStore.GetMetric(ctx, "Decommissioned", metrics.MetricTypeGauge)
+			if tt.wantDeleted {
+				assert.ErrorIs(t, err, repository.ErrMetricNotFound)
+			} else {
+				assert.NoError(t, err, "unauthorized request must not delete metrics")
+			}
+		})
+	}
+}
+
+func TestRouter_Limits(t *testing.T) {
+	limitedStore, err := repository.NewLimitedStore(context.Background(), repository.NewInMemoryStore(),
+		repository.Limits{MaxMetrics: 2, AllowedNames: regexp.MustCompile("^(?:[A-Za-z_]+)$")})
+	require.NoError(t, err)
+
+	mux := chi.NewRouter()
+	http2.RegisterHandlers(mux, limitedStore, "", testAdminToken)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		body   string
+		want   want
+	}{
+		{
+			name:   "Update allowed metric",
+			method: http.MethodPost,
+			url:    "/update/gauge/request_count/1",
+			want:   want{code: http.StatusOK},
+		},
+		{
+			name:   "BAD update of metric with not allowed name",
+			method: http.MethodPost,
+			url:    "/update/gauge/request_7f3a/1",
+			want:   want{code: http.StatusBadRequest},
+		},
+		{
+			name:   "BAD batch update over limit of metrics",
+			method: http.MethodPost,
+			url:    "/updates/",
+			body:   `[{"id":"Alloc","type":"gauge","value":1},{"id":"HeapAlloc","type":"gauge","value":1}]`,
+			want:   want{code: http.StatusTooManyRequests},
+		},
+		{
+			name:   "JSON update of metric within limit",
+			method: http.MethodPost,
+			url:    "/update/",
+			body:   `{"id":"request_size","type":"counter","delta":1,"labels":{"host":"a"}}`,
+			want:   want{code: http.StatusOK},
+		},
+		{
+			name:   "BAD JSON update over limit of metrics",
+			method: http.MethodPost,
+			url:    "/update/",
+			body:   `{"id":"request_size","type":"counter","delta":1,"labels":{"host":"b"}}`,
+			want:   want{code: http.StatusTooManyRequests},
+		},
+		{
+			name:   "Get cardinality of metrics",
+			method: http.MethodGet,
+			url:    "/admin/cardinality?limit=1",
+			want: want{
+				code: http.StatusOK,
+				data: `{"series":2,"prefixes":[{"prefix":"request","series":2,"names":2}]}`,
+			},
+		},
+		{
+			name:   "BAD limit of cardinality report",
+			method: http.MethodGet,
+			url:    "/admin/cardinality?limit=all",
+			want: want{
+				code: http.StatusBadRequest,
+				data: "Cannot parse limit: \"strconv.Atoi: parsing \\\"all\\\": invalid syntax\"\n",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, ts.URL+tt.url, strings.NewReader(tt.body))
+			require.NoError(t, err)
+			req.Header.Set(security.AdminTokenHeader, testAdminToken)
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer func() { _ = resp.Body.Close() }()
+			assert.Equal(t, tt.want.code, resp.StatusCode)
+
+			if tt.method == http.MethodGet {
+				respBody, err := ioutil.ReadAll(resp.Body)
+				require.NoError(t, err)
+				assert.Equal(t, tt.want.data, string(respBody))
+			}
+		})
+	}
+}
+
+func TestRouter_TenantCardinality(t *testing.T) {
+	tenants, err := tenant.NewRegistry([]tenant.Config{
+		{Name: "team-a", Token: "token-a"},
+		{Name: "team-b", Token: "token-b"},
+	})
+	require.NoError(t, err)
+	limitedStore, err := repository.NewLimitedStore(context.Background(), repository.NewInMemoryStore(),
+		repository.Limits{MaxMetrics: 2})
+	require.NoError(t, err)
+
+	router := http2.NewRouter(&http2.Config{}, repository.NewTenantStore(limitedStore), "", testAdminToken, tenants,
+		nil)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	do := func(method string, url string, token string) (int, string) {
+		req, err := http.NewRequest(method, ts.URL+url, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set(security.AdminTokenHeader, testAdminToken)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer func() { _ = resp.Body.Close() }()
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		return resp.StatusCode, string(body)
+	}
+
+	for _, url := range []string{"/update/gauge/request_size/1", "/update/gauge/request_time/1"} {
+		code, _ := do(http.MethodPost, url, "token-a")
+		require.Equal(t, http.StatusOK, code)
+	}
+	code, _ := do(http.MethodPost, "/update/gauge/response_size/1", "token-a")
+	assert.Equal(t, http.StatusTooManyRequests, code, "tenant must be limited by its own metrics")
+	code, _ = do(http.MethodPost, "/update/gauge/response_size/1", "token-b")
+	assert.Equal(t, http.StatusOK, code, "limit must not be exhausted by other tenant")
+
+	code, body := do(http.MethodGet, "/admin/cardinality", "token-a")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, `{"series":2,"prefixes":[{"prefix":"request","series":2,"names":2}]}`, body)
+
+	code, body = do(http.MethodGet, "/admin/cardinality", "token-b")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, `{"series":1,"prefixes":[{"prefix":"response","series":1,"names":1}]}`, body)
+}
+
 func BenchmarkRouter(b *testing.B) {
 	mux := chi.NewRouter()
-	http2.RegisterHandlers(mux, repository.NewInMemoryStore(), "")
+	http2.RegisterHandlers(mux, repository.NewInMemoryStore(), "", testAdminToken)
 	ts := httptest.NewServer(mux)
 	defer ts.Close()
 
@@ -290,6 +1171,7 @@ func testJSONRequest(t *testing.T, ts *httptest.Server, testData testJSON) {
 func testRequest(t *testing.T, ts *httptest.Server, testData test) {
 	req, err := http.NewRequest(testData.method, ts.URL+testData.metric, nil)
 	require.NoError(t, err)
+	req.Header.Set(security.AdminTokenHeader, testAdminToken)
 
 	resp, err := http.DefaultClient.Do(req)
 	assert.Equal(t, testData.want.code, resp.StatusCode)
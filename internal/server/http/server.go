@@ -11,6 +11,7 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 
 	"github.com/itd27m01/go-metrics-service/internal/repository"
+	"github.com/itd27m01/go-metrics-service/internal/tenant"
 	"github.com/itd27m01/go-metrics-service/pkg/encryption"
 	"github.com/itd27m01/go-metrics-service/pkg/logging"
 	"github.com/itd27m01/go-metrics-service/pkg/logging/log"
@@ -34,6 +35,8 @@ type Config struct {
 type Server struct {
 	Cfg          *Config
 	SignKey      string
+	AdminToken   string
+	Tenants      *tenant.Registry
 	metricsStore repository.Store
 	privateKey   *rsa.PrivateKey
 }
@@ -55,22 +58,7 @@ func (s *Server) Start(ctx context.Context, storage repository.Store) error {
 
 // listenAndServe registers handlers and starts listener
 func (s *Server) listenAndServe(ctx context.Context) error {
-	router := chi.NewRouter()
-
-	router.Use(logging.HTTPRequestLogger())
-	router.Use(middleware.RequestID)
-	router.Use(middleware.RealIP)
-	router.Use(security.CheckRealIP(s.Cfg.TrustedSubnet))
-	router.Use(middleware.Recoverer)
-
-	compressor := middleware.NewCompressor(gzip.BestCompression)
-	router.Use(compressor.Handler)
-
-	router.Use(encryption.BodyDecrypt(s.privateKey))
-
-	router.Mount("/debug", middleware.Profiler())
-
-	RegisterHandlers(router, s.metricsStore, s.SignKey)
+	router := NewRouter(s.Cfg, s.metricsStore, s.SignKey, s.AdminToken, s.Tenants, s.privateKey)
 	httpServer := &http.Server{
 		Addr:    s.Cfg.ServerAddress,
 		Handler: router,
@@ -87,3 +75,28 @@ func (s *Server) listenAndServe(ctx context.Context) error {
 
 	return httpServer.ListenAndServe()
 }
+
+// NewRouter creates router of metrics server with its middlewares and handlers,
+// administrative requests are authorized by X-Admin-Token header
+func NewRouter(cfg *Config, metricsStore repository.Store, signKey string, adminToken string,
+	tenants *tenant.Registry, privateKey *rsa.PrivateKey) *chi.Mux {
+	router := chi.NewRouter()
+
+	router.Use(logging.HTTPRequestLogger())
+	router.Use(middleware.RequestID)
+	router.Use(middleware.RealIP)
+	router.Use(security.CheckRealIP(cfg.TrustedSubnet))
+	router.Use(middleware.Recoverer)
+
+	compressor := middleware.NewCompressor(gzip.BestCompression)
+	router.Use(compressor.Handler)
+
+	router.Use(encryption.BodyDecrypt(privateKey))
+	router.Use(tenant.Middleware(tenants))
+
+	router.Mount("/debug", middleware.Profiler())
+
+	RegisterHandlers(router, metricsStore, signKey, adminToken)
+
+	return router
+}
@@ -8,9 +8,11 @@ import (
 	"syscall"
 
 	"github.com/itd27m01/go-metrics-service/internal/config"
+	"github.com/itd27m01/go-metrics-service/internal/repository"
 	"github.com/itd27m01/go-metrics-service/internal/server/grpc"
 	"github.com/itd27m01/go-metrics-service/internal/server/http"
 	"github.com/itd27m01/go-metrics-service/internal/server/storage"
+	"github.com/itd27m01/go-metrics-service/internal/tenant"
 	"github.com/itd27m01/go-metrics-service/pkg/logging/log"
 )
 
@@ -37,28 +39,38 @@ func (ms *MetricsServer) Start(parent context.Context) {
 		}
 	}()
 
+	tenants, err := tenant.NewRegistry(ms.Cfg.Tenants)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to configure tenants")
+	}
+	tenantStorage := repository.NewTenantStore(metricsStorage)
+
 	wg := sync.WaitGroup{}
 
 	ms.http = http.Server{
-		Cfg:     &ms.Cfg.HTTPConfig,
-		SignKey: ms.Cfg.SignKey,
+		Cfg:        &ms.Cfg.HTTPConfig,
+		SignKey:    ms.Cfg.SignKey,
+		AdminToken: ms.Cfg.AdminToken,
+		Tenants:    tenants,
 	}
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		if err := ms.http.Start(ctx, metricsStorage); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		if err := ms.http.Start(ctx, tenantStorage); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Fatal().Err(err).Msgf("error on listen and serve HTTP server: %s", err)
 		}
 	}()
 
 	ms.grpc = grpc.Server{
-		Cfg:     &ms.Cfg.GRPCConfig,
-		SignKey: ms.Cfg.SignKey,
+		Cfg:        &ms.Cfg.GRPCConfig,
+		SignKey:    ms.Cfg.SignKey,
+		AdminToken: ms.Cfg.AdminToken,
+		Tenants:    tenants,
 	}
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		if err := ms.grpc.Start(ctx, metricsStorage); err != nil {
+		if err := ms.grpc.Start(ctx, tenantStorage); err != nil {
 			log.Fatal().Err(err).Msgf("error on listen and serve GRPC server: %s", err)
 		}
 	}()
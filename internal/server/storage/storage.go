@@ -2,48 +2,159 @@ package storage
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
 	"time"
 
+	"github.com/itd27m01/go-metrics-service/internal/flusher"
 	"github.com/itd27m01/go-metrics-service/internal/preserver"
 	"github.com/itd27m01/go-metrics-service/internal/repository"
+	"github.com/itd27m01/go-metrics-service/internal/sweeper"
+	"github.com/itd27m01/go-metrics-service/pkg/encryption"
 	"github.com/itd27m01/go-metrics-service/pkg/logging/log"
 )
 
+const (
+	walSuffix = ".wal"
+	// flushTimeout limits the last flush of write-behind store on shutdown
+	flushTimeout = 10 * time.Second
+)
+
 // Config collects configuration for metrics storage
 type Config struct {
-	DatabaseDSN   string        `yaml:"database_dsn" env:"DATABASE_DSN"`
-	StoreFilePath string        `yaml:"store_file_path" env:"STORE_FILE"`
-	StoreInterval time.Duration `yaml:"store_interval" env:"STORE_INTERVAL"`
-	Restore       bool          `yaml:"restore" env:"RESTORE"`
+	DatabaseDSN      string        `yaml:"database_dsn" env:"DATABASE_DSN"`
+	StoreFilePath    string        `yaml:"store_file_path" env:"STORE_FILE"`
+	BoltPath         string        `yaml:"bolt_path" env:"STORE_BOLT"`
+	StoreInterval    time.Duration `yaml:"store_interval" env:"STORE_INTERVAL"`
+	Restore          bool          `yaml:"restore" env:"RESTORE"`
+	WAL              bool          `yaml:"wal" env:"STORE_WAL"`
+	HistoryRetention time.Duration `yaml:"history_retention" env:"HISTORY_RETENTION"`
+	Shards           int           `yaml:"shards" env:"STORE_SHARDS"`
+	// FlushInterval enables write-behind to database, updates are served from memory and flushed on the interval
+	FlushInterval time.Duration `yaml:"flush_interval" env:"STORE_FLUSH_INTERVAL"`
+	// TTL of metrics after the last update, TTLOverrides overrides it for metrics by the longest prefix of name
+	TTL           time.Duration            `yaml:"ttl" env:"STORE_TTL"`
+	TTLOverrides  map[string]time.Duration `yaml:"ttl_overrides"`
+	SweepInterval time.Duration            `yaml:"sweep_interval" env:"STORE_SWEEP_INTERVAL"`
+	// Mirrors are database DSNs, bolt:// paths or file paths of secondary stores which get copies of every update
+	Mirrors     []string `yaml:"mirrors" env:"STORE_MIRRORS" envSeparator:","`
+	MirrorAsync bool     `yaml:"mirror_async" env:"STORE_MIRROR_ASYNC"`
+	// MirrorResyncEmpty allows to wipe secondary stores on start when the primary store is empty
+	MirrorResyncEmpty bool `yaml:"mirror_resync_empty" env:"STORE_MIRROR_RESYNC_EMPTY"`
+	// Limits of cardinality of metrics which are checked before writes for every tenant, zero limit is disabled.
+	// AllowedNames is a regexp which has to match the whole name of metric.
+	MaxMetrics      int    `yaml:"max_metrics" env:"STORE_MAX_METRICS"`
+	MaxNewPerMinute int    `yaml:"max_new_per_minute" env:"STORE_MAX_NEW_PER_MINUTE"`
+	MaxNameLength   int    `yaml:"max_name_length" env:"STORE_MAX_NAME_LENGTH"`
+	AllowedNames    string `yaml:"allowed_names" env:"STORE_ALLOWED_NAMES"`
+	// SnapshotKeys are base64 encoded AES keys of snapshots of file stores followed by keys of SnapshotKeyFile,
+	// the first key encrypts snapshots and the rest only decrypt them to rotate keys
+	SnapshotKeys    []string `yaml:"snapshot_keys" env:"STORE_KEYS" envSeparator:","`
+	SnapshotKeyFile string   `yaml:"snapshot_key_file" env:"STORE_KEY_FILE"`
+	// ArchiveDir enables archive of compressed snapshots of file store on ArchiveInterval,
+	// the latest ArchiveKeep snapshots which are younger than ArchiveMaxAge are kept
+	ArchiveDir      string        `yaml:"archive_dir" env:"STORE_ARCHIVE_DIR"`
+	ArchiveInterval time.Duration `yaml:"archive_interval" env:"STORE_ARCHIVE_INTERVAL"`
+	ArchiveKeep     int           `yaml:"archive_keep" env:"STORE_ARCHIVE_KEEP"`
+	ArchiveMaxAge   time.Duration `yaml:"archive_max_age" env:"STORE_ARCHIVE_MAX_AGE"`
+	// RestoreFrom is a path of snapshot or RFC3339 time of archived snapshot to restore metrics of file store from,
+	// it takes precedence over Restore
+	RestoreFrom string `yaml:"restore_from" env:"RESTORE_FROM"`
 }
 
 // StartMetricsStorage starts storage repository for metrics
 func StartMetricsStorage(ctx context.Context, config *Config) (repository.Store, func() error) {
+	metricsStore, closeStore := startStorage(ctx, config)
+	// history of the primary store is pruned, secondary stores don't keep history
+	historyPruner, _ := metricsStore.(repository.HistoryPruner)
+	if len(config.Mirrors) > 0 {
+		metricsStore, closeStore = startMirrorStorage(ctx, metricsStore, closeStore, config)
+	}
+	metricsStore = startLimitedStorage(ctx, metricsStore, config)
+
+	sweeperContext, sweeperCancel := context.WithCancel(ctx)
+	startSweeper(sweeperContext, metricsStore, historyPruner, config)
+
+	return metricsStore, func() error {
+		sweeperCancel()
+
+		return closeStore()
+	}
+}
+
+// startStorage starts the primary storage repository for metrics
+func startStorage(ctx context.Context, config *Config) (repository.Store, func() error) {
 	switch {
 	case config.DatabaseDSN != "":
-		metricsStore, err := repository.NewDBStore(config.DatabaseDSN)
+		metricsStore, err := repository.NewDBStore(config.DatabaseDSN, config.HistoryRetention)
 		if err != nil {
 			log.Fatal().Msgf("Couldn't connect to database: %q", err)
 		}
 
+		if config.FlushInterval > 0 {
+			return startTieredStorage(ctx, metricsStore, config)
+		}
+
 		log.Info().Msg("Using Database storage")
 
+		return metricsStore, func() error {
+			return metricsStore.Close()
+		}
+	case config.BoltPath != "":
+		metricsStore, err := repository.NewBoltStore(config.BoltPath, config.HistoryRetention)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to open bolt storage")
+		}
+
+		log.Info().Msg("Using bolt storage")
+
 		return metricsStore, func() error {
 			return metricsStore.Close()
 		}
 	case config.StoreFilePath != "":
 		syncChannel := make(chan struct{}, 1)
-		metricsStore, err := repository.NewFileStore(config.StoreFilePath, syncChannel)
+		metricsStore, err := repository.NewFileStore(config.StoreFilePath, syncChannel, config.HistoryRetention)
 		if err != nil {
 			log.Fatal().Err(err).Msg("Failed to make file storage")
 		}
 
 		log.Info().Msg("Using file storage")
 
+		if keys := snapshotKeys(config); keys != nil {
+			metricsStore.EncryptSnapshots(keys)
+
+			log.Info().Msgf("Using encryption of snapshots with key %s", keys.KeyID())
+		}
+
+		if config.WAL {
+			if err := metricsStore.EnableWAL(config.StoreFilePath + walSuffix); err != nil {
+				log.Fatal().Err(err).Msg("Failed to open write-ahead log")
+			}
+
+			log.Info().Msg("Using write-ahead log for file storage")
+		}
+
 		metricsPreserver := preserver.NewPreserver(metricsStore, config.StoreInterval, syncChannel)
+		if config.ArchiveDir != "" {
+			metricsPreserver.EnableArchive(config.ArchiveDir, config.ArchiveInterval,
+				repository.Retention{Keep: config.ArchiveKeep, MaxAge: config.ArchiveMaxAge})
+		}
 
-		if config.Restore && metricsStore.LoadMetrics() != nil {
-			log.Error().Msg("Filed to load metrics from store")
+		if config.RestoreFrom != "" {
+			restoreMetrics(metricsStore, config)
+		} else if config.Restore {
+			err := metricsStore.LoadMetrics()
+			switch {
+			case errors.Is(err, repository.ErrSnapshotKey):
+				log.Fatal().Err(err).Msg("Failed to decrypt metrics of store, check keys of snapshots")
+			case err != nil:
+				log.Error().Msg("Filed to load metrics from store")
+			}
+		}
+		if !config.Restore && config.RestoreFrom == "" && config.WAL && metricsStore.SaveMetrics() != nil {
+			log.Error().Msg("Filed to reset write-ahead log")
 		}
 
 		preserverContext, preserverCancel := context.WithCancel(ctx)
@@ -64,10 +175,248 @@ func StartMetricsStorage(ctx context.Context, config *Config) (repository.Store,
 			return err
 		}
 	default:
-		log.Info().Msg("Using memory storage")
+		var metricsStore repository.Store
+		if config.Shards > 1 {
+			log.Info().Msgf("Using sharded memory storage with %d shards", config.Shards)
+
+			metricsStore = repository.NewShardedStore(config.Shards, config.HistoryRetention)
+		} else {
+			log.Info().Msg("Using memory storage")
+
+			metricsStore = repository.NewInMemoryStoreWithHistory(config.HistoryRetention)
+		}
 
-		return repository.NewInMemoryStore(), func() error {
+		return metricsStore, func() error {
 			return nil
 		}
 	}
 }
+
+// startTieredStorage starts memory storage which writes metrics behind to database
+func startTieredStorage(ctx context.Context, dbStore *repository.DBStore, config *Config) (repository.Store, func() error) {
+	metricsStore := repository.NewTieredStore(dbStore)
+	if err := metricsStore.LoadMetrics(ctx); err != nil {
+		log.Fatal().Err(err).Msg("Failed to load metrics from database")
+	}
+
+	log.Info().Msg("Using memory storage with write-behind to database")
+
+	flusherContext, flusherCancel := context.WithCancel(ctx)
+
+	go flusher.NewFlusher(metricsStore, config.FlushInterval).RunFlusher(flusherContext)
+
+	return metricsStore, func() error {
+		flusherCancel()
+
+		flushContext, flushCancel := context.WithTimeout(context.Background(), flushTimeout)
+		defer flushCancel()
+
+		var err error
+		if err = metricsStore.Flush(flushContext); err != nil {
+			log.Error().Err(err).Msg("Something went wrong during metrics flush, unflushed changes are lost")
+		}
+
+		if err = dbStore.Close(); err != nil {
+			log.Error().Err(err).Msg("Something went wrong during database close")
+		}
+
+		return err
+	}
+}
+
+// startMirrorStorage starts secondary stores and mirrors updates of the primary store to them
+func startMirrorStorage(ctx context.Context, primaryStore repository.Store, closePrimary func() error,
+	config *Config) (repository.Store, func() error) {
+	secondaries := make([]repository.Secondary, 0, len(config.Mirrors))
+	closeSecondaries := make([]func() error, 0, len(config.Mirrors))
+	for i, mirror := range config.Mirrors {
+		secondaryStore, closeSecondary := startSecondaryStorage(ctx, mirror, config)
+
+		secondaries = append(secondaries, repository.Secondary{Name: secondaryName(i, mirror), Store: secondaryStore})
+		closeSecondaries = append(closeSecondaries, closeSecondary)
+	}
+
+	metricsStore := repository.NewMirrorStore(primaryStore, config.MirrorAsync, secondaries...)
+	err := metricsStore.Resync(ctx, config.MirrorResyncEmpty)
+	switch {
+	case errors.Is(err, repository.ErrEmptyPrimary):
+		log.Error().Err(err).Msg("Secondary stores are kept as is, restore the primary store from them " +
+			"or allow resync from empty primary store")
+	case err != nil:
+		log.Error().Err(err).Msg("Failed to copy metrics to secondary stores")
+	}
+
+	if config.MirrorAsync {
+		log.Info().Msgf("Mirror metrics to %d secondary stores asynchronously", len(secondaries))
+	} else {
+		log.Info().Msgf("Mirror metrics to %d secondary stores", len(secondaries))
+	}
+
+	return metricsStore, func() error {
+		if err := metricsStore.Close(); err != nil {
+			log.Error().Err(err).Msg("Something went wrong during mirroring close")
+		}
+
+		for _, closeSecondary := range closeSecondaries {
+			if err := closeSecondary(); err != nil {
+				log.Error().Err(err).Msg("Something went wrong during secondary store close")
+			}
+		}
+
+		return closePrimary()
+	}
+}
+
+// secondaryName names secondary store by its kind and position in mirrors, the name is seen in labels of metrics
+// and in logs, so it's never made of DSN which can have credentials
+func secondaryName(i int, mirror string) string {
+	kind := "file"
+	switch {
+	case strings.HasPrefix(mirror, "postgres://") || strings.HasPrefix(mirror, "postgresql://"):
+		kind = "postgres"
+	case strings.HasPrefix(mirror, repository.SQLiteScheme):
+		kind = "sqlite"
+	case strings.HasPrefix(mirror, "bolt://"):
+		kind = "bolt"
+	}
+
+	return fmt.Sprintf("%s-%d", kind, i+1)
+}
+
+// startSecondaryStorage starts secondary store by database DSN, sqlite:// path, bolt:// path or file path,
+// secondary stores don't keep history and file store is preserved on the same interval as the primary one
+func startSecondaryStorage(ctx context.Context, mirror string, config *Config) (repository.Store, func() error) {
+	if strings.HasPrefix(mirror, "postgres://") || strings.HasPrefix(mirror, "postgresql://") ||
+		strings.HasPrefix(mirror, repository.SQLiteScheme) {
+		metricsStore, err := repository.NewDBStore(mirror, 0)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Couldn't connect to secondary database")
+		}
+
+		return metricsStore, metricsStore.Close
+	}
+	if boltPath := strings.TrimPrefix(mirror, "bolt://"); boltPath != mirror {
+		metricsStore, err := repository.NewBoltStore(boltPath, 0)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to open secondary bolt storage")
+		}
+
+		return metricsStore, metricsStore.Close
+	}
+
+	syncChannel := make(chan struct{}, 1)
+	metricsStore, err := repository.NewFileStore(mirror, syncChannel, 0)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to make secondary file storage")
+	}
+	if keys := snapshotKeys(config); keys != nil {
+		metricsStore.EncryptSnapshots(keys)
+	}
+
+	preserverContext, preserverCancel := context.WithCancel(ctx)
+
+	go preserver.NewPreserver(metricsStore, config.StoreInterval, syncChannel).RunPreserver(preserverContext)
+
+	return metricsStore, func() error {
+		var err error
+		if err = metricsStore.SaveMetrics(); err != nil {
+			log.Error().Err(err).Msg("Something went wrong during metrics preserve")
+		}
+
+		if err = metricsStore.Close(); err != nil {
+			log.Error().Err(err).Msg("Something went wrong during file close")
+		}
+		preserverCancel()
+
+		return err
+	}
+}
+
+// restoreMetrics restores metrics of file store from snapshot by path or from the latest archived snapshot
+// which isn't newer than RFC3339 time, server doesn't start with metrics which weren't asked for
+func restoreMetrics(metricsStore *repository.FileStore, config *Config) {
+	snapshotPath := config.RestoreFrom
+	if restoreAt, err := time.Parse(time.RFC3339, config.RestoreFrom); err == nil {
+		if config.ArchiveDir == "" {
+			log.Fatal().Msg("Archive of snapshots isn't configured to restore metrics at time")
+		}
+
+		snapshot, err := metricsStore.FindArchivedSnapshot(config.ArchiveDir, restoreAt)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to find archived snapshot to restore metrics")
+		}
+		snapshotPath = snapshot.Path
+	}
+
+	if err := metricsStore.RestoreMetrics(snapshotPath); err != nil {
+		log.Fatal().Err(err).Msgf("Failed to restore metrics from %s", snapshotPath)
+	}
+}
+
+// snapshotKeys returns key ring of snapshots of file stores, it's nil if no keys are configured
+func snapshotKeys(config *Config) *encryption.AESKeyRing {
+	fileKeys, err := encryption.ReadAESKeys(config.SnapshotKeyFile)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to read keys of snapshots")
+	}
+
+	encodedKeys := make([]string, 0, len(config.SnapshotKeys)+len(fileKeys))
+	encodedKeys = append(encodedKeys, config.SnapshotKeys...)
+	keys, err := encryption.NewAESKeyRing(append(encodedKeys, fileKeys...)...)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to make keys of snapshots")
+	}
+
+	return keys
+}
+
+// startLimitedStorage checks limits of cardinality of metrics before writes to the store if any of them is configured
+func startLimitedStorage(ctx context.Context, metricsStore repository.Store, config *Config) repository.Store {
+	limits := repository.Limits{
+		MaxMetrics:      config.MaxMetrics,
+		MaxNewPerMinute: config.MaxNewPerMinute,
+		MaxNameLength:   config.MaxNameLength,
+	}
+	if config.AllowedNames != "" {
+		allowedNames, err := regexp.Compile("^(?:" + config.AllowedNames + ")$")
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to parse allowed names of metrics")
+		}
+		limits.AllowedNames = allowedNames
+	}
+	if !limits.Enabled() {
+		return metricsStore
+	}
+
+	limitedStore, err := repository.NewLimitedStore(ctx, metricsStore, limits)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to count metrics of storage")
+	}
+
+	log.Info().Msg("Using limits of cardinality of metrics")
+
+	return limitedStore
+}
+
+// startSweeper runs sweeper of stale metrics if TTL of metrics is configured
+// and of expired history if the store prunes its history by sweeper
+func startSweeper(ctx context.Context, metricsStore repository.Store, historyPruner repository.HistoryPruner,
+	config *Config) {
+	ttl := repository.TTL{
+		Default:   config.TTL,
+		Overrides: config.TTLOverrides,
+	}
+	if config.HistoryRetention <= 0 {
+		historyPruner = nil
+	}
+	if (!ttl.Enabled() && historyPruner == nil) || config.SweepInterval <= 0 {
+		return
+	}
+
+	metricsSweeper := sweeper.NewSweeper(metricsStore, &ttl, config.SweepInterval)
+	if historyPruner != nil {
+		metricsSweeper.PruneHistory(historyPruner)
+	}
+
+	go metricsSweeper.RunSweeper(ctx)
+}
@@ -0,0 +1,90 @@
+package sweeper
+
+import (
+	"context"
+	"time"
+
+	"github.com/itd27m01/go-metrics-service/internal/repository"
+	"github.com/itd27m01/go-metrics-service/pkg/logging/log"
+)
+
+// Sweeper defines worker to evict stale metrics and expired samples of history from store
+type Sweeper struct {
+	store         repository.Store
+	ttl           *repository.TTL
+	history       repository.HistoryPruner
+	sweepInterval time.Duration
+}
+
+// NewSweeper creates sweeper
+func NewSweeper(store repository.Store, ttl *repository.TTL, sweepInterval time.Duration) *Sweeper {
+	s := Sweeper{
+		store:         store,
+		ttl:           ttl,
+		sweepInterval: sweepInterval,
+	}
+
+	return &s
+}
+
+// PruneHistory makes sweeper drop expired samples of history by pruner on every sweep
+func (s *Sweeper) PruneHistory(history repository.HistoryPruner) *Sweeper {
+	s.history = history
+
+	return s
+}
+
+// RunSweeper runs sweeper worker
+func (s *Sweeper) RunSweeper(ctx context.Context) {
+	log.Info().Msgf("Run sweeper for metrics every %s", s.sweepInterval)
+
+	sweepTicker := time.NewTicker(s.sweepInterval)
+	defer sweepTicker.Stop()
+
+	for {
+		select {
+		case now := <-sweepTicker.C:
+			s.sweep(ctx, now)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sweep evicts metrics which are stale at now and samples of history which are expired at now
+func (s *Sweeper) sweep(ctx context.Context, now time.Time) {
+	if s.ttl.Enabled() {
+		s.evictStale(ctx, now)
+	}
+	if s.history != nil {
+		s.pruneHistory(ctx, now)
+	}
+}
+
+// evictStale evicts metrics which are stale at now
+func (s *Sweeper) evictStale(ctx context.Context, now time.Time) {
+	deleted, err := s.store.DeleteStaleMetrics(ctx, s.ttl, now)
+	if err != nil {
+		log.Error().Err(err).Msg("Something went wrong during stale metrics eviction")
+
+		return
+	}
+
+	if deleted > 0 {
+		log.Info().Msgf("Evict %d stale metrics", deleted)
+	}
+}
+
+// pruneHistory drops samples of history which are expired at now
+func (s *Sweeper) pruneHistory(ctx context.Context, now time.Time) {
+	pruned, err := s.history.PruneHistory(ctx, now)
+	if err != nil {
+		log.Error().Err(err).Msg("Something went wrong during history pruning")
+
+		return
+	}
+
+	if pruned > 0 {
+		log.Info().Msgf("Prune %d expired samples of history", pruned)
+	}
+}
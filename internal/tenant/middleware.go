@@ -0,0 +1,158 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Header and gRPC metadata keys which carry the name and the auth token of tenant
+const (
+	HeaderName          = "X-Tenant"
+	authorizationHeader = "Authorization"
+	bearerPrefix        = "Bearer "
+	metadataName        = "x-tenant"
+	metadataToken       = "authorization"
+)
+
+// Middleware resolves tenant of request by X-Tenant header and bearer token and puts it to the request context
+func Middleware(registry *Registry) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := strings.TrimPrefix(r.Header.Get(authorizationHeader), bearerPrefix)
+
+			t, err := registry.Resolve(r.Header.Get(HeaderName), token)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Cannot resolve tenant: %q", err), statusOf(err))
+
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), t)))
+		})
+	}
+}
+
+// statusOf returns HTTP status of failed resolving of tenant
+func statusOf(err error) int {
+	if errors.Is(err, ErrUnauthorized) {
+		return http.StatusUnauthorized
+	}
+
+	return http.StatusForbidden
+}
+
+// UnaryServerInterceptor resolves tenant of unary call by its metadata and puts it to the call context
+func UnaryServerInterceptor(registry *Registry) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := resolveIncoming(ctx, registry)
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor resolves tenant of stream by its metadata and puts it to the stream context
+func StreamServerInterceptor(registry *Registry) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := resolveIncoming(stream.Context(), registry)
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, &serverStream{ServerStream: stream, ctx: ctx})
+	}
+}
+
+// serverStream overrides context of the server stream
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context returns context of stream with tenant
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// resolveIncoming returns context with tenant resolved by incoming metadata of call
+func resolveIncoming(ctx context.Context, registry *Registry) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	t, err := registry.Resolve(firstValue(md, metadataName),
+		strings.TrimPrefix(firstValue(md, metadataToken), bearerPrefix))
+	if err != nil {
+		code := codes.PermissionDenied
+		if errors.Is(err, ErrUnauthorized) {
+			code = codes.Unauthenticated
+		}
+
+		return nil, status.Error(code, err.Error())
+	}
+
+	return NewContext(ctx, t), nil
+}
+
+// firstValue returns the first value of metadata key
+func firstValue(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+
+	return ""
+}
+
+// NewOutgoingContext returns context of gRPC calls which are made on behalf of tenant, empty values aren't sent
+func NewOutgoingContext(ctx context.Context, name string, token string) context.Context {
+	if name != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, metadataName, name)
+	}
+	if token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, metadataToken, bearerPrefix+token)
+	}
+
+	return ctx
+}
+
+// RoundTripper sets name and auth token of tenant to requests
+type RoundTripper struct {
+	proxied http.RoundTripper
+	name    string
+	token   string
+}
+
+// NewRoundTripper creates round tripper which makes requests on behalf of tenant, empty values aren't sent
+func NewRoundTripper(proxied http.RoundTripper, name string, token string) *RoundTripper {
+	return &RoundTripper{
+		proxied: proxied,
+		name:    name,
+		token:   token,
+	}
+}
+
+// RoundTrip sets headers of tenant to the copy of request and sends it
+func (rt *RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if rt.name == "" && rt.token == "" {
+		return rt.proxied.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	if rt.name != "" {
+		req.Header.Set(HeaderName, rt.name)
+	}
+	if rt.token != "" {
+		req.Header.Set(authorizationHeader, bearerPrefix+rt.token)
+	}
+
+	return rt.proxied.RoundTrip(req)
+}
@@ -0,0 +1,130 @@
+// Package tenant provides isolation of metrics of several teams which are hosted on one server
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Errors of tenants
+var (
+	ErrInvalidConfig = errors.New("invalid config of tenants")
+	ErrUnknownTenant = errors.New("unknown tenant")
+	ErrUnauthorized  = errors.New("tenant is not authorized")
+)
+
+// maxNameLength limits the name of tenant which is a part of storage keys of its metrics
+const maxNameLength = 64
+
+// Config defines tenant with its auth token and the key to sign its metrics.
+// Tenant without token is selected by name only, tenant without sign key uses the sign key of server.
+type Config struct {
+	Name    string `yaml:"name"`
+	Token   string `yaml:"token"`
+	SignKey string `yaml:"sign_key"`
+}
+
+// Registry resolves tenants of requests by their names and auth tokens
+type Registry struct {
+	byName  map[string]*Config
+	byToken map[string]*Config
+}
+
+// NewRegistry creates registry of tenants, names and tokens of tenants must be unique
+func NewRegistry(tenants []Config) (*Registry, error) {
+	r := Registry{
+		byName:  make(map[string]*Config, len(tenants)),
+		byToken: make(map[string]*Config, len(tenants)),
+	}
+
+	for i := range tenants {
+		t := tenants[i]
+		if err := validateName(t.Name); err != nil {
+			return nil, err
+		}
+		if _, ok := r.byName[t.Name]; ok {
+			return nil, fmt.Errorf("%w: duplicated tenant %s", ErrInvalidConfig, t.Name)
+		}
+		r.byName[t.Name] = &t
+
+		if t.Token == "" {
+			continue
+		}
+		if _, ok := r.byToken[t.Token]; ok {
+			return nil, fmt.Errorf("%w: duplicated token of tenant %s", ErrInvalidConfig, t.Name)
+		}
+		r.byToken[t.Token] = &t
+	}
+
+	return &r, nil
+}
+
+// Resolve returns tenant by its name and auth token, either of them can be empty.
+// Request without name and token belongs to the default tenant which is returned as nil.
+func (r *Registry) Resolve(name string, token string) (*Config, error) {
+	if token != "" {
+		t, ok := r.byToken[token]
+		if !ok || (name != "" && name != t.Name) {
+			return nil, ErrUnauthorized
+		}
+
+		return t, nil
+	}
+
+	if name == "" {
+		return nil, nil
+	}
+
+	t, ok := r.byName[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTenant, name)
+	}
+	if t.Token != "" {
+		return nil, ErrUnauthorized
+	}
+
+	return t, nil
+}
+
+// validateName checks that name of tenant is non-empty and consists of letters, digits, dots, dashes and underscores
+func validateName(name string) error {
+	if name == "" || len(name) > maxNameLength {
+		return fmt.Errorf("%w: name of tenant must have 1 to %d chars: %q", ErrInvalidConfig, maxNameLength, name)
+	}
+
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c != '.' && c != '-' && c != '_' && (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			return fmt.Errorf("%w: invalid char %q in name of tenant %s", ErrInvalidConfig, c, name)
+		}
+	}
+
+	return nil
+}
+
+// tenantKey is the key of tenant in context
+type tenantKey struct{}
+
+// NewContext returns context of the tenant, nil tenant is the default one
+func NewContext(ctx context.Context, t *Config) context.Context {
+	return context.WithValue(ctx, tenantKey{}, t)
+}
+
+// FromContext returns name of the tenant of context, the default tenant has empty name
+func FromContext(ctx context.Context) string {
+	if t, ok := ctx.Value(tenantKey{}).(*Config); ok && t != nil {
+		return t.Name
+	}
+
+	return ""
+}
+
+// SignKey returns the key to sign metrics of the tenant of context, the default key is used if tenant has no own key
+func SignKey(ctx context.Context, defaultKey string) string {
+	if t, ok := ctx.Value(tenantKey{}).(*Config); ok && t != nil && t.SignKey != "" {
+		return t.SignKey
+	}
+
+	return defaultKey
+}
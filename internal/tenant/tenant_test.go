@@ -0,0 +1,200 @@
+package tenant
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testTenants = []Config{
+	{Name: "team-a", Token: "token-a", SignKey: "key-a"},
+	{Name: "team-b"},
+}
+
+func TestNewRegistry(t *testing.T) {
+	tests := []struct {
+		name    string
+		tenants []Config
+		wantErr bool
+	}{
+		{
+			name:    "Valid tenants",
+			tenants: testTenants,
+		},
+		{
+			name: "No tenants",
+		},
+		{
+			name:    "Empty name",
+			tenants: []Config{{Token: "token"}},
+			wantErr: true,
+		},
+		{
+			name:    "Invalid name",
+			tenants: []Config{{Name: `team"a`}},
+			wantErr: true,
+		},
+		{
+			name:    "Duplicated name",
+			tenants: []Config{{Name: "team-a"}, {Name: "team-a"}},
+			wantErr: true,
+		},
+		{
+			name:    "Duplicated token",
+			tenants: []Config{{Name: "team-a", Token: "token"}, {Name: "team-b", Token: "token"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewRegistry(tt.tenants)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidConfig)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestRegistry_Resolve(t *testing.T) {
+	registry, err := NewRegistry(testTenants)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		tenantName string
+		token      string
+		want       string
+		wantErr    error
+	}{
+		{
+			name: "Default tenant",
+		},
+		{
+			name:  "Tenant by token",
+			token: "token-a",
+			want:  "team-a",
+		},
+		{
+			name:       "Tenant by name and token",
+			tenantName: "team-a",
+			token:      "token-a",
+			want:       "team-a",
+		},
+		{
+			name:       "Tenant without token by name",
+			tenantName: "team-b",
+			want:       "team-b",
+		},
+		{
+			name:       "Tenant with token by name",
+			tenantName: "team-a",
+			wantErr:    ErrUnauthorized,
+		},
+		{
+			name:       "Token of another tenant",
+			tenantName: "team-b",
+			token:      "token-a",
+			wantErr:    ErrUnauthorized,
+		},
+		{
+			name:    "Unknown token",
+			token:   "token-c",
+			wantErr: ErrUnauthorized,
+		},
+		{
+			name:       "Unknown tenant",
+			tenantName: "team-c",
+			wantErr:    ErrUnknownTenant,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := registry.Resolve(tt.tenantName, tt.token)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, FromContext(NewContext(context.Background(), got)))
+		})
+	}
+}
+
+func TestSignKey(t *testing.T) {
+	ctx := context.Background()
+	assert.Equal(t, "server-key", SignKey(ctx, "server-key"))
+	assert.Equal(t, "server-key", SignKey(NewContext(ctx, &testTenants[1]), "server-key"))
+	assert.Equal(t, "key-a", SignKey(NewContext(ctx, &testTenants[0]), "server-key"))
+}
+
+func TestMiddleware(t *testing.T) {
+	registry, err := NewRegistry(testTenants)
+	require.NoError(t, err)
+
+	handler := Middleware(registry)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(FromContext(r.Context())))
+	}))
+	client := &http.Client{}
+
+	tests := []struct {
+		name       string
+		tenantName string
+		token      string
+		wantCode   int
+		want       string
+	}{
+		{
+			name:     "Default tenant",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Tenant by token",
+			token:    "token-a",
+			wantCode: http.StatusOK,
+			want:     "team-a",
+		},
+		{
+			name:       "Tenant by header",
+			tenantName: "team-b",
+			wantCode:   http.StatusOK,
+			want:       "team-b",
+		},
+		{
+			name:       "Tenant without token",
+			tenantName: "team-a",
+			wantCode:   http.StatusUnauthorized,
+		},
+		{
+			name:       "Unknown tenant",
+			tenantName: "team-c",
+			wantCode:   http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(handler)
+			defer server.Close()
+			client.Transport = NewRoundTripper(http.DefaultTransport, tt.tenantName, tt.token)
+
+			resp, err := client.Get(server.URL)
+			require.NoError(t, err)
+			defer func() { _ = resp.Body.Close() }()
+
+			assert.Equal(t, tt.wantCode, resp.StatusCode)
+			if tt.wantCode == http.StatusOK {
+				body, err := ioutil.ReadAll(resp.Body)
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, string(body))
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Errors of AES keys
+var (
+	ErrBadAESKeyFormat = errors.New("wrong AES key format, ensure base64 encoded 16, 24 or 32 bytes")
+	ErrUnknownAESKey   = errors.New("data is sealed with unknown AES key")
+	ErrBadSealedData   = errors.New("sealed data can't be authenticated")
+)
+
+// AESKeyRing seals data by AES-GCM with the current key and opens data sealed with any of its keys,
+// so keys can be rotated: data sealed with the previous keys is readable while the new key is used for sealing
+type AESKeyRing struct {
+	keys []aesKey // the first key is the current one
+}
+
+// aesKey is AES-GCM cipher with ID of its key
+type aesKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// ReadAESKeys reads base64 encoded AES keys from file, one key per line, empty lines and # comments are skipped
+func ReadAESKeys(keysPath string) ([]string, error) {
+	if keysPath == "" {
+		return nil, nil
+	}
+
+	keysBytes, err := os.ReadFile(keysPath)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, 1)
+	scanner := bufio.NewScanner(bytes.NewReader(keysBytes))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		keys = append(keys, line)
+	}
+
+	return keys, scanner.Err()
+}
+
+// NewAESKeyRing creates key ring of base64 encoded AES keys, the first key is the current one.
+// Key ring isn't created without keys.
+func NewAESKeyRing(encodedKeys ...string) (*AESKeyRing, error) {
+	if len(encodedKeys) == 0 {
+		return nil, nil
+	}
+
+	ring := AESKeyRing{
+		keys: make([]aesKey, 0, len(encodedKeys)),
+	}
+	for i, encodedKey := range encodedKeys {
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encodedKey))
+		if err != nil {
+			return nil, fmt.Errorf("%w: key #%d: %s", ErrBadAESKeyFormat, i+1, err)
+		}
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("%w: key #%d: %s", ErrBadAESKeyFormat, i+1, err)
+		}
+
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		sum := sha256.Sum256(key)
+		ring.keys = append(ring.keys, aesKey{id: hex.EncodeToString(sum[:4]), aead: aead})
+	}
+
+	return &ring, nil
+}
+
+// KeyID returns ID of the current key, ID is derived from the key and doesn't disclose it
+func (r *AESKeyRing) KeyID() string {
+	return r.keys[0].id
+}
+
+// Seal encrypts and authenticates plaintext and additional data with the current key,
+// the result is random nonce followed by ciphertext
+func (r *AESKeyRing) Seal(plaintext []byte, additionalData []byte) ([]byte, error) {
+	aead := r.keys[0].aead
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// Open decrypts and authenticates data sealed with the key of ID and additional data
+func (r *AESKeyRing) Open(keyID string, sealed []byte, additionalData []byte) ([]byte, error) {
+	for _, key := range r.keys {
+		if key.id != keyID {
+			continue
+		}
+
+		nonceSize := key.aead.NonceSize()
+		if len(sealed) < nonceSize {
+			return nil, fmt.Errorf("%w: data is too short", ErrBadSealedData)
+		}
+
+		plaintext, err := key.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], additionalData)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrBadSealedData, err)
+		}
+
+		return plaintext, nil
+	}
+
+	return nil, fmt.Errorf("%w %s", ErrUnknownAESKey, keyID)
+}
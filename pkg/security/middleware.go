@@ -1,12 +1,17 @@
 package security
 
 import (
+	"crypto/subtle"
 	"fmt"
 	"github.com/itd27m01/go-metrics-service/pkg/logging/log"
 	"net"
 	"net/http"
 )
 
+// AdminTokenHeader carries the token of administrative requests, it's separate from Authorization header
+// which carries the token of tenant
+const AdminTokenHeader = "X-Admin-Token"
+
 // RealIPRoundTripper sets X-Real-IP header for client
 type RealIPRoundTripper struct {
 	proxied http.RoundTripper
@@ -62,3 +67,25 @@ func CheckRealIP(trustedCIDR string) func(http.Handler) http.Handler {
 		})
 	}
 }
+
+// CheckAdminToken checks that request is authorized by the token in X-Admin-Token header,
+// all requests are forbidden if token isn't configured
+func CheckAdminToken(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token == "" {
+				http.Error(w, "access forbidden: token isn't configured", http.StatusForbidden)
+
+				return
+			}
+
+			if subtle.ConstantTimeCompare([]byte(r.Header.Get(AdminTokenHeader)), []byte(token)) != 1 {
+				http.Error(w, "access unauthorized: invalid token", http.StatusUnauthorized)
+
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}